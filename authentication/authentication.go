@@ -2,6 +2,7 @@ package authentication
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -10,21 +11,37 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/simhonchourasia/betfr-be/config"
 	"github.com/simhonchourasia/betfr-be/database"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/simhonchourasia/betfr-be/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var userCollection *mongo.Collection = database.OpenCollection(database.Client, config.GlobalConfig.UserCollection)
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+
+	refreshTokenHours = 24 * 7
+	// Signing in on more devices than this signs the oldest one out
+	maxSessions = 10
+)
+
+var (
+	ErrRevokedToken = errors.New("token has been revoked")
+	// Returned when a refresh token that was already rotated out is presented again
+	ErrTokenReuse = errors.New("refresh token was already used; its session has been signed out")
+)
 
 // Used to hold JWT info
+// Every access/refresh pair minted since a login shares a Family, so one stolen token can revoke them all
 type SignedDetails struct {
-	Username string
+	Username  string
+	TokenType string
+	Family    string
 	jwt.StandardClaims
 }
 
-func ValidateToken(signedToken string) (*SignedDetails, error) {
+// Checks the signature, expiry and type of a token
+// Does not check revocation; see CheckNotRevoked
+func ValidateToken(signedToken string, tokenType string) (*SignedDetails, error) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
@@ -45,27 +62,55 @@ func ValidateToken(signedToken string) (*SignedDetails, error) {
 		return nil, fmt.Errorf("expired token")
 	}
 
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("expected %s token", tokenType)
+	}
+
 	return claims, nil
 }
 
-func GenerateAllTokens(username string) (string, string, error) {
+// A token is only live while the user still has a session with its family
+func CheckNotRevoked(ctx context.Context, users database.UserStore, claims *SignedDetails) error {
+	user, err := users.FindByUsername(ctx, claims.Username)
+	if err != nil {
+		return ErrRevokedToken
+	}
+	if _, ok := user.Session(claims.Family); !ok {
+		return ErrRevokedToken
+	}
+	return nil
+}
+
+// Starts a new token family, as on login
+func NewTokenFamily() string {
+	return primitive.NewObjectID().Hex()
+}
+
+func GenerateAllTokens(username string, family string) (string, string, error) {
 	expiryHours := 24
 	if config.GlobalConfig.Debug {
 		expiryHours = 168
 	}
 	log.Printf("Created JWT token expiring in %d hours\n", expiryHours)
 	claims := &SignedDetails{
-		Username: username,
+		Username:  username,
+		TokenType: AccessToken,
+		Family:    family,
 		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
 			Issuer:    username,
 			ExpiresAt: time.Now().Local().Add(time.Duration(expiryHours) * time.Hour).Unix(),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
+		Username:  username,
+		TokenType: RefreshToken,
+		Family:    family,
 		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
 			Issuer:    username,
-			ExpiresAt: time.Now().Local().Add(time.Duration(24) * time.Duration(7) * time.Hour).Unix(),
+			ExpiresAt: time.Now().Local().Add(time.Duration(refreshTokenHours) * time.Hour).Unix(),
 		},
 	}
 
@@ -81,45 +126,82 @@ func GenerateAllTokens(username string) (string, string, error) {
 	return token, refreshToken, err
 }
 
-// Uses only username
-func UpdateAllTokens(signedToken string, signedRefreshToken string, username string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), time.Duration(2)*time.Minute)
-	defer cancel()
+// Starts a session for a login, alongside any the user already has on other devices
+func StartSession(ctx context.Context, users database.UserStore, username string, family string, signedRefreshToken string) error {
+	session := models.Session{
+		Family:       family,
+		RefreshToken: signedRefreshToken,
+		CreateDate:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+	return users.AddSession(ctx, username, session, maxSessions)
+}
 
-	var updateObj primitive.D
-	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken}, bson.E{Key: "refresh_token", Value: signedRefreshToken})
+// Exchanges a refresh token for a new pair in the same family, storing the new refresh token
+// If the token is an older member of one of the user's live families it has been used twice,
+// so that family is revoked and ErrTokenReuse is returned; the user's other sessions carry on
+// The new pair is only stored if the presented token is still the session's current one, so of two
+// concurrent refreshes with the same token only one can succeed, and the other counts as reuse
+func RotateTokens(ctx context.Context, users database.UserStore, signedRefreshToken string) (string, string, error) {
+	claims, err := ValidateToken(signedRefreshToken, RefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	user, err := users.FindByUsername(ctx, claims.Username)
+	if err != nil {
+		return "", "", ErrRevokedToken
+	}
+	session, ok := user.Session(claims.Family)
+	if !ok {
+		return "", "", ErrRevokedToken
+	}
 
-	upsert := true
-	filter := bson.M{"username": username}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
+	if session.RefreshToken != signedRefreshToken {
+		return "", "", revokeReused(ctx, users, claims.Username, claims.Family)
 	}
-	_, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{
-			{Key: "$set", Value: updateObj},
-		},
-		&opt,
-	)
 
+	token, refreshToken, err := GenerateAllTokens(claims.Username, claims.Family)
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", "", err
+	}
+	rotated, err := users.RotateSession(ctx, claims.Username, claims.Family, signedRefreshToken, refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if !rotated {
+		return "", "", revokeReused(ctx, users, claims.Username, claims.Family)
 	}
+	return token, refreshToken, nil
 }
 
+func revokeReused(ctx context.Context, users database.UserStore, username string, family string) error {
+	log.Printf("Refresh token reuse detected for %s; revoking token family\n", username)
+	if err := RevokeSession(ctx, users, username, family); err != nil {
+		return err
+	}
+	return ErrTokenReuse
+}
+
+// Signs the user out of one session; tokens from their other sessions keep working
+func RevokeSession(ctx context.Context, users database.UserStore, username string, family string) error {
+	if family == "" {
+		return ErrRevokedToken
+	}
+	return users.RevokeSessions(ctx, username, family)
+}
+
+// Signs the user out everywhere; every token they hold stops working
+func RevokeTokens(ctx context.Context, users database.UserStore, username string) error {
+	return users.RevokeSessions(ctx, username, "")
+}
+
+// Checks that the logged in user is the given user
 func CheckUserPermissions(c *gin.Context, username *string) error {
-	un, ok := c.Get("username")
-	uns, isString := un.(string)
-	if !ok || !isString {
+	principal, ok := GetPrincipal(c)
+	if !ok {
 		return fmt.Errorf("could not get username from context")
 	}
-	fmt.Printf("current user: %s\n", uns)
-	if ok && isString {
-		if *username == uns {
-			return nil
-		}
+	if username != nil && *username == principal.Username {
+		return nil
 	}
-	return fmt.Errorf("current user %s does not have the required permissions", uns)
+	return fmt.Errorf("current user %s does not have the required permissions", principal.Username)
 }
@@ -0,0 +1,159 @@
+package authentication
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func signIn(t *testing.T, users database.UserStore, username string) string {
+	t.Helper()
+	name := username
+	if _, err := users.FindByUsername(context.Background(), username); err == database.ErrNotFound {
+		if err := users.Insert(context.Background(), models.User{ID: primitive.NewObjectID(), Username: &name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	family := NewTokenFamily()
+	_, refreshToken, err := GenerateAllTokens(username, family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := StartSession(context.Background(), users, username, family, refreshToken); err != nil {
+		t.Fatal(err)
+	}
+	return refreshToken
+}
+
+func TestRotateTokensDetectsReuse(t *testing.T) {
+	config.GlobalConfig.SecretKey = "test"
+	ctx := context.Background()
+	users := database.NewMemoryStores().Users
+	first := signIn(t, users, "alice")
+
+	_, second, err := RotateTokens(ctx, users, first)
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+	if _, _, err := RotateTokens(ctx, users, first); err != ErrTokenReuse {
+		t.Fatalf("reusing a rotated token gave %v, want ErrTokenReuse", err)
+	}
+	// Reuse revokes the whole family, including the token handed out by the legitimate rotation
+	if _, _, err := RotateTokens(ctx, users, second); err != ErrRevokedToken {
+		t.Fatalf("rotating after reuse gave %v, want ErrRevokedToken", err)
+	}
+}
+
+func TestReuseOnlyRevokesItsSession(t *testing.T) {
+	config.GlobalConfig.SecretKey = "test"
+	ctx := context.Background()
+	users := database.NewMemoryStores().Users
+	phone := signIn(t, users, "alice")
+	laptop := signIn(t, users, "alice")
+
+	if _, _, err := RotateTokens(ctx, users, phone); err != nil {
+		t.Fatalf("rotating the phone's token: %v", err)
+	}
+	if _, _, err := RotateTokens(ctx, users, phone); err != ErrTokenReuse {
+		t.Fatalf("reusing the phone's token gave %v, want ErrTokenReuse", err)
+	}
+	// The laptop signed in separately, so it is not signed out along with the phone
+	access, rotated, err := RotateTokens(ctx, users, laptop)
+	if err != nil {
+		t.Fatalf("rotating the laptop's token after the phone's was reused: %v", err)
+	}
+	claims, err := ValidateToken(access, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNotRevoked(ctx, users, claims); err != nil {
+		t.Fatalf("laptop's access token: %v", err)
+	}
+
+	if err := RevokeTokens(ctx, users, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := RotateTokens(ctx, users, rotated); err != ErrRevokedToken {
+		t.Fatalf("rotating after signing out everywhere gave %v, want ErrRevokedToken", err)
+	}
+}
+
+func TestOldestSessionIsDropped(t *testing.T) {
+	config.GlobalConfig.SecretKey = "test"
+	ctx := context.Background()
+	users := database.NewMemoryStores().Users
+	oldest := signIn(t, users, "alice")
+	for i := 1; i < maxSessions; i++ {
+		signIn(t, users, "alice")
+	}
+	if _, _, err := RotateTokens(ctx, users, oldest); err != nil {
+		t.Fatalf("oldest of %d sessions: %v", maxSessions, err)
+	}
+	newest := signIn(t, users, "alice")
+	if _, _, err := RotateTokens(ctx, users, newest); err != nil {
+		t.Fatalf("newest session: %v", err)
+	}
+	user, err := users.FindByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Sessions) != maxSessions {
+		t.Fatalf("alice has %d sessions, want %d", len(user.Sessions), maxSessions)
+	}
+	if _, _, err := RotateTokens(ctx, users, oldest); err != ErrRevokedToken {
+		t.Fatalf("oldest session after one too many sign ins gave %v, want ErrRevokedToken", err)
+	}
+}
+
+// Holds every lookup until all of them have been made, so each refresh reads the user before any of them writes
+type barrierUsers struct {
+	database.UserStore
+	arrived sync.WaitGroup
+}
+
+func (u *barrierUsers) FindByUsername(ctx context.Context, username string) (models.User, error) {
+	user, err := u.UserStore.FindByUsername(ctx, username)
+	u.arrived.Done()
+	u.arrived.Wait()
+	return user, err
+}
+
+func TestConcurrentRotationsOnlyOneSucceeds(t *testing.T) {
+	config.GlobalConfig.SecretKey = "test"
+	ctx := context.Background()
+	store := database.NewMemoryStores().Users
+	refreshToken := signIn(t, store, "alice")
+
+	const attempts = 20
+	users := &barrierUsers{UserStore: store}
+	users.arrived.Add(attempts)
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = RotateTokens(ctx, users, refreshToken)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case ErrTokenReuse, ErrRevokedToken:
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d concurrent rotations of one token succeeded, want 1", succeeded)
+	}
+}
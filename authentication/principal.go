@@ -0,0 +1,79 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+)
+
+// Where the access token for a request came from
+type TokenSource string
+
+const (
+	BearerSource TokenSource = "bearer" // Authorization: Bearer header
+	CookieSource TokenSource = "cookie" // jwt cookie set at login
+	HeaderSource TokenSource = "header" // legacy token header
+)
+
+const principalKey = "principal"
+
+var ErrMissingToken = errors.New("missing access token")
+
+// The authenticated user behind a request, as set by middleware.Authentication
+type Principal struct {
+	Username string
+	Family   string // token family of the session the request belongs to
+	Source   TokenSource
+}
+
+func SetPrincipal(c *gin.Context, principal Principal) {
+	c.Set(principalKey, principal)
+}
+
+func GetPrincipal(c *gin.Context) (Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	return principal, ok
+}
+
+// Looks for an access token in the Authorization header, then the jwt cookie, then the legacy token header
+func TokenFromRequest(c *gin.Context) (string, TokenSource, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", BearerSource, fmt.Errorf("authorization header must be of the form Bearer <token>")
+		}
+		return strings.TrimSpace(token), BearerSource, nil
+	}
+	if cookie, err := c.Cookie("jwt"); err == nil && cookie != "" {
+		return cookie, CookieSource, nil
+	}
+	if header := c.GetHeader("token"); header != "" {
+		return header, HeaderSource, nil
+	}
+	return "", "", ErrMissingToken
+}
+
+// Validates the request's access token and checks that its session has not been revoked
+// Any error means the request is unauthenticated
+func Authenticate(ctx context.Context, users database.UserStore, c *gin.Context) (Principal, error) {
+	token, source, err := TokenFromRequest(c)
+	if err != nil {
+		return Principal{}, err
+	}
+	claims, err := ValidateToken(token, AccessToken)
+	if err != nil {
+		return Principal{}, err
+	}
+	if err := CheckNotRevoked(ctx, users, claims); err != nil {
+		return Principal{}, err
+	}
+	return Principal{Username: claims.Username, Family: claims.Family, Source: source}, nil
+}
@@ -10,16 +10,108 @@ import (
 
 // Add field here when new config element in json
 type Config struct {
-	MongoURI        string `json:"mongoURI"`
-	Cluster         string `json:"cluster"`
-	UserCollection  string `json:"userCollection"`
-	BetCollection   string `json:"betCollection"`
-	StakeCollection string `json:"stakeCollection"`
-	SecretKey       string `json:"secretKey"`
-	Domain          string `json:"domain"`
-	Port            string `json:"port"`
-	Debug           bool   `json:"debug"`
-	OriginFE        string `json:originFE"`
+	MongoURI               string `json:"mongoURI"`
+	Cluster                string `json:"cluster"`
+	UserCollection         string `json:"userCollection"`
+	BetCollection          string `json:"betCollection"`
+	StakeCollection        string `json:"stakeCollection"`
+	LedgerCollection       string `json:"ledgerCollection"`
+	EventCollection        string `json:"eventCollection"`
+	CounterCollection      string `json:"counterCollection"`
+	WebhookCollection      string `json:"webhookCollection"`
+	DeliveryCollection     string `json:"webhookDeliveryCollection"`
+	NotificationCollection string `json:"notificationCollection"`
+	SettlementCollection   string `json:"settlementCollection"`
+	PoolCollection         string `json:"poolCollection"`
+	TemplateCollection     string `json:"templateCollection"`
+	ScheduleCollection     string `json:"scheduleCollection"`
+	SecretKey              string `json:"secretKey"`
+	Domain                 string `json:"domain"`
+	Port                   string `json:"port"`
+	Debug                  bool   `json:"debug"`
+	OriginFE               string `json:"originFE"`
+	InMemory               bool   `json:"inMemory"` // use the in-memory stores instead of Mongo
+	ExpirySweepSecs        int    `json:"expirySweepSeconds"`
+	WebhookPollSecs        int    `json:"webhookPollSeconds"`        // how often the outbox is checked for deliveries that are due
+	AllowNonFriendBetReqs  bool   `json:"allowNonFriendBetRequests"` // bet requests are only allowed between friends unless this is set
+	AllowBettorStakes      bool   `json:"allowBettorStakes"`         // whether the creator and receiver may stake on their own bets
+	MaxStakeSharesPerUser  int64  `json:"maxStakeSharesPerUser"`     // zero for no limit
+	MaxStakeSharesPerBet   int64  `json:"maxStakeSharesPerBet"`      // zero for no limit
+	Mailer                 string `json:"mailer"`                    // smtp, file or memory
+	SMTPHost               string `json:"smtpHost"`
+	SMTPPort               int    `json:"smtpPort"`
+	SMTPUsername           string `json:"smtpUsername"`
+	SMTPPassword           string `json:"smtpPassword"`
+	MailFrom               string `json:"mailFrom"`
+	MailDir                string `json:"mailDir"`             // where the file mailer writes messages
+	DigestHours            int    `json:"digestHours"`         // how often each user gets a digest email
+	DigestPollSecs         int    `json:"digestPollSeconds"`   // how often the digest job checks for users who are due one
+	ExpiryWarningHours     int    `json:"expiryWarningHours"`  // how close to expiry an ongoing bet must be to go in the digest
+	SchedulePollSecs       int    `json:"schedulePollSeconds"` // how often recurring bet schedules are checked for runs that are due
+}
+
+// Fills in values that older config files may not have yet
+func setDefaults(cfg *Config) {
+	if cfg.LedgerCollection == "" {
+		cfg.LedgerCollection = "Ledger"
+	}
+	if cfg.EventCollection == "" {
+		cfg.EventCollection = "Events"
+	}
+	if cfg.CounterCollection == "" {
+		cfg.CounterCollection = "Counters"
+	}
+	if cfg.WebhookCollection == "" {
+		cfg.WebhookCollection = "Webhooks"
+	}
+	if cfg.DeliveryCollection == "" {
+		cfg.DeliveryCollection = "WebhookDeliveries"
+	}
+	if cfg.NotificationCollection == "" {
+		cfg.NotificationCollection = "Notifications"
+	}
+	if cfg.SettlementCollection == "" {
+		cfg.SettlementCollection = "Settlements"
+	}
+	if cfg.PoolCollection == "" {
+		cfg.PoolCollection = "Pools"
+	}
+	if cfg.TemplateCollection == "" {
+		cfg.TemplateCollection = "BetTemplates"
+	}
+	if cfg.ScheduleCollection == "" {
+		cfg.ScheduleCollection = "BetSchedules"
+	}
+	if cfg.WebhookPollSecs <= 0 {
+		cfg.WebhookPollSecs = 5
+	}
+	if cfg.ExpirySweepSecs <= 0 {
+		cfg.ExpirySweepSecs = 60
+	}
+	if cfg.Mailer == "" {
+		cfg.Mailer = "file"
+	}
+	if cfg.SMTPPort == 0 {
+		cfg.SMTPPort = 587
+	}
+	if cfg.MailFrom == "" {
+		cfg.MailFrom = "betfr <noreply@betfr.local>"
+	}
+	if cfg.MailDir == "" {
+		cfg.MailDir = "sentmail"
+	}
+	if cfg.DigestHours <= 0 {
+		cfg.DigestHours = 24
+	}
+	if cfg.DigestPollSecs <= 0 {
+		cfg.DigestPollSecs = 300
+	}
+	if cfg.ExpiryWarningHours <= 0 {
+		cfg.ExpiryWarningHours = 48
+	}
+	if cfg.SchedulePollSecs <= 0 {
+		cfg.SchedulePollSecs = 60
+	}
 }
 
 var configOnce sync.Once
@@ -41,6 +133,7 @@ func SetupConfig() error {
 				json.Unmarshal(configBytes, &GlobalConfig)
 			}
 		}
+		setDefaults(&GlobalConfig)
 	})
 	return cfgErr
 }
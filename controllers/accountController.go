@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/authentication"
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/matching"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Returned when deletion is refused because the account still has open bets, stakes, pools or balances
+var errAccountOpen = fmt.Errorf("account has ongoing bets, stakes, pools or non-zero balances; settle them first or pass force")
+
+// Returned when even a forced deletion is refused, since it would leave other users out of pocket
+var (
+	errAccountOwes  = fmt.Errorf("account owes other users; settle up with them before deleting it")
+	errStakesFilled = fmt.Errorf("account has filled stakes on open bets; wait for the bets to resolve before deleting it")
+)
+
+// Returns everything stored about the logged in user as one JSON document
+func (ctl *Controller) ExportUserFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	export, err := ctl.exportUser(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", username+".json"))
+	c.JSON(http.StatusOK, export)
+}
+
+func (ctl *Controller) exportUser(ctx context.Context, username string) (models.UserExport, error) {
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return models.UserExport{}, fmt.Errorf("user %s not found", username)
+	}
+
+	export := models.UserExport{
+		User:       models.NewUserView(user),
+		Bets:       make([]models.BetView, 0),
+		Stakes:     make([]models.StakeView, 0),
+		ExportDate: primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	page := database.PageRequest{SortBy: database.SortByCreateDate, Limit: maxPageSize}
+	for {
+		bets, err := ctl.Bets.List(ctx, database.BetFilter{Participant: username}, page)
+		if err != nil {
+			return models.UserExport{}, err
+		}
+		for _, bet := range bets {
+			export.Bets = append(export.Bets, models.NewBetView(bet))
+		}
+		if len(bets) < page.Limit {
+			break
+		}
+		cursor := database.BetCursor(bets[len(bets)-1], page.SortBy)
+		page.After = &cursor
+	}
+
+	page = database.PageRequest{SortBy: database.SortByCreateDate, Limit: maxPageSize}
+	for {
+		stakes, err := ctl.Stakes.List(ctx, database.StakeFilter{OwnerName: username}, page)
+		if err != nil {
+			return models.UserExport{}, err
+		}
+		for _, stake := range stakes {
+			export.Stakes = append(export.Stakes, models.NewStakeView(stake))
+		}
+		if len(stakes) < page.Limit {
+			break
+		}
+		cursor := database.StakeCursor(stakes[len(stakes)-1])
+		page.After = &cursor
+	}
+
+	export.Ledger, err = ctl.Ledger.ListByUsername(ctx, username)
+	if err != nil {
+		return models.UserExport{}, err
+	}
+	return export, nil
+}
+
+// Pass in username, password and optionally force
+// Deletes the logged in user's account, leaving a tombstone so the username is never reused
+// Refuses while the account has open bets, stakes, pools or balances, unless force is set, in which case they are called off and settled
+// Even with force, refuses while the user owes anyone or has filled stakes on open bets
+// The response includes an export of the account as it was before deletion
+func (ctl *Controller) DeleteUserFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var deleteReq models.DeleteAccountRequest
+
+	if err := c.BindJSON(&deleteReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the user being deleted is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &deleteReq.Username); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	var export models.UserExport
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		export, status, err = ctl.deleteUser(ctx, deleteReq)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.SetCookie("jwt", "", -1, "/", config.GlobalConfig.Domain, false, true)
+	c.JSON(http.StatusOK, gin.H{
+		"msg":    fmt.Sprintf("Successfully deleted user %s", deleteReq.Username),
+		"export": export,
+	})
+}
+
+func (ctl *Controller) deleteUser(ctx context.Context, deleteReq models.DeleteAccountRequest) (models.UserExport, int, error) {
+	user, err := ctl.Users.FindByUsername(ctx, deleteReq.Username)
+	if err != nil || user.Deleted {
+		return models.UserExport{}, http.StatusNotFound, fmt.Errorf("user %s could not be found for deletion", deleteReq.Username)
+	}
+	if user.Password == nil || !VerifyPassword(deleteReq.Password, *user.Password) {
+		return models.UserExport{}, http.StatusForbidden, fmt.Errorf("incorrect password")
+	}
+
+	export, err := ctl.exportUser(ctx, deleteReq.Username)
+	if err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+
+	pools, err := ctl.openPools(ctx, deleteReq.Username)
+	if err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	if accountOpen(user) || len(pools) > 0 {
+		if !deleteReq.Force {
+			return models.UserExport{}, http.StatusConflict, errAccountOpen
+		}
+		if status, err := ctl.forceSettle(ctx, deleteReq.Username); err != nil {
+			return models.UserExport{}, status, err
+		}
+		for _, pool := range pools {
+			if err := ctl.leavePool(ctx, pool, deleteReq.Username); err != nil {
+				return models.UserExport{}, http.StatusInternalServerError, err
+			}
+		}
+	}
+
+	if err := ctl.Users.PullFromAllLists(ctx, deleteReq.Username); err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.Webhooks.DeleteByOwner(ctx, deleteReq.Username); err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.Schedules.DeleteByOwner(ctx, deleteReq.Username); err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.Templates.DeleteByOwner(ctx, deleteReq.Username); err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.Notifications.DeleteByUsername(ctx, deleteReq.Username); err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.Users.Tombstone(ctx, deleteReq.Username, time.Now()); err != nil {
+		return models.UserExport{}, http.StatusInternalServerError, err
+	}
+	return export, http.StatusOK, nil
+}
+
+func accountOpen(user models.User) bool {
+	if len(user.OngoingBets) > 0 || len(user.ConflictedBets) > 0 || len(user.OngoingStakes) > 0 ||
+		len(user.IncomingBetReqs) > 0 || len(user.OutgoingBetReqs) > 0 {
+		return true
+	}
+	for _, balance := range user.Balances {
+		if balance != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Calls off every open bet and stake of the user and writes off what others owe them
+// Bet requests are cancelled, ongoing and conflicted bets are voided, and the unfilled part of the user's own stakes is withdrawn
+// Filled stakes and debts the user owes are left for the caller to refuse on, so that the transaction is rolled back
+func (ctl *Controller) forceSettle(ctx context.Context, username string) (int, error) {
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	betIDs := make([]primitive.ObjectID, 0)
+	for _, list := range [][]primitive.ObjectID{user.IncomingBetReqs, user.OutgoingBetReqs, user.OngoingBets, user.ConflictedBets} {
+		for _, betID := range list {
+			if !containsID(betIDs, betID) {
+				betIDs = append(betIDs, betID)
+			}
+		}
+	}
+	for _, betID := range betIDs {
+		if err := ctl.callOffBet(ctx, betID); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	// Stakes on other people's bets; those on the user's own bets were voided above
+	user, err = ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, stakeID := range user.OngoingStakes {
+		if err := ctl.withdrawStake(ctx, stakeID); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	// Filled stakes would still pay out against the deleted account, so the user has to wait for those bets
+	user, err = ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(user.OngoingStakes) > 0 {
+		return http.StatusConflict, errStakesFilled
+	}
+
+	// Only what others owe the user is written off, so that nobody is left owing a deleted account;
+	// debts the user owes have to be settled first
+	for _, balance := range user.Balances {
+		if balance < 0 {
+			return http.StatusConflict, errAccountOwes
+		}
+	}
+	for counterparty, balance := range user.Balances {
+		if balance > 0 {
+			if err := ctl.transferBalance(ctx, username, counterparty, balance, primitive.NilObjectID, primitive.NilObjectID); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// Closes a pending, ongoing or conflicted bet without moving any balances, then evaluates the bets derived from it
+// Bets that have already been closed, e.g. as a derived bet of an earlier one, are left alone
+func (ctl *Controller) callOffBet(ctx context.Context, betID primitive.ObjectID) error {
+	bet, err := ctl.Bets.FindByID(ctx, betID)
+	if err != nil {
+		return err
+	}
+	if bet.OverallStatus != models.Undecided && bet.OverallStatus != models.Conflicted {
+		return nil
+	}
+	creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName)
+	if err != nil {
+		return fmt.Errorf("bet creator %s not found", bet.CreatorName)
+	}
+
+	pending := containsID(creator.OutgoingBetReqs, bet.ID) || containsID(creator.IncomingBetReqs, bet.ID)
+	for _, username := range []string{bet.CreatorName, bet.ReceiverName} {
+		fields := []string{"ongoingbets", "conflictedbets"}
+		if pending {
+			fields = []string{"outgoingbetreqs", "incomingbetreqs"}
+		}
+		for _, field := range fields {
+			update := models.UpdateUserHelperStruct{
+				Username:  username,
+				Operation: "$pullAll",
+				Field:     field,
+				IdVal:     bet.ID,
+			}
+			if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+				return err
+			}
+		}
+		if !pending {
+			update := models.UpdateUserHelperStruct{
+				Username:  username,
+				Operation: "$push",
+				Field:     "resolvedbets",
+				IdVal:     bet.ID,
+			}
+			if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ctl.voidStakes(ctx, &bet); err != nil {
+		return err
+	}
+	if pending {
+		bet.OverallStatus = models.Cancelled
+	} else {
+		bet.OverallStatus = models.Voided
+	}
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return err
+	}
+	return ctl.settleDerivedBets(ctx, bet)
+}
+
+// Takes whatever is unfilled of one stake off its bet's queue, as cancelling it would
+// Filled shares stay on the bet, since other stakes were matched against them, and pay out when the bet resolves
+func (ctl *Controller) withdrawStake(ctx context.Context, stakeID primitive.ObjectID) error {
+	stake, err := ctl.Stakes.FindByID(ctx, stakeID)
+	if err != nil {
+		return err
+	}
+	if stake.Voided {
+		return nil
+	}
+
+	bet, err := ctl.Bets.FindByID(ctx, stake.Underlying)
+	if err != nil {
+		return err
+	}
+	if bet.OverallStatus != models.Undecided && bet.OverallStatus != models.Conflicted {
+		return nil
+	}
+	book, err := ctl.loadBook(ctx, &bet)
+	if err != nil {
+		return err
+	}
+	order, err := book.Cancel(stake.ID.Hex())
+	if err == matching.ErrUnknownOrder {
+		// Completely filled already
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	remainder := order.Remaining()
+	if stake.BackingCreator {
+		bet.CreatorStaked -= remainder
+	} else {
+		bet.ReceiverStaked -= remainder
+	}
+	if err := syncBetQueues(&bet, book); err != nil {
+		return err
+	}
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return err
+	}
+
+	stake.SharesStaked -= remainder
+	if stake.SharesFilled == 0 {
+		// Nothing of the stake is left, so it is closed like a voided stake
+		stake.Voided = true
+		update := models.UpdateUserHelperStruct{
+			Username:  stake.OwnerName,
+			Operation: "$pullAll",
+			Field:     "ongoingstakes",
+			IdVal:     stake.ID,
+		}
+		if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+			return err
+		}
+		update.Operation = "$push"
+		update.Field = "resolvedstakes"
+		if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+			return err
+		}
+	}
+	return ctl.Stakes.Replace(ctx, stake)
+}
@@ -0,0 +1,125 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "dave")
+	h.befriend("bob", "carol")
+	h.befriend("bob", "dave")
+	// A settled bet that leaves bob owing alice, an ongoing one that carol staked on, and a pending request from dave
+	settled := h.ongoingBet("alice", "bob")
+	h.claim("alice", settled, models.CreatorWon)
+	h.claim("bob", settled, models.CreatorWon)
+	ongoing := h.ongoingBet("alice", "bob")
+	carolStake := h.stake("carol", ongoing, 2, true)
+	pending := h.betRequest("dave", "alice", "Rain", time.Now().Add(time.Hour))
+	// alice also has an unfilled stake on someone else's bet
+	other := h.ongoingBet("bob", "dave")
+	aliceStake := h.stake("alice", other, 2, true)
+
+	deleteAlice := map[string]interface{}{"username": "alice", "password": "wrong"}
+	h.must(http.StatusForbidden, "alice", "DELETE", "/users/deleteuser", deleteAlice)
+	deleteAlice["password"] = "password"
+	h.must(http.StatusConflict, "alice", "DELETE", "/users/deleteuser", deleteAlice)
+	if u := h.user("alice"); u.Deleted || len(u.OngoingBets) != 1 {
+		t.Fatal("refusing to delete the account changed it")
+	}
+	deleteAlice["force"] = true
+	out := h.must(http.StatusOK, "alice", "DELETE", "/users/deleteuser", deleteAlice)
+	export := out["export"].(map[string]interface{})
+	if len(export["bets"].([]interface{})) != 3 || len(export["stakes"].([]interface{})) != 1 || len(export["ledger"].([]interface{})) != 1 {
+		t.Errorf("export has %d bets, %d stakes and %d ledger entries, want 3, 1 and 1",
+			len(export["bets"].([]interface{})), len(export["stakes"].([]interface{})), len(export["ledger"].([]interface{})))
+	}
+
+	if alice := h.user("alice"); !alice.Deleted || alice.Email != nil || alice.Password != nil {
+		t.Errorf("alice's tombstone still has an email or password: %+v", alice)
+	}
+	if status := h.bet(ongoing).OverallStatus; status != models.Voided {
+		t.Errorf("ongoing bet has status %d, want Voided", status)
+	}
+	if status := h.bet(pending).OverallStatus; status != models.Cancelled {
+		t.Errorf("pending request has status %d, want Cancelled", status)
+	}
+	for name, id := range map[string]primitive.ObjectID{"carol": carolStake, "alice": aliceStake} {
+		if stake := h.stakeByID(id); !stake.Voided {
+			t.Errorf("%s's stake was not voided", name)
+		}
+	}
+	// What bob owed alice is written off, and nobody has her in their lists any more
+	if bob := h.user("bob"); bob.TotalBalance != 0 || bob.Balances["alice"] != 0 {
+		t.Errorf("bob still owes %d", -bob.TotalBalance)
+	}
+	for _, name := range []string{"bob", "carol", "dave"} {
+		u := h.user(name)
+		for _, friend := range u.Friends {
+			if friend == "alice" {
+				t.Errorf("%s is still friends with alice", name)
+			}
+		}
+		if len(u.IncomingBetReqs) != 0 || len(u.OutgoingBetReqs) != 0 {
+			t.Errorf("%s has requests %v %v", name, u.IncomingBetReqs, u.OutgoingBetReqs)
+		}
+	}
+	ledger, err := h.ctl.Ledger.ListByUsername(context.Background(), "alice")
+	if err != nil || len(ledger) != 2 {
+		t.Errorf("alice's ledger has %d entries, want the bet and the write-off: %v", len(ledger), err)
+	}
+
+	// The username stays taken but the email is free again
+	h.must(http.StatusUnauthorized, "alice", "GET", "/users/get", nil)
+	if code, out := h.do("", "POST", "/users/signup", map[string]string{"username": "alice", "email": "alice2@example.com", "password": "password"}); code == http.StatusOK {
+		t.Errorf("signed up as alice again: %v", out)
+	}
+	h.must(http.StatusOK, "", "POST", "/users/signup", map[string]string{"username": "alice2", "email": "alice@example.com", "password": "password"})
+	if code, out := h.do("bob", "POST", "/users/sendfriendreq", map[string]interface{}{"sender": "bob", "receiver": "alice"}); code == http.StatusOK {
+		t.Errorf("sent a friend request to a deleted account: %v", out)
+	}
+}
+
+// A forced deletion only writes off what others owe the user, and never leaves anyone out of pocket
+func TestForceDeleteRefusedWhileOwing(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "dave")
+	settled := h.ongoingBet("alice", "bob")
+	h.claim("alice", settled, models.CreatorWon)
+	h.claim("bob", settled, models.CreatorWon)
+
+	deleteBob := map[string]interface{}{"username": "bob", "password": "password", "force": true}
+	h.must(http.StatusConflict, "bob", "DELETE", "/users/deleteuser", deleteBob)
+	if alice, bob := h.user("alice"), h.user("bob"); bob.Deleted || alice.TotalBalance != 100 || bob.Balances["alice"] != -100 {
+		t.Fatalf("refused deletion changed balances: alice %d, bob %v, deleted %t", alice.TotalBalance, bob.Balances, bob.Deleted)
+	}
+
+	// carol and dave are matched against each other, so carol's stake cannot be taken back
+	ongoing := h.ongoingBet("alice", "bob")
+	carolStake := h.stake("carol", ongoing, 2, true)
+	h.stake("dave", ongoing, 2, false)
+	deleteCarol := map[string]interface{}{"username": "carol", "password": "password", "force": true}
+	h.must(http.StatusConflict, "carol", "DELETE", "/users/deleteuser", deleteCarol)
+	if stake := h.stakeByID(carolStake); stake.Voided || stake.SharesFilled != 2 || h.user("carol").Deleted {
+		t.Fatalf("refused deletion changed carol's stake: %+v", stake)
+	}
+
+	// Once bob has paid alice he can go
+	out := h.must(http.StatusOK, "bob", "POST", "/settlements/propose", map[string]interface{}{"payee": "alice"})
+	h.must(http.StatusOK, "alice", "POST", "/settlements/confirm", map[string]interface{}{"settlementid": out["id"], "status": models.Accepted})
+	h.must(http.StatusOK, "bob", "DELETE", "/users/deleteuser", deleteBob)
+	if alice := h.user("alice"); alice.TotalBalance != 0 || len(alice.OngoingBets) != 0 {
+		t.Fatalf("alice has balance %d and ongoing bets %v after bob left", alice.TotalBalance, alice.OngoingBets)
+	}
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/authentication"
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// Checks that arbiter can rule on a bet between creatorName and receiverName
+// The arbiter must be a friend of both parties and not a party themselves
+func (ctl *Controller) checkArbiter(ctx context.Context, creatorName string, receiverName string, arbiter string) error {
+	if arbiter == creatorName || arbiter == receiverName {
+		return fmt.Errorf("a bettor cannot arbitrate their own bet")
+	}
+	arbiterUser, err := ctl.Users.FindByUsername(ctx, arbiter)
+	if err != nil {
+		return fmt.Errorf("arbiter %s not found", arbiter)
+	}
+	if !containsString(arbiterUser.Friends, creatorName) || !containsString(arbiterUser.Friends, receiverName) {
+		return fmt.Errorf("arbiter %s must be friends with both %s and %s", arbiter, creatorName, receiverName)
+	}
+	return nil
+}
+
+// Records one party's choice of arbiter for a bet; once both parties name the same person they become the arbiter
+func (ctl *Controller) ProposeArbiterFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var proposal models.ArbiterProposal
+
+	if err := c.BindJSON(&proposal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the user proposing the arbiter is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &proposal.Username); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.proposeArbiter(ctx, proposal)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+func (ctl *Controller) proposeArbiter(ctx context.Context, proposal models.ArbiterProposal) (string, int, error) {
+	bet, err := ctl.Bets.FindByID(ctx, proposal.BetID)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet ID %s not found", proposal.BetID.String())
+	}
+	if bet.CreatorName != proposal.Username && bet.ReceiverName != proposal.Username {
+		return "", http.StatusBadRequest, fmt.Errorf("only creator or receiver can propose an arbiter")
+	}
+	if bet.OverallStatus != models.Undecided && bet.OverallStatus != models.Conflicted {
+		return "", http.StatusBadRequest, fmt.Errorf("bet is already closed")
+	}
+	if err := ctl.checkArbiter(ctx, bet.CreatorName, bet.ReceiverName, proposal.Arbiter); err != nil {
+		return "", http.StatusBadRequest, err
+	}
+
+	if proposal.Username == bet.CreatorName {
+		bet.CreatorArbiter = proposal.Arbiter
+	} else {
+		bet.ReceiverArbiter = proposal.Arbiter
+	}
+
+	msg := fmt.Sprintf("%s proposed %s as arbiter", proposal.Username, proposal.Arbiter)
+	if bet.CreatorArbiter == bet.ReceiverArbiter {
+		bet.Arbiter = proposal.Arbiter
+		msg = fmt.Sprintf("%s is now the arbiter for the bet between %s and %s", bet.Arbiter, bet.CreatorName, bet.ReceiverName)
+	}
+
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return msg, http.StatusOK, nil
+}
+
+// Lets the arbiter of a conflicted bet rule on it, which settles the bet as if both parties had agreed
+func (ctl *Controller) ArbitrateFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var ruling models.ArbiterRuling
+
+	if err := c.BindJSON(&ruling); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the arbiter making the ruling is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &ruling.Username); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.arbitrate(ctx, ruling)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+func (ctl *Controller) arbitrate(ctx context.Context, ruling models.ArbiterRuling) (string, int, error) {
+	bet, err := ctl.Bets.FindByID(ctx, ruling.BetID)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet ID %s not found", ruling.BetID.String())
+	}
+	if bet.Arbiter == "" || bet.Arbiter != ruling.Username {
+		return "", http.StatusBadRequest, fmt.Errorf("%s is not the arbiter for this bet", ruling.Username)
+	}
+	if bet.OverallStatus != models.Conflicted {
+		return "", http.StatusBadRequest, fmt.Errorf("only conflicted bets can be arbitrated")
+	}
+	if ruling.Ruling != models.CreatorWon && ruling.Ruling != models.ReceiverWon {
+		return "", http.StatusBadRequest, fmt.Errorf("ruling must be that the creator or the receiver won")
+	}
+
+	bet.OverallStatus = ruling.Ruling
+	if err := ctl.settleBet(ctx, &bet, "conflictedbets"); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if err := ctl.settleDerivedBets(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return fmt.Sprintf("%s settled the bet between %s and %s", bet.Arbiter, bet.CreatorName, bet.ReceiverName), http.StatusOK, nil
+}
@@ -0,0 +1,110 @@
+package controllers_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *harness) claim(user string, betID primitive.ObjectID, outcome models.BetStatus) string {
+	h.t.Helper()
+	out := h.must(http.StatusOK, user, "POST", "/bets/resolvebet", map[string]interface{}{"betid": betID, "betresolvestatus": outcome, "username": user})
+	return out["msg"].(string)
+}
+
+func TestArbiterSettlesConflict(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "judge")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "judge")
+
+	// The arbiter has to be friends with both sides
+	code, out := h.do("alice", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "alice", "receivername": "bob", "title": "Rain", "arbiter": "judge",
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	if code != http.StatusBadRequest {
+		t.Fatalf("bet with an arbiter bob does not know: %d %v", code, out)
+	}
+	h.befriend("bob", "judge")
+	betID := h.ongoingBet("alice", "bob")
+	propose := func(user, arbiter string) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/bets/proposearbiter", map[string]interface{}{"betid": betID, "username": user, "arbiter": arbiter})
+	}
+	arbitrate := func(user string, ruling models.BetStatus) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/bets/arbitrate", map[string]interface{}{"betid": betID, "username": user, "ruling": ruling})
+	}
+
+	if code, out := propose("alice", "alice"); code != http.StatusBadRequest {
+		t.Fatalf("alice proposed herself as arbiter: %d %v", code, out)
+	}
+	if code, out := propose("alice", "judge"); code != http.StatusOK || h.bet(betID).Arbiter != "" {
+		t.Fatalf("arbiter set on one side's say-so: %d %v", code, out)
+	}
+	if code, out := propose("bob", "judge"); code != http.StatusOK || h.bet(betID).Arbiter != "judge" {
+		t.Fatalf("arbiter not set once both agreed: %d %v", code, out)
+	}
+	if code, out := arbitrate("judge", models.ReceiverWon); code != http.StatusBadRequest {
+		t.Fatalf("arbitrated a bet that is not conflicted: %d %v", code, out)
+	}
+
+	h.claim("alice", betID, models.CreatorWon)
+	h.claim("bob", betID, models.ReceiverWon)
+	if status := h.bet(betID).OverallStatus; status != models.Conflicted {
+		t.Fatalf("bet status %d after opposing claims, want Conflicted", status)
+	}
+	if code, out := arbitrate("bob", models.ReceiverWon); code != http.StatusBadRequest {
+		t.Fatalf("bob arbitrated his own bet: %d %v", code, out)
+	}
+	if code, out := arbitrate("judge", models.Conflicted); code != http.StatusBadRequest {
+		t.Fatalf("ruling that the bet is conflicted: %d %v", code, out)
+	}
+	if code, out := arbitrate("judge", models.ReceiverWon); code != http.StatusOK {
+		t.Fatalf("judge's ruling: %d %v", code, out)
+	}
+
+	if status := h.bet(betID).OverallStatus; status != models.ReceiverWon {
+		t.Fatalf("bet status %d after the ruling, want ReceiverWon", status)
+	}
+	// bob put up 3 a share over 10 shares
+	if alice, bob := h.user("alice"), h.user("bob"); alice.TotalBalance != -30 || bob.TotalBalance != 30 {
+		t.Errorf("balances alice %d, bob %d, want -30 and 30", alice.TotalBalance, bob.TotalBalance)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if u := h.user(name); len(u.ConflictedBets) != 0 || !reflect.DeepEqual(u.ResolvedBets, []primitive.ObjectID{betID}) {
+			t.Errorf("%s has conflicted %v and resolved %v", name, u.ConflictedBets, u.ResolvedBets)
+		}
+	}
+	if code, out := arbitrate("judge", models.CreatorWon); code != http.StatusBadRequest {
+		t.Fatalf("arbitrated a settled bet: %d %v", code, out)
+	}
+}
+
+func TestRevisedClaimSettlesConflict(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	betID := h.ongoingBet("alice", "bob")
+
+	h.claim("alice", betID, models.CreatorWon)
+	h.claim("bob", betID, models.ReceiverWon)
+	if u := h.user("alice"); !reflect.DeepEqual(u.ConflictedBets, []primitive.ObjectID{betID}) || len(u.OngoingBets) != 0 {
+		t.Fatalf("alice has conflicted %v and ongoing %v", u.ConflictedBets, u.OngoingBets)
+	}
+	// bob sticking to his claim changes nothing, but alice conceding settles it
+	h.claim("bob", betID, models.ReceiverWon)
+	if status := h.bet(betID).OverallStatus; status != models.Conflicted {
+		t.Fatalf("bet status %d, want Conflicted", status)
+	}
+	h.claim("alice", betID, models.ReceiverWon)
+	if status := h.bet(betID).OverallStatus; status != models.ReceiverWon {
+		t.Fatalf("bet status %d after alice conceded, want ReceiverWon", status)
+	}
+	if bob := h.user("bob"); bob.TotalBalance != 30 || len(bob.ConflictedBets) != 0 {
+		t.Errorf("bob has balance %d and conflicted bets %v", bob.TotalBalance, bob.ConflictedBets)
+	}
+}
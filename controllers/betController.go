@@ -2,77 +2,118 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/simhonchourasia/betfr-be/authentication"
 	"github.com/simhonchourasia/betfr-be/config"
-	"github.com/simhonchourasia/betfr-be/database"
 	"github.com/simhonchourasia/betfr-be/models"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var betCollection *mongo.Collection = database.OpenCollection(database.Client, config.GlobalConfig.BetCollection)
-
 // Pass in creator name, receiver name, creator amount, receiver amount, underlying, title, description, expiry date
-// Underlying can also be passed in, if appropriate
-var CreateBetReqFunc gin.HandlerFunc = func(c *gin.Context) {
+// Conditional bets also pass in an underlying bet and condition, and parlays pass in their legs
+func (ctl *Controller) CreateBetReqFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	var bet models.Bet
+	var betReq models.Bet
 
-	if err := c.BindJSON(&bet); err != nil {
+	if err := c.BindJSON(&betReq); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Check that the user sending the bet request is the one logged in
-	if permissionErr := authentication.CheckUserPermissions(c, &bet.CreatorName); permissionErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": permissionErr.Error()})
+	if permissionErr := authentication.CheckUserPermissions(c, &betReq.CreatorName); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
 		return
 	}
 
-	fmt.Printf("\naslkdfjlk\n")
+	var bet models.Bet
+	status := http.StatusOK
+	err := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		bet, status, err = ctl.createBetReq(ctx, betReq)
+		if err != nil {
+			return err
+		}
+		return ctl.Bus.Publish(ctx, betRequestEvent(bet))
+	})
+	if err != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
-	fmt.Printf("%v\n", bet)
+	c.JSON(http.StatusOK, gin.H{"InsertedID": bet.ID})
+}
 
+// Checks and inserts a new bet request from bet.CreatorName, who must already be known to be the one sending it
+// Shared by the handler and by scheduled bets; must be run inside a transaction, and the caller publishes the event for it
+func (ctl *Controller) createBetReq(ctx context.Context, bet models.Bet) (models.Bet, int, error) {
 	if validationErr := validate.Struct(bet); validationErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-		return
+		return bet, http.StatusBadRequest, validationErr
 	}
 
-	update := bson.D{
-		{Key: "$inc", Value: bson.M{"numbets": 1}},
+	if bet.Arbiter != "" {
+		if err := ctl.checkArbiter(ctx, bet.CreatorName, bet.ReceiverName, bet.Arbiter); err != nil {
+			return bet, http.StatusBadRequest, err
+		}
 	}
-	creatorUser := userCollection.FindOneAndUpdate(ctx, bson.M{"username": bet.CreatorName}, update)
-	if creatorUser.Err() != nil {
-		fmt.Printf("%s\n", creatorUser.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet creator %s not found", bet.CreatorName)})
-		return
+
+	// Deleted accounts keep their username but cannot take part in new bets
+	if receiver, err := ctl.Users.FindByUsername(ctx, bet.ReceiverName); err == nil {
+		if receiver.Deleted {
+			return bet, http.StatusBadRequest, fmt.Errorf("Bet receiver %s not found", bet.ReceiverName)
+		}
+		if containsString(receiver.BlockedUsers, bet.CreatorName) {
+			return bet, http.StatusForbidden, fmt.Errorf("can't send bet request to %s", bet.ReceiverName)
+		}
 	}
-	receiverUser := userCollection.FindOneAndUpdate(ctx, bson.M{"username": bet.ReceiverName}, update)
-	if receiverUser.Err() != nil {
-		fmt.Printf("%s\n", receiverUser.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet receiver %s not found", bet.ReceiverName)})
-		return
+	if creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName); err == nil {
+		if containsString(creator.BlockedUsers, bet.ReceiverName) {
+			return bet, http.StatusBadRequest, fmt.Errorf("unblock %s before sending them a bet request", bet.ReceiverName)
+		}
+		// Friendship is mutual, so the creator's list is enough
+		if !config.GlobalConfig.AllowNonFriendBetReqs && !containsString(creator.Friends, bet.ReceiverName) {
+			return bet, http.StatusForbidden, fmt.Errorf("can only send bet requests to friends, and %s is not a friend", bet.ReceiverName)
+		}
 	}
 
-	var creator models.User
-	if err := creatorUser.Decode(&creator); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if bet.Visibility < models.FriendsOfParticipants || bet.Visibility > models.Public {
+		return bet, http.StatusBadRequest, fmt.Errorf("invalid bet visibility %d", bet.Visibility)
 	}
-	var receiver models.User
-	if err := receiverUser.Decode(&receiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if bet.Underlying != nil && *bet.Underlying == "" {
+		bet.Underlying = nil
+	}
+	if err := validateBetType(bet); err != nil {
+		return bet, http.StatusBadRequest, err
+	}
+	if err := ctl.checkDerived(ctx, bet); err != nil {
+		return bet, http.StatusBadRequest, err
+	}
+	now := time.Now()
+	if bet.ExpiryDate.Time().Before(now.Add(5 * time.Minute)) {
+		return bet, http.StatusBadRequest, errors.New("Bets cannot be created with less than 5 minutes to expiry upon creation")
+	}
+
+	creator, err := ctl.Users.IncrementNumBets(ctx, bet.CreatorName)
+	if err != nil {
+		log.Printf("Could not count bet for creator %s: %v\n", bet.CreatorName, err)
+		return bet, http.StatusInternalServerError, fmt.Errorf("Bet creator %s not found", bet.CreatorName)
+	}
+	receiver, err := ctl.Users.IncrementNumBets(ctx, bet.ReceiverName)
+	if err != nil {
+		log.Printf("Could not count bet for receiver %s: %v\n", bet.ReceiverName, err)
+		return bet, http.StatusInternalServerError, fmt.Errorf("Bet receiver %s not found", bet.ReceiverName)
 	}
 
 	bet.ID = primitive.NewObjectID()
@@ -81,6 +122,9 @@ var CreateBetReqFunc gin.HandlerFunc = func(c *gin.Context) {
 	bet.OverallStatus = models.Undecided
 	bet.CreatorStatus = models.Undecided
 	bet.ReceiverStatus = models.Undecided
+	bet.CreatorResult = nil
+	bet.ReceiverResult = nil
+	bet.Activated = false
 	bet.CreatorStaked = 0
 	bet.ReceiverStaked = 0
 	if bet.NumShares == 0 {
@@ -90,77 +134,66 @@ var CreateBetReqFunc gin.HandlerFunc = func(c *gin.Context) {
 	bet.ReceiverStakedUnfilled = 0
 	bet.CreatorStakes = make([]primitive.ObjectID, 0)
 	bet.ReceiverStakes = make([]primitive.ObjectID, 0)
-	bet.CreateDate = primitive.NewDateTimeFromTime(time.Now())
+	bet.CreateDate = primitive.NewDateTimeFromTime(now)
+	bet.AwaitingResponse = bet.ReceiverName
+	bet.CreatorCancel = false
+	bet.ReceiverCancel = false
+	// Accepting the request also accepts any arbiter named in it
+	bet.CreatorArbiter = bet.Arbiter
+	bet.ReceiverArbiter = bet.Arbiter
+	bet.Negotiation = []models.BetTerms{{
+		ProposedBy:     bet.CreatorName,
+		CreatorAmount:  bet.CreatorAmount,
+		ReceiverAmount: bet.ReceiverAmount,
+		NumShares:      bet.NumShares,
+		ExpiryDate:     bet.ExpiryDate,
+		ProposeDate:    bet.CreateDate,
+	}}
 
-	if bet.ExpiryDate.Time().Before(bet.CreateDate.Time().Add(5 * time.Minute)) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bets cannot be created with less than 5 minutes to expiry upon creation"})
+	if err := ctl.Bets.Insert(ctx, bet); err != nil {
+		return bet, http.StatusInternalServerError, errors.New("Bet creation unsuccessful")
 	}
 
-	res, err := betCollection.InsertOne(ctx, bet)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Bet creation unsuccessful"})
-		return
-	}
-
-	betObjectId := (res.InsertedID).(primitive.ObjectID)
-	updateCreatorBet := bson.D{
-		{Key: "$push", Value: bson.M{"outgoingbetreqs": betObjectId}},
+	updateCreatorBet := models.UpdateUserHelperStruct{
+		Username:  bet.CreatorName,
+		Operation: "$push",
+		Field:     "outgoingbetreqs",
+		IdVal:     bet.ID,
 	}
-	updateReceiverBet := bson.D{
-		{Key: "$push", Value: bson.M{"incomingbetreqs": betObjectId}},
+	if err := ctl.UpdateBetHelper(ctx, updateCreatorBet); err != nil {
+		log.Printf("Could not add bet request to creator %s: %v\n", bet.CreatorName, err)
+		return bet, http.StatusInternalServerError, fmt.Errorf("Bet creator %s not found", bet.CreatorName)
 	}
-	creatorUser = userCollection.FindOneAndUpdate(ctx, bson.M{"username": bet.CreatorName}, updateCreatorBet)
-	if creatorUser.Err() != nil {
-		fmt.Printf("%s\n", creatorUser.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet creator %s not found", bet.CreatorName)})
-		return
+	updateReceiverBet := models.UpdateUserHelperStruct{
+		Username:  bet.ReceiverName,
+		Operation: "$push",
+		Field:     "incomingbetreqs",
+		IdVal:     bet.ID,
 	}
-	receiverUser = userCollection.FindOneAndUpdate(ctx, bson.M{"username": bet.ReceiverName}, updateReceiverBet)
-	if receiverUser.Err() != nil {
-		fmt.Printf("%s\n", receiverUser.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet receiver %s not found", bet.ReceiverName)})
-		return
+	if err := ctl.UpdateBetHelper(ctx, updateReceiverBet); err != nil {
+		log.Printf("Could not add bet request to receiver %s: %v\n", bet.ReceiverName, err)
+		return bet, http.StatusInternalServerError, fmt.Errorf("Bet receiver %s not found", bet.ReceiverName)
 	}
 
-	c.JSON(http.StatusOK, res)
+	return bet, http.StatusOK, nil
 }
 
-// Helper function to be used in handling bet requests
-func UpdateBetHelper(ctx context.Context, friendUpdate models.UpdateUserHelperStruct) error {
-	fmt.Printf("update bet: %v\n", friendUpdate)
-	upsert := true
-	filter := bson.M{"username": friendUpdate.Username}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	var update primitive.M
-	if friendUpdate.Operation == "$pullAll" {
-		toRemove := []interface{}{friendUpdate.IdVal}
-		update = bson.M{friendUpdate.Field: toRemove}
-	} else {
-		update = bson.M{friendUpdate.Field: friendUpdate.IdVal}
-	}
-
-	res, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{{Key: friendUpdate.Operation, Value: update}},
-		&opt,
-	)
-
-	if err != nil {
-		log.Panic(err)
-		return err
+func betRequestEvent(bet models.Bet) models.Event {
+	return models.Event{
+		Type:     models.BetRequestCreated,
+		Audience: betAudience(bet),
+		Actor:    bet.CreatorName,
+		Target:   bet.ReceiverName,
+		BetID:    &bet.ID,
 	}
+}
 
-	if res.MatchedCount == 0 {
-		return fmt.Errorf("tried to handle friend request for invalid user %s", friendUpdate.Username)
-	}
-	return nil
+// Helper function to be used in handling bet requests
+func (ctl *Controller) UpdateBetHelper(ctx context.Context, friendUpdate models.UpdateUserHelperStruct) error {
+	return ctl.Users.UpdateList(ctx, friendUpdate)
 }
 
-var HandleBetReqFunc gin.HandlerFunc = func(c *gin.Context) {
+func (ctl *Controller) HandleBetReqFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
@@ -171,169 +204,234 @@ var HandleBetReqFunc gin.HandlerFunc = func(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("%v\n", betReqHandle)
-
 	if validationErr := validate.Struct(betReqHandle); validationErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 		return
 	}
 
-	betId := betReqHandle.BetID
-	betResult := betCollection.FindOne(ctx, bson.M{"_id": betId})
-	var bet models.Bet
-	if betResult.Err() != nil {
-		fmt.Println(betResult.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet ID %s not found", betId.String())})
-		return
-	}
-	if err := betResult.Decode(&bet); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Check that the user accepting the bet request is the one logged in
-	if permissionErr := authentication.CheckUserPermissions(c, &bet.ReceiverName); permissionErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": permissionErr.Error()})
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.handleBetReq(ctx, betReqHandle, username)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
 		return
 	}
 
-	var msg string
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
 
-	if betReqHandle.BetReqStatus == models.Unchanged {
-		msg = fmt.Sprintf("Unchanged bet: %s", bet.Title)
-		c.JSON(http.StatusOK, gin.H{"msg": msg})
-		return
+// The party who has to answer a pending bet request
+// This is the receiver until a counter-offer swaps the roles
+func pendingResponder(bet models.Bet) string {
+	if bet.AwaitingResponse == "" {
+		return bet.ReceiverName
 	}
-	if betReqHandle.BetReqStatus != models.Accepted && betReqHandle.BetReqStatus != models.Declined {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "bad bet req status used"})
-		return
+	return bet.AwaitingResponse
+}
+
+// The party whose terms are currently on the table
+func pendingProposer(bet models.Bet) string {
+	if pendingResponder(bet) == bet.CreatorName {
+		return bet.ReceiverName
 	}
+	return bet.CreatorName
+}
 
-	// first check that the bet request is in the outgoing of creator and incoming of receiver
-	creatorRes := userCollection.FindOne(ctx, bson.M{"username": bet.CreatorName})
-	if creatorRes.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet creator %s not found", bet.CreatorName)})
+// Accepts, declines or counters a pending bet request on behalf of whoever has to answer it
+func (ctl *Controller) handleBetReq(ctx context.Context, betReqHandle models.BetReqHandle, username string) (string, int, error) {
+	betId := betReqHandle.BetID
+	bet, err := ctl.Bets.FindByID(ctx, betId)
+	if err != nil {
+		log.Printf("Could not load bet %s: %v\n", betId.Hex(), err)
+		return "", http.StatusInternalServerError, fmt.Errorf("bet ID %s not found", betId.String())
 	}
-	receiverRes := userCollection.FindOne(ctx, bson.M{"username": bet.ReceiverName})
-	if receiverRes.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet creator %s not found", bet.ReceiverName)})
-		return
+
+	// Check that the user answering the bet request is the one it is waiting on
+	responderName := pendingResponder(bet)
+	proposerName := pendingProposer(bet)
+	if responderName != username {
+		return "", http.StatusBadRequest, fmt.Errorf("current user %s is not the one this bet request is waiting on", username)
 	}
-	var creator models.User
-	if err := creatorRes.Decode(&creator); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+
+	if betReqHandle.BetReqStatus == models.Unchanged {
+		return fmt.Sprintf("Unchanged bet: %s", bet.Title), http.StatusOK, nil
 	}
-	var receiver models.User
-	if err := receiverRes.Decode(&receiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if betReqHandle.BetReqStatus != models.Accepted && betReqHandle.BetReqStatus != models.Declined && betReqHandle.BetReqStatus != models.CounterOffered {
+		return "", http.StatusBadRequest, fmt.Errorf("bad bet req status used")
 	}
-	for _, v := range creator.OngoingBets {
-		if v == betId {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "bet is already ongoing"})
-			return
-		}
+	if betReqHandle.BetReqStatus == models.Accepted && (bet.OverallStatus == models.Expired || bet.ExpiryDate.Time().Before(time.Now())) {
+		return "", http.StatusBadRequest, fmt.Errorf("bet request has expired")
 	}
-	for _, v := range receiver.OngoingBets {
-		if v == betId {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "bet is already ongoingr"})
-			return
-		}
+
+	// first check that the bet request is in the outgoing of the proposer and incoming of the responder
+	proposer, err := ctl.Users.FindByUsername(ctx, proposerName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet party %s not found", proposerName)
 	}
-	sent := false
-	received := false
-	for _, v := range creator.OutgoingBetReqs {
-		if v == betId {
-			sent = true
-			break
-		}
+	responder, err := ctl.Users.FindByUsername(ctx, responderName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet party %s not found", responderName)
 	}
-	if !sent {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "trying to handle bet request that was not sent"})
-		return
+	if containsID(proposer.OngoingBets, betId) || containsID(responder.OngoingBets, betId) {
+		return "", http.StatusBadRequest, fmt.Errorf("bet is already ongoing")
 	}
-	for _, v := range receiver.IncomingBetReqs {
-		if v == betId {
-			received = true
-			break
-		}
+	if !containsID(proposer.OutgoingBetReqs, betId) {
+		return "", http.StatusBadRequest, fmt.Errorf("trying to handle bet request that was not sent")
 	}
-	if !received {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "trying to handle bet request that was not received"})
-		return
+	if !containsID(responder.IncomingBetReqs, betId) {
+		return "", http.StatusBadRequest, fmt.Errorf("trying to handle bet request that was not received")
 	}
 
-	// after checking, remove from the creator and receiver incoming/outgoing bet reqs
-	updateCreator := models.UpdateUserHelperStruct{
-		Username:  bet.CreatorName,
+	// after checking, remove from the proposer and responder incoming/outgoing bet reqs
+	updateProposer := models.UpdateUserHelperStruct{
+		Username:  proposerName,
 		Operation: "$pullAll",
 		Field:     "outgoingbetreqs",
 		IdVal:     betId,
 	}
-	if err := UpdateBetHelper(ctx, updateCreator); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := ctl.UpdateBetHelper(ctx, updateProposer); err != nil {
+		return "", http.StatusInternalServerError, err
 	}
-	updateReceiver := models.UpdateUserHelperStruct{
-		Username:  bet.ReceiverName,
+	updateResponder := models.UpdateUserHelperStruct{
+		Username:  responderName,
 		Operation: "$pullAll",
 		Field:     "incomingbetreqs",
 		IdVal:     betId,
 	}
-	if err := UpdateBetHelper(ctx, updateReceiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := ctl.UpdateBetHelper(ctx, updateResponder); err != nil {
+		return "", http.StatusInternalServerError, err
 	}
 
+	var msg string
+
 	// Then, if accepted, add to ongoing bets
 	// (the check that the bet hasn't been set with an expiry date in the past should be in creation)
 	if betReqHandle.BetReqStatus == models.Accepted {
-		updateCreator = models.UpdateUserHelperStruct{
-			Username:  bet.CreatorName,
+		updateProposer = models.UpdateUserHelperStruct{
+			Username:  proposerName,
 			Operation: "$push",
 			Field:     "ongoingbets",
 			IdVal:     betId,
 		}
-		if err := UpdateBetHelper(ctx, updateCreator); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.UpdateBetHelper(ctx, updateProposer); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-		updateReceiver = models.UpdateUserHelperStruct{
-			Username:  bet.ReceiverName,
+		updateResponder = models.UpdateUserHelperStruct{
+			Username:  responderName,
 			Operation: "$push",
 			Field:     "ongoingbets",
 			IdVal:     betId,
 		}
-		if err := UpdateBetHelper(ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.UpdateBetHelper(ctx, updateResponder); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
 		msg = fmt.Sprintf("Added bet between %s and %s", bet.CreatorName, bet.ReceiverName)
 	} else if betReqHandle.BetReqStatus == models.Declined {
 		msg = fmt.Sprintf("Declined bet request between %s and %s", bet.CreatorName, bet.ReceiverName)
+	} else if betReqHandle.BetReqStatus == models.CounterOffered {
+		// The responder's terms go on the table and the request goes back the other way
+		if err := counterBetTerms(&bet, betReqHandle.CounterTerms, responderName); err != nil {
+			return "", http.StatusBadRequest, err
+		}
+		updateProposer = models.UpdateUserHelperStruct{
+			Username:  proposerName,
+			Operation: "$push",
+			Field:     "incomingbetreqs",
+			IdVal:     betId,
+		}
+		if err := ctl.UpdateBetHelper(ctx, updateProposer); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		updateResponder = models.UpdateUserHelperStruct{
+			Username:  responderName,
+			Operation: "$push",
+			Field:     "outgoingbetreqs",
+			IdVal:     betId,
+		}
+		if err := ctl.UpdateBetHelper(ctx, updateResponder); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		bet.AwaitingResponse = proposerName
+		if err := ctl.Bets.Replace(ctx, bet); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		msg = fmt.Sprintf("Sent counter-offer from %s to %s", responderName, proposerName)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"msg": msg})
+	eventTypes := map[models.RequestStatus]models.EventType{
+		models.Accepted:       models.BetRequestAccepted,
+		models.Declined:       models.BetRequestDeclined,
+		models.CounterOffered: models.BetRequestCountered,
+	}
+	event := models.Event{
+		Type:     eventTypes[betReqHandle.BetReqStatus],
+		Audience: betAudience(bet),
+		Actor:    responderName,
+		Target:   proposerName,
+		BetID:    &betId,
+	}
+	if err := ctl.Bus.Publish(ctx, event); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	return msg, http.StatusOK, nil
 }
 
-var ResolveBetFunc gin.HandlerFunc = func(c *gin.Context) {
+// Validates a counter-offer and makes it the bet's current terms, recording it in the negotiation history
+func counterBetTerms(bet *models.Bet, terms *models.BetTerms, proposedBy string) error {
+	if terms == nil {
+		return fmt.Errorf("counter-offer is missing its terms")
+	}
+	if terms.CreatorAmount <= 0 || terms.ReceiverAmount <= 0 || terms.NumShares <= 0 {
+		return fmt.Errorf("counter-offer amounts and number of shares must be positive")
+	}
+	now := time.Now()
+	if terms.ExpiryDate.Time().Before(now.Add(5 * time.Minute)) {
+		return fmt.Errorf("counter-offers cannot have less than 5 minutes to expiry")
+	}
+
+	bet.CreatorAmount = terms.CreatorAmount
+	bet.ReceiverAmount = terms.ReceiverAmount
+	bet.NumShares = terms.NumShares
+	bet.ExpiryDate = terms.ExpiryDate
+	bet.Negotiation = append(bet.Negotiation, models.BetTerms{
+		ProposedBy:     proposedBy,
+		CreatorAmount:  terms.CreatorAmount,
+		ReceiverAmount: terms.ReceiverAmount,
+		NumShares:      terms.NumShares,
+		ExpiryDate:     terms.ExpiryDate,
+		ProposeDate:    primitive.NewDateTimeFromTime(now),
+	})
+	return nil
+}
+
+func (ctl *Controller) ResolveBetFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	msg := "ok"
 	var betResolve models.BetResolve
 
 	if err := c.BindJSON(&betResolve); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	fmt.Printf("%v\n", betResolve)
 
 	// Check that the user modifying the bet request is the one logged in
 	if permissionErr := authentication.CheckUserPermissions(c, &betResolve.Username); permissionErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": permissionErr.Error()})
-		fmt.Printf("bet request modifier is not the one logged in\n")
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
 		return
 	}
 
@@ -342,64 +440,101 @@ var ResolveBetFunc gin.HandlerFunc = func(c *gin.Context) {
 		return
 	}
 
-	betResult := betCollection.FindOne(ctx, bson.M{"_id": betResolve.BetID})
-	var bet models.Bet
-	if betResult.Err() != nil {
-		fmt.Println(betResult.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet ID %s not found", betResolve.BetID.String())})
+	// Every write below happens in one transaction, so the bet is either fully settled or left untouched
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.resolveBet(ctx, betResolve)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
 		return
 	}
-	if err := betResult.Decode(&bet); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+// Records one party's claim on a bet, settling balances and stakes once both claims agree
+// Returns the HTTP status to respond with alongside any error
+func (ctl *Controller) resolveBet(ctx context.Context, betResolve models.BetResolve) (string, int, error) {
+	msg := "ok"
+
+	bet, err := ctl.Bets.FindByID(ctx, betResolve.BetID)
+	if err != nil {
+		log.Printf("Could not load bet %s: %v\n", betResolve.BetID.Hex(), err)
+		return "", http.StatusInternalServerError, fmt.Errorf("bet ID %s not found", betResolve.BetID.String())
 	}
 
-	// Ensure that only one of the two members of the bet can provide updates for it
-	creatorPermissible := authentication.CheckUserPermissions(c, &bet.CreatorName)
-	receiverPermissible := authentication.CheckUserPermissions(c, &bet.ReceiverName)
-	if creatorPermissible != nil && receiverPermissible != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": creatorPermissible.Error()})
-		fmt.Printf("logged in user is not one of the bettors\n")
-		return
+	if bet.OverallStatus == models.Expired {
+		return "", http.StatusBadRequest, fmt.Errorf("bet has expired")
+	}
+	if bet.OverallStatus == models.Cancelled || bet.OverallStatus == models.Voided {
+		return "", http.StatusBadRequest, fmt.Errorf("bet has been called off")
+	}
+	if bet.Type == models.Parlay {
+		return "", http.StatusBadRequest, fmt.Errorf("parlays are settled automatically once their legs resolve")
+	}
+	if bet.Type == models.Conditional && !bet.Activated {
+		return "", http.StatusBadRequest, fmt.Errorf("conditional bet cannot be resolved until its underlying bet resolves as required")
 	}
 
+	// Ensure that only one of the two members of the bet can provide updates for it
 	if bet.CreatorName != betResolve.Username && bet.ReceiverName != betResolve.Username {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "only creator or receiver can provide a resolve update"})
-		return
+		return "", http.StatusBadRequest, fmt.Errorf("only creator or receiver can provide a resolve update")
 	}
 	// First make sure that the bet isn't already resolved (can't change a resolved bet)
-	creatorRes := userCollection.FindOne(ctx, bson.M{"username": bet.CreatorName})
-	if creatorRes.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet creator %s not found", bet.CreatorName)})
-	}
-	receiverRes := userCollection.FindOne(ctx, bson.M{"username": bet.ReceiverName})
-	if receiverRes.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Bet creator %s not found", bet.ReceiverName)})
-		return
-	}
-	var creator models.User
-	if err := creatorRes.Decode(&creator); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet creator %s not found", bet.CreatorName)
 	}
-	var receiver models.User
-	if err := receiverRes.Decode(&receiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	receiver, err := ctl.Users.FindByUsername(ctx, bet.ReceiverName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet receiver %s not found", bet.ReceiverName)
 	}
 	for _, v := range creator.ResolvedBets {
 		if v == bet.ID {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "bet is already resolved"})
-			return
+			return "", http.StatusBadRequest, fmt.Errorf("bet is already resolved")
 		}
 	}
 	for _, v := range receiver.ResolvedBets {
 		if v == bet.ID {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "bet is already resolved"})
-			return
+			return "", http.StatusBadRequest, fmt.Errorf("bet is already resolved")
 		}
 	}
 	// Assume then that the bet is ongoing or conflicted
+	wasConflicted := bet.OverallStatus == models.Conflicted
+	if !wasConflicted && !containsID(creator.OngoingBets, bet.ID) {
+		return "", http.StatusBadRequest, fmt.Errorf("bet has not been accepted yet")
+	}
+	// Conflicted bets sit in the conflicted list; parties can revise their claims there until they agree
+	currentList := "ongoingbets"
+	if wasConflicted {
+		currentList = "conflictedbets"
+	}
+
+	if bet.Type == models.OverUnder {
+		// The winner follows from the reported number, so any claimed winner is ignored
+		if betResolve.Result == nil || !finite(*betResolve.Result) {
+			return "", http.StatusBadRequest, fmt.Errorf("over/under bets are resolved by reporting the result")
+		}
+		result := *betResolve.Result
+		betResolve.BetResolveStatus = bet.OverUnderOutcome(result)
+		if betResolve.Username == bet.CreatorName {
+			bet.CreatorResult = &result
+		} else {
+			bet.ReceiverResult = &result
+		}
+	} else if betResolve.Result != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("only over/under bets are resolved by reporting a result")
+	} else if claim := betResolve.BetResolveStatus; claim != models.Undecided && claim != models.CreatorWon && claim != models.ReceiverWon {
+		// Expiring, cancelling and voiding have their own paths, which also close the bet's stakes
+		return "", http.StatusBadRequest, fmt.Errorf("can only claim that the creator or the receiver won")
+	}
 
 	// In any case, update the CreatorStatus/ReceiverStatus
 	bothStatusDecided := false
@@ -416,124 +551,503 @@ var ResolveBetFunc gin.HandlerFunc = func(c *gin.Context) {
 		}
 	}
 	log.Printf("Both status decided for bet resolve: %t\n", bothStatusDecided)
+	if !bothStatusDecided && betResolve.BetResolveStatus != models.Undecided {
+		// The other party needs to confirm or dispute the claim
+		counterparty := bet.ReceiverName
+		if betResolve.Username == bet.ReceiverName {
+			counterparty = bet.CreatorName
+		}
+		event := models.Event{
+			Type:     models.BetResolutionClaimed,
+			Audience: betAudience(bet),
+			Actor:    betResolve.Username,
+			Target:   counterparty,
+			BetID:    &bet.ID,
+			Outcome:  betResolve.BetResolveStatus,
+			Result:   betResolve.Result,
+		}
+		if err := ctl.Bus.Publish(ctx, event); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+	}
 	if bothStatusDecided {
-		if bet.ReceiverStatus != bet.CreatorStatus {
+		// Over/under reports have to agree on the number itself, not just on who it makes the winner
+		if bet.ReceiverStatus != bet.CreatorStatus || (bet.Type == models.OverUnder && *bet.CreatorResult != *bet.ReceiverResult) {
 			bet.OverallStatus = models.Conflicted
 		} else {
 			// bet is fully resolved
 			bet.OverallStatus = bet.CreatorStatus
 		}
-		// Either way, remove from ongoing bets (either conflicted or resolved)
-		// Remove from ongoing bets
-		updateCreator := models.UpdateUserHelperStruct{
-			Username:  bet.CreatorName,
-			Operation: "$pullAll",
-			Field:     "ongoingbets",
-			IdVal:     bet.ID,
+	}
+
+	// If the other person already provided a status, and if they match, move the bet to the resolved list and change balances
+	if bet.OverallStatus == models.CreatorWon || bet.OverallStatus == models.ReceiverWon {
+		if err := ctl.settleBet(ctx, &bet, currentList); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		msg = fmt.Sprintf("Resolved bet between %s and %s", bet.CreatorName, bet.ReceiverName)
+	}
+
+	// An over/under result exactly on the line is a push, which calls the bet off
+	if bet.OverallStatus == models.Voided {
+		if err := ctl.voidBet(ctx, &bet, currentList); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		msg = fmt.Sprintf("Voided bet between %s and %s, since the result landed on the line", bet.CreatorName, bet.ReceiverName)
+	}
+
+	// if the other person already provided a status, and if they don't match, move to the conflicted list
+	if bet.OverallStatus == models.Conflicted && !wasConflicted {
+		event := models.Event{
+			Type:     models.BetConflicted,
+			Audience: betAudience(bet),
+			Actor:    betResolve.Username,
+			BetID:    &bet.ID,
+		}
+		if err := ctl.Bus.Publish(ctx, event); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		for _, username := range []string{bet.CreatorName, bet.ReceiverName} {
+			update := models.UpdateUserHelperStruct{
+				Username:  username,
+				Operation: "$pullAll",
+				Field:     "ongoingbets",
+				IdVal:     bet.ID,
+			}
+			if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+				return "", http.StatusInternalServerError, err
+			}
+			update.Operation = "$push"
+			update.Field = "conflictedbets"
+			if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+				return "", http.StatusInternalServerError, err
+			}
 		}
-		if err := UpdateBetHelper(ctx, updateCreator); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	}
+	if bet.OverallStatus == models.Conflicted {
+		msg = fmt.Sprintf("Conflicted bet between %s and %s", bet.CreatorName, bet.ReceiverName)
+	}
+
+	// Finally, update the bet itself
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		log.Printf("Could not update bet when trying to resolve\n")
+		return "", http.StatusInternalServerError, err
+	}
+	if bet.OverallStatus == models.CreatorWon || bet.OverallStatus == models.ReceiverWon || bet.OverallStatus == models.Voided {
+		if err := ctl.settleDerivedBets(ctx, bet); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-		updateReceiver := models.UpdateUserHelperStruct{
-			Username:  bet.ReceiverName,
+	}
+
+	return msg, http.StatusOK, nil
+}
+
+// Pays out a bet whose OverallStatus has been decided, moving it from fromList to the resolved list
+// fromList is "ongoingbets" or "conflictedbets"; the caller persists the bet itself
+func (ctl *Controller) settleBet(ctx context.Context, bet *models.Bet, fromList string) error {
+	for _, username := range []string{bet.CreatorName, bet.ReceiverName} {
+		update := models.UpdateUserHelperStruct{
+			Username:  username,
 			Operation: "$pullAll",
-			Field:     "ongoingbets",
+			Field:     fromList,
 			IdVal:     bet.ID,
 		}
-		if err := UpdateBetHelper(ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+			return err
+		}
+		update.Operation = "$push"
+		update.Field = "resolvedbets"
+		if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+			return err
 		}
 	}
 
-	// If the other person already provided a status, and if they match, move the bet to the resolved list and change balances
-	if bet.OverallStatus == models.CreatorWon || bet.OverallStatus == models.ReceiverWon {
-		// Add to resolved bets
-		updateCreator := models.UpdateUserHelperStruct{
-			Username:  bet.CreatorName,
-			Operation: "$push",
-			Field:     "resolvedbets",
-			IdVal:     bet.ID,
+	// Handle balances for the winner and loser
+	var balanceErr error
+	if bet.OverallStatus == models.CreatorWon {
+		balanceErr = ctl.transferBalance(ctx, bet.ReceiverName, bet.CreatorName, bet.CreatorAmount*bet.NumShares, bet.ID, primitive.NilObjectID)
+	} else if bet.OverallStatus == models.ReceiverWon {
+		balanceErr = ctl.transferBalance(ctx, bet.CreatorName, bet.ReceiverName, bet.ReceiverAmount*bet.NumShares, bet.ID, primitive.NilObjectID)
+	} else {
+		balanceErr = fmt.Errorf("cannot settle bet %s without a winner", bet.ID.Hex())
+	}
+	if balanceErr != nil {
+		return balanceErr
+	}
+
+	// Go over stakes and change balances accordingly
+	if err := ctl.PayoutStakes(ctx, bet); err != nil {
+		return err
+	}
+
+	// Stake owners hear about the result as well as the bet's parties
+	audience := betAudience(*bet)
+	stakes, err := ctl.Stakes.FindByUnderlying(ctx, bet.ID)
+	if err != nil {
+		return err
+	}
+	for _, stake := range stakes {
+		if !stake.Voided && !containsString(audience, stake.OwnerName) {
+			audience = append(audience, stake.OwnerName)
 		}
-		if err := UpdateBetHelper(ctx, updateCreator); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	}
+	winner := bet.CreatorName
+	if bet.OverallStatus == models.ReceiverWon {
+		winner = bet.ReceiverName
+	}
+	event := models.Event{
+		Type:     models.BetResolved,
+		Audience: audience,
+		Target:   winner,
+		BetID:    &bet.ID,
+		Outcome:  bet.OverallStatus,
+	}
+	return ctl.Bus.Publish(ctx, event)
+}
+
+// Closes every undecided bet whose expiry date has passed
+// Pending requests are auto-declined, and ongoing bets become Expired with their stakes voided
+// Returns the number of bets closed
+func (ctl *Controller) SweepExpiredBets(ctx context.Context, now time.Time) (int, error) {
+	bets, err := ctl.Bets.FindExpired(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for _, bet := range bets {
+		betID := bet.ID
+		err := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+			return ctl.expireBet(ctx, betID, now)
+		})
+		if err != nil {
+			log.Printf("Could not expire bet %s: %v\n", betID.Hex(), err)
+			continue
 		}
-		updateReceiver := models.UpdateUserHelperStruct{
-			Username:  bet.ReceiverName,
-			Operation: "$push",
-			Field:     "resolvedbets",
-			IdVal:     bet.ID,
+		closed++
+	}
+	return closed, nil
+}
+
+// Re-reads the bet so that a resolution racing with the sweep is respected
+func (ctl *Controller) expireBet(ctx context.Context, betID primitive.ObjectID, now time.Time) error {
+	bet, err := ctl.Bets.FindByID(ctx, betID)
+	if err != nil {
+		return err
+	}
+	if bet.OverallStatus != models.Undecided || !bet.ExpiryDate.Time().Before(now) {
+		return nil
+	}
+
+	creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName)
+	if err != nil {
+		return fmt.Errorf("bet creator %s not found", bet.CreatorName)
+	}
+	receiver, err := ctl.Users.FindByUsername(ctx, bet.ReceiverName)
+	if err != nil {
+		return fmt.Errorf("bet receiver %s not found", bet.ReceiverName)
+	}
+
+	// Unaccepted requests are declined on the responder's behalf
+	// A counter-offer may have swapped the lists, so clear both sides from both parties
+	pending := containsID(creator.OutgoingBetReqs, bet.ID) || containsID(creator.IncomingBetReqs, bet.ID) ||
+		containsID(receiver.OutgoingBetReqs, bet.ID) || containsID(receiver.IncomingBetReqs, bet.ID)
+	if pending {
+		for _, username := range []string{bet.CreatorName, bet.ReceiverName} {
+			for _, field := range []string{"outgoingbetreqs", "incomingbetreqs"} {
+				update := models.UpdateUserHelperStruct{
+					Username:  username,
+					Operation: "$pullAll",
+					Field:     field,
+					IdVal:     bet.ID,
+				}
+				if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+					return err
+				}
+			}
 		}
-		if err := UpdateBetHelper(ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	}
+
+	// Ongoing bets are closed out and their stakes voided; no balances move
+	if containsID(creator.OngoingBets, bet.ID) || containsID(receiver.OngoingBets, bet.ID) {
+		for _, username := range []string{bet.CreatorName, bet.ReceiverName} {
+			update := models.UpdateUserHelperStruct{
+				Username:  username,
+				Operation: "$pullAll",
+				Field:     "ongoingbets",
+				IdVal:     bet.ID,
+			}
+			if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+				return err
+			}
+			update.Operation = "$push"
+			update.Field = "resolvedbets"
+			if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+				return err
+			}
 		}
-		// Handle balances for the winner and loser
-		var balanceErr error
-		if bet.OverallStatus == models.CreatorWon {
-			balanceErr = transferBalance(ctx, receiver, creator, bet.CreatorAmount*bet.NumShares)
-		} else if bet.OverallStatus == models.ReceiverWon {
-			balanceErr = transferBalance(ctx, creator, receiver, bet.ReceiverAmount*bet.NumShares)
+		if err := ctl.voidStakes(ctx, &bet); err != nil {
+			return err
 		}
-		if balanceErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": balanceErr.Error()})
-			return
+	}
+
+	// Declined requests are also marked, so that they are not swept again
+	bet.OverallStatus = models.Expired
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return err
+	}
+	return ctl.settleDerivedBets(ctx, bet)
+}
+
+// Lets the creator withdraw a bet request that has not been accepted yet
+// After a counter-offer, it is the receiver who can withdraw their terms
+func (ctl *Controller) CancelBetReqFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var betCancel models.BetCancel
+
+	if err := c.BindJSON(&betCancel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the user withdrawing the bet request is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &betCancel.Username); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.cancelBetReq(ctx, betCancel)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
 		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+func (ctl *Controller) cancelBetReq(ctx context.Context, betCancel models.BetCancel) (string, int, error) {
+	bet, err := ctl.Bets.FindByID(ctx, betCancel.BetID)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet ID %s not found", betCancel.BetID.String())
+	}
+	// Whoever's terms are on the table can withdraw them; after a counter-offer that is the receiver
+	proposerName := pendingProposer(bet)
+	if proposerName != betCancel.Username {
+		return "", http.StatusBadRequest, fmt.Errorf("only %s can withdraw this bet request", proposerName)
+	}
+
+	proposer, err := ctl.Users.FindByUsername(ctx, proposerName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet party %s not found", proposerName)
+	}
+	if bet.OverallStatus != models.Undecided || !containsID(proposer.OutgoingBetReqs, bet.ID) {
+		return "", http.StatusBadRequest, fmt.Errorf("bet request is no longer pending")
+	}
+
+	updateProposer := models.UpdateUserHelperStruct{
+		Username:  proposerName,
+		Operation: "$pullAll",
+		Field:     "outgoingbetreqs",
+		IdVal:     bet.ID,
+	}
+	if err := ctl.UpdateBetHelper(ctx, updateProposer); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	updateResponder := models.UpdateUserHelperStruct{
+		Username:  pendingResponder(bet),
+		Operation: "$pullAll",
+		Field:     "incomingbetreqs",
+		IdVal:     bet.ID,
+	}
+	if err := ctl.UpdateBetHelper(ctx, updateResponder); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	if err := ctl.voidStakes(ctx, &bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	bet.OverallStatus = models.Cancelled
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if err := ctl.settleDerivedBets(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	return fmt.Sprintf("Withdrew bet request from %s to %s", proposerName, pendingResponder(bet)), http.StatusOK, nil
+}
+
+// Records one party's proposal to call off an ongoing or conflicted bet
+// Once both parties agree, the bet is voided without any balance transfer and every stake on it is voided
+func (ctl *Controller) ProposeCancelFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var betCancel models.BetCancel
 
-		// Go over stakes and change balances accordingly
-		if err := PayoutStakes(ctx, &bet); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	if err := c.BindJSON(&betCancel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the user proposing the cancellation is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &betCancel.Username); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.proposeCancel(ctx, betCancel)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
 		}
-		msg = fmt.Sprintf("Resolved bet between %s and %s", bet.CreatorName, bet.ReceiverName)
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
 	}
 
-	// if the other person already provided a status, and if they don't match, move to the conflicted list
-	if bet.OverallStatus == models.Conflicted {
-		// Add to conflicted bets
-		updateCreator := models.UpdateUserHelperStruct{
-			Username:  bet.CreatorName,
-			Operation: "$push",
-			Field:     "conflictedbets",
-			IdVal:     bet.ID,
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+func (ctl *Controller) proposeCancel(ctx context.Context, betCancel models.BetCancel) (string, int, error) {
+	bet, err := ctl.Bets.FindByID(ctx, betCancel.BetID)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet ID %s not found", betCancel.BetID.String())
+	}
+	if bet.CreatorName != betCancel.Username && bet.ReceiverName != betCancel.Username {
+		return "", http.StatusBadRequest, fmt.Errorf("only creator or receiver can propose cancelling a bet")
+	}
+
+	creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("bet creator %s not found", bet.CreatorName)
+	}
+	ongoing := bet.OverallStatus == models.Undecided && containsID(creator.OngoingBets, bet.ID)
+	if !ongoing && bet.OverallStatus != models.Conflicted {
+		return "", http.StatusBadRequest, fmt.Errorf("only ongoing or conflicted bets can be cancelled")
+	}
+
+	if betCancel.Username == bet.CreatorName {
+		bet.CreatorCancel = betCancel.Cancel
+	} else {
+		bet.ReceiverCancel = betCancel.Cancel
+	}
+
+	msg := fmt.Sprintf("Recorded cancellation proposal from %s", betCancel.Username)
+	if !betCancel.Cancel {
+		msg = fmt.Sprintf("Withdrew cancellation proposal from %s", betCancel.Username)
+	}
+
+	if bet.CreatorCancel && bet.ReceiverCancel {
+		// Both agree, so close the bet out without moving any balances
+		listField := "ongoingbets"
+		if bet.OverallStatus == models.Conflicted {
+			listField = "conflictedbets"
 		}
-		if err := UpdateBetHelper(ctx, updateCreator); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.voidBet(ctx, &bet, listField); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-		updateReceiver := models.UpdateUserHelperStruct{
-			Username:  bet.ReceiverName,
-			Operation: "$push",
-			Field:     "conflictedbets",
+		msg = fmt.Sprintf("Voided bet between %s and %s", bet.CreatorName, bet.ReceiverName)
+	}
+
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if bet.OverallStatus == models.Voided {
+		if err := ctl.settleDerivedBets(ctx, bet); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+	}
+	return msg, http.StatusOK, nil
+}
+
+// Closes a bet out without moving any balances, moving it from fromList to the resolved list and voiding its stakes
+// fromList is "ongoingbets" or "conflictedbets"; the caller persists the bet itself
+func (ctl *Controller) voidBet(ctx context.Context, bet *models.Bet, fromList string) error {
+	for _, username := range []string{bet.CreatorName, bet.ReceiverName} {
+		update := models.UpdateUserHelperStruct{
+			Username:  username,
+			Operation: "$pullAll",
+			Field:     fromList,
 			IdVal:     bet.ID,
 		}
-		if err := UpdateBetHelper(ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+			return err
 		}
-		msg = fmt.Sprintf("Conflicted bet between %s and %s", bet.CreatorName, bet.ReceiverName)
+		update.Operation = "$push"
+		update.Field = "resolvedbets"
+		if err := ctl.UpdateBetHelper(ctx, update); err != nil {
+			return err
+		}
+	}
+	if err := ctl.voidStakes(ctx, bet); err != nil {
+		return err
 	}
+	bet.OverallStatus = models.Voided
+	return nil
+}
 
-	// Finally, update the bet itself
-	opts := options.Replace().SetUpsert(true)
-	filter := bson.M{"_id": bet.ID}
-
-	res, err := betCollection.ReplaceOne(
-		ctx,
-		filter,
-		bet,
-		opts,
-	)
-	if err != nil || res.MatchedCount == 0 {
-		log.Printf("Could not update bet when trying to resolve\n")
-		if err == nil {
-			err = fmt.Errorf("bet did not previously exist when trying to update/replace")
+// Over/under bets need a line, parlays need legs and conditional bets need an underlying bet and condition
+// No other type of bet can have any of these
+func validateBetType(bet models.Bet) error {
+	if bet.Type != models.OverUnder && bet.Line != nil {
+		return fmt.Errorf("only over/under bets have a line")
+	}
+	if bet.Type != models.Parlay && len(bet.Legs) > 0 {
+		return fmt.Errorf("only parlays have legs")
+	}
+	if bet.Type != models.Conditional && bet.Underlying != nil {
+		return fmt.Errorf("only conditional bets have an underlying bet")
+	}
+	switch bet.Type {
+	case models.Binary:
+	case models.OverUnder:
+		if bet.Line == nil || !finite(*bet.Line) {
+			return fmt.Errorf("over/under bets need a line")
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	case models.Parlay:
+		if len(bet.Legs) < 2 || len(bet.Legs) > maxParlayLegs {
+			return fmt.Errorf("parlays need between 2 and %d legs", maxParlayLegs)
+		}
+		for i, leg := range bet.Legs {
+			if leg.Outcome != models.CreatorWon && leg.Outcome != models.ReceiverWon {
+				return fmt.Errorf("each leg must be picked to have its creator or its receiver win")
+			}
+			for _, other := range bet.Legs[:i] {
+				if other.Underlying == leg.Underlying {
+					return fmt.Errorf("bet %s is in the parlay more than once", leg.Underlying)
+				}
+			}
+		}
+	case models.Conditional:
+		if bet.Underlying == nil {
+			return fmt.Errorf("conditional bets need an underlying bet")
+		}
+		if bet.Condition != models.CreatorWon && bet.Condition != models.ReceiverWon {
+			return fmt.Errorf("condition must be that the underlying bet's creator or receiver wins")
+		}
+	default:
+		return fmt.Errorf("invalid bet type %d", bet.Type)
 	}
+	return nil
+}
 
-	c.JSON(http.StatusOK, gin.H{"msg": msg})
+func finite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
 }
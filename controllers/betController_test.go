@@ -0,0 +1,621 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errInjected = errors.New("injected failure")
+
+// Counts writes across every wrapped store and fails the failAt-th one
+type faults struct {
+	mu     sync.Mutex
+	writes int
+	failAt int // zero to never fail
+}
+
+func (f *faults) write() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	if f.writes == f.failAt {
+		return errInjected
+	}
+	return nil
+}
+
+func (f *faults) reset(failAt int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = 0
+	f.failAt = failAt
+}
+
+type faultyUsers struct {
+	database.UserStore
+	f *faults
+}
+
+func (s faultyUsers) IncrementNumBets(ctx context.Context, username string) (models.User, error) {
+	if err := s.f.write(); err != nil {
+		return models.User{}, err
+	}
+	return s.UserStore.IncrementNumBets(ctx, username)
+}
+
+func (s faultyUsers) UpdateList(ctx context.Context, update models.UpdateUserHelperStruct) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.UserStore.UpdateList(ctx, update)
+}
+
+func (s faultyUsers) SetBalances(ctx context.Context, username string, balances map[string]int64, totalBalance int64) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.UserStore.SetBalances(ctx, username, balances, totalBalance)
+}
+
+type faultyBets struct {
+	database.BetStore
+	f *faults
+}
+
+func (s faultyBets) Insert(ctx context.Context, bet models.Bet) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.BetStore.Insert(ctx, bet)
+}
+
+func (s faultyBets) Replace(ctx context.Context, bet models.Bet) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.BetStore.Replace(ctx, bet)
+}
+
+type faultyStakes struct {
+	database.StakeStore
+	f *faults
+}
+
+func (s faultyStakes) Insert(ctx context.Context, stake models.Stake) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.StakeStore.Insert(ctx, stake)
+}
+
+func (s faultyStakes) UpdateFilled(ctx context.Context, id primitive.ObjectID, sharesFilled int64) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.StakeStore.UpdateFilled(ctx, id, sharesFilled)
+}
+
+func (s faultyStakes) Replace(ctx context.Context, stake models.Stake) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.StakeStore.Replace(ctx, stake)
+}
+
+type faultyLedger struct {
+	database.LedgerStore
+	f *faults
+}
+
+func (s faultyLedger) Append(ctx context.Context, entries ...models.LedgerEntry) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.LedgerStore.Append(ctx, entries...)
+}
+
+type faultyEvents struct {
+	database.EventStore
+	f *faults
+}
+
+func (s faultyEvents) Append(ctx context.Context, events ...models.Event) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.EventStore.Append(ctx, events...)
+}
+
+type faultyNotifications struct {
+	database.NotificationStore
+	f *faults
+}
+
+func (s faultyNotifications) Insert(ctx context.Context, notifications ...models.Notification) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.NotificationStore.Insert(ctx, notifications...)
+}
+
+type faultyDeliveries struct {
+	database.DeliveryStore
+	f *faults
+}
+
+func (s faultyDeliveries) Insert(ctx context.Context, deliveries ...models.WebhookDelivery) error {
+	if err := s.f.write(); err != nil {
+		return err
+	}
+	return s.DeliveryStore.Insert(ctx, deliveries...)
+}
+
+// Memory stores whose writes go through f
+func faultyStores(f *faults) database.Stores {
+	stores := database.NewMemoryStores()
+	stores.Users = faultyUsers{stores.Users, f}
+	stores.Bets = faultyBets{stores.Bets, f}
+	stores.Stakes = faultyStakes{stores.Stakes, f}
+	stores.Ledger = faultyLedger{stores.Ledger, f}
+	stores.Events = faultyEvents{stores.Events, f}
+	stores.Notifications = faultyNotifications{stores.Notifications, f}
+	stores.Deliveries = faultyDeliveries{stores.Deliveries, f}
+	return stores
+}
+
+// Everything resolving a bet can write to
+type betState struct {
+	Bet           models.Bet
+	Stakes        []models.Stake
+	Users         map[string]models.User
+	Ledger        map[string][]models.LedgerEntry
+	Events        map[string][]models.Event
+	Notifications map[string][]models.Notification
+	Deliveries    []models.WebhookDelivery
+}
+
+func (h *harness) betState(betID, webhookID primitive.ObjectID, usernames ...string) betState {
+	h.t.Helper()
+	ctx := context.Background()
+	state := betState{
+		Bet:           h.bet(betID),
+		Users:         make(map[string]models.User),
+		Ledger:        make(map[string][]models.LedgerEntry),
+		Events:        make(map[string][]models.Event),
+		Notifications: make(map[string][]models.Notification),
+	}
+	var err error
+	if state.Stakes, err = h.ctl.Stakes.FindByUnderlying(ctx, betID); err != nil {
+		h.t.Fatal(err)
+	}
+	if state.Deliveries, err = h.ctl.Deliveries.ListByWebhook(ctx, webhookID, 0); err != nil {
+		h.t.Fatal(err)
+	}
+	for _, name := range usernames {
+		state.Users[name] = h.user(name)
+		if state.Ledger[name], err = h.ctl.Ledger.ListByUsername(ctx, name); err != nil {
+			h.t.Fatal(err)
+		}
+		if state.Events[name], err = h.ctl.Events.ListAfter(ctx, name, 0, 0); err != nil {
+			h.t.Fatal(err)
+		}
+		filter := database.NotificationFilter{Username: name}
+		if state.Notifications[name], err = h.ctl.Notifications.List(ctx, filter, database.PageRequest{SortBy: database.SortByCreateDate, Limit: 1000}); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	return state
+}
+
+// Fails each write resolving a bet makes in turn, and checks every failure leaves everything as it was
+func TestResolveBetRollsBackOnAnyFailedWrite(t *testing.T) {
+	f := &faults{}
+	h := newHarnessWithStores(t, faultyStores(f))
+	users := []string{"alice", "bob", "carol", "dave"}
+	h.signup(users...)
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "dave")
+	betID := h.ongoingBet("alice", "bob")
+	// Matched stakes on both sides so resolving pays them out too, and a webhook so deliveries are queued
+	h.must(http.StatusOK, "carol", "POST", "/stakes/createstake", map[string]interface{}{"underlying": betID, "ownername": "carol", "numshares": 3, "backingcreator": true})
+	h.must(http.StatusOK, "dave", "POST", "/stakes/createstake", map[string]interface{}{"underlying": betID, "ownername": "dave", "numshares": 2, "backingcreator": false})
+	out := h.must(http.StatusOK, "carol", "POST", "/webhooks", map[string]interface{}{"url": "http://127.0.0.1:1/hook", "eventtypes": []models.EventType{models.BetResolved, models.StakePaidOut}})
+	webhookID := h.objectID(out["webhook"].(map[string]interface{})["id"])
+	h.must(http.StatusOK, "alice", "POST", "/bets/resolvebet", map[string]interface{}{"betid": betID, "betresolvestatus": models.CreatorWon, "username": "alice"})
+
+	before := h.betState(betID, webhookID, users...)
+	resolve := map[string]interface{}{"betid": betID, "betresolvestatus": models.CreatorWon, "username": "bob"}
+	failAt := 1
+	for ; ; failAt++ {
+		f.reset(failAt)
+		code, out := h.do("bob", "POST", "/bets/resolvebet", resolve)
+		if f.writes < failAt {
+			t.Logf("failed each of %d writes", failAt-1)
+			// Every write has been failed once, and this time none was
+			if code != http.StatusOK {
+				t.Fatalf("resolving with no failures: %d %v", code, out)
+			}
+			break
+		}
+		if code == http.StatusOK {
+			t.Fatalf("write %d failed but the bet was still resolved", failAt)
+		}
+		if after := h.betState(betID, webhookID, users...); !reflect.DeepEqual(after, before) {
+			t.Fatalf("write %d failed and the state changed:\nbefore %+v\nafter  %+v", failAt, before, after)
+		}
+	}
+	f.reset(0)
+	if failAt < 10 {
+		t.Fatalf("resolving only made %d writes", failAt-1)
+	}
+
+	after := h.betState(betID, webhookID, users...)
+	if after.Bet.OverallStatus != models.CreatorWon {
+		t.Fatalf("bet status = %d, want CreatorWon", after.Bet.OverallStatus)
+	}
+	if after.Users["alice"].TotalBalance != 100 || after.Users["bob"].TotalBalance != -100 {
+		t.Errorf("balances alice %d, bob %d, want 100 and -100", after.Users["alice"].TotalBalance, after.Users["bob"].TotalBalance)
+	}
+	if after.Users["carol"].TotalBalance <= 0 || after.Users["dave"].TotalBalance >= 0 {
+		t.Errorf("stakes not paid out: carol %d, dave %d", after.Users["carol"].TotalBalance, after.Users["dave"].TotalBalance)
+	}
+	if len(after.Deliveries) == len(before.Deliveries) {
+		t.Error("no webhook delivery was queued")
+	}
+}
+
+// Neither a refused bet request nor one whose writes fail partway counts towards either user's bets
+func TestCreateBetReqRollsBackOnAnyFailedWrite(t *testing.T) {
+	f := &faults{}
+	h := newHarnessWithStores(t, faultyStores(f))
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	request := func(expiry time.Time) (int, map[string]interface{}) {
+		return h.do("alice", "POST", "/bets/createbetreq", map[string]interface{}{
+			"creatorname": "alice", "receivername": "bob", "title": "test bet",
+			"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": expiry,
+		})
+	}
+	unchanged := func(when string) {
+		t.Helper()
+		for _, name := range []string{"alice", "bob"} {
+			if u := h.user(name); u.NumBets != 0 || len(u.OutgoingBetReqs) != 0 || len(u.IncomingBetReqs) != 0 {
+				t.Fatalf("%s: %s has %d bets, outgoing %v and incoming %v", when, name, u.NumBets, u.OutgoingBetReqs, u.IncomingBetReqs)
+			}
+		}
+	}
+
+	if code, out := request(time.Now().Add(-time.Hour)); code != http.StatusBadRequest {
+		t.Fatalf("bet request that has already expired: %d %v", code, out)
+	}
+	unchanged("after an expired request")
+
+	var created map[string]interface{}
+	failAt := 1
+	for ; ; failAt++ {
+		f.reset(failAt)
+		code, out := request(time.Now().Add(time.Hour))
+		if f.writes < failAt {
+			if code != http.StatusOK {
+				t.Fatalf("creating with no failures: %d %v", code, out)
+			}
+			created = out
+			break
+		}
+		if code == http.StatusOK {
+			t.Fatalf("write %d failed but the bet request was still created", failAt)
+		}
+		unchanged(fmt.Sprintf("after failing write %d", failAt))
+	}
+	f.reset(0)
+	if failAt < 4 {
+		t.Fatalf("creating a bet request only made %d writes", failAt-1)
+	}
+	// The first request to go through is numbered as the first bet either of them has made
+	if betID := *h.bet(h.objectID(created["InsertedID"])).BetID; betID != "alice.bob.0.0" {
+		t.Fatalf("bet request has BetID %s, want alice.bob.0.0", betID)
+	}
+	if alice, bob := h.user("alice"), h.user("bob"); alice.NumBets != 1 || bob.NumBets != 1 {
+		t.Fatalf("alice has %d bets and bob %d, want 1 each", alice.NumBets, bob.NumBets)
+	}
+}
+
+func TestSweepExpiredBets(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	expiry := time.Now().Add(10 * time.Minute)
+	pending := h.betRequest("alice", "bob", "Rain", expiry)
+	ongoing := h.acceptedBet("alice", "bob", "Snow", expiry)
+	stakeID := h.stake("carol", ongoing, 2, true)
+
+	if closed, err := h.ctl.SweepExpiredBets(context.Background(), time.Now()); err != nil || closed != 0 {
+		t.Fatalf("sweeping before expiry closed %d bets: %v", closed, err)
+	}
+	if closed, err := h.ctl.SweepExpiredBets(context.Background(), expiry.Add(time.Minute)); err != nil || closed != 2 {
+		t.Fatalf("sweeping after expiry closed %d bets, want 2: %v", closed, err)
+	}
+	for _, id := range []primitive.ObjectID{pending, ongoing} {
+		if status := h.bet(id).OverallStatus; status != models.Expired {
+			t.Errorf("bet %s has status %d, want Expired", id.Hex(), status)
+		}
+	}
+
+	// The request is gone from both sides, and the ongoing bet has moved to resolved with nothing paid
+	for _, name := range []string{"alice", "bob"} {
+		u := h.user(name)
+		if len(u.OutgoingBetReqs) != 0 || len(u.IncomingBetReqs) != 0 || len(u.OngoingBets) != 0 {
+			t.Errorf("%s still has requests %v %v or ongoing bets %v", name, u.OutgoingBetReqs, u.IncomingBetReqs, u.OngoingBets)
+		}
+		if !reflect.DeepEqual(u.ResolvedBets, []primitive.ObjectID{ongoing}) {
+			t.Errorf("%s has resolved bets %v, want only the accepted one", name, u.ResolvedBets)
+		}
+		if u.TotalBalance != 0 {
+			t.Errorf("%s has balance %d after a bet expired", name, u.TotalBalance)
+		}
+	}
+	stakes, err := h.ctl.Stakes.FindByUnderlying(context.Background(), ongoing)
+	if err != nil || len(stakes) != 1 || !stakes[0].Voided {
+		t.Fatalf("stakes on the expired bet: %+v, %v", stakes, err)
+	}
+	if carol := h.user("carol"); len(carol.OngoingStakes) != 0 || !reflect.DeepEqual(carol.ResolvedStakes, []primitive.ObjectID{stakeID}) {
+		t.Errorf("carol has ongoing stakes %v and resolved stakes %v", carol.OngoingStakes, carol.ResolvedStakes)
+	}
+
+	if code, out := h.do("alice", "POST", "/bets/resolvebet", map[string]interface{}{"betid": ongoing, "betresolvestatus": models.CreatorWon, "username": "alice"}); code != http.StatusBadRequest {
+		t.Errorf("resolving an expired bet: %d %v", code, out)
+	}
+	if closed, err := h.ctl.SweepExpiredBets(context.Background(), expiry.Add(time.Hour)); err != nil || closed != 0 {
+		t.Errorf("sweeping again closed %d bets: %v", closed, err)
+	}
+}
+
+func TestWithdrawBetRequest(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	betID := h.betRequest("alice", "bob", "Rain", time.Now().Add(time.Hour))
+	withdraw := func(user string) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/bets/cancelbetreq", map[string]interface{}{"betid": betID, "username": user})
+	}
+
+	if code, out := withdraw("bob"); code != http.StatusBadRequest {
+		t.Fatalf("receiver withdrew the request: %d %v", code, out)
+	}
+	if code, out := h.do("alice", "POST", "/bets/cancelbetreq", map[string]interface{}{"betid": betID, "username": "bob"}); code != http.StatusForbidden {
+		t.Fatalf("alice withdrew as bob: %d %v", code, out)
+	}
+	h.must(http.StatusOK, "alice", "POST", "/bets/cancelbetreq", map[string]interface{}{"betid": betID, "username": "alice"})
+	if status := h.bet(betID).OverallStatus; status != models.Cancelled {
+		t.Fatalf("bet status %d, want Cancelled", status)
+	}
+	if alice, bob := h.user("alice"), h.user("bob"); len(alice.OutgoingBetReqs) != 0 || len(bob.IncomingBetReqs) != 0 {
+		t.Fatalf("request still listed: alice %v, bob %v", alice.OutgoingBetReqs, bob.IncomingBetReqs)
+	}
+	if code, out := withdraw("alice"); code != http.StatusBadRequest {
+		t.Fatalf("withdrew the request twice: %d %v", code, out)
+	}
+	if code, out := h.do("bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": betID, "betreqstatus": models.Accepted}); code == http.StatusOK {
+		t.Fatalf("accepted a withdrawn request: %v", out)
+	}
+}
+
+func TestMutualCancel(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	betID := h.ongoingBet("alice", "bob")
+	h.stake("carol", betID, 2, true)
+	propose := func(user string, cancel bool) string {
+		out := h.must(http.StatusOK, user, "POST", "/bets/proposecancel", map[string]interface{}{"betid": betID, "username": user, "cancel": cancel})
+		return out["msg"].(string)
+	}
+
+	if code, out := h.do("carol", "POST", "/bets/proposecancel", map[string]interface{}{"betid": betID, "username": "carol", "cancel": true}); code != http.StatusBadRequest {
+		t.Fatalf("a staker proposed cancelling: %d %v", code, out)
+	}
+	propose("alice", true)
+	// Taking the proposal back means bob agreeing alone does not void the bet
+	propose("alice", false)
+	propose("bob", true)
+	if status := h.bet(betID).OverallStatus; status != models.Undecided {
+		t.Fatalf("bet status %d after only bob still wants to cancel", status)
+	}
+	if msg := propose("alice", true); msg != "Voided bet between alice and bob" {
+		t.Fatalf("both agreed to cancel: %q", msg)
+	}
+
+	if status := h.bet(betID).OverallStatus; status != models.Voided {
+		t.Fatalf("bet status %d, want Voided", status)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if u := h.user(name); len(u.OngoingBets) != 0 || len(u.ResolvedBets) != 1 || u.TotalBalance != 0 {
+			t.Errorf("%s has ongoing %v, resolved %v and balance %d", name, u.OngoingBets, u.ResolvedBets, u.TotalBalance)
+		}
+	}
+	stakes, err := h.ctl.Stakes.FindByUnderlying(context.Background(), betID)
+	if err != nil || len(stakes) != 1 || !stakes[0].Voided {
+		t.Fatalf("stakes on the voided bet: %+v, %v", stakes, err)
+	}
+	if code, out := h.do("alice", "POST", "/bets/resolvebet", map[string]interface{}{"betid": betID, "betresolvestatus": models.CreatorWon, "username": "alice"}); code == http.StatusOK {
+		t.Fatalf("resolved a voided bet: %v", out)
+	}
+}
+
+func TestCounterOffers(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	expiry := time.Now().Add(time.Hour)
+	betID := h.betRequest("alice", "bob", "Rain", expiry)
+	counter := func(user string, creatorAmount int64) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/bets/handlebetreq", map[string]interface{}{
+			"betid": betID, "betreqstatus": models.CounterOffered,
+			"counterterms": map[string]interface{}{"creatoramount": creatorAmount, "receiveramount": 3, "numshares": 5, "expirydate": expiry},
+		})
+	}
+	accept := func(user string) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/bets/handlebetreq", map[string]interface{}{"betid": betID, "betreqstatus": models.Accepted})
+	}
+
+	if code, out := accept("alice"); code != http.StatusBadRequest {
+		t.Fatalf("creator accepted her own request: %d %v", code, out)
+	}
+	if code, out := counter("bob", 8); code != http.StatusOK {
+		t.Fatalf("bob's counter-offer: %d %v", code, out)
+	}
+	// Now alice has to answer, and only bob can withdraw
+	if code, out := accept("bob"); code != http.StatusBadRequest {
+		t.Fatalf("bob accepted his own counter-offer: %d %v", code, out)
+	}
+	if code, out := h.do("alice", "POST", "/bets/cancelbetreq", map[string]interface{}{"betid": betID, "username": "alice"}); code != http.StatusBadRequest {
+		t.Fatalf("alice withdrew bob's counter-offer: %d %v", code, out)
+	}
+	if alice := h.user("alice"); !reflect.DeepEqual(alice.IncomingBetReqs, []primitive.ObjectID{betID}) || len(alice.OutgoingBetReqs) != 0 {
+		t.Fatalf("alice has incoming %v and outgoing %v after the counter-offer", alice.IncomingBetReqs, alice.OutgoingBetReqs)
+	}
+	if code, out := counter("alice", 9); code != http.StatusOK {
+		t.Fatalf("alice's counter-offer: %d %v", code, out)
+	}
+	if code, out := accept("bob"); code != http.StatusOK {
+		t.Fatalf("bob accepting: %d %v", code, out)
+	}
+
+	bet := h.bet(betID)
+	if bet.CreatorAmount != 9 || bet.ReceiverAmount != 3 || bet.NumShares != 5 {
+		t.Errorf("bet went ahead at %d:%d over %d shares, want the last offer of 9:3 over 5", bet.CreatorAmount, bet.ReceiverAmount, bet.NumShares)
+	}
+	if len(bet.Negotiation) != 3 || bet.Negotiation[1].ProposedBy != "bob" || bet.Negotiation[2].ProposedBy != "alice" {
+		t.Errorf("negotiation %+v", bet.Negotiation)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		u := h.user(name)
+		if len(u.IncomingBetReqs) != 0 || len(u.OutgoingBetReqs) != 0 || !reflect.DeepEqual(u.OngoingBets, []primitive.ObjectID{betID}) {
+			t.Errorf("%s has incoming %v, outgoing %v and ongoing %v", name, u.IncomingBetReqs, u.OutgoingBetReqs, u.OngoingBets)
+		}
+	}
+}
+
+func TestOverUnder(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	create := func(terms map[string]interface{}) (int, map[string]interface{}) {
+		body := map[string]interface{}{
+			"creatorname": "alice", "receivername": "bob", "title": "Points scored",
+			"creatoramount": 1, "receiveramount": 1, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+		}
+		for k, v := range terms {
+			body[k] = v
+		}
+		return h.do("alice", "POST", "/bets/createbetreq", body)
+	}
+	accepted := func(terms map[string]interface{}) primitive.ObjectID {
+		code, out := create(terms)
+		if code != http.StatusOK {
+			t.Fatalf("creating bet %v: %d %v", terms, code, out)
+		}
+		id := h.objectID(out["InsertedID"])
+		h.must(http.StatusOK, "bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": id, "betreqstatus": models.Accepted})
+		return id
+	}
+	report := func(user string, betID primitive.ObjectID, result interface{}) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/bets/resolvebet", map[string]interface{}{"betid": betID, "result": result, "username": user})
+	}
+
+	for _, terms := range []map[string]interface{}{
+		{"type": models.OverUnder}, // no line
+		{"line": 3.5},              // a line on a binary bet
+		{"type": 7, "line": 3.5},   // no such type
+	} {
+		if code, out := create(terms); code != http.StatusBadRequest {
+			t.Errorf("created bet %v: %d %v", terms, code, out)
+		}
+	}
+
+	betID := accepted(map[string]interface{}{"type": models.OverUnder, "line": 42.5, "creatortakesover": true})
+	if code, out := h.do("alice", "POST", "/bets/resolvebet", map[string]interface{}{"betid": betID, "betresolvestatus": models.CreatorWon, "username": "alice"}); code != http.StatusBadRequest {
+		t.Fatalf("resolved an over/under bet without a result: %d %v", code, out)
+	}
+	if code, out := report("alice", betID, 40); code != http.StatusOK {
+		t.Fatalf("alice's result: %d %v", code, out)
+	}
+	// Both results are under the line, but they still have to agree
+	if code, out := report("bob", betID, 41); code != http.StatusOK || h.bet(betID).OverallStatus != models.Conflicted {
+		t.Fatalf("bob's different result: %d %v, bet status %d", code, out, h.bet(betID).OverallStatus)
+	}
+	if code, out := report("alice", betID, 41); code != http.StatusOK {
+		t.Fatalf("alice's revised result: %d %v", code, out)
+	}
+	bet := h.bet(betID)
+	if bet.OverallStatus != models.ReceiverWon || *bet.CreatorResult != 41 || *bet.ReceiverResult != 41 {
+		t.Fatalf("bet status %d with results %v and %v, want ReceiverWon at 41", bet.OverallStatus, *bet.CreatorResult, *bet.ReceiverResult)
+	}
+	if alice, bob := h.user("alice"), h.user("bob"); alice.TotalBalance != -10 || bob.TotalBalance != 10 {
+		t.Fatalf("balances alice %d, bob %d, want -10 and 10 for taking the under", alice.TotalBalance, bob.TotalBalance)
+	}
+
+	// A result right on the line is a push, so nobody wins
+	pushID := accepted(map[string]interface{}{"type": models.OverUnder, "line": 3})
+	report("alice", pushID, 3)
+	if code, out := report("bob", pushID, 3); code != http.StatusOK || h.bet(pushID).OverallStatus != models.Voided {
+		t.Fatalf("result on the line: %d %v, bet status %d", code, out, h.bet(pushID).OverallStatus)
+	}
+	if alice := h.user("alice"); alice.TotalBalance != -10 {
+		t.Fatalf("alice's balance moved to %d on a push", alice.TotalBalance)
+	}
+
+	binaryID := accepted(nil)
+	if code, out := report("bob", binaryID, 3); code != http.StatusBadRequest {
+		t.Fatalf("reported a number for a binary bet: %d %v", code, out)
+	}
+}
+
+// Only a winner can be claimed; expiring, cancelling and voiding a bet go through their own endpoints
+func TestClaimOnlyWinners(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "judge")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "judge")
+	h.befriend("bob", "judge")
+	binary := h.ongoingBet("alice", "bob")
+	out := h.must(http.StatusOK, "alice", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "alice", "receivername": "bob", "title": "Rain", "arbiter": "judge",
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	arbitrated := h.objectID(out["InsertedID"])
+	h.must(http.StatusOK, "bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": arbitrated, "betreqstatus": models.Accepted})
+	underlying, underlyingBetID := h.leg()
+	conditional := h.derivedBet(map[string]interface{}{"type": models.Conditional, "underlying": underlyingBetID, "condition": models.CreatorWon})
+	h.settle(underlying, models.CreatorWon)
+
+	for name, betID := range map[string]primitive.ObjectID{"binary": binary, "arbitrated": arbitrated, "conditional": conditional} {
+		for _, claim := range []models.BetStatus{models.Conflicted, models.Expired, models.Cancelled, models.Voided, 9} {
+			for _, user := range []string{"alice", "bob"} {
+				code, out := h.do(user, "POST", "/bets/resolvebet", map[string]interface{}{"betid": betID, "betresolvestatus": claim, "username": user})
+				if code != http.StatusBadRequest {
+					t.Errorf("%s bet: %s claimed status %d: %d %v", name, user, claim, code, out)
+				}
+			}
+		}
+		if bet := h.bet(betID); bet.OverallStatus != models.Undecided || bet.CreatorStatus != models.Undecided || bet.ReceiverStatus != models.Undecided {
+			t.Errorf("%s bet has status %d with claims %d and %d", name, bet.OverallStatus, bet.CreatorStatus, bet.ReceiverStatus)
+		}
+	}
+	if alice := h.user("alice"); len(alice.OngoingBets) != 3 || len(alice.ResolvedBets) != 1 {
+		t.Errorf("alice has ongoing bets %v and resolved %v, want the 3 claimed on still ongoing", alice.OngoingBets, alice.ResolvedBets)
+	}
+}
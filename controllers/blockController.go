@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/authentication"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Pass in blocker and blocked
+// Ends any friendship and pending friend or bet requests between the two users
+// Afterwards the blocked user cannot send the blocker friend or bet requests, stake on their bets or find them in search
+func (ctl *Controller) BlockUserFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var blockReq models.BlockRequest
+
+	if err := c.BindJSON(&blockReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the user blocking is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &blockReq.Blocker); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		status, err = ctl.blockUser(ctx, blockReq.Blocker, blockReq.Blocked)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("%s blocked %s", blockReq.Blocker, blockReq.Blocked)})
+}
+
+func (ctl *Controller) blockUser(ctx context.Context, blockerName string, blockedName string) (int, error) {
+	if blockerName == blockedName {
+		return http.StatusBadRequest, fmt.Errorf("can't block yourself")
+	}
+	blocker, err := ctl.Users.FindByUsername(ctx, blockerName)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("user %s not found", blockerName)
+	}
+	blocked, err := ctl.Users.FindByUsername(ctx, blockedName)
+	if err != nil || blocked.Deleted {
+		return http.StatusNotFound, fmt.Errorf("user %s not found", blockedName)
+	}
+	if containsString(blocker.BlockedUsers, blockedName) {
+		return http.StatusBadRequest, fmt.Errorf("%s has already blocked %s", blockerName, blockedName)
+	}
+
+	// Drop the friendship and friend requests in both directions
+	pairs := [][2]string{{blockerName, blockedName}, {blockedName, blockerName}}
+	for _, pair := range pairs {
+		for _, field := range []string{"friends", "outgoingfriendreqs", "incomingfriendreqs"} {
+			update := models.UpdateUserHelperStruct{
+				Username:  pair[0],
+				Operation: "$pullAll",
+				Field:     field,
+				Val:       pair[1],
+			}
+			if err := ctl.Users.UpdateList(ctx, update); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+	}
+
+	// Call off bet requests still pending between the two
+	pendingIDs := make([]primitive.ObjectID, 0)
+	pendingIDs = append(pendingIDs, blocker.IncomingBetReqs...)
+	pendingIDs = append(pendingIDs, blocker.OutgoingBetReqs...)
+	for _, betID := range pendingIDs {
+		bet, err := ctl.Bets.FindByID(ctx, betID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if bet.CreatorName == blockedName || bet.ReceiverName == blockedName {
+			if err := ctl.callOffBet(ctx, betID); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+	}
+
+	update := models.UpdateUserHelperStruct{
+		Username:  blockerName,
+		Operation: "$push",
+		Field:     "blockedusers",
+		Val:       blockedName,
+	}
+	if err := ctl.Users.UpdateList(ctx, update); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// Pass in blocker and blocked
+// Unblocking does not restore the friendship; a new friend request has to be sent
+func (ctl *Controller) UnblockUserFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var blockReq models.BlockRequest
+
+	if err := c.BindJSON(&blockReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check that the user unblocking is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &blockReq.Blocker); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	blocker, err := ctl.Users.FindByUsername(ctx, blockReq.Blocker)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("user %s not found", blockReq.Blocker)})
+		return
+	}
+	if !containsString(blocker.BlockedUsers, blockReq.Blocked) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s has not blocked %s", blockReq.Blocker, blockReq.Blocked)})
+		return
+	}
+
+	update := models.UpdateUserHelperStruct{
+		Username:  blockReq.Blocker,
+		Operation: "$pullAll",
+		Field:     "blockedusers",
+		Val:       blockReq.Blocked,
+	}
+	if err := ctl.Users.UpdateList(ctx, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("%s unblocked %s", blockReq.Blocker, blockReq.Blocked)})
+}
@@ -0,0 +1,88 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// Usernames found by GET /users/search as user
+func (h *harness) search(user, query string) []string {
+	h.t.Helper()
+	req := httptest.NewRequest("GET", "/users/search?"+query, nil)
+	req.Header.Set("token", h.tokens[user])
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	var found []models.UserSummary
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &found) != nil {
+		h.t.Fatalf("search %q as %s: %d %s", query, user, w.Code, w.Body.String())
+	}
+	names := make([]string, 0)
+	for _, user := range found {
+		names = append(names, user.Username)
+	}
+	return names
+}
+
+func TestBlockUser(t *testing.T) {
+	h := newHarness(t)
+	config.GlobalConfig.AllowNonFriendBetReqs = true
+	h.signup("alice", "bob", "carol", "albert")
+	h.befriend("alice", "bob")
+	pending := h.betRequest("bob", "alice", "Rain", time.Now().Add(time.Hour))
+	out := h.must(http.StatusOK, "alice", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "alice", "receivername": "carol", "title": "Snow", "visibility": models.Public,
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	public := h.objectID(out["InsertedID"])
+	h.must(http.StatusOK, "carol", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": public, "betreqstatus": models.Accepted})
+	block := map[string]interface{}{"blocker": "alice", "blocked": "bob"}
+
+	if got := h.search("bob", "q=al"); !reflect.DeepEqual(got, []string{"albert", "alice"}) {
+		t.Fatalf("bob searching before the block found %v", got)
+	}
+	h.must(http.StatusBadRequest, "alice", "POST", "/users/block", map[string]interface{}{"blocker": "alice", "blocked": "alice"})
+	h.must(http.StatusOK, "alice", "POST", "/users/block", block)
+	h.must(http.StatusBadRequest, "alice", "POST", "/users/block", block)
+
+	alice := h.user("alice")
+	if len(alice.Friends) != 0 || !reflect.DeepEqual(alice.BlockedUsers, []string{"bob"}) || len(alice.IncomingBetReqs) != 0 {
+		t.Errorf("alice has friends %v, blocked %v and incoming requests %v", alice.Friends, alice.BlockedUsers, alice.IncomingBetReqs)
+	}
+	if status := h.bet(pending).OverallStatus; status != models.Cancelled {
+		t.Errorf("bob's pending request has status %d, want Cancelled", status)
+	}
+
+	// alice disappears from bob's searches only
+	if got := h.search("bob", "q=al"); !reflect.DeepEqual(got, []string{"albert"}) {
+		t.Errorf("bob searching after the block found %v", got)
+	}
+	if got := h.search("carol", "q=al&limit=1"); !reflect.DeepEqual(got, []string{"albert"}) {
+		t.Errorf("carol searching with a limit of 1 found %v", got)
+	}
+	if got := h.search("carol", "q=.*"); len(got) != 0 {
+		t.Errorf("search is not literal: %v", got)
+	}
+
+	h.must(http.StatusForbidden, "bob", "POST", "/users/sendfriendreq", map[string]interface{}{"sender": "bob", "receiver": "alice"})
+	h.must(http.StatusBadRequest, "alice", "POST", "/users/sendfriendreq", map[string]interface{}{"sender": "alice", "receiver": "bob"})
+	h.must(http.StatusForbidden, "bob", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "bob", "receivername": "alice", "title": "Hail",
+		"creatoramount": 1, "receiveramount": 1, "numshares": 1, "expirydate": time.Now().Add(time.Hour),
+	})
+	h.must(http.StatusForbidden, "bob", "POST", "/stakes/createstake", map[string]interface{}{"underlying": public, "ownername": "bob", "numshares": 1, "backingcreator": true})
+
+	// Unblocking lets bob ask again, but does not bring back the friendship
+	h.must(http.StatusOK, "alice", "POST", "/users/unblock", block)
+	if alice := h.user("alice"); len(alice.BlockedUsers) != 0 || len(alice.Friends) != 0 {
+		t.Errorf("after unblocking alice has friends %v and blocked %v", alice.Friends, alice.BlockedUsers)
+	}
+	h.must(http.StatusOK, "bob", "POST", "/users/sendfriendreq", map[string]interface{}{"sender": "bob", "receiver": "alice"})
+	h.stake("bob", public, 1, true)
+}
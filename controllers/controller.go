@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/authentication"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/events"
+	"github.com/simhonchourasia/betfr-be/mail"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Holds the stores that every request handler reads from and writes to
+// Use NewController with database.NewMongoStores or database.NewMemoryStores
+type Controller struct {
+	database.Stores
+	Bus    *events.Bus
+	Mailer mail.Mailer
+}
+
+// Transactions run through the event bus so events are only announced once they are committed
+func NewController(stores database.Stores, mailer mail.Mailer) *Controller {
+	bus := events.NewBus(stores.Events)
+	stores.Tx = bus.Transactor(stores.Tx)
+	ctl := &Controller{Stores: stores, Bus: bus, Mailer: mailer}
+	bus.OnPublish(ctl.enqueueWebhooks)
+	bus.OnPublish(ctl.enqueueNotifications)
+	return ctl
+}
+
+// Username of the logged in user, as set by the authentication middleware
+func currentUsername(c *gin.Context) (string, error) {
+	principal, ok := authentication.GetPrincipal(c)
+	if !ok {
+		return "", fmt.Errorf("could not get username from context")
+	}
+	return principal.Username, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxParlayLegs = 10
+
+// Parlays and conditional bets can only be made on bets that are still open and that both parties can see,
+// and cannot expire before the bets they depend on
+func (ctl *Controller) checkDerived(ctx context.Context, bet models.Bet) error {
+	betIDs := make([]string, 0, len(bet.Legs))
+	switch bet.Type {
+	case models.Parlay:
+		for _, leg := range bet.Legs {
+			betIDs = append(betIDs, leg.Underlying)
+		}
+	case models.Conditional:
+		betIDs = append(betIDs, *bet.Underlying)
+	default:
+		return nil
+	}
+
+	creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName)
+	if err != nil {
+		return fmt.Errorf("bet creator %s not found", bet.CreatorName)
+	}
+	receiver, err := ctl.Users.FindByUsername(ctx, bet.ReceiverName)
+	if err != nil {
+		return fmt.Errorf("bet receiver %s not found", bet.ReceiverName)
+	}
+	for _, betID := range betIDs {
+		underlying, err := ctl.Bets.FindByBetID(ctx, betID)
+		if err != nil || !underlying.VisibleTo(bet.CreatorName, creator.Friends) || !underlying.VisibleTo(bet.ReceiverName, receiver.Friends) {
+			return fmt.Errorf("underlying bet %s not found", betID)
+		}
+		if underlying.OverallStatus != models.Undecided && underlying.OverallStatus != models.Conflicted {
+			return fmt.Errorf("underlying bet %s has already finished", betID)
+		}
+		if bet.ExpiryDate < underlying.ExpiryDate {
+			return fmt.Errorf("bet cannot expire before its underlying bet %s", betID)
+		}
+	}
+	return nil
+}
+
+// Evaluates every open parlay and conditional bet that depends on a bet which has just finished
+// Runs in the caller's transaction after the finished bet has been saved
+func (ctl *Controller) settleDerivedBets(ctx context.Context, bet models.Bet) error {
+	if bet.BetID == nil {
+		return nil
+	}
+	derived, err := ctl.Bets.FindDerived(ctx, *bet.BetID)
+	if err != nil {
+		return err
+	}
+	for _, d := range derived {
+		if err := ctl.evaluateDerived(ctx, d.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Settles a parlay once its outcome is known, and activates or calls off a conditional bet once its underlying bet finishes
+// Requests that have not been accepted are withdrawn rather than settled; whatever this closes is evaluated in turn
+func (ctl *Controller) evaluateDerived(ctx context.Context, betID primitive.ObjectID) error {
+	bet, err := ctl.Bets.FindByID(ctx, betID)
+	if err != nil {
+		return err
+	}
+	if bet.OverallStatus != models.Undecided {
+		return nil
+	}
+	creator, err := ctl.Users.FindByUsername(ctx, bet.CreatorName)
+	if err != nil {
+		return fmt.Errorf("bet creator %s not found", bet.CreatorName)
+	}
+	ongoing := containsID(creator.OngoingBets, bet.ID)
+	pending := containsID(creator.OutgoingBetReqs, bet.ID) || containsID(creator.IncomingBetReqs, bet.ID)
+	// Declined requests are left for the expiry sweep
+	if !ongoing && !pending {
+		return nil
+	}
+
+	if bet.Type == models.Conditional {
+		underlying, err := ctl.Bets.FindByBetID(ctx, *bet.Underlying)
+		if err != nil {
+			return err
+		}
+		switch underlying.OverallStatus {
+		case models.Undecided, models.Conflicted:
+			return nil
+		case bet.Condition:
+			bet.Activated = true
+			return ctl.Bets.Replace(ctx, bet)
+		}
+		return ctl.callOffBet(ctx, bet.ID)
+	}
+
+	legs := make(map[string]models.BetStatus, len(bet.Legs))
+	for _, leg := range bet.Legs {
+		underlying, err := ctl.Bets.FindByBetID(ctx, leg.Underlying)
+		if err != nil {
+			return err
+		}
+		legs[leg.Underlying] = underlying.OverallStatus
+	}
+	outcome := bet.ParlayOutcome(legs)
+	if outcome == models.Undecided {
+		return nil
+	}
+	if pending {
+		return ctl.callOffBet(ctx, bet.ID)
+	}
+
+	if outcome == models.Voided {
+		err = ctl.voidBet(ctx, &bet, "ongoingbets")
+	} else {
+		bet.OverallStatus = outcome
+		err = ctl.settleBet(ctx, &bet, "ongoingbets")
+	}
+	if err != nil {
+		return err
+	}
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return err
+	}
+	return ctl.settleDerivedBets(ctx, bet)
+}
@@ -0,0 +1,175 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sends bob a bet request from alice with the given terms on top of 1 to 1 over 10 shares, expiring in two hours
+func (h *harness) derivedRequest(terms map[string]interface{}) (int, map[string]interface{}) {
+	h.t.Helper()
+	body := map[string]interface{}{
+		"creatorname": "alice", "receivername": "bob", "title": "derived",
+		"creatoramount": 1, "receiveramount": 1, "numshares": 10, "expirydate": time.Now().Add(2 * time.Hour),
+	}
+	for k, v := range terms {
+		body[k] = v
+	}
+	return h.do("alice", "POST", "/bets/createbetreq", body)
+}
+
+func (h *harness) derivedBet(terms map[string]interface{}) primitive.ObjectID {
+	h.t.Helper()
+	code, out := h.derivedRequest(terms)
+	if code != http.StatusOK {
+		h.t.Fatalf("creating bet %v: %d %v", terms, code, out)
+	}
+	id := h.objectID(out["InsertedID"])
+	h.must(http.StatusOK, "bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": id, "betreqstatus": models.Accepted})
+	return id
+}
+
+// An ongoing bet to build on, with its ID and the BetID that parlays and conditional bets refer to it by
+func (h *harness) leg() (primitive.ObjectID, string) {
+	h.t.Helper()
+	id := h.acceptedBet("alice", "bob", "leg", time.Now().Add(time.Hour))
+	return id, *h.bet(id).BetID
+}
+
+func (h *harness) settle(betID primitive.ObjectID, outcome models.BetStatus) {
+	h.t.Helper()
+	h.claim("alice", betID, outcome)
+	h.claim("bob", betID, outcome)
+}
+
+func parlay(legs map[string]models.BetStatus) map[string]interface{} {
+	terms := map[string]interface{}{"type": models.Parlay, "legs": []models.BetLeg{}}
+	for underlying, outcome := range legs {
+		terms["legs"] = append(terms["legs"].([]models.BetLeg), models.BetLeg{Underlying: underlying, Outcome: outcome})
+	}
+	return terms
+}
+
+func TestParlay(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	first, firstBetID := h.leg()
+	second, secondBetID := h.leg()
+
+	for name, terms := range map[string]map[string]interface{}{
+		"one leg":         parlay(map[string]models.BetStatus{firstBetID: models.CreatorWon}),
+		"unknown leg":     parlay(map[string]models.BetStatus{firstBetID: models.CreatorWon, "nope": models.CreatorWon}),
+		"bad outcome":     parlay(map[string]models.BetStatus{firstBetID: models.CreatorWon, secondBetID: models.Voided}),
+		"binary with leg": {"underlying": firstBetID},
+		"duplicate leg": {"type": models.Parlay, "legs": []models.BetLeg{
+			{Underlying: firstBetID, Outcome: models.CreatorWon}, {Underlying: firstBetID, Outcome: models.ReceiverWon},
+		}},
+		"expires first": {"type": models.Parlay, "expirydate": time.Now().Add(30 * time.Minute), "legs": []models.BetLeg{
+			{Underlying: firstBetID, Outcome: models.CreatorWon}, {Underlying: secondBetID, Outcome: models.ReceiverWon},
+		}},
+	} {
+		if code, out := h.derivedRequest(terms); code != http.StatusBadRequest {
+			t.Errorf("%s: %d %v", name, code, out)
+		}
+	}
+
+	// alice stands to win 5 a share if the first leg goes to its creator and the second to its receiver
+	won := h.derivedBet(map[string]interface{}{"creatoramount": 5, "type": models.Parlay, "legs": []models.BetLeg{
+		{Underlying: firstBetID, Outcome: models.CreatorWon}, {Underlying: secondBetID, Outcome: models.ReceiverWon},
+	}})
+	if code, out := h.do("alice", "POST", "/bets/resolvebet", map[string]interface{}{"betid": won, "betresolvestatus": models.CreatorWon, "username": "alice"}); code != http.StatusBadRequest {
+		t.Fatalf("resolved a parlay by hand: %d %v", code, out)
+	}
+	h.settle(first, models.CreatorWon)
+	if status := h.bet(won).OverallStatus; status != models.Undecided {
+		t.Fatalf("parlay status %d with a leg still open", status)
+	}
+	h.settle(second, models.ReceiverWon)
+	if status := h.bet(won).OverallStatus; status != models.CreatorWon {
+		t.Fatalf("parlay status %d once every leg went alice's way, want CreatorWon", status)
+	}
+	// alice won 100 on the first leg and lost 30 on the second, on top of 50 on the parlay
+	if alice := h.user("alice"); alice.TotalBalance != 120 {
+		t.Fatalf("alice has a balance of %d, want 120", alice.TotalBalance)
+	}
+
+	// A parlay is lost as soon as one leg is
+	lost, lostBetID := h.leg()
+	_, openBetID := h.leg()
+	lostParlay := h.derivedBet(parlay(map[string]models.BetStatus{lostBetID: models.CreatorWon, openBetID: models.CreatorWon}))
+	h.settle(lost, models.ReceiverWon)
+	if status := h.bet(lostParlay).OverallStatus; status != models.ReceiverWon {
+		t.Fatalf("parlay status %d after losing a leg, want ReceiverWon", status)
+	}
+
+	// A voided leg is dropped, and the parlay goes by the rest
+	voided, voidedBetID := h.leg()
+	remaining, remainingBetID := h.leg()
+	shortened := h.derivedBet(parlay(map[string]models.BetStatus{voidedBetID: models.CreatorWon, remainingBetID: models.CreatorWon}))
+	for _, user := range []string{"alice", "bob"} {
+		h.must(http.StatusOK, user, "POST", "/bets/proposecancel", map[string]interface{}{"betid": voided, "username": user, "cancel": true})
+	}
+	if status := h.bet(shortened).OverallStatus; status != models.Undecided {
+		t.Fatalf("parlay status %d after a leg was voided, want Undecided", status)
+	}
+	h.settle(remaining, models.CreatorWon)
+	if status := h.bet(shortened).OverallStatus; status != models.CreatorWon {
+		t.Fatalf("parlay status %d after its remaining leg was won, want CreatorWon", status)
+	}
+
+	// A parlay that is lost before bob accepts it is withdrawn
+	pendingLeg, pendingLegBetID := h.leg()
+	_, otherBetID := h.leg()
+	code, out := h.derivedRequest(parlay(map[string]models.BetStatus{pendingLegBetID: models.CreatorWon, otherBetID: models.CreatorWon}))
+	if code != http.StatusOK {
+		t.Fatalf("parlay request: %d %v", code, out)
+	}
+	pending := h.objectID(out["InsertedID"])
+	out = h.must(http.StatusOK, "alice", "GET", "/bets?underlying="+pendingLegBetID, nil)
+	if bets := out["bets"].([]interface{}); len(bets) != 1 || bets[0].(map[string]interface{})["id"] != pending.Hex() {
+		t.Fatalf("bets on %s: %v", pendingLegBetID, bets)
+	}
+	h.settle(pendingLeg, models.ReceiverWon)
+	if status := h.bet(pending).OverallStatus; status != models.Cancelled {
+		t.Fatalf("pending parlay status %d after losing a leg, want Cancelled", status)
+	}
+	if bob := h.user("bob"); len(bob.IncomingBetReqs) != 0 {
+		t.Fatalf("bob still has bet requests %v", bob.IncomingBetReqs)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	met, metBetID := h.leg()
+	failed, failedBetID := h.leg()
+
+	if code, out := h.derivedRequest(map[string]interface{}{"type": models.Conditional, "underlying": metBetID, "condition": models.Voided}); code != http.StatusBadRequest {
+		t.Fatalf("conditional bet on the underlying bet being voided: %d %v", code, out)
+	}
+
+	conditional := h.derivedBet(map[string]interface{}{"type": models.Conditional, "underlying": metBetID, "condition": models.CreatorWon})
+	if code, out := h.do("alice", "POST", "/bets/resolvebet", map[string]interface{}{"betid": conditional, "betresolvestatus": models.CreatorWon, "username": "alice"}); code != http.StatusBadRequest {
+		t.Fatalf("resolved a conditional bet before its condition was met: %d %v", code, out)
+	}
+	h.settle(met, models.CreatorWon)
+	if bet := h.bet(conditional); !bet.Activated || bet.OverallStatus != models.Undecided {
+		t.Fatalf("conditional bet activated %t with status %d once its condition was met", bet.Activated, bet.OverallStatus)
+	}
+	h.settle(conditional, models.ReceiverWon)
+	if status := h.bet(conditional).OverallStatus; status != models.ReceiverWon {
+		t.Fatalf("activated conditional bet status %d, want ReceiverWon", status)
+	}
+
+	calledOff := h.derivedBet(map[string]interface{}{"type": models.Conditional, "underlying": failedBetID, "condition": models.CreatorWon})
+	h.settle(failed, models.ReceiverWon)
+	if bet := h.bet(calledOff); bet.Activated || bet.OverallStatus != models.Voided {
+		t.Fatalf("conditional bet activated %t with status %d once its condition failed, want Voided", bet.Activated, bet.OverallStatus)
+	}
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/mail"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const digestBatchSize = 100
+
+func (ctl *Controller) GetNotificationPrefsFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, user.NotificationPrefs)
+}
+
+// Pass in the full set of preferences; anything left out is turned off
+func (ctl *Controller) SetNotificationPrefsFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var prefs models.NotificationPrefs
+
+	if err := c.BindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ctl.Users.SetNotificationPrefs(ctx, username, prefs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// Emails a digest to every user who has not had one in the last digestHours
+// Users with nothing to report get no email, but are not checked again until the next period either
+// A digest that fails to send is tried again on the next run
+// Returns the number of digests sent
+func (ctl *Controller) SendDigests(ctx context.Context, now time.Time) (int, error) {
+	period := time.Duration(config.GlobalConfig.DigestHours) * time.Hour
+	due, err := ctl.Users.FindDigestDue(ctx, now.Add(-period), digestBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, user := range due {
+		data, err := ctl.digestData(ctx, user, now)
+		if err != nil {
+			log.Printf("Could not build digest for %s: %v\n", *user.Username, err)
+			continue
+		}
+		if user.Email != nil && (len(data.BetRequests) > 0 || len(data.ExpiringBets) > 0) {
+			msg, err := mail.DigestTemplate.Render(*user.Email, data)
+			if err != nil {
+				return sent, err
+			}
+			if err := ctl.Mailer.Send(ctx, msg); err != nil {
+				log.Printf("Could not send digest to %s: %v\n", *user.Username, err)
+				continue
+			}
+			sent++
+		}
+		if err := ctl.Users.SetLastDigestDate(ctx, *user.Username, now); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// Pending bet requests waiting on the user, and ongoing bets expiring within expiryWarningHours
+// Only the parts the user has asked for are filled in
+func (ctl *Controller) digestData(ctx context.Context, user models.User, now time.Time) (mail.DigestData, error) {
+	username := *user.Username
+	data := mail.DigestData{
+		Username:     username,
+		BetRequests:  make([]mail.DigestBet, 0),
+		ExpiringBets: make([]mail.DigestBet, 0),
+	}
+
+	if user.NotificationPrefs.DigestBetReqs {
+		reqIDs := append(append([]primitive.ObjectID(nil), user.IncomingBetReqs...), user.OutgoingBetReqs...)
+		for _, betID := range reqIDs {
+			bet, err := ctl.Bets.FindByID(ctx, betID)
+			if err == database.ErrNotFound {
+				continue
+			} else if err != nil {
+				return mail.DigestData{}, err
+			}
+			if bet.OverallStatus == models.Undecided && pendingResponder(bet) == username && bet.ExpiryDate.Time().After(now) {
+				data.BetRequests = append(data.BetRequests, digestBet(bet, username))
+			}
+		}
+	}
+
+	if user.NotificationPrefs.DigestExpiringBets {
+		warnBefore := now.Add(time.Duration(config.GlobalConfig.ExpiryWarningHours) * time.Hour)
+		for _, betID := range user.OngoingBets {
+			bet, err := ctl.Bets.FindByID(ctx, betID)
+			if err == database.ErrNotFound {
+				continue
+			} else if err != nil {
+				return mail.DigestData{}, err
+			}
+			expiry := bet.ExpiryDate.Time()
+			if bet.OverallStatus == models.Undecided && expiry.After(now) && expiry.Before(warnBefore) {
+				data.ExpiringBets = append(data.ExpiringBets, digestBet(bet, username))
+			}
+		}
+	}
+	return data, nil
+}
+
+func digestBet(bet models.Bet, username string) mail.DigestBet {
+	counterparty := bet.CreatorName
+	if username == bet.CreatorName {
+		counterparty = bet.ReceiverName
+	}
+	return mail.DigestBet{Title: bet.Title, Counterparty: counterparty, ExpiryDate: bet.ExpiryDate.Time()}
+}
@@ -0,0 +1,182 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/mail"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fails every send while down is set, and passes the rest on
+type flakyMailer struct {
+	mail.Mailer
+	mu   sync.Mutex
+	down bool
+}
+
+func (m *flakyMailer) setDown(down bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.down = down
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	down := m.down
+	m.mu.Unlock()
+	if down {
+		return errors.New("mail server unavailable")
+	}
+	return m.Mailer.Send(ctx, msg)
+}
+
+// Sets the digest period and expiry warning the digest tests expect
+// This is a harness method because newHarness saves config.GlobalConfig and restores it when the test ends,
+// so the settings only stay out of other tests if they are changed after the harness is made
+func (h *harness) setupDigests() {
+	config.GlobalConfig.DigestHours = 24
+	config.GlobalConfig.ExpiryWarningHours = 48
+}
+
+// A bet request of 10 to 3 over 10 shares; also used to set up bets well beyond the digest tests
+func (h *harness) betRequest(creator, receiver, title string, expiry time.Time) primitive.ObjectID {
+	h.t.Helper()
+	out := h.must(http.StatusOK, creator, "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": creator, "receivername": receiver, "title": title,
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": expiry,
+	})
+	return h.objectID(out["InsertedID"])
+}
+
+// A bet request the receiver has accepted, returning its ID
+func (h *harness) acceptedBet(creator, receiver, title string, expiry time.Time) primitive.ObjectID {
+	h.t.Helper()
+	id := h.betRequest(creator, receiver, title, expiry)
+	h.must(http.StatusOK, receiver, "POST", "/bets/handlebetreq", map[string]interface{}{"betid": id, "betreqstatus": models.Accepted})
+	return id
+}
+
+func (h *harness) sendDigests(now time.Time) int {
+	h.t.Helper()
+	sent, err := h.ctl.SendDigests(context.Background(), now)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return sent
+}
+
+func TestDigestContents(t *testing.T) {
+	h := newHarness(t)
+	h.setupDigests()
+	h.signup("alice", "bob", "carol", "dave", "erin")
+	for _, friend := range []string{"bob", "carol", "dave", "erin"} {
+		h.befriend("alice", friend)
+	}
+	// Digests are next due a day after signing up
+	now := time.Now().Add(25 * time.Hour)
+	h.betRequest("alice", "bob", "Rain", now.Add(72*time.Hour))
+	h.betRequest("alice", "bob", "Fog", time.Now().Add(time.Hour)) // expired by the time the digest goes out
+	h.acceptedBet("alice", "carol", "Snow", now.Add(24*time.Hour))
+	h.acceptedBet("alice", "dave", "Sleet", now.Add(10*24*time.Hour)) // too far off to warn about
+	h.betRequest("alice", "erin", "Hail", now.Add(72*time.Hour))
+	h.must(http.StatusOK, "carol", "POST", "/users/notificationprefs", models.NotificationPrefs{DigestBetReqs: true})
+	h.must(http.StatusOK, "erin", "POST", "/users/notificationprefs", models.NotificationPrefs{DigestExpiringBets: true})
+
+	if sent := h.sendDigests(now); sent != 2 {
+		t.Fatalf("sent %d digests, want 2", sent)
+	}
+	bodies := make(map[string]string)
+	for _, msg := range h.mailer.Sent() {
+		bodies[msg.To] = msg.Body
+	}
+	tests := []struct {
+		email   string
+		has     []string
+		hasNone []string
+	}{
+		// alice is waiting on bob and erin rather than them on her, and only Snow expires soon
+		{"alice@example.com", []string{"Snow"}, []string{"Rain", "Fog", "Sleet", "Hail", "waiting on your answer"}},
+		{"bob@example.com", []string{"Rain", "from alice"}, []string{"Fog", "Snow"}},
+	}
+	for _, tt := range tests {
+		body, ok := bodies[tt.email]
+		if !ok {
+			t.Errorf("no digest sent to %s", tt.email)
+			continue
+		}
+		for _, s := range tt.has {
+			if !strings.Contains(body, s) {
+				t.Errorf("digest to %s does not mention %q:\n%s", tt.email, s, body)
+			}
+		}
+		for _, s := range tt.hasNone {
+			if strings.Contains(body, s) {
+				t.Errorf("digest to %s mentions %q:\n%s", tt.email, s, body)
+			}
+		}
+	}
+	// carol only wants bet requests and erin only expiring bets, and dave has nothing coming up
+	for _, email := range []string{"carol@example.com", "dave@example.com", "erin@example.com"} {
+		if _, ok := bodies[email]; ok {
+			t.Errorf("digest sent to %s, who had nothing to report", email)
+		}
+	}
+
+	// Everyone was checked, so nobody is due again until the next day
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		if got := h.user(name).LastDigestDate; got != primitive.NewDateTimeFromTime(now) {
+			t.Errorf("%s last checked at %v, want %v", name, got.Time(), now)
+		}
+	}
+	if sent := h.sendDigests(now.Add(time.Hour)); sent != 0 {
+		t.Fatalf("sent %d more digests an hour later", sent)
+	}
+	// By the next day Snow has expired, so only bob is still waiting on something
+	if sent := h.sendDigests(now.Add(25 * time.Hour)); sent != 1 {
+		t.Fatalf("sent %d digests the next day, want 1", sent)
+	}
+}
+
+func TestDigestRetriedAfterSendFailure(t *testing.T) {
+	h := newHarness(t)
+	h.setupDigests()
+	mailer := &flakyMailer{Mailer: h.mailer, down: true}
+	h.ctl.Mailer = mailer
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	signedUp := h.user("bob").LastDigestDate
+	now := time.Now().Add(25 * time.Hour)
+	h.betRequest("alice", "bob", "Rain", now.Add(72*time.Hour))
+
+	if sent := h.sendDigests(now); sent != 0 {
+		t.Fatalf("sent %d digests while the mail server was down", sent)
+	}
+	if got := h.user("bob").LastDigestDate; got != signedUp {
+		t.Fatalf("bob's digest failed but his last digest date moved to %v", got.Time())
+	}
+	// alice had nothing to report, so she is done for the day either way
+	if got := h.user("alice").LastDigestDate; got != primitive.NewDateTimeFromTime(now) {
+		t.Fatalf("alice last checked at %v, want %v", got.Time(), now)
+	}
+
+	mailer.setDown(false)
+	later := now.Add(10 * time.Minute)
+	if sent := h.sendDigests(later); sent != 1 {
+		t.Fatalf("sent %d digests once the mail server was back, want 1", sent)
+	}
+	sent := h.mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "bob@example.com" || !strings.Contains(sent[0].Body, "Rain") {
+		t.Fatalf("sent %+v, want bob's digest", sent)
+	}
+	if got := h.user("bob").LastDigestDate; got != primitive.NewDateTimeFromTime(later) {
+		t.Fatalf("bob last checked at %v, want %v", got.Time(), later)
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+const (
+	eventBatchSize    = 100
+	keepAliveInterval = 30 * time.Second
+)
+
+// Everyone directly involved in a bet
+func betAudience(bet models.Bet) []string {
+	audience := []string{bet.CreatorName, bet.ReceiverName}
+	if bet.Arbiter != "" {
+		audience = append(audience, bet.Arbiter)
+	}
+	return audience
+}
+
+// Streams the logged in user's events as Server-Sent Events
+// Clients that reconnect with a Last-Event-ID header first get every event they missed from the event log
+func (ctl *Controller) StreamEventsFunc(c *gin.Context) {
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Event ids are the events' Seq, which follows commit order, so nothing committed late is skipped
+	after := int64(0)
+	if lastID := c.GetHeader("Last-Event-ID"); lastID != "" {
+		seq, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || seq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+		after = seq
+	}
+
+	// Subscribe before reading the log so nothing published in between is missed
+	wake, unsubscribe := ctl.Bus.Subscribe(username)
+	defer unsubscribe()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	c.Stream(func(w io.Writer) bool {
+		events, err := ctl.Events.ListAfter(ctx, username, after, eventBatchSize)
+		if err != nil {
+			log.Printf("Could not read events for %s: %v\n", username, err)
+			return false
+		}
+		for _, event := range events {
+			c.Render(-1, sse.Event{Id: strconv.FormatInt(event.Seq, 10), Event: string(event.Type), Data: event})
+			after = event.Seq
+		}
+		// Stream flushes between steps, so only wait once the log has been read to the end
+		if len(events) > 0 {
+			return true
+		}
+
+		// The log is read again after every keep-alive too, in case a wake-up went to another server
+		select {
+		case _, ok := <-wake:
+			return ok
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+type streamedEvent struct {
+	id    string
+	event models.Event
+}
+
+// Opens GET /events as user and sends each event received down the returned channel until the test ends
+func (h *harness) streamEvents(server *httptest.Server, user, lastEventID string) (int, <-chan streamedEvent) {
+	h.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	h.t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.tokens[user])
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	events := make(chan streamedEvent, 100)
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		close(events)
+		return resp.StatusCode, events
+	}
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		var next streamedEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id:"):
+				next.id = strings.TrimPrefix(line, "id:")
+			case strings.HasPrefix(line, "data:"):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &next.event)
+			case line == "" && next.id != "":
+				events <- next
+				next = streamedEvent{}
+			}
+		}
+	}()
+	return resp.StatusCode, events
+}
+
+// Waits for n events from the stream
+func (h *harness) receive(events <-chan streamedEvent, n int) []streamedEvent {
+	h.t.Helper()
+	received := make([]streamedEvent, 0, n)
+	timeout := time.After(5 * time.Second)
+	for len(received) < n {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				h.t.Fatalf("stream ended after %d events, want %d", len(received), n)
+			}
+			received = append(received, event)
+		case <-timeout:
+			h.t.Fatalf("received %d events, want %d", len(received), n)
+		}
+	}
+	return received
+}
+
+func eventTypes(events []streamedEvent) []models.EventType {
+	types := make([]models.EventType, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.event.Type)
+	}
+	return types
+}
+
+func TestEventStream(t *testing.T) {
+	h := newHarness(t)
+	server := httptest.NewServer(h.router)
+	t.Cleanup(server.Close) // runs after the streams are cancelled, or it would wait on them
+	h.signup("alice", "bob", "carol", "dave")
+
+	// bob is connected before anything happens, so gets everything live
+	status, live := h.streamEvents(server, "bob", "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /events: %d", status)
+	}
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "dave")
+	betID := h.ongoingBet("alice", "bob")
+	h.stake("carol", betID, 3, true)
+	h.stake("dave", betID, 2, false)
+	h.claim("alice", betID, models.CreatorWon)
+	h.claim("bob", betID, models.CreatorWon)
+
+	received := h.receive(live, 8)
+	want := []models.EventType{
+		models.FriendRequestSent, models.FriendRequestAccepted, models.FriendRequestSent, models.FriendRequestAccepted,
+		models.BetRequestCreated, models.BetRequestAccepted, models.BetResolutionClaimed, models.BetResolved,
+	}
+	if got := eventTypes(received); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bob received %v, want %v", got, want)
+	}
+	last := int64(0)
+	for _, event := range received {
+		if event.id != strconv.FormatInt(event.event.Seq, 10) || event.event.Seq <= last {
+			t.Fatalf("event id %s with seq %d after %d", event.id, event.event.Seq, last)
+		}
+		last = event.event.Seq
+	}
+
+	// carol connects afterwards and is replayed her log, or the part of it after the id she last saw
+	logged, err := h.ctl.Events.ListAfter(context.Background(), "carol", 0, 0)
+	if err != nil || len(logged) < 2 {
+		t.Fatalf("carol's event log: %v, %v", logged, err)
+	}
+	_, replay := h.streamEvents(server, "carol", "")
+	if got := h.receive(replay, len(logged)); got[0].event.Seq != logged[0].Seq || got[len(got)-1].event.Seq != logged[len(logged)-1].Seq {
+		t.Fatalf("carol's replay ran from %d to %d, want %d to %d", got[0].event.Seq, got[len(got)-1].event.Seq, logged[0].Seq, logged[len(logged)-1].Seq)
+	}
+	_, resumed := h.streamEvents(server, "carol", strconv.FormatInt(logged[0].Seq, 10))
+	if got := h.receive(resumed, len(logged)-1); got[0].event.Seq != logged[1].Seq {
+		t.Fatalf("resuming after %d started at %d, want %d", logged[0].Seq, got[0].event.Seq, logged[1].Seq)
+	}
+
+	for _, lastEventID := range []string{"zz", "-1"} {
+		if status, _ := h.streamEvents(server, "carol", lastEventID); status != http.StatusBadRequest {
+			t.Errorf("Last-Event-ID %q: %d", lastEventID, status)
+		}
+	}
+	h.tokens["carol"] = "not a token"
+	if status, _ := h.streamEvents(server, "carol", ""); status != http.StatusUnauthorized {
+		t.Errorf("stream with a bad token: %d", status)
+	}
+}
@@ -0,0 +1,150 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/controllers"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/mail"
+	"github.com/simhonchourasia/betfr-be/middleware"
+	"github.com/simhonchourasia/betfr-be/models"
+	"github.com/simhonchourasia/betfr-be/routes"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Serves every route the way server.go does, against in-memory stores
+type harness struct {
+	t      *testing.T
+	router *gin.Engine
+	ctl    *controllers.Controller
+	mailer *mail.MemoryMailer
+	tokens map[string]string // keyed by username
+}
+
+func newHarness(t *testing.T) *harness {
+	return newHarnessWithStores(t, database.NewMemoryStores())
+}
+
+// Config changes made during the test are undone when it finishes
+func newHarnessWithStores(t *testing.T, stores database.Stores) *harness {
+	saved := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = saved })
+	config.GlobalConfig.SecretKey = "test"
+	gin.SetMode(gin.TestMode)
+	mailer := mail.NewMemoryMailer()
+	ctl := controllers.NewController(stores, mailer)
+	r := gin.New()
+	routes.UnprotectedUserRoutes(r, ctl)
+	routes.UnprotectedBetRoutes(r, ctl)
+	routes.UnprotectedStakeRoutes(r, ctl)
+	r.Use(middleware.Authentication(ctl.Users))
+	routes.ProtectedUserRoutes(r, ctl)
+	routes.ProtectedBetRoutes(r, ctl)
+	routes.ProtectedStakeRoutes(r, ctl)
+	routes.ProtectedEventRoutes(r, ctl)
+	routes.ProtectedWebhookRoutes(r, ctl)
+	routes.ProtectedNotificationRoutes(r, ctl)
+	routes.ProtectedSettlementRoutes(r, ctl)
+	routes.ProtectedPoolRoutes(r, ctl)
+	routes.ProtectedTemplateRoutes(r, ctl)
+	return &harness{t: t, router: r, ctl: ctl, mailer: mailer, tokens: make(map[string]string)}
+}
+
+// Sends body as JSON, signed in as user unless user is empty, and decodes the JSON response
+func (h *harness) do(user, method, path string, body interface{}) (int, map[string]interface{}) {
+	h.t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	if token, ok := h.tokens[user]; ok {
+		req.Header.Set("token", token)
+	}
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	out := make(map[string]interface{})
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	return w.Code, out
+}
+
+// Like do, but fails the test unless the response has the given status
+func (h *harness) must(status int, user, method, path string, body interface{}) map[string]interface{} {
+	h.t.Helper()
+	code, out := h.do(user, method, path, body)
+	if code != status {
+		h.t.Fatalf("%s %s as %q: got %d %v, want %d", method, path, user, code, out, status)
+	}
+	return out
+}
+
+func (h *harness) signup(names ...string) {
+	h.t.Helper()
+	for _, name := range names {
+		h.must(http.StatusOK, "", "POST", "/users/signup", map[string]string{"username": name, "email": name + "@example.com", "password": "password"})
+		out := h.must(http.StatusOK, "", "POST", "/users/login", map[string]string{"email": name + "@example.com", "password": "password"})
+		h.tokens[name] = out["token"].(string)
+	}
+}
+
+func (h *harness) befriend(a, b string) {
+	h.t.Helper()
+	h.must(http.StatusOK, a, "POST", "/users/sendfriendreq", map[string]interface{}{"sender": a, "receiver": b})
+	h.must(http.StatusOK, b, "POST", "/users/handlefriendreq", map[string]interface{}{"sender": a, "receiver": b, "friendreqstatus": models.Accepted})
+}
+
+// Creates a bet between friends at 10 to 3 over 10 shares and has the receiver accept it
+func (h *harness) ongoingBet(creator, receiver string) primitive.ObjectID {
+	h.t.Helper()
+	out := h.must(http.StatusOK, creator, "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": creator, "receivername": receiver, "title": "test bet",
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	id := h.objectID(out["InsertedID"])
+	h.must(http.StatusOK, receiver, "POST", "/bets/handlebetreq", map[string]interface{}{"betid": id, "betreqstatus": models.Accepted})
+	return id
+}
+
+func (h *harness) stake(owner string, betID primitive.ObjectID, shares int64, backingCreator bool) primitive.ObjectID {
+	h.t.Helper()
+	out := h.must(http.StatusOK, owner, "POST", "/stakes/createstake", map[string]interface{}{
+		"underlying": betID, "ownername": owner, "numshares": shares, "backingcreator": backingCreator,
+	})
+	return h.objectID(out["InsertedID"])
+}
+
+func (h *harness) objectID(v interface{}) primitive.ObjectID {
+	h.t.Helper()
+	s, _ := v.(string)
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		h.t.Fatalf("%v is not an id: %v", v, err)
+	}
+	return id
+}
+
+func (h *harness) user(name string) models.User {
+	h.t.Helper()
+	user, err := h.ctl.Users.FindByUsername(context.Background(), name)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return user
+}
+
+func (h *harness) bet(id primitive.ObjectID) models.Bet {
+	h.t.Helper()
+	bet, err := h.ctl.Bets.FindByID(context.Background(), id)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return bet
+}
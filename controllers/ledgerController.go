@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Helper function to transfer a balance from one user to another
+// Appends a debit for the loser and a credit for the winner, then refreshes both balance projections
+// Pass primitive.NilObjectID as stakeID when the transfer is not for a stake
+func (ctl *Controller) transferBalance(ctx context.Context, loser string, winner string, amount int64, betID primitive.ObjectID, stakeID primitive.ObjectID) error {
+	if err := ctl.appendTransfer(ctx, loser, winner, amount, models.LedgerEntry{BetID: betID, StakeID: stakeID}); err != nil {
+		return err
+	}
+
+	if err := ctl.refreshBalances(ctx, winner); err != nil {
+		return err
+	}
+	return ctl.refreshBalances(ctx, loser)
+}
+
+// Appends the debit and credit for a transfer without refreshing any balances
+// What the transfer is for (BetID, StakeID, SettlementID or PoolID) is copied from ref
+func (ctl *Controller) appendTransfer(ctx context.Context, loser string, winner string, amount int64, ref models.LedgerEntry) error {
+	if amount < 0 {
+		return fmt.Errorf("cannot transfer negative amount %d from %s to %s", amount, loser, winner)
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	transferID := primitive.NewObjectID()
+	debit := models.LedgerEntry{
+		ID:           primitive.NewObjectID(),
+		TransferID:   transferID,
+		Username:     loser,
+		Counterparty: winner,
+		Direction:    models.Debit,
+		Amount:       amount,
+		BetID:        ref.BetID,
+		StakeID:      ref.StakeID,
+		SettlementID: ref.SettlementID,
+		PoolID:       ref.PoolID,
+		CreateDate:   now,
+	}
+	credit := models.LedgerEntry{
+		ID:           primitive.NewObjectID(),
+		TransferID:   transferID,
+		Username:     winner,
+		Counterparty: loser,
+		Direction:    models.Credit,
+		Amount:       amount,
+		BetID:        ref.BetID,
+		StakeID:      ref.StakeID,
+		SettlementID: ref.SettlementID,
+		PoolID:       ref.PoolID,
+		CreateDate:   now,
+	}
+	return ctl.Ledger.Append(ctx, debit, credit)
+}
+
+// Recomputes User.Balances and User.TotalBalance from the user's ledger entries
+func (ctl *Controller) refreshBalances(ctx context.Context, username string) error {
+	entries, err := ctl.Ledger.ListByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	balances, total := projectBalances(entries)
+	return ctl.Users.SetBalances(ctx, username, balances, total)
+}
+
+// Per-counterparty and total balances implied by a user's ledger entries
+func projectBalances(entries []models.LedgerEntry) (map[string]int64, int64) {
+	balances := make(map[string]int64)
+	var total int64
+	for _, entry := range entries {
+		balances[entry.Counterparty] += entry.SignedAmount()
+		total += entry.SignedAmount()
+	}
+	return balances, total
+}
+
+// GET /users/ledger; lists the logged in user's ledger entries a page at a time, newest first unless order=asc
+func (ctl *Controller) GetLedgerFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if page.SortBy != database.SortByCreateDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be createdate"})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Fetch one extra to find out whether there is another page
+	limit := page.Limit
+	page.Limit++
+	entries, err := ctl.Ledger.List(ctx, username, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := models.LedgerPage{Entries: entries}
+	if len(entries) > limit {
+		res.Entries = entries[:limit]
+		res.NextCursor = database.LedgerCursor(entries[limit-1]).Encode()
+	}
+	c.JSON(http.StatusOK, res)
+}
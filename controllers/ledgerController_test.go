@@ -0,0 +1,126 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *harness) ledgerPage(user, query string) models.LedgerPage {
+	h.t.Helper()
+	req := httptest.NewRequest("GET", "/users/ledger?"+query, nil)
+	req.Header.Set("token", h.tokens[user])
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	var page models.LedgerPage
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+		h.t.Fatalf("ledger %q as %s: %d %s", query, user, w.Code, w.Body.String())
+	}
+	return page
+}
+
+// Every entry in the user's ledger, read a page of the given size at a time
+func (h *harness) ledger(user string, limit int) []models.LedgerEntry {
+	h.t.Helper()
+	entries := make([]models.LedgerEntry, 0)
+	query := "limit=" + strconv.Itoa(limit)
+	for {
+		page := h.ledgerPage(user, query)
+		if len(page.Entries) > limit {
+			h.t.Fatalf("ledger page of %d entries, want at most %d", len(page.Entries), limit)
+		}
+		entries = append(entries, page.Entries...)
+		if page.NextCursor == "" {
+			return entries
+		}
+		query = "limit=" + strconv.Itoa(limit) + "&cursor=" + page.NextCursor
+	}
+}
+
+func TestLedger(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "dave")
+	won := h.ongoingBet("alice", "bob")
+	carolStake := h.stake("carol", won, 2, true)
+	daveStake := h.stake("dave", won, 2, false)
+	h.claim("alice", won, models.CreatorWon)
+	h.claim("bob", won, models.CreatorWon)
+	lost := h.ongoingBet("alice", "bob")
+	h.claim("alice", lost, models.ReceiverWon)
+	h.claim("bob", lost, models.ReceiverWon)
+
+	// alice's ledger is newest first, one entry for each bet
+	page := h.ledgerPage("alice", "")
+	if len(page.Entries) != 2 || page.NextCursor != "" {
+		t.Fatalf("alice's ledger %+v", page)
+	}
+	for i, want := range []struct {
+		betID     primitive.ObjectID
+		direction models.LedgerDirection
+		amount    int64
+	}{{lost, models.Debit, 30}, {won, models.Credit, 100}} {
+		entry := page.Entries[i]
+		if entry.BetID != want.betID || entry.Direction != want.direction || entry.Amount != want.amount || entry.Counterparty != "bob" || !entry.StakeID.IsZero() {
+			t.Errorf("alice's entry %d is %+v, want %+v with bob", i, entry, want)
+		}
+	}
+	if oldest := h.ledgerPage("alice", "order=asc&limit=1"); len(oldest.Entries) != 1 || oldest.Entries[0].BetID != won || oldest.NextCursor == "" {
+		t.Fatalf("alice's oldest entry %+v", oldest)
+	}
+
+	// Stake payouts name both the bet and the stake
+	for name, stakeID := range map[string]primitive.ObjectID{"carol": carolStake, "dave": daveStake} {
+		entries := h.ledger(name, 5)
+		if len(entries) != 1 || entries[0].BetID != won || entries[0].StakeID != stakeID {
+			t.Errorf("%s's ledger %+v, want one entry for stake %s on bet %s", name, entries, stakeID.Hex(), won.Hex())
+		}
+	}
+
+	// Reading the ledger a page at a time gives the same entries as reading it whole, and they add up to
+	// the balances projected from it
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		whole, paged := h.ledger(name, 100), h.ledger(name, 1)
+		if len(whole) != len(paged) {
+			t.Fatalf("%s's ledger has %d entries whole and %d a page at a time", name, len(whole), len(paged))
+		}
+		balances, total := make(map[string]int64), int64(0)
+		for i, entry := range paged {
+			if entry.ID != whole[i].ID {
+				t.Fatalf("%s's entry %d is %s a page at a time and %s whole", name, i, entry.ID.Hex(), whole[i].ID.Hex())
+			}
+			if entry.Username != name {
+				t.Fatalf("%s's ledger has %+v", name, entry)
+			}
+			balances[entry.Counterparty] += entry.SignedAmount()
+			total += entry.SignedAmount()
+		}
+		user := h.user(name)
+		if user.TotalBalance != total {
+			t.Errorf("%s has a balance of %d, but their ledger adds up to %d", name, user.TotalBalance, total)
+		}
+		for counterparty, balance := range balances {
+			if user.Balances[counterparty] != balance {
+				t.Errorf("%s has a balance of %d with %s, but their ledger adds up to %d", name, user.Balances[counterparty], counterparty, balance)
+			}
+		}
+		for counterparty, balance := range user.Balances {
+			if balances[counterparty] != balance {
+				t.Errorf("%s has a balance of %d with %s, but their ledger adds up to %d", name, balance, counterparty, balances[counterparty])
+			}
+		}
+	}
+
+	for _, query := range []string{"cursor=nope", "sort=expirydate", "limit=0"} {
+		if code, out := h.do("alice", "GET", "/users/ledger?"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("ledger with %s: %d %v", query, code, out)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who gets an inbox entry for an event, and what it says
+// Only events that need the user's attention make it into the inbox
+func notificationRecipients(event models.Event) ([]string, string) {
+	switch event.Type {
+	case models.FriendRequestSent:
+		return []string{event.Target}, fmt.Sprintf("%s sent you a friend request", event.Actor)
+	case models.BetRequestCreated:
+		return []string{event.Target}, fmt.Sprintf("%s sent you a bet request", event.Actor)
+	case models.BetRequestCountered:
+		return []string{event.Target}, fmt.Sprintf("%s made a counter-offer on your bet request", event.Actor)
+	case models.BetResolutionClaimed:
+		if event.Result != nil {
+			return []string{event.Target}, fmt.Sprintf("%s reported a result of %g for your bet; confirm or dispute it", event.Actor, *event.Result)
+		}
+		winner := "the creator"
+		if event.Outcome == models.ReceiverWon {
+			winner = "the receiver"
+		}
+		return []string{event.Target}, fmt.Sprintf("%s says %s won your bet; confirm or dispute it", event.Actor, winner)
+	case models.BetResolved:
+		return []string{event.Target}, "You won a bet and have been paid out"
+	case models.StakeFilled:
+		// The user who placed the matching stake already knows about it
+		recipients := make([]string, 0, len(event.Audience))
+		for _, username := range event.Audience {
+			if username != event.Actor {
+				recipients = append(recipients, username)
+			}
+		}
+		return recipients, fmt.Sprintf("%d shares of your stake were matched", event.Shares)
+	case models.StakePaidOut:
+		if event.Amount > 0 {
+			return event.Audience, fmt.Sprintf("Your stake won %d tokens", event.Amount)
+		}
+	case models.SettlementProposed:
+		return []string{event.Target}, fmt.Sprintf("%s says they paid you %d tokens; confirm it to settle up", event.Actor, event.Amount)
+	case models.SettlementConfirmed:
+		return []string{event.Target}, fmt.Sprintf("%s confirmed your payment of %d tokens", event.Actor, event.Amount)
+	case models.SettlementDeclined:
+		return []string{event.Target}, fmt.Sprintf("%s declined your payment of %d tokens", event.Actor, event.Amount)
+	case models.PoolResolved:
+		if event.Amount > 0 {
+			return []string{event.Target}, fmt.Sprintf("A pool you bought into was resolved and you won %d tokens", event.Amount)
+		} else if event.Amount < 0 {
+			return []string{event.Target}, fmt.Sprintf("A pool you bought into was resolved and you lost %d tokens", -event.Amount)
+		}
+		return []string{event.Target}, "A pool you bought into was resolved and you broke even"
+	case models.PoolVoided:
+		return []string{event.Target}, "A pool you bought into was voided, so no tokens changed hands"
+	}
+	return nil, ""
+}
+
+// Adds the event to the inbox of everyone it concerns
+// Runs as a bus hook, so the notifications are written in the same transaction as the event
+func (ctl *Controller) enqueueNotifications(ctx context.Context, event models.Event) error {
+	recipients, message := notificationRecipients(event)
+	notifications := make([]models.Notification, 0, len(recipients))
+	for _, username := range recipients {
+		if username == "" {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			ID:           primitive.NewObjectID(),
+			Username:     username,
+			Type:         event.Type,
+			EventID:      event.ID,
+			Actor:        event.Actor,
+			BetID:        event.BetID,
+			StakeID:      event.StakeID,
+			SettlementID: event.SettlementID,
+			PoolID:       event.PoolID,
+			Message:      message,
+			Read:         false,
+			CreateDate:   event.CreateDate,
+		})
+	}
+	return ctl.Notifications.Insert(ctx, notifications...)
+}
+
+// GET /notifications; pass unread=true to leave out notifications that have been read
+func (ctl *Controller) ListNotificationsFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if page.SortBy != database.SortByCreateDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be createdate"})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	filter := database.NotificationFilter{Username: username, UnreadOnly: c.Query("unread") == "true"}
+
+	// Fetch one extra to find out whether there is another page
+	limit := page.Limit
+	page.Limit++
+	notifications, err := ctl.Notifications.List(ctx, filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := models.NotificationPage{Notifications: notifications}
+	if len(notifications) > limit {
+		res.Notifications = notifications[:limit]
+		res.NextCursor = database.NotificationCursor(notifications[limit-1]).Encode()
+	}
+	res.Unread, err = ctl.Notifications.CountUnread(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// Pass in the IDs of the notifications to mark as read; IDs of other users' notifications are ignored
+func (ctl *Controller) MarkNotificationsReadFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var markReq models.MarkReadRequest
+
+	if err := c.BindJSON(&markReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(markReq); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	marked, err := ctl.Notifications.MarkRead(ctx, username, markReq.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("Marked %d notifications as read", marked), "marked": marked})
+}
+
+func (ctl *Controller) MarkAllNotificationsReadFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	marked, err := ctl.Notifications.MarkAllRead(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("Marked %d notifications as read", marked), "marked": marked})
+}
@@ -0,0 +1,89 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *harness) notifications(user, query string) models.NotificationPage {
+	h.t.Helper()
+	req := httptest.NewRequest("GET", "/notifications?"+query, nil)
+	req.Header.Set("token", h.tokens[user])
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	var page models.NotificationPage
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+		h.t.Fatalf("notifications %q as %s: %d %s", query, user, w.Code, w.Body.String())
+	}
+	return page
+}
+
+func notificationTypes(page models.NotificationPage) []models.EventType {
+	types := make([]models.EventType, 0)
+	for _, n := range page.Notifications {
+		types = append(types, n.Type)
+	}
+	return types
+}
+
+func TestNotifications(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol")
+	h.befriend("alice", "bob")
+	betID := h.ongoingBet("alice", "bob")
+	h.claim("alice", betID, models.CreatorWon)
+
+	// bob is told about the friend request, the bet request and alice's claim, newest first,
+	// but not about anything he did himself
+	page := h.notifications("bob", "")
+	want := []models.EventType{models.BetResolutionClaimed, models.BetRequestCreated, models.FriendRequestSent}
+	if got := notificationTypes(page); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("bob's notifications are %v, want %v", got, want)
+	}
+	if page.Unread != 3 || page.NextCursor != "" {
+		t.Fatalf("unread %d, next cursor %q", page.Unread, page.NextCursor)
+	}
+	if n := page.Notifications[0]; n.Actor != "alice" || n.BetID == nil || *n.BetID != betID || n.Read {
+		t.Fatalf("claim notification %+v", n)
+	}
+	if len(h.notifications("carol", "").Notifications) != 0 {
+		t.Fatal("carol was notified about someone else's bet")
+	}
+	if out := h.must(http.StatusOK, "bob", "GET", "/users/get", nil); out["unreadnotifications"] != 3.0 {
+		t.Fatalf("bob's profile shows %v unread notifications", out["unreadnotifications"])
+	}
+
+	first := h.notifications("bob", "limit=1")
+	if len(first.Notifications) != 1 || first.NextCursor == "" {
+		t.Fatalf("first page %+v", first)
+	}
+	rest := h.notifications("bob", "limit=5&cursor="+first.NextCursor)
+	if got := notificationTypes(rest); len(got) != 2 || got[0] != models.BetRequestCreated {
+		t.Fatalf("second page is %v", got)
+	}
+
+	// Other users' notifications are ignored rather than marked
+	ids := []primitive.ObjectID{first.Notifications[0].ID}
+	if out := h.must(http.StatusOK, "alice", "POST", "/notifications/markread", map[string]interface{}{"ids": ids}); out["marked"] != 0.0 {
+		t.Fatalf("alice marked %v of bob's notifications", out["marked"])
+	}
+	if out := h.must(http.StatusOK, "bob", "POST", "/notifications/markread", map[string]interface{}{"ids": ids}); out["marked"] != 1.0 {
+		t.Fatalf("bob marked %v notifications, want 1", out["marked"])
+	}
+	unread := h.notifications("bob", "unread=true")
+	if got := notificationTypes(unread); len(got) != 2 || got[0] != models.BetRequestCreated || unread.Unread != 2 {
+		t.Fatalf("unread notifications are %v with %d unread", got, unread.Unread)
+	}
+
+	if out := h.must(http.StatusOK, "bob", "POST", "/notifications/markallread", nil); out["marked"] != 2.0 {
+		t.Fatalf("marked %v notifications, want the other 2", out["marked"])
+	}
+	if page := h.notifications("bob", ""); page.Unread != 0 || len(page.Notifications) != 3 {
+		t.Fatalf("%d unread out of %d after marking all read", page.Unread, len(page.Notifications))
+	}
+}
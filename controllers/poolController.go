@@ -0,0 +1,486 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"github.com/simhonchourasia/betfr-be/parimutuel"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Pass in title, description, outcomes, close date and optionally a resolver
+// The logged in user creates the pool; the resolver must be the creator or one of their friends,
+// and if there is none the pool is resolved by a majority vote of the entrants
+func (ctl *Controller) CreatePoolFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var poolReq models.PoolRequest
+
+	if err := c.BindJSON(&poolReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(poolReq); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var created models.Pool
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		created, status, err = ctl.createPool(ctx, username, poolReq)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, created)
+}
+
+func (ctl *Controller) createPool(ctx context.Context, username string, poolReq models.PoolRequest) (models.Pool, int, error) {
+	creator, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil || creator.Deleted {
+		return models.Pool{}, http.StatusNotFound, fmt.Errorf("user %s not found", username)
+	}
+
+	outcomes := make([]string, 0, len(poolReq.Outcomes))
+	seen := make(map[string]bool, len(poolReq.Outcomes))
+	for _, outcome := range poolReq.Outcomes {
+		outcome = strings.TrimSpace(outcome)
+		if outcome == "" {
+			return models.Pool{}, http.StatusBadRequest, fmt.Errorf("outcomes cannot be blank")
+		}
+		if seen[strings.ToLower(outcome)] {
+			return models.Pool{}, http.StatusBadRequest, fmt.Errorf("outcome %q is listed more than once", outcome)
+		}
+		seen[strings.ToLower(outcome)] = true
+		outcomes = append(outcomes, outcome)
+	}
+
+	resolver := strings.TrimSpace(poolReq.Resolver)
+	if resolver != "" && resolver != username {
+		if !containsString(creator.Friends, resolver) {
+			return models.Pool{}, http.StatusBadRequest, fmt.Errorf("the resolver must be you or one of your friends, and %s is not a friend", resolver)
+		}
+		if user, err := ctl.Users.FindByUsername(ctx, resolver); err != nil || user.Deleted {
+			return models.Pool{}, http.StatusBadRequest, fmt.Errorf("resolver %s not found", resolver)
+		}
+	}
+
+	now := time.Now()
+	if poolReq.CloseDate.Time().Before(now.Add(5 * time.Minute)) {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("pools cannot be created with less than 5 minutes until buy-ins close")
+	}
+
+	created := models.Pool{
+		ID:          primitive.NewObjectID(),
+		CreatorName: username,
+		Title:       poolReq.Title,
+		Description: poolReq.Description,
+		Outcomes:    outcomes,
+		Resolver:    resolver,
+		Entries:     make([]models.PoolEntry, 0),
+		Votes:       make([]models.PoolVote, 0),
+		Status:      models.PoolOpen,
+		Transfers:   make([]models.Debt, 0),
+		CloseDate:   poolReq.CloseDate,
+		CreateDate:  primitive.NewDateTimeFromTime(now),
+	}
+	if err := ctl.Pools.Insert(ctx, created); err != nil {
+		return models.Pool{}, http.StatusInternalServerError, err
+	}
+	return created, http.StatusOK, nil
+}
+
+// Pass in pool ID, outcome index and amount
+// The logged in user buys in on an outcome; buying in again adds to their entry, which must be on the same outcome
+func (ctl *Controller) BuyInPoolFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var buyIn models.PoolBuyIn
+
+	if err := c.BindJSON(&buyIn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(buyIn); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updated models.Pool
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		updated, status, err = ctl.buyIntoPool(ctx, username, buyIn)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func (ctl *Controller) buyIntoPool(ctx context.Context, username string, buyIn models.PoolBuyIn) (models.Pool, int, error) {
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return models.Pool{}, http.StatusNotFound, fmt.Errorf("user %s not found", username)
+	}
+	pool, status, err := ctl.findVisiblePool(ctx, buyIn.PoolID, user)
+	if err != nil {
+		return models.Pool{}, status, err
+	}
+
+	if pool.Status != models.PoolOpen {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("pool has already been resolved")
+	}
+	now := time.Now()
+	if !now.Before(pool.CloseDate.Time()) {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("buy-ins for this pool have closed")
+	}
+	if len(pool.Votes) > 0 {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("buy-ins for this pool closed when voting started")
+	}
+	if username == pool.Resolver {
+		return models.Pool{}, http.StatusForbidden, fmt.Errorf("the resolver of a pool cannot buy into it")
+	}
+	// Friendship is mutual, so the user's own list is enough
+	if username != pool.CreatorName && !containsString(user.Friends, pool.CreatorName) {
+		return models.Pool{}, http.StatusForbidden, fmt.Errorf("only %s and their friends can buy into this pool", pool.CreatorName)
+	}
+	if buyIn.Outcome >= len(pool.Outcomes) {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("pool has no outcome %d", buyIn.Outcome)
+	}
+
+	var total int64
+	for _, entry := range pool.Entries {
+		total += entry.Amount
+	}
+	if buyIn.Amount > math.MaxInt64-total {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("buy-in of %d would make the pool too large", buyIn.Amount)
+	}
+
+	if entry, ok := pool.Entry(username); ok {
+		if entry.Outcome != buyIn.Outcome {
+			return models.Pool{}, http.StatusBadRequest, fmt.Errorf("you have already backed %q in this pool", pool.Outcomes[entry.Outcome])
+		}
+		for i := range pool.Entries {
+			if pool.Entries[i].Username == username {
+				pool.Entries[i].Amount += buyIn.Amount
+			}
+		}
+	} else {
+		pool.Entries = append(pool.Entries, models.PoolEntry{
+			Username:   username,
+			Outcome:    buyIn.Outcome,
+			Amount:     buyIn.Amount,
+			CreateDate: primitive.NewDateTimeFromTime(now),
+		})
+	}
+
+	if err := ctl.Pools.Replace(ctx, pool); err != nil {
+		return models.Pool{}, http.StatusInternalServerError, err
+	}
+	return pool, http.StatusOK, nil
+}
+
+// Pass in pool ID and the index of the outcome that happened
+// The resolver's ruling pays the pool out straight away; if there is no resolver this is the logged in entrant's vote,
+// which can be changed until more than half of the entrants agree on an outcome
+func (ctl *Controller) ResolvePoolFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var poolResolve models.PoolResolve
+
+	if err := c.BindJSON(&poolResolve); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(poolResolve); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updated models.Pool
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		updated, status, err = ctl.resolvePool(ctx, username, poolResolve)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func (ctl *Controller) resolvePool(ctx context.Context, username string, poolResolve models.PoolResolve) (models.Pool, int, error) {
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return models.Pool{}, http.StatusNotFound, fmt.Errorf("user %s not found", username)
+	}
+	pool, status, err := ctl.findVisiblePool(ctx, poolResolve.PoolID, user)
+	if err != nil {
+		return models.Pool{}, status, err
+	}
+
+	if pool.Status != models.PoolOpen {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("pool has already been resolved")
+	}
+	if poolResolve.Outcome >= len(pool.Outcomes) {
+		return models.Pool{}, http.StatusBadRequest, fmt.Errorf("pool has no outcome %d", poolResolve.Outcome)
+	}
+
+	if pool.Resolver != "" {
+		if username != pool.Resolver {
+			return models.Pool{}, http.StatusForbidden, fmt.Errorf("only %s can resolve this pool", pool.Resolver)
+		}
+		if err := ctl.payOutPool(ctx, &pool, poolResolve.Outcome, username); err != nil {
+			return models.Pool{}, http.StatusInternalServerError, err
+		}
+		return pool, http.StatusOK, nil
+	}
+
+	if _, ok := pool.Entry(username); !ok {
+		return models.Pool{}, http.StatusForbidden, fmt.Errorf("only users who have bought into this pool can vote on it")
+	}
+	votes := make([]models.PoolVote, 0, len(pool.Votes)+1)
+	for _, vote := range pool.Votes {
+		if vote.Username != username {
+			votes = append(votes, vote)
+		}
+	}
+	pool.Votes = append(votes, models.PoolVote{Username: username, Outcome: poolResolve.Outcome})
+
+	// Votes are counted per entrant, not per token bought in
+	agreed := 0
+	for _, vote := range pool.Votes {
+		if vote.Outcome == poolResolve.Outcome {
+			agreed++
+		}
+	}
+	if 2*agreed > len(pool.Entries) {
+		if err := ctl.payOutPool(ctx, &pool, poolResolve.Outcome, username); err != nil {
+			return models.Pool{}, http.StatusInternalServerError, err
+		}
+		return pool, http.StatusOK, nil
+	}
+
+	if err := ctl.Pools.Replace(ctx, pool); err != nil {
+		return models.Pool{}, http.StatusInternalServerError, err
+	}
+	return pool, http.StatusOK, nil
+}
+
+// Resolves the pool with the given winning outcome, moving balances from losers to winners
+// If nobody backed the winning outcome the pool is called off instead
+func (ctl *Controller) payOutPool(ctx context.Context, pool *models.Pool, winning int, actor string) error {
+	pool.WinningOutcome = &winning
+	pool.ResolveDate = primitive.NewDateTimeFromTime(time.Now())
+
+	payout, ok := parimutuel.Resolve(pool.Entries, winning)
+	if !ok {
+		pool.Status = models.PoolCalledOff
+		if err := ctl.Pools.Replace(ctx, *pool); err != nil {
+			return err
+		}
+		return ctl.publishPool(ctx, models.PoolVoided, *pool, actor, nil)
+	}
+
+	ref := models.LedgerEntry{PoolID: pool.ID}
+	for _, transfer := range payout.Transfers {
+		if err := ctl.appendTransfer(ctx, transfer.From, transfer.To, transfer.Amount, ref); err != nil {
+			return err
+		}
+	}
+	for _, entry := range pool.Entries {
+		if err := ctl.refreshBalances(ctx, entry.Username); err != nil {
+			return err
+		}
+	}
+
+	pool.Status = models.PoolPaidOut
+	pool.Transfers = payout.Transfers
+	if err := ctl.Pools.Replace(ctx, *pool); err != nil {
+		return err
+	}
+	return ctl.publishPool(ctx, models.PoolResolved, *pool, actor, payout.Winnings)
+}
+
+// Calls off an open pool the user is leaving because their account is being deleted
+// A pool the user created or resolves is voided; otherwise just their entry and vote are taken out
+func (ctl *Controller) leavePool(ctx context.Context, pool models.Pool, username string) error {
+	if username == pool.CreatorName || username == pool.Resolver {
+		pool.Status = models.PoolCalledOff
+		pool.ResolveDate = primitive.NewDateTimeFromTime(time.Now())
+		if err := ctl.Pools.Replace(ctx, pool); err != nil {
+			return err
+		}
+		return ctl.publishPool(ctx, models.PoolVoided, pool, username, nil)
+	}
+
+	entries := make([]models.PoolEntry, 0, len(pool.Entries))
+	for _, entry := range pool.Entries {
+		if entry.Username != username {
+			entries = append(entries, entry)
+		}
+	}
+	votes := make([]models.PoolVote, 0, len(pool.Votes))
+	for _, vote := range pool.Votes {
+		if vote.Username != username {
+			votes = append(votes, vote)
+		}
+	}
+	pool.Entries = entries
+	pool.Votes = votes
+	return ctl.Pools.Replace(ctx, pool)
+}
+
+// Open pools the user created, resolves or has bought into
+func (ctl *Controller) openPools(ctx context.Context, username string) ([]models.Pool, error) {
+	pools, err := ctl.Pools.ListByUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	open := make([]models.Pool, 0)
+	for _, pool := range pools {
+		if pool.Status == models.PoolOpen {
+			open = append(open, pool)
+		}
+	}
+	return open, nil
+}
+
+// GET /pools/:id
+func (ctl *Controller) GetPoolFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	poolID, err := parseIDParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	pool, status, err := ctl.findVisiblePool(ctx, poolID, user)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pool)
+}
+
+// Pools the logged in user created, resolves or has bought into, newest first
+func (ctl *Controller) ListPoolsFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	pools, err := ctl.Pools.ListByUser(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pools": pools})
+}
+
+// Pools the user is not allowed to see are reported as missing
+func (ctl *Controller) findVisiblePool(ctx context.Context, poolID primitive.ObjectID, user models.User) (models.Pool, int, error) {
+	pool, err := ctl.Pools.FindByID(ctx, poolID)
+	if err == database.ErrNotFound || (err == nil && !pool.VisibleTo(*user.Username, user.Friends)) {
+		return models.Pool{}, http.StatusNotFound, fmt.Errorf("pool ID %s not found", poolID.Hex())
+	} else if err != nil {
+		return models.Pool{}, http.StatusInternalServerError, err
+	}
+	return pool, http.StatusOK, nil
+}
+
+// Sends each entrant their own event, with what they won or lost if the pool was paid out
+func (ctl *Controller) publishPool(ctx context.Context, eventType models.EventType, pool models.Pool, actor string, winnings map[string]int64) error {
+	poolID := pool.ID
+	for _, entry := range pool.Entries {
+		amount := int64(0)
+		if winnings != nil {
+			if won, ok := winnings[entry.Username]; ok {
+				amount = won
+			} else {
+				amount = -entry.Amount
+			}
+		}
+		err := ctl.Bus.Publish(ctx, models.Event{
+			Type:     eventType,
+			Audience: []string{entry.Username},
+			Actor:    actor,
+			Target:   entry.Username,
+			PoolID:   &poolID,
+			Amount:   amount,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
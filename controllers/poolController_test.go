@@ -0,0 +1,137 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+func (h *harness) pool(creator, resolver string, outcomes ...string) string {
+	h.t.Helper()
+	out := h.must(http.StatusOK, creator, "POST", "/pools/create", map[string]interface{}{
+		"title": "league", "outcomes": outcomes, "resolver": resolver, "closedate": time.Now().Add(time.Hour),
+	})
+	return out["id"].(string)
+}
+
+func (h *harness) buyIn(user, poolID string, outcome int, amount int64) (int, map[string]interface{}) {
+	return h.do(user, "POST", "/pools/buyin", map[string]interface{}{"poolid": poolID, "outcome": outcome, "amount": amount})
+}
+
+func (h *harness) resolvePool(user, poolID string, outcome int) (int, map[string]interface{}) {
+	return h.do(user, "POST", "/pools/resolve", map[string]interface{}{"poolid": poolID, "outcome": outcome})
+}
+
+func TestPoolWithResolver(t *testing.T) {
+	h := newHarness(t)
+	h.signup("host", "ann", "ben", "cat", "ref", "stranger")
+	for _, friend := range []string{"ann", "ben", "cat", "ref"} {
+		h.befriend("host", friend)
+	}
+
+	create := func(outcomes []string, resolver string) (int, map[string]interface{}) {
+		return h.do("host", "POST", "/pools/create", map[string]interface{}{
+			"title": "league", "outcomes": outcomes, "resolver": resolver, "closedate": time.Now().Add(time.Hour),
+		})
+	}
+	if code, out := create([]string{"A", "a"}, "ref"); code != http.StatusBadRequest {
+		t.Fatalf("created a pool with the same outcome twice: %d %v", code, out)
+	}
+	if code, out := create([]string{"A", "B", "C"}, "stranger"); code != http.StatusBadRequest {
+		t.Fatalf("created a pool resolved by a stranger: %d %v", code, out)
+	}
+	poolID := h.pool("host", "ref", "A", "B", "C")
+
+	for _, tt := range []struct {
+		user    string
+		outcome int
+		status  int
+	}{
+		{"stranger", 0, http.StatusNotFound}, // not the host's friend, so cannot see the pool
+		{"ref", 0, http.StatusForbidden},
+		{"ann", 3, http.StatusBadRequest},
+	} {
+		if code, out := h.buyIn(tt.user, poolID, tt.outcome, 5); code != tt.status {
+			t.Errorf("%s buying into outcome %d: %d %v, want %d", tt.user, tt.outcome, code, out, tt.status)
+		}
+	}
+	for _, entry := range []struct {
+		user    string
+		outcome int
+		amount  int64
+	}{{"host", 0, 10}, {"ann", 0, 15}, {"ben", 1, 30}, {"cat", 2, 1}, {"ann", 0, 5}} {
+		if code, out := h.buyIn(entry.user, poolID, entry.outcome, entry.amount); code != http.StatusOK {
+			t.Fatalf("%s buying into outcome %d: %d %v", entry.user, entry.outcome, code, out)
+		}
+	}
+	if code, out := h.buyIn("ann", poolID, 1, 5); code != http.StatusBadRequest {
+		t.Fatalf("ann backed a second outcome: %d %v", code, out)
+	}
+
+	if code, out := h.resolvePool("ann", poolID, 0); code != http.StatusForbidden {
+		t.Fatalf("ann resolved a pool with a resolver: %d %v", code, out)
+	}
+	code, out := h.resolvePool("ref", poolID, 0)
+	if code != http.StatusOK || out["status"] != float64(models.PoolPaidOut) {
+		t.Fatalf("ref resolving the pool: %d %v", code, out)
+	}
+	// ben and cat's 31 is split 10:20 between host and ann, and the leftover token goes to ann
+	for name, want := range map[string]int64{"host": 10, "ann": 21, "ben": -30, "cat": -1, "ref": 0} {
+		if got := h.user(name).TotalBalance; got != want {
+			t.Errorf("%s has a balance of %d, want %d", name, got, want)
+		}
+	}
+	if code, out := h.resolvePool("ref", poolID, 1); code != http.StatusBadRequest {
+		t.Fatalf("resolved a pool twice: %d %v", code, out)
+	}
+}
+
+func TestPoolMajorityVote(t *testing.T) {
+	h := newHarness(t)
+	h.signup("host", "ann", "ben", "cat")
+	for _, friend := range []string{"ann", "ben", "cat"} {
+		h.befriend("host", friend)
+	}
+	poolID := h.pool("host", "", "X", "Y")
+	h.buyIn("ann", poolID, 0, 5)
+	h.buyIn("ben", poolID, 1, 5)
+	h.buyIn("cat", poolID, 1, 5)
+
+	if code, out := h.resolvePool("host", poolID, 0); code != http.StatusForbidden {
+		t.Fatalf("host voted without buying in: %d %v", code, out)
+	}
+	if code, out := h.resolvePool("ann", poolID, 0); code != http.StatusOK || out["status"] != float64(models.PoolOpen) {
+		t.Fatalf("first vote: %d %v", code, out)
+	}
+	if code, out := h.buyIn("host", poolID, 0, 5); code != http.StatusBadRequest {
+		t.Fatalf("host bought in after voting started: %d %v", code, out)
+	}
+	if code, out := h.resolvePool("ben", poolID, 0); code != http.StatusOK || out["status"] != float64(models.PoolPaidOut) || out["winningoutcome"] != 0.0 {
+		t.Fatalf("majority vote: %d %v", code, out)
+	}
+	for name, want := range map[string]int64{"ann": 10, "ben": -5, "cat": -5} {
+		if got := h.user(name).TotalBalance; got != want {
+			t.Errorf("%s has a balance of %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestDeleteAccountInOpenPool(t *testing.T) {
+	h := newHarness(t)
+	h.signup("host", "ann")
+	h.befriend("host", "ann")
+	poolID := h.pool("host", "host", "X", "Y")
+	h.buyIn("ann", poolID, 0, 5)
+
+	deleteAnn := map[string]interface{}{"username": "ann", "password": "password"}
+	h.must(http.StatusConflict, "ann", "DELETE", "/users/deleteuser", deleteAnn)
+	deleteAnn["force"] = true
+	h.must(http.StatusOK, "ann", "DELETE", "/users/deleteuser", deleteAnn)
+	// Only ann's entry goes; the pool stays open for everyone else
+	out := h.must(http.StatusOK, "host", "GET", "/pools/"+poolID, nil)
+	if entries, _ := out["entries"].([]interface{}); out["status"] != float64(models.PoolOpen) || len(entries) != 0 {
+		t.Fatalf("pool after ann left: %v", out)
+	}
+}
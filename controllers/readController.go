@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Reads the sort, order, limit and cursor query parameters shared by every list endpoint
+func parsePageRequest(c *gin.Context) (database.PageRequest, error) {
+	page := database.PageRequest{
+		SortBy: c.DefaultQuery("sort", database.SortByCreateDate),
+		Limit:  defaultPageSize,
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		page.Descending = false
+	case "desc":
+		page.Descending = true
+	default:
+		return page, fmt.Errorf("order must be asc or desc")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.Limit = n
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := database.DecodeCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		page.After = after
+	}
+	return page, nil
+}
+
+// Parses an optional RFC 3339 time query parameter
+func parseTimeQuery(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", key)
+	}
+	return t, nil
+}
+
+func parseIDParam(c *gin.Context) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return id, fmt.Errorf("invalid ID %s", c.Param("id"))
+	}
+	return id, nil
+}
+
+// Whether the user can see the bet under its visibility level
+func (ctl *Controller) canViewBet(ctx context.Context, bet models.Bet, username string) (bool, error) {
+	viewer, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		return false, fmt.Errorf("user %s not found", username)
+	}
+	return bet.VisibleTo(username, viewer.Friends), nil
+}
+
+// GET /bets/:id
+func (ctl *Controller) GetBetFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	betID, err := parseIDParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	bet, err := ctl.Bets.FindByID(ctx, betID)
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("bet ID %s not found", betID.Hex())})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Bets the caller is not allowed to see are reported as missing
+	visible, err := ctl.canViewBet(ctx, bet, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("bet ID %s not found", betID.Hex())})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.NewBetView(bet))
+}
+
+// GET /bets, filtered by the participant, status, underlying, from and to query parameters
+func (ctl *Controller) ListBetsFunc(c *gin.Context) {
+	var filter database.BetFilter
+	filter.Participant = c.Query("participant")
+	filter.Underlying = c.Query("underlying")
+
+	if status := c.Query("status"); status != "" {
+		n, err := strconv.Atoi(status)
+		if err != nil || n < int(models.Undecided) || n > int(models.Voided) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bet status " + status})
+			return
+		}
+		betStatus := models.BetStatus(n)
+		filter.Status = &betStatus
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.CreatedBefore, err = parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctl.listBets(c, filter)
+}
+
+// GET /users/:username/bets
+func (ctl *Controller) ListUserBetsFunc(c *gin.Context) {
+	ctl.listBets(c, database.BetFilter{Participant: c.Param("username")})
+}
+
+func (ctl *Controller) listBets(c *gin.Context, filter database.BetFilter) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if page.SortBy != database.SortByCreateDate && page.SortBy != database.SortByExpiryDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be createdate or expirydate"})
+		return
+	}
+
+	// Only list bets the caller is allowed to see
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	viewer, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("user %s not found", username)})
+		return
+	}
+	filter.Viewer = username
+	filter.ViewerFriends = viewer.Friends
+
+	// Fetch one extra to find out whether there is another page
+	limit := page.Limit
+	page.Limit++
+	bets, err := ctl.Bets.List(ctx, filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := models.BetPage{Bets: make([]models.BetView, 0, len(bets))}
+	if len(bets) > limit {
+		bets = bets[:limit]
+		res.NextCursor = database.BetCursor(bets[limit-1], page.SortBy).Encode()
+	}
+	for _, bet := range bets {
+		res.Bets = append(res.Bets, models.NewBetView(bet))
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /users/search?q=prefix
+// Users who have blocked the caller are never returned
+func (ctl *Controller) SearchUsersFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := defaultPageSize
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+		limit = n
+	}
+
+	users, err := ctl.Users.Search(ctx, c.Query("q"), username, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make([]models.UserSummary, 0, len(users))
+	for _, user := range users {
+		res = append(res, models.NewUserSummary(user))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /stakes/:id
+func (ctl *Controller) GetStakeFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	stakeID, err := parseIDParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	stake, err := ctl.Stakes.FindByID(ctx, stakeID)
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("stake ID %s not found", stakeID.Hex())})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A stake is as visible as the bet it is on, except to its owner
+	if stake.OwnerName != username {
+		bet, err := ctl.Bets.FindByID(ctx, stake.Underlying)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		visible, err := ctl.canViewBet(ctx, bet, username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !visible {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("stake ID %s not found", stakeID.Hex())})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.NewStakeView(stake))
+}
+
+// GET /users/:username/stakes
+func (ctl *Controller) ListUserStakesFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if page.SortBy != database.SortByCreateDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be createdate"})
+		return
+	}
+
+	// Fetch one extra to find out whether there is another page
+	limit := page.Limit
+	page.Limit++
+	stakes, err := ctl.Stakes.List(ctx, database.StakeFilter{OwnerName: c.Param("username")}, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := models.StakePage{Stakes: make([]models.StakeView, 0, len(stakes))}
+	if len(stakes) > limit {
+		stakes = stakes[:limit]
+		res.NextCursor = database.StakeCursor(stakes[limit-1]).Encode()
+	}
+
+	// Stakes on bets the caller cannot see are left out, so a page may come back short
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	viewer, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("user %s not found", username)})
+		return
+	}
+	visible := make(map[primitive.ObjectID]bool)
+	for _, stake := range stakes {
+		if stake.OwnerName != username {
+			if _, ok := visible[stake.Underlying]; !ok {
+				bet, err := ctl.Bets.FindByID(ctx, stake.Underlying)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				visible[stake.Underlying] = bet.VisibleTo(username, viewer.Friends)
+			}
+			if !visible[stake.Underlying] {
+				continue
+			}
+		}
+		res.Stakes = append(res.Stakes, models.NewStakeView(stake))
+	}
+
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,144 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func titles(out map[string]interface{}) []string {
+	titles := make([]string, 0)
+	for _, bet := range out["bets"].([]interface{}) {
+		titles = append(titles, bet.(map[string]interface{})["title"].(string))
+	}
+	return titles
+}
+
+func TestReadBetsAndStakes(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	// Bets created later expire sooner, so sorting by expiry reverses them
+	expiry := time.Now().Add(time.Hour)
+	var last primitive.ObjectID
+	for i := 0; i < 5; i++ {
+		last = h.betRequest("alice", "bob", fmt.Sprint(i), expiry.Add(time.Duration(5-i)*time.Minute))
+	}
+	h.must(http.StatusOK, "bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": last, "betreqstatus": models.Accepted})
+	stakeID := h.stake("carol", last, 2, true)
+
+	if out := h.must(http.StatusOK, "carol", "GET", "/bets/"+last.Hex(), nil); out["title"] != "4" || out["creatorstaked"] != 2.0 {
+		t.Errorf("GET /bets/:id returned %v", out)
+	}
+	if out := h.must(http.StatusOK, "carol", "GET", "/stakes/"+stakeID.Hex(), nil); out["ownername"] != "carol" || out["sharesstaked"] != 2.0 {
+		t.Errorf("GET /stakes/:id returned %v", out)
+	}
+	if out := h.must(http.StatusOK, "carol", "GET", "/users/carol/stakes", nil); len(out["stakes"].([]interface{})) != 1 {
+		t.Errorf("carol's stakes: %v", out)
+	}
+	h.must(http.StatusBadRequest, "carol", "GET", "/bets/zzz", nil)
+	h.must(http.StatusNotFound, "carol", "GET", "/bets/"+primitive.NewObjectID().Hex(), nil)
+
+	var pages [][]string
+	cursor := ""
+	for len(pages) < 5 {
+		out := h.must(http.StatusOK, "carol", "GET", "/users/alice/bets?limit=2&sort=expirydate&order=asc&cursor="+cursor, nil)
+		pages = append(pages, titles(out))
+		if cursor = out["nextcursor"].(string); cursor == "" {
+			break
+		}
+	}
+	if want := [][]string{{"4", "3"}, {"2", "1"}, {"0"}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %v, want %v", pages, want)
+	}
+	if got := titles(h.must(http.StatusOK, "carol", "GET", "/bets?participant=bob&order=asc", nil)); !reflect.DeepEqual(got, []string{"0", "1", "2", "3", "4"}) {
+		t.Errorf("bob's bets by creation date: %v", got)
+	}
+	if got := titles(h.must(http.StatusOK, "carol", "GET", fmt.Sprintf("/bets?participant=bob&status=%d", models.Undecided), nil)); len(got) != 5 {
+		t.Errorf("bob's undecided bets: %v", got)
+	}
+	if got := titles(h.must(http.StatusOK, "carol", "GET", "/bets?from=2000-01-01T00:00:00Z&to=2001-01-01T00:00:00Z", nil)); len(got) != 0 {
+		t.Errorf("bets created in 2000: %v", got)
+	}
+	for _, query := range []string{"status=9", "sort=title", "order=up", "limit=0", "limit=101", "cursor=zzz", "from=yesterday"} {
+		if code, out := h.do("carol", "GET", "/bets?"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /bets?%s: %d %v", query, code, out)
+		}
+	}
+}
+
+func TestBetVisibility(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "dave")
+	bet := func(title string, visibility models.BetVisibility) primitive.ObjectID {
+		out := h.must(http.StatusOK, "alice", "POST", "/bets/createbetreq", map[string]interface{}{
+			"creatorname": "alice", "receivername": "bob", "title": title, "visibility": visibility,
+			"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+		})
+		id := h.objectID(out["InsertedID"])
+		h.must(http.StatusOK, "bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": id, "betreqstatus": models.Accepted})
+		return id
+	}
+
+	// Bet requests only go to friends
+	h.signup("erin")
+	code, out := h.do("alice", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "alice", "receivername": "erin", "title": "Fog",
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	if code != http.StatusForbidden {
+		t.Fatalf("bet request to a stranger: %d %v", code, out)
+	}
+
+	private := bet("Private", models.Private)
+	friends := bet("Friends", models.FriendsOfParticipants)
+	public := bet("Public", models.Public)
+	tests := []struct {
+		user    string
+		id      primitive.ObjectID
+		visible bool
+	}{
+		{"bob", private, true},
+		{"carol", private, false},
+		{"carol", friends, true},
+		{"dave", friends, true}, // a friend of bob's
+		{"erin", friends, false},
+		{"erin", public, true},
+	}
+	for _, tt := range tests {
+		code, _ := h.do(tt.user, "GET", "/bets/"+tt.id.Hex(), nil)
+		if (code == http.StatusOK) != tt.visible {
+			t.Errorf("%s reading %s got %d", tt.user, h.bet(tt.id).Title, code)
+		}
+		if tt.user == "bob" {
+			continue
+		}
+		code, _ = h.do(tt.user, "POST", "/stakes/createstake", map[string]interface{}{"underlying": tt.id, "ownername": tt.user, "numshares": 1, "backingcreator": true})
+		want := http.StatusNotFound
+		if tt.visible {
+			want = http.StatusOK
+		}
+		if code != want {
+			t.Errorf("%s staking on %s got %d", tt.user, h.bet(tt.id).Title, code)
+		}
+	}
+
+	for user, want := range map[string][]string{
+		"bob":   {"Private", "Friends", "Public"},
+		"carol": {"Friends", "Public"},
+		"erin":  {"Public"},
+	} {
+		if got := titles(h.must(http.StatusOK, user, "GET", "/users/alice/bets?order=asc", nil)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s listing alice's bets got %v, want %v", user, got, want)
+		}
+	}
+}
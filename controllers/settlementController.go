@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"github.com/simhonchourasia/betfr-be/settlement"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxSettlementMembers = 50
+
+// GET /settlements/plan; pass members as a comma-separated list of usernames to pick the group
+// The group defaults to the logged in user and all of their friends
+// Each transfer lists the debts it pays down, which can run through other members; proposing it with those
+// paid debts settles it, and the transfers can be settled in any order
+func (ctl *Controller) GetSettlementPlanFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var requested []string
+	if raw := c.Query("members"); raw != "" {
+		requested = strings.Split(raw, ",")
+	}
+	members, err := settlementMembers(user, requested, append([]string{username}, user.Friends...))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	debts, status, err := ctl.groupDebts(ctx, members)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.SettlementPlan{
+		Members:   members,
+		Debts:     debts,
+		Transfers: settlement.Plan(debts),
+	})
+}
+
+// The logged in user says they have paid the payee outside the app
+// The payment can be at most what the user owes the payee, or a transfer from a settlement plan that pays
+// down a chain of debts to the payee, and is settled once the payee confirms it
+func (ctl *Controller) ProposeSettlementFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var proposal models.SettlementProposal
+
+	if err := c.BindJSON(&proposal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(proposal); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if proposal.Payee == username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot settle up with yourself"})
+		return
+	}
+
+	var proposed models.Settlement
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		proposed, status, err = ctl.proposeSettlement(ctx, username, proposal)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, proposed)
+}
+
+func (ctl *Controller) proposeSettlement(ctx context.Context, payer string, proposal models.SettlementProposal) (models.Settlement, int, error) {
+	user, err := ctl.Users.FindByUsername(ctx, payer)
+	if err != nil {
+		return models.Settlement{}, http.StatusNotFound, fmt.Errorf("user %s not found", payer)
+	}
+	if _, err := ctl.Users.FindByUsername(ctx, proposal.Payee); err == database.ErrNotFound {
+		return models.Settlement{}, http.StatusBadRequest, fmt.Errorf("user %s not found", proposal.Payee)
+	} else if err != nil {
+		return models.Settlement{}, http.StatusInternalServerError, err
+	}
+
+	amount, paid, status, err := ctl.settlementPayment(ctx, user, proposal)
+	if err != nil {
+		return models.Settlement{}, status, err
+	}
+
+	proposed := models.Settlement{
+		ID:         primitive.NewObjectID(),
+		Payer:      payer,
+		Payee:      proposal.Payee,
+		Amount:     amount,
+		Paid:       paid,
+		Status:     models.SettlementPending,
+		CreateDate: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if err := ctl.Settlements.Insert(ctx, proposed); err != nil {
+		return models.Settlement{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.publishSettlement(ctx, models.SettlementProposed, proposed, payer, proposed.Payee); err != nil {
+		return models.Settlement{}, http.StatusInternalServerError, err
+	}
+	return proposed, http.StatusOK, nil
+}
+
+// How much the proposal pays the payee and the debts it pays down
+func (ctl *Controller) settlementPayment(ctx context.Context, user models.User, proposal models.SettlementProposal) (int64, []models.Debt, int, error) {
+	payer := *user.Username
+	if len(proposal.Paid) == 0 {
+		owed := -user.Balances[proposal.Payee]
+		if owed <= 0 {
+			return 0, nil, http.StatusBadRequest, fmt.Errorf("you do not owe %s anything", proposal.Payee)
+		}
+		amount := proposal.Amount
+		if amount == 0 {
+			amount = owed
+		} else if amount > owed {
+			return 0, nil, http.StatusBadRequest, fmt.Errorf("you only owe %s %d", proposal.Payee, owed)
+		}
+		return amount, []models.Debt{{From: payer, To: proposal.Payee, Amount: amount}}, http.StatusOK, nil
+	}
+
+	amount, ok := settlement.Payment(proposal.Paid, payer, proposal.Payee)
+	if !ok {
+		return 0, nil, http.StatusBadRequest, fmt.Errorf("the paid debts must take you to %s and leave everyone else even", proposal.Payee)
+	}
+	if proposal.Amount != 0 && proposal.Amount != amount {
+		return 0, nil, http.StatusBadRequest, fmt.Errorf("the paid debts pay %s %d, not %d", proposal.Payee, amount, proposal.Amount)
+	}
+	// Paying down other users' debts needs the same view of them as planning it did
+	if _, err := settlementMembers(user, debtMembers(proposal.Paid), nil); err != nil {
+		return 0, nil, http.StatusBadRequest, err
+	}
+	unpaid, status, err := ctl.unpaidDebt(ctx, proposal.Paid)
+	if err != nil {
+		return 0, nil, status, err
+	} else if unpaid != nil {
+		return 0, nil, http.StatusBadRequest, fmt.Errorf("%s owes %s less than %d", unpaid.From, unpaid.To, unpaid.Amount)
+	}
+	return amount, proposal.Paid, http.StatusOK, nil
+}
+
+// The payee accepts the settlement to confirm they were paid, or declines it
+// Confirming pays down the settlement's debts in one go; only the payer's and the payee's net positions change
+func (ctl *Controller) ConfirmSettlementFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var response models.SettlementResponse
+
+	if err := c.BindJSON(&response); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(response); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+	if response.Status != models.Accepted && response.Status != models.Declined {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be accepted or declined"})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resolved models.Settlement
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		resolved, status, err = ctl.confirmSettlement(ctx, username, response)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resolved)
+}
+
+func (ctl *Controller) confirmSettlement(ctx context.Context, username string, response models.SettlementResponse) (models.Settlement, int, error) {
+	proposed, err := ctl.Settlements.FindByID(ctx, response.SettlementID)
+	if err == database.ErrNotFound || (err == nil && username != proposed.Payer && username != proposed.Payee) {
+		return models.Settlement{}, http.StatusNotFound, fmt.Errorf("settlement ID %s not found", response.SettlementID.Hex())
+	} else if err != nil {
+		return models.Settlement{}, http.StatusInternalServerError, err
+	}
+	if username != proposed.Payee {
+		return models.Settlement{}, http.StatusForbidden, fmt.Errorf("only %s can confirm this settlement", proposed.Payee)
+	}
+	if proposed.Status != models.SettlementPending {
+		return models.Settlement{}, http.StatusBadRequest, fmt.Errorf("settlement has already been answered")
+	}
+
+	proposed.ResolveDate = primitive.NewDateTimeFromTime(time.Now())
+	if response.Status == models.Declined {
+		proposed.Status = models.SettlementRejected
+		if err := ctl.Settlements.Replace(ctx, proposed); err != nil {
+			return models.Settlement{}, http.StatusInternalServerError, err
+		}
+		if err := ctl.publishSettlement(ctx, models.SettlementDeclined, proposed, username, proposed.Payer); err != nil {
+			return models.Settlement{}, http.StatusInternalServerError, err
+		}
+		return proposed, http.StatusOK, nil
+	}
+
+	// The debts are read again, since they may have been paid down some other way since the proposal
+	unpaid, status, err := ctl.unpaidDebt(ctx, proposed.Paid)
+	if err != nil {
+		return models.Settlement{}, status, err
+	} else if unpaid != nil {
+		return models.Settlement{}, http.StatusConflict, fmt.Errorf("%s now owes %s less than the settlement pays down, so it has to be proposed again", unpaid.From, unpaid.To)
+	}
+
+	// A transfer the other way is what cancels out a debt in the ledger
+	ref := models.LedgerEntry{SettlementID: proposed.ID}
+	for _, debt := range proposed.Paid {
+		if err := ctl.appendTransfer(ctx, debt.To, debt.From, debt.Amount, ref); err != nil {
+			return models.Settlement{}, http.StatusInternalServerError, err
+		}
+	}
+	for _, member := range debtMembers(proposed.Paid) {
+		if err := ctl.refreshBalances(ctx, member); err != nil {
+			return models.Settlement{}, http.StatusInternalServerError, err
+		}
+	}
+
+	proposed.Status = models.SettlementSettled
+	if err := ctl.Settlements.Replace(ctx, proposed); err != nil {
+		return models.Settlement{}, http.StatusInternalServerError, err
+	}
+	if err := ctl.publishSettlement(ctx, models.SettlementConfirmed, proposed, username, proposed.Payer); err != nil {
+		return models.Settlement{}, http.StatusInternalServerError, err
+	}
+	return proposed, http.StatusOK, nil
+}
+
+// Settlements the logged in user has paid or been paid, newest first
+func (ctl *Controller) ListSettlementsFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	settlements, err := ctl.Settlements.ListByUser(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"settlements": settlements})
+}
+
+// The group a user wants to settle up, sorted and without duplicates; uses defaults if nothing was requested
+// The user is always a member, and everyone else must be their friend so other users' debts are not exposed,
+// unless the group is just the user and one other person
+func settlementMembers(user models.User, requested []string, defaults []string) ([]string, error) {
+	if len(requested) == 0 {
+		requested = defaults
+	}
+	username := *user.Username
+	members := []string{username}
+	for _, member := range requested {
+		member = strings.TrimSpace(member)
+		if member != "" && !containsString(members, member) {
+			members = append(members, member)
+		}
+	}
+	if len(members) > maxSettlementMembers {
+		return nil, fmt.Errorf("groups can have at most %d members", maxSettlementMembers)
+	}
+	if len(members) > 2 {
+		for _, member := range members[1:] {
+			if !containsString(user.Friends, member) {
+				return nil, fmt.Errorf("%s is not your friend, so they cannot be in a group with anyone else", member)
+			}
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// What the members of a group owe each other, read from their balances
+func (ctl *Controller) groupDebts(ctx context.Context, members []string) ([]models.Debt, int, error) {
+	balances := make(map[string]map[string]int64, len(members))
+	for _, member := range members {
+		user, err := ctl.Users.FindByUsername(ctx, member)
+		if err == database.ErrNotFound {
+			return nil, http.StatusBadRequest, fmt.Errorf("user %s not found", member)
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		balances[member] = user.Balances
+	}
+	return settlement.GroupDebts(balances, members), http.StatusOK, nil
+}
+
+// The first of the debts that is no longer owed in full, or nil if they all are
+func (ctl *Controller) unpaidDebt(ctx context.Context, paid []models.Debt) (*models.Debt, int, error) {
+	debts, status, err := ctl.groupDebts(ctx, debtMembers(paid))
+	if err != nil {
+		return nil, status, err
+	}
+	owed := make(map[[2]string]int64, len(debts))
+	for _, debt := range debts {
+		owed[[2]string{debt.From, debt.To}] = debt.Amount
+	}
+	for _, debt := range paid {
+		if owed[[2]string{debt.From, debt.To}] < debt.Amount {
+			unpaid := debt
+			return &unpaid, http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusOK, nil
+}
+
+// Everyone who owes or is owed one of the debts, in the order they first appear
+func debtMembers(debts []models.Debt) []string {
+	members := make([]string, 0)
+	for _, debt := range debts {
+		for _, member := range []string{debt.From, debt.To} {
+			if !containsString(members, member) {
+				members = append(members, member)
+			}
+		}
+	}
+	return members
+}
+
+func (ctl *Controller) publishSettlement(ctx context.Context, eventType models.EventType, s models.Settlement, actor string, target string) error {
+	settlementID := s.ID
+	// Everyone whose debts the settlement pays down hears about it, not just the payer and payee
+	audience := []string{s.Payer, s.Payee}
+	for _, member := range debtMembers(s.Paid) {
+		if !containsString(audience, member) {
+			audience = append(audience, member)
+		}
+	}
+	return ctl.Bus.Publish(ctx, models.Event{
+		Type:         eventType,
+		Audience:     audience,
+		Actor:        actor,
+		Target:       target,
+		SettlementID: &settlementID,
+		Amount:       s.Amount,
+	})
+}
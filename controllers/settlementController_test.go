@@ -0,0 +1,178 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// Has the loser lose a 10 to 3 bet against the winner, so the loser owes the winner 100 more
+func (h *harness) lose(loser, winner string) {
+	h.t.Helper()
+	betID := h.ongoingBet(winner, loser)
+	h.claim(winner, betID, models.CreatorWon)
+	h.claim(loser, betID, models.CreatorWon)
+}
+
+func (h *harness) settlementPlan(user, query string) models.SettlementPlan {
+	h.t.Helper()
+	out := h.must(http.StatusOK, user, "GET", "/settlements/plan?"+query, nil)
+	var plan models.SettlementPlan
+	b, _ := json.Marshal(out)
+	if err := json.Unmarshal(b, &plan); err != nil {
+		h.t.Fatal(err)
+	}
+	return plan
+}
+
+func TestSettleUp(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "carol")
+	h.befriend("bob", "dave")
+	h.lose("alice", "bob")
+	h.lose("bob", "carol")
+	h.lose("dave", "bob")
+	// carol owes alice 30 the other way round
+	betID := h.ongoingBet("carol", "alice")
+	h.claim("carol", betID, models.ReceiverWon)
+	h.claim("alice", betID, models.ReceiverWon)
+
+	// bob's debts cancel out, so alice can pay carol through him
+	plan := h.settlementPlan("alice", "")
+	if want := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(plan.Members, want) {
+		t.Fatalf("plan members %v, want %v", plan.Members, want)
+	}
+	if len(plan.Transfers) != 1 || plan.Transfers[0].Debt != (models.Debt{From: "alice", To: "carol", Amount: 70}) {
+		t.Fatalf("plan transfers %v, want alice paying carol 70", plan.Transfers)
+	}
+	if len(plan.Debts) != 3 {
+		t.Fatalf("plan debts %v, want the 3 between alice, bob and carol", plan.Debts)
+	}
+	// dave can see what he owes bob, but not what bob and alice owe each other
+	if code, out := h.do("dave", "GET", "/settlements/plan?members=bob,alice", nil); code != http.StatusBadRequest {
+		t.Fatalf("dave planned a group with alice, who is not his friend: %d %v", code, out)
+	}
+	if want := []models.Transfer{{Debt: models.Debt{From: "dave", To: "bob", Amount: 100}, Paid: []models.Debt{{From: "dave", To: "bob", Amount: 100}}}}; !reflect.DeepEqual(h.settlementPlan("dave", "members=bob").Transfers, want) {
+		t.Fatalf("dave's plan with bob is not just his own debt")
+	}
+
+	// Without paid debts from a plan, settlements only pay down what the payer owes the payee directly
+	propose := func(payer, payee string, amount int64) (int, map[string]interface{}) {
+		return h.do(payer, "POST", "/settlements/propose", map[string]interface{}{"payee": payee, "amount": amount})
+	}
+	if code, out := propose("alice", "carol", 0); code != http.StatusBadRequest {
+		t.Fatalf("alice proposed paying carol, who owes her: %d %v", code, out)
+	}
+	if code, out := propose("alice", "bob", 101); code != http.StatusBadRequest {
+		t.Fatalf("alice proposed paying bob more than she owes: %d %v", code, out)
+	}
+	code, out := propose("alice", "bob", 0)
+	if code != http.StatusOK || out["amount"] != 100.0 || out["status"] != float64(models.SettlementPending) {
+		t.Fatalf("alice proposed paying bob everything: %d %v", code, out)
+	}
+	settlementID := out["id"]
+	confirm := func(user string, status models.RequestStatus) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/settlements/confirm", map[string]interface{}{"settlementid": settlementID, "status": status})
+	}
+	if code, out := confirm("alice", models.Accepted); code != http.StatusForbidden {
+		t.Fatalf("alice confirmed her own payment: %d %v", code, out)
+	}
+	if code, out := confirm("dave", models.Accepted); code != http.StatusNotFound {
+		t.Fatalf("dave confirmed a payment between others: %d %v", code, out)
+	}
+	if code, out := confirm("bob", models.Accepted); code != http.StatusOK || out["status"] != float64(models.SettlementSettled) {
+		t.Fatalf("confirmed settlement: %d %v", code, out)
+	}
+	if alice, bob := h.user("alice"), h.user("bob"); alice.Balances["bob"] != 0 || bob.Balances["alice"] != 0 || alice.TotalBalance != 30 {
+		t.Fatalf("after settling, alice has %v (%d) and bob %v", alice.Balances, alice.TotalBalance, bob.Balances)
+	}
+	if code, out := confirm("bob", models.Accepted); code != http.StatusBadRequest {
+		t.Fatalf("settlement confirmed twice: %d %v", code, out)
+	}
+
+	// A debt that shrank after the proposal has to be proposed again
+	code, out = propose("dave", "bob", 60)
+	if code != http.StatusOK {
+		t.Fatalf("dave proposed paying bob: %d %v", code, out)
+	}
+	settlementID = out["id"]
+	h.lose("bob", "dave")
+	if code, out := confirm("bob", models.Accepted); code != http.StatusConflict {
+		t.Fatalf("confirmed a settlement for more than is owed: %d %v", code, out)
+	}
+	if code, out := confirm("bob", models.Declined); code != http.StatusOK || out["status"] != float64(models.SettlementRejected) {
+		t.Fatalf("declined settlement: %d %v", code, out)
+	}
+
+	out = h.must(http.StatusOK, "dave", "GET", "/settlements", nil)
+	if settlements := out["settlements"].([]interface{}); len(settlements) != 1 || settlements[0].(map[string]interface{})["id"] != settlementID {
+		t.Fatalf("dave's settlements are %v", settlements)
+	}
+	out = h.must(http.StatusOK, "bob", "GET", "/settlements", nil)
+	if settlements := out["settlements"].([]interface{}); len(settlements) != 2 {
+		t.Fatalf("bob has %d settlements, want 2", len(settlements))
+	}
+}
+
+func TestSettleCircleFromPlan(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("bob", "carol")
+	h.befriend("carol", "dave")
+	// alice owes bob 100, bob owes carol 100 and carol owes alice 30
+	h.lose("alice", "bob")
+	h.lose("bob", "carol")
+	betID := h.ongoingBet("carol", "alice")
+	h.claim("carol", betID, models.ReceiverWon)
+	h.claim("alice", betID, models.ReceiverWon)
+
+	plan := h.settlementPlan("alice", "")
+	if len(plan.Transfers) != 1 {
+		t.Fatalf("plan transfers %v, want just alice paying carol", plan.Transfers)
+	}
+	transfer := plan.Transfers[0]
+	propose := func(user string, paid []models.Debt, amount int64) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/settlements/propose", map[string]interface{}{"payee": transfer.To, "amount": amount, "paid": paid})
+	}
+	for name, tt := range map[string]struct {
+		user   string
+		paid   []models.Debt
+		amount int64
+	}{
+		"someone else's transfer":   {"bob", transfer.Paid, 0},
+		"wrong amount":              {"alice", transfer.Paid, 20},
+		"half a chain":              {"alice", transfer.Paid[:1], 0},
+		"more than is owed":         {"alice", []models.Debt{{From: "alice", To: "bob", Amount: 200}, {From: "bob", To: "carol", Amount: 200}}, 0},
+		"through a stranger's debt": {"alice", []models.Debt{{From: "alice", To: "dave", Amount: 70}, {From: "dave", To: "carol", Amount: 70}}, 0},
+	} {
+		if code, out := propose(tt.user, tt.paid, tt.amount); code != http.StatusBadRequest {
+			t.Errorf("%s: %d %v", name, code, out)
+		}
+	}
+
+	code, out := propose("alice", transfer.Paid, 0)
+	if code != http.StatusOK || out["amount"] != 70.0 {
+		t.Fatalf("alice proposed the plan's transfer: %d %v", code, out)
+	}
+	out = h.must(http.StatusOK, "carol", "POST", "/settlements/confirm", map[string]interface{}{"settlementid": out["id"], "status": models.Accepted})
+	if out["status"] != float64(models.SettlementSettled) {
+		t.Fatalf("confirmed settlement %v", out)
+	}
+	// Paying carol 70 clears the whole circle, including bob's debt and credit
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if user := h.user(name); user.TotalBalance != 0 || user.Balances["alice"] != 0 || user.Balances["bob"] != 0 || user.Balances["carol"] != 0 {
+			t.Errorf("%s has balances %v (%d) after settling the plan", name, user.Balances, user.TotalBalance)
+		}
+	}
+	if plan := h.settlementPlan("alice", ""); len(plan.Debts) != 0 || len(plan.Transfers) != 0 {
+		t.Fatalf("plan after settling %+v", plan)
+	}
+}
@@ -2,25 +2,23 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/authentication"
 	"github.com/simhonchourasia/betfr-be/config"
-	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/eligibility"
+	"github.com/simhonchourasia/betfr-be/matching"
 	"github.com/simhonchourasia/betfr-be/models"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var stakeCollection *mongo.Collection = database.OpenCollection(database.Client, config.GlobalConfig.StakeCollection)
-
-// Pass in bet, owner name, number of shares requested, backing creator/receiver, comment
-var CreateStakeFunc gin.HandlerFunc = func(c *gin.Context) {
+// Pass in bet, owner name, number of shares (or tokens) requested, backing creator/receiver, comment
+func (ctl *Controller) CreateStakeFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
@@ -36,148 +34,118 @@ var CreateStakeFunc gin.HandlerFunc = func(c *gin.Context) {
 		return
 	}
 
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ownerErr := eligibility.CheckOwner(username, stakeReq.OwnerName); ownerErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": ownerErr.Error()})
+		return
+	}
+
+	var stake models.Stake
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		stake, status, err = ctl.createStake(ctx, stakeReq)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"InsertedID": stake.ID, "SharesFilled": stake.SharesFilled})
+}
+
+// Creates a stake and matches it against the bet's queues
+// Must be run inside a transaction
+func (ctl *Controller) createStake(ctx context.Context, stakeReq models.StakeRequest) (models.Stake, int, error) {
+	bet, err := ctl.Bets.FindByID(ctx, stakeReq.Underlying)
+	if err != nil {
+		log.Printf("Could not load underlying bet %s: %v\n", stakeReq.Underlying.Hex(), err)
+		return models.Stake{}, http.StatusInternalServerError, fmt.Errorf("Underlying ID %s not found", stakeReq.Underlying.String())
+	}
+
+	// Bets the staker cannot see are reported as missing
+	visible, err := ctl.canViewBet(ctx, bet, stakeReq.OwnerName)
+	if err != nil {
+		return models.Stake{}, http.StatusInternalServerError, err
+	}
+	if !visible {
+		return models.Stake{}, http.StatusNotFound, fmt.Errorf("Underlying ID %s not found", stakeReq.Underlying.String())
+	}
+
+	// Users cannot stake on the bets of someone who has blocked them
+	var creator models.User
+	for _, party := range []string{bet.CreatorName, bet.ReceiverName} {
+		user, err := ctl.Users.FindByUsername(ctx, party)
+		if err != nil {
+			return models.Stake{}, http.StatusInternalServerError, fmt.Errorf("bet party %s not found", party)
+		}
+		if containsString(user.BlockedUsers, stakeReq.OwnerName) {
+			return models.Stake{}, http.StatusForbidden, fmt.Errorf("can't stake on bets of %s", party)
+		}
+		if party == bet.CreatorName {
+			creator = user
+		}
+	}
+
+	odds := matching.Odds{CreatorAmount: bet.CreatorAmount, ReceiverAmount: bet.ReceiverAmount}
+	numShares := stakeReq.NumShares
+	if numShares == 0 && stakeReq.NumTokens > 0 {
+		shares, leftover, err := odds.SharesForTokens(stakeSide(stakeReq.BackingCreator), stakeReq.NumTokens)
+		if err != nil {
+			return models.Stake{}, http.StatusBadRequest, err
+		}
+		if leftover != 0 {
+			return models.Stake{}, http.StatusBadRequest, fmt.Errorf("stakes on this side must be a multiple of %d tokens", odds.Price(stakeSide(stakeReq.BackingCreator)))
+		}
+		numShares = shares
+	}
+
+	state, err := ctl.stakeEligibilityState(ctx, bet, creator, stakeReq.OwnerName)
+	if err != nil {
+		return models.Stake{}, http.StatusInternalServerError, err
+	}
+	if err := eligibility.CheckStake(state, stakeReq.OwnerName, numShares, stakeLimits(), time.Now()); err != nil {
+		return models.Stake{}, eligibilityStatus(err), err
+	}
+
 	stake := models.Stake{
 		ID:             primitive.NewObjectID(),
 		Underlying:     stakeReq.Underlying,
 		OwnerName:      stakeReq.OwnerName,
-		SharesStaked:   stakeReq.NumShares,
+		SharesStaked:   numShares,
 		SharesFilled:   0,
 		BackingCreator: stakeReq.BackingCreator,
 		Comment:        stakeReq.Comment,
 		CreateDate:     primitive.NewDateTimeFromTime(time.Now()),
 	}
 
-	betResult := betCollection.FindOne(ctx, bson.M{"_id": stakeReq.Underlying})
-	var bet models.Bet
-	if betResult.Err() != nil {
-		fmt.Println(betResult.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Underlying ID %s not found", stakeReq.Underlying.String())})
-		return
-	}
-	if err := betResult.Decode(&bet); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if bet.CreatorStakedUnfilled != 0 && bet.ReceiverStakedUnfilled != 0 {
-		panic(fmt.Errorf("bet %s has nonzero unfilled amounts for both sides. this should not happen", bet.ID.String()))
-	}
-	if bet.CreatorStakedUnfilled < 0 || bet.ReceiverStakedUnfilled < 0 {
-		panic(fmt.Errorf("bet %s has negative filled amount for a side. this should not happen", bet.ID.String()))
-	}
-
-	// if stakeReq.BackingCreator {
-	// 	bet.CreatorStaked += stake.SharesStaked
-	// 	if stake.SharesStaked <= bet.ReceiverStakedUnfilled {
-	// 		// Case 1: We can fully fill this stake; it will not go into the bet's queue
-	// 		stake.SharesFilled = stake.SharesStaked
-	// 		bet.ReceiverStakedUnfilled -= stake.SharesFilled
-	// 		// Update the receiver's stakes to be filled
-	// 		// creatorStakeNum := stake.SharesFilled
-	// 		for _, stakeID := range bet.ReceiverStakes {
-	// 			stakeRes := userCollection.FindOne(ctx, bson.M{"_id": stakeID})
-	// 			if stakeRes.Err() != nil {
-	// 				c.JSON(http.StatusInternalServerError, gin.H{"error": stakeRes.Err()})
-	// 				return
-	// 			}
-	// 			var currStake models.Stake
-	// 			if err := stakeRes.Decode(&currStake); err != nil {
-	// 				c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-	// 				return
-	// 			}
-
-	// 			remainder := currStake.SharesStaked - currStake.SharesFilled
-	// 			if bet.ReceiverStakedUnfilled < remainder {
-	// 				// Case 1: We can only fill the queued stake partially, so we do that and update the stake and then end
-	// 				// Note that we do not remove anything from the queue in this case
-	// 				currStake.SharesFilled += bet.ReceiverStakedUnfilled
-	// 				bet.ReceiverStakedUnfilled = 0
-	// 				updateStakeFilledHelper(ctx, currStake)
-	// 				break
-	// 			} else {
-	// 				// Case 2: We can fill up the queued stake entirely, so we do that and pop it from the queue and move on
-	// 				// Note that we will remove things from the queue in this case
-	// 				currStake.SharesFilled += remainder
-	// 				bet.ReceiverStakedUnfilled -= remainder
-	// 				filledIdx += 1
-	// 				updateStakeFilledHelper(ctx, currStake)
-	// 			}
-	// 		}
-
-	// 	} else {
-	// 		// Case 2: We can partially fill this stake (or not at all)
-	// 		// It will sit in the bet's queue until it can be further filled
-	// 		// And it may help fill other stakes on the other side
-	// 		bet.CreatorStakes = append(bet.CreatorStakes, stake.ID)
-	// 		stake.SharesFilled = bet.ReceiverStakedUnfilled
-	// 		bet.ReceiverStakedUnfilled = 0
-	// 		bet.CreatorStakedUnfilled += stake.SharesStaked - stake.SharesFilled
-	// 		// Then go through the receiver stake queue and fill up as many as possible
-	// 		if err := HandleStakes(ctx, &bet); err != nil {
-	// 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-	// 			return
-	// 		}
-	// 	}
-	// } else {
-	// 	bet.ReceiverStaked += stake.SharesStaked
-	// 	// Stake is backing up the Receiver
-	// 	// Symmetric logic to when creator is backed up
-	// 	if stake.SharesStaked <= bet.CreatorStakedUnfilled {
-	// 		// Case 1: We can fully fill this stake; it will not go into the bet's queue
-	// 		stake.SharesFilled = stake.SharesStaked
-	// 		bet.CreatorStakedUnfilled -= stake.SharesFilled
-	// 	} else {
-	// 		// Case 2: We can partially fill this stake (or not at all)
-	// 		bet.ReceiverStakes = append(bet.ReceiverStakes, stake.ID)
-	// 		stake.SharesFilled = bet.CreatorStakedUnfilled
-	// 		bet.CreatorStakedUnfilled = 0
-	// 		bet.ReceiverStaked += stake.SharesStaked - stake.SharesFilled
-	// 		// Then go through the receiver stake queue and fill up as many as possible
-	// 		if err := HandleStakes(ctx, &bet); err != nil {
-	// 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-	// 			return
-	// 		}
-	// 	}
-	// }
-
 	if stakeReq.BackingCreator {
 		bet.CreatorStaked += stake.SharesStaked
-		bet.CreatorStakedUnfilled += stake.SharesStaked
 	} else {
 		bet.ReceiverStaked += stake.SharesStaked
-		bet.ReceiverStakedUnfilled += stake.SharesStaked
 	}
 
-	if err := HandleStakes(ctx, &bet); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := ctl.HandleStakes(ctx, &bet, &stake); err != nil {
+		return models.Stake{}, http.StatusInternalServerError, err
 	}
 
 	// Update bet and insert stake
-	opts := options.Replace().SetUpsert(true)
-	filter := bson.M{"_id": bet.ID}
-
-	replaceRes, err := betCollection.ReplaceOne(
-		ctx,
-		filter,
-		bet,
-		opts,
-	)
-
-	if err != nil || replaceRes.MatchedCount == 0 {
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
 		log.Printf("Could not find/update bet for stake\n")
-		if err == nil {
-			err = fmt.Errorf("bet did not previously exist")
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return models.Stake{}, http.StatusInternalServerError, err
 	}
 
-	res, err := stakeCollection.InsertOne(ctx, stake)
-	if err != nil {
+	if err := ctl.Stakes.Insert(ctx, stake); err != nil {
 		log.Printf("Could not create stake\n")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return models.Stake{}, http.StatusInternalServerError, err
 	}
 
 	// Add to stake owner's list
@@ -187,256 +155,404 @@ var CreateStakeFunc gin.HandlerFunc = func(c *gin.Context) {
 		Field:     "ongoingstakes",
 		IdVal:     stake.ID,
 	}
-	if err := UpdateBetHelper(ctx, updateOwner); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
+		return models.Stake{}, http.StatusInternalServerError, err
 	}
 
-	c.JSON(http.StatusOK, res)
+	return stake, http.StatusOK, nil
+}
+
+func stakeLimits() eligibility.Limits {
+	return eligibility.Limits{
+		AllowBettorStakes: config.GlobalConfig.AllowBettorStakes,
+		MaxSharesPerUser:  config.GlobalConfig.MaxStakeSharesPerUser,
+		MaxSharesPerBet:   config.GlobalConfig.MaxStakeSharesPerBet,
+	}
 }
 
-func updateStakeFilledHelper(ctx context.Context, stake models.Stake) error {
-	res, err := stakeCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": stake.ID},
-		bson.D{{Key: "$set", Value: bson.D{{Key: "sharesfilled", Value: stake.SharesFilled}}}},
-	)
+// Collects what the eligibility rules need to know about a bet and the stake owner's position in it
+func (ctl *Controller) stakeEligibilityState(ctx context.Context, bet models.Bet, creator models.User, owner string) (eligibility.BetState, error) {
+	stakes, err := ctl.Stakes.FindByUnderlying(ctx, bet.ID)
 	if err != nil {
-		return err
+		return eligibility.BetState{}, err
 	}
-	if res.MatchedCount == 0 {
-		return fmt.Errorf("could not find stake with ID %s to update", stake.ID.String())
+	var ownerShares int64
+	for _, stake := range stakes {
+		if stake.OwnerName == owner && !stake.Voided {
+			ownerShares += stake.SharesStaked
+		}
 	}
-	return nil
+	return eligibility.BetState{
+		Bet:         bet,
+		Ongoing:     containsID(creator.OngoingBets, bet.ID),
+		OwnerShares: ownerShares,
+	}, nil
+}
+
+// Staking on your own bet is a permissions problem; every other violation is a bad request
+func eligibilityStatus(err error) int {
+	var bettorErr *eligibility.BettorStakeError
+	var ownerErr *eligibility.NotOwnerError
+	if errors.As(err, &bettorErr) || errors.As(err, &ownerErr) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func (ctl *Controller) updateStakeFilledHelper(ctx context.Context, stake models.Stake) error {
+	return ctl.Stakes.UpdateFilled(ctx, stake.ID, stake.SharesFilled)
+}
+
+func stakeSide(backingCreator bool) matching.Side {
+	if backingCreator {
+		return matching.BackCreator
+	}
+	return matching.BackReceiver
 }
 
-// Handles all stakes for a bet
-// To be called when a stake is created
-func HandleStakes(ctx context.Context, bet *models.Bet) error {
-	if bet.CreatorStakedUnfilled != 0 && bet.ReceiverStakedUnfilled != 0 {
-		panic(fmt.Errorf("bet %s has nonzero unfilled amounts for both sides. this should not happen", bet.ID.String()))
-	}
-	if bet.CreatorStakedUnfilled < 0 || bet.ReceiverStakedUnfilled < 0 {
-		panic(fmt.Errorf("bet %s has negative filled amount for a side. this should not happen", bet.ID.String()))
-	}
-
-	filledIdx := 0 // At the end, we will have queue[filledIdx:] as our new queue (as we might pop things off the queue)
-	queueName := ""
-
-	if bet.CreatorStakedUnfilled > 0 {
-		// Case for when we are trying to fill up receiver stakes with a new surplus of creator stakes
-		queueName = "receiverstakes"
-
-		for _, stakeID := range bet.ReceiverStakes {
-			if bet.CreatorStakedUnfilled > 0 {
-				// Get next receiver stake in the queue and operate
-				stakeRes := userCollection.FindOne(ctx, bson.M{"_id": stakeID})
-				if stakeRes.Err() != nil {
-					return stakeRes.Err()
-				}
-				var currStake models.Stake
-				if err := stakeRes.Decode(&currStake); err != nil {
-					return err
-				}
-
-				// Try to fill it up as much as possible
-				remainder := currStake.SharesStaked - currStake.SharesFilled
-				if bet.CreatorStakedUnfilled < remainder {
-					// Case 1: We can only fill the queued stake partially, so we do that and update the stake and then end
-					// Note that we do not remove anything from the queue in this case
-					currStake.SharesFilled += bet.CreatorStakedUnfilled
-					bet.CreatorStakedUnfilled = 0
-					updateStakeFilledHelper(ctx, currStake)
-					break
-				} else {
-					// Case 2: We can fill up the queued stake entirely, so we do that and pop it from the queue and move on
-					// Note that we will remove things from the queue in this case
-					currStake.SharesFilled += remainder
-					bet.CreatorStakedUnfilled -= remainder
-					filledIdx += 1
-					updateStakeFilledHelper(ctx, currStake)
-				}
+// Rebuilds the order book for a bet from the stakes waiting in its queues
+func (ctl *Controller) loadBook(ctx context.Context, bet *models.Bet) (*matching.Book, error) {
+	book, err := matching.NewBook(matching.Odds{CreatorAmount: bet.CreatorAmount, ReceiverAmount: bet.ReceiverAmount})
+	if err != nil {
+		return nil, err
+	}
+	for _, queue := range [][]primitive.ObjectID{bet.CreatorStakes, bet.ReceiverStakes} {
+		for _, stakeID := range queue {
+			stake, err := ctl.Stakes.FindByID(ctx, stakeID)
+			if err != nil {
+				return nil, fmt.Errorf("stake id %s not found", stakeID.String())
+			}
+			order := matching.Order{
+				ID:     stake.ID.Hex(),
+				Side:   stakeSide(stake.BackingCreator),
+				Shares: stake.SharesStaked,
+				Filled: stake.SharesFilled,
+			}
+			if err := book.Restore(order); err != nil {
+				return nil, err
 			}
 		}
-	} else {
-		// Case for when we are trying to fill up creator stakes with a new surplus of receiver stakes
-		// Symmetric logic to above
-		queueName = "creatorstakes"
-
-		for _, stakeID := range bet.CreatorStakes {
-			if bet.ReceiverStakedUnfilled > 0 {
-				// Get next receiver stake in the queue and operate
-				stakeRes := userCollection.FindOne(ctx, bson.M{"_id": stakeID})
-				if stakeRes.Err() != nil {
-					return stakeRes.Err()
-				}
-				var currStake models.Stake
-				if err := stakeRes.Decode(&currStake); err != nil {
-					return err
-				}
-
-				// Try to fill it up as much as possible
-				remainder := currStake.SharesStaked - currStake.SharesFilled
-				if bet.ReceiverStakedUnfilled < remainder {
-					// Case 1: We can only fill the queued stake partially, so we do that and update the stake and then end
-					// Note that we do not remove anything from the queue in this case
-					currStake.SharesFilled += bet.ReceiverStakedUnfilled
-					bet.ReceiverStakedUnfilled = 0
-					updateStakeFilledHelper(ctx, currStake)
-					break
-				} else {
-					// Case 2: We can fill up the queued stake entirely, so we do that and pop it from the queue and move on
-					// Note that we will remove things from the queue in this case
-					currStake.SharesFilled += remainder
-					bet.ReceiverStakedUnfilled -= remainder
-					filledIdx += 1
-					updateStakeFilledHelper(ctx, currStake)
-				}
+	}
+	return book, nil
+}
+
+// Copies the resting orders in a book back onto the bet's queues and unfilled totals
+func syncBetQueues(bet *models.Bet, book *matching.Book) error {
+	queues := [2][]primitive.ObjectID{}
+	for _, side := range []matching.Side{matching.BackCreator, matching.BackReceiver} {
+		queues[side] = make([]primitive.ObjectID, 0)
+		for _, order := range book.Resting(side) {
+			id, err := primitive.ObjectIDFromHex(order.ID)
+			if err != nil {
+				return err
 			}
+			queues[side] = append(queues[side], id)
 		}
 	}
+	bet.CreatorStakes = queues[matching.BackCreator]
+	bet.ReceiverStakes = queues[matching.BackReceiver]
+	bet.CreatorStakedUnfilled = book.Unfilled(matching.BackCreator)
+	bet.ReceiverStakedUnfilled = book.Unfilled(matching.BackReceiver)
+	return nil
+}
 
-	newQueue := bet.ReceiverStakes[filledIdx:]
-	res, err := betCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": bet.ID},
-		bson.D{{Key: "$set", Value: bson.D{{Key: queueName, Value: newQueue}}}}, // hopefully this is the right way to do it
-	)
+// Matches a new stake against the stakes queued on the other side of a bet
+// To be called when a stake is created, before it is inserted
+// Updates the filled amount of every queued stake it matches; the caller persists the bet and the new stake
+func (ctl *Controller) HandleStakes(ctx context.Context, bet *models.Bet, stake *models.Stake) error {
+	book, err := ctl.loadBook(ctx, bet)
 	if err != nil {
 		return err
 	}
-	if res.MatchedCount == 0 {
-		return fmt.Errorf("could not find bet with ID %s to update for stake", bet.ID.String())
+
+	fills, order, err := book.Submit(matching.Order{
+		ID:     stake.ID.Hex(),
+		Side:   stakeSide(stake.BackingCreator),
+		Shares: stake.SharesStaked,
+	})
+	if err != nil {
+		return err
 	}
+	stake.SharesFilled = order.Filled
 
-	return nil
+	for _, fill := range fills {
+		makerHex := fill.CreatorOrder
+		if stake.BackingCreator {
+			makerHex = fill.ReceiverOrder
+		}
+		makerID, err := primitive.ObjectIDFromHex(makerHex)
+		if err != nil {
+			return err
+		}
+		maker, err := ctl.Stakes.FindByID(ctx, makerID)
+		if err != nil {
+			return fmt.Errorf("stake id %s not found", makerID.String())
+		}
+		maker.SharesFilled += fill.Shares
+		if err := ctl.updateStakeFilledHelper(ctx, maker); err != nil {
+			return err
+		}
+
+		// Both owners hear about the fill, each against their own stake
+		for _, filled := range []models.Stake{maker, *stake} {
+			stakeID := filled.ID
+			event := models.Event{
+				Type:     models.StakeFilled,
+				Audience: []string{filled.OwnerName},
+				Actor:    stake.OwnerName,
+				BetID:    &bet.ID,
+				StakeID:  &stakeID,
+				Shares:   fill.Shares,
+			}
+			if err := ctl.Bus.Publish(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The caller persists the bet, including the updated queues
+	return syncBetQueues(bet, book)
 }
 
-// Pays out stake owners
-// To be called when a bet is resolved
-// Instead of trying to match up stakes on both sides, the stake winners will be owed by the original bet's loser
-// And the original bet's loser will be owed by the stake losers
-func PayoutStakes(ctx context.Context, bet *models.Bet) error {
-	creatorRes := userCollection.FindOne(ctx, bson.M{"username": bet.CreatorName})
-	if creatorRes.Err() != nil {
-		return fmt.Errorf("bet creator %s not found", bet.CreatorName)
+// Pass in stake ID and the stake owner's username
+// Takes the unfilled part of a stake off the bet's queue; the filled part stays live
+func (ctl *Controller) CancelStakeFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var stakeCancel models.StakeCancel
+
+	if err := c.BindJSON(&stakeCancel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	var creator models.User
-	if err := creatorRes.Decode(&creator); err != nil {
+
+	// Check that the user cancelling the stake is the one logged in
+	if permissionErr := authentication.CheckUserPermissions(c, &stakeCancel.Username); permissionErr != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
+		return
+	}
+
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.cancelStake(ctx, stakeCancel)
 		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
 	}
-	receiverRes := userCollection.FindOne(ctx, bson.M{"username": bet.ReceiverName})
-	if receiverRes.Err() != nil {
-		return fmt.Errorf("bet creator %s not found", bet.ReceiverName)
+
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+func (ctl *Controller) cancelStake(ctx context.Context, stakeCancel models.StakeCancel) (string, int, error) {
+	stake, err := ctl.Stakes.FindByID(ctx, stakeCancel.StakeID)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("stake ID %s not found", stakeCancel.StakeID.String())
 	}
-	var receiver models.User
-	if err := receiverRes.Decode(&receiver); err != nil {
-		return err
+	if stake.OwnerName != stakeCancel.Username {
+		return "", http.StatusForbidden, fmt.Errorf("only %s can cancel this stake", stake.OwnerName)
+	}
+	if stake.Voided {
+		return "", http.StatusBadRequest, fmt.Errorf("stake has been voided")
+	}
+
+	bet, err := ctl.Bets.FindByID(ctx, stake.Underlying)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Underlying ID %s not found", stake.Underlying.String())
+	}
+	if bet.OverallStatus != models.Undecided {
+		return "", http.StatusBadRequest, fmt.Errorf("bet is no longer open for staking")
+	}
+
+	book, err := ctl.loadBook(ctx, &bet)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	order, err := book.Cancel(stake.ID.Hex())
+	if err == matching.ErrUnknownOrder {
+		return "", http.StatusBadRequest, fmt.Errorf("stake has no unfilled shares")
+	} else if err != nil {
+		return "", http.StatusInternalServerError, err
 	}
 
-	for _, stakeID := range bet.CreatorStakes {
-		stakeRes := stakeCollection.FindOne(ctx, bson.M{"_id": stakeID})
-		if stakeRes.Err() != nil {
-			return fmt.Errorf("stake id %s not found", stakeID.String())
+	remainder := order.Remaining()
+	if stake.BackingCreator {
+		bet.CreatorStaked -= remainder
+	} else {
+		bet.ReceiverStaked -= remainder
+	}
+	if err := syncBetQueues(&bet, book); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if err := ctl.Bets.Replace(ctx, bet); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	stake.SharesStaked -= remainder
+	if stake.SharesFilled == 0 {
+		// Nothing of the stake is left, so it is closed like a voided stake
+		stake.Voided = true
+		updateOwner := models.UpdateUserHelperStruct{
+			Username:  stake.OwnerName,
+			Operation: "$pullAll",
+			Field:     "ongoingstakes",
+			IdVal:     stake.ID,
 		}
-		var stake models.Stake
-		if err := stakeRes.Decode(&stake); err != nil {
-			return err
+		if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		updateOwner = models.UpdateUserHelperStruct{
+			Username:  stake.OwnerName,
+			Operation: "$push",
+			Field:     "resolvedstakes",
+			IdVal:     stake.ID,
+		}
+		if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
+	}
+	if err := ctl.Stakes.Replace(ctx, stake); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	return fmt.Sprintf("Cancelled %d unfilled shares of stake", remainder), http.StatusOK, nil
+}
 
-		creatorStakerRes := userCollection.FindOne(ctx, bson.M{"username": stake.OwnerName})
-		if creatorRes.Err() != nil {
-			return fmt.Errorf("stake winner %s not found", stake.OwnerName)
+// Pays out stake owners
+// To be called when a bet is resolved
+// Instead of trying to match up stakes on both sides, the stake winners will be owed by the original bet's loser
+// And the original bet's loser will be owed by the stake losers
+// Only the filled part of a stake pays out, so every stake on the bet is visited, not just the ones still queued
+func (ctl *Controller) PayoutStakes(ctx context.Context, bet *models.Bet) error {
+	stakes, err := ctl.Stakes.FindByUnderlying(ctx, bet.ID)
+	if err != nil {
+		return err
+	}
+	odds := matching.Odds{CreatorAmount: bet.CreatorAmount, ReceiverAmount: bet.ReceiverAmount}
+
+	for _, stake := range stakes {
+		if stake.Voided {
+			continue
 		}
-		var creatorStaker models.User
-		if err := creatorStakerRes.Decode(&creatorStaker); err != nil {
-			return err
+
+		staker, err := ctl.Users.FindByUsername(ctx, stake.OwnerName)
+		if err != nil {
+			return fmt.Errorf("stake owner %s not found", stake.OwnerName)
 		}
 
 		// Move from ongoing to resolved stake list
 		updateOwner := models.UpdateUserHelperStruct{
-			Username:  *creatorStaker.Username,
+			Username:  *staker.Username,
 			Operation: "$pullAll",
 			Field:     "ongoingstakes",
 			IdVal:     stake.ID,
 		}
-		if err := UpdateBetHelper(ctx, updateOwner); err != nil {
+		if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
 			return err
 		}
 		updateOwner = models.UpdateUserHelperStruct{
-			Username:  *creatorStaker.Username,
+			Username:  *staker.Username,
 			Operation: "$push",
 			Field:     "resolvedstakes",
 			IdVal:     stake.ID,
 		}
-		if err := UpdateBetHelper(ctx, updateOwner); err != nil {
+		if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
 			return err
 		}
 
-		if bet.OverallStatus == models.CreatorWon {
-			// Make original bet's loser (bet receiver, in this case) pay out to stake winners
-			if err := transferBalance(ctx, receiver, creatorStaker, stake.SharesFilled*bet.CreatorAmount); err != nil {
+		if stake.SharesFilled == 0 {
+			continue
+		}
+		side := stakeSide(stake.BackingCreator)
+		stakeWon := stake.BackingCreator == (bet.OverallStatus == models.CreatorWon)
+		stakeID := stake.ID
+		event := models.Event{
+			Type:     models.StakePaidOut,
+			Audience: []string{stake.OwnerName},
+			BetID:    &bet.ID,
+			StakeID:  &stakeID,
+			Shares:   stake.SharesFilled,
+			Amount:   -stake.SharesFilled * odds.Price(side),
+			Outcome:  bet.OverallStatus,
+		}
+		if stakeWon {
+			event.Amount = stake.SharesFilled * odds.Payout(side)
+		}
+		if err := ctl.Bus.Publish(ctx, event); err != nil {
+			return err
+		}
+		if stakeWon {
+			// Make original bet's loser pay out to stake winners
+			betLoser := bet.ReceiverName
+			if !stake.BackingCreator {
+				betLoser = bet.CreatorName
+			}
+			if err := ctl.transferBalance(ctx, betLoser, stake.OwnerName, stake.SharesFilled*odds.Payout(side), bet.ID, stake.ID); err != nil {
 				return err
 			}
 		} else {
-			// Make stake losers pay out to original bet's loser (stake creator, in this case)
-			if err := transferBalance(ctx, creatorStaker, creator, stake.SharesFilled*bet.ReceiverAmount); err != nil {
+			// Make stake losers pay out to original bet's loser
+			betLoser := bet.CreatorName
+			if !stake.BackingCreator {
+				betLoser = bet.ReceiverName
+			}
+			if err := ctl.transferBalance(ctx, stake.OwnerName, betLoser, stake.SharesFilled*odds.Price(side), bet.ID, stake.ID); err != nil {
 				return err
 			}
 		}
 	}
 
-	for _, stakeID := range bet.ReceiverStakes {
-		stakeRes := stakeCollection.FindOne(ctx, bson.M{"_id": stakeID})
-		if stakeRes.Err() != nil {
-			return fmt.Errorf("stake id %s not found", stakeID.String())
-		}
-		var stake models.Stake
-		if err := stakeRes.Decode(&stake); err != nil {
-			return err
-		}
+	return nil
+}
 
-		receiverStakerRes := userCollection.FindOne(ctx, bson.M{"username": stake.OwnerName})
-		if creatorRes.Err() != nil {
-			return fmt.Errorf("stake winner %s not found", stake.OwnerName)
+// Calls off every stake on a bet without moving any balances
+// To be called when a bet is expired or cancelled
+func (ctl *Controller) voidStakes(ctx context.Context, bet *models.Bet) error {
+	stakes, err := ctl.Stakes.FindByUnderlying(ctx, bet.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, stake := range stakes {
+		if stake.Voided {
+			continue
 		}
-		var receiverStaker models.User
-		if err := receiverStakerRes.Decode(&receiverStaker); err != nil {
+		stake.Voided = true
+		if err := ctl.Stakes.Replace(ctx, stake); err != nil {
 			return err
 		}
 
 		// Move from ongoing to resolved stake list
 		updateOwner := models.UpdateUserHelperStruct{
-			Username:  *receiverStaker.Username,
+			Username:  stake.OwnerName,
 			Operation: "$pullAll",
 			Field:     "ongoingstakes",
 			IdVal:     stake.ID,
 		}
-		if err := UpdateBetHelper(ctx, updateOwner); err != nil {
+		if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
 			return err
 		}
 		updateOwner = models.UpdateUserHelperStruct{
-			Username:  *receiverStaker.Username,
+			Username:  stake.OwnerName,
 			Operation: "$push",
 			Field:     "resolvedstakes",
 			IdVal:     stake.ID,
 		}
-		if err := UpdateBetHelper(ctx, updateOwner); err != nil {
+		if err := ctl.UpdateBetHelper(ctx, updateOwner); err != nil {
 			return err
 		}
-
-		if bet.OverallStatus == models.CreatorWon {
-			// Make stake losers pay out to original bet's loser (stake receiver, in this case)
-			if err := transferBalance(ctx, receiverStaker, receiver, stake.SharesFilled*bet.CreatorAmount); err != nil {
-				return err
-			}
-		} else {
-			// Make original bet's loser (bet creator, in this case) pay out to stake winners
-			if err := transferBalance(ctx, creator, receiverStaker, stake.SharesFilled*bet.ReceiverAmount); err != nil {
-				return err
-			}
-		}
 	}
 
+	bet.CreatorStakes = make([]primitive.ObjectID, 0)
+	bet.ReceiverStakes = make([]primitive.ObjectID, 0)
+	bet.CreatorStakedUnfilled = 0
+	bet.ReceiverStakedUnfilled = 0
 	return nil
 }
@@ -0,0 +1,193 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *harness) stakeByID(id primitive.ObjectID) models.Stake {
+	h.t.Helper()
+	stake, err := h.ctl.Stakes.FindByID(context.Background(), id)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return stake
+}
+
+func TestStakesMatchInOrder(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave", "erin")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("alice", "erin")
+	h.befriend("bob", "dave")
+	betID := h.ongoingBet("alice", "bob")
+
+	carol := h.stake("carol", betID, 2, true)
+	erin := h.stake("erin", betID, 3, true)
+	if bet := h.bet(betID); !reflect.DeepEqual(bet.CreatorStakes, []primitive.ObjectID{carol, erin}) || bet.CreatorStakedUnfilled != 5 {
+		t.Fatalf("creator queue %v with %d unfilled, want carol then erin with 5", bet.CreatorStakes, bet.CreatorStakedUnfilled)
+	}
+
+	// A receiver-side share costs the creator's 10 tokens, so stakes in tokens must be a multiple of that
+	stake := map[string]interface{}{"underlying": betID, "ownername": "dave", "numtokens": 31, "backingcreator": false}
+	if code, out := h.do("dave", "POST", "/stakes/createstake", stake); code != http.StatusBadRequest {
+		t.Fatalf("stake of 31 tokens: %d %v", code, out)
+	}
+	stake["numtokens"] = 40
+	out := h.must(http.StatusOK, "dave", "POST", "/stakes/createstake", stake)
+	dave := h.objectID(out["InsertedID"])
+	if out["SharesFilled"] != 4.0 {
+		t.Fatalf("dave's 4 shares filled %v", out["SharesFilled"])
+	}
+
+	// carol was first in the queue so she is filled in full, and erin is filled with what is left
+	for _, tt := range []struct {
+		name   string
+		id     primitive.ObjectID
+		filled int64
+	}{{"carol", carol, 2}, {"erin", erin, 2}, {"dave", dave, 4}} {
+		if got := h.stakeByID(tt.id).SharesFilled; got != tt.filled {
+			t.Errorf("%s's stake has %d shares filled, want %d", tt.name, got, tt.filled)
+		}
+	}
+	bet := h.bet(betID)
+	if !reflect.DeepEqual(bet.CreatorStakes, []primitive.ObjectID{erin}) || bet.CreatorStakedUnfilled != 1 {
+		t.Errorf("creator queue %v with %d unfilled, want erin with 1", bet.CreatorStakes, bet.CreatorStakedUnfilled)
+	}
+	if len(bet.ReceiverStakes) != 0 || bet.ReceiverStakedUnfilled != 0 {
+		t.Errorf("receiver queue %v with %d unfilled, want it empty", bet.ReceiverStakes, bet.ReceiverStakedUnfilled)
+	}
+
+	// Only filled shares pay out; a creator-side share wins the creator's 10 tokens
+	h.claim("alice", betID, models.CreatorWon)
+	h.claim("bob", betID, models.CreatorWon)
+	for name, want := range map[string]int64{"carol": 20, "erin": 20, "dave": -40} {
+		u := h.user(name)
+		if u.TotalBalance != want {
+			t.Errorf("%s has balance %d, want %d", name, u.TotalBalance, want)
+		}
+		if len(u.OngoingStakes) != 0 || len(u.ResolvedStakes) != 1 {
+			t.Errorf("%s has ongoing stakes %v and resolved stakes %v", name, u.OngoingStakes, u.ResolvedStakes)
+		}
+	}
+}
+
+func TestCancelStake(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave", "erin")
+	h.befriend("alice", "bob")
+	h.befriend("alice", "carol")
+	h.befriend("alice", "erin")
+	h.befriend("bob", "dave")
+	betID := h.ongoingBet("alice", "bob")
+	carol := h.stake("carol", betID, 5, true)
+	dave := h.stake("dave", betID, 2, false)
+	cancel := func(user string, stakeID primitive.ObjectID) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/stakes/cancelstake", map[string]interface{}{"stakeid": stakeID, "username": user})
+	}
+
+	if code, out := cancel("dave", carol); code != http.StatusForbidden {
+		t.Fatalf("dave cancelled carol's stake: %d %v", code, out)
+	}
+	if code, out := cancel("dave", dave); code != http.StatusBadRequest {
+		t.Fatalf("cancelled a stake with nothing unfilled: %d %v", code, out)
+	}
+	if code, out := cancel("carol", carol); code != http.StatusOK || out["msg"] != "Cancelled 3 unfilled shares of stake" {
+		t.Fatalf("carol cancelling: %d %v", code, out)
+	}
+	if code, out := cancel("carol", carol); code != http.StatusBadRequest {
+		t.Fatalf("cancelled the same stake twice: %d %v", code, out)
+	}
+
+	// The filled part of carol's stake stands
+	if stake := h.stakeByID(carol); stake.SharesStaked != 2 || stake.SharesFilled != 2 || stake.Voided {
+		t.Errorf("carol's stake after cancelling: %+v", stake)
+	}
+	bet := h.bet(betID)
+	if bet.CreatorStaked != 2 || len(bet.CreatorStakes) != 0 || bet.CreatorStakedUnfilled != 0 {
+		t.Errorf("bet has %d creator-side shares staked, queue %v and %d unfilled", bet.CreatorStaked, bet.CreatorStakes, bet.CreatorStakedUnfilled)
+	}
+	if u := h.user("carol"); !reflect.DeepEqual(u.OngoingStakes, []primitive.ObjectID{carol}) {
+		t.Errorf("carol has ongoing stakes %v", u.OngoingStakes)
+	}
+
+	// A stake with nothing filled is closed out entirely
+	erin := h.stake("erin", betID, 1, true)
+	if code, out := cancel("erin", erin); code != http.StatusOK {
+		t.Fatalf("erin cancelling: %d %v", code, out)
+	}
+	if stake := h.stakeByID(erin); stake.SharesStaked != 0 || !stake.Voided {
+		t.Errorf("erin's stake after cancelling: %+v", stake)
+	}
+	if u := h.user("erin"); len(u.OngoingStakes) != 0 || !reflect.DeepEqual(u.ResolvedStakes, []primitive.ObjectID{erin}) {
+		t.Errorf("erin has ongoing stakes %v and resolved stakes %v", u.OngoingStakes, u.ResolvedStakes)
+	}
+
+	h.claim("alice", betID, models.CreatorWon)
+	h.claim("bob", betID, models.CreatorWon)
+	for name, want := range map[string]int64{"carol": 20, "dave": -20, "erin": 0} {
+		if got := h.user(name).TotalBalance; got != want {
+			t.Errorf("%s has balance %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestStakeEligibility(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob", "carol", "dave")
+	h.befriend("alice", "bob")
+	out := h.must(http.StatusOK, "alice", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "alice", "receivername": "bob", "title": "Rain", "visibility": models.Public,
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	betID := h.objectID(out["InsertedID"])
+	stake := func(user, owner string, shares int64) (int, map[string]interface{}) {
+		return h.do(user, "POST", "/stakes/createstake", map[string]interface{}{"underlying": betID, "ownername": owner, "numshares": shares, "backingcreator": true})
+	}
+
+	if code, out := stake("carol", "carol", 2); code != http.StatusBadRequest {
+		t.Fatalf("staked on a bet request: %d %v", code, out)
+	}
+	h.must(http.StatusOK, "bob", "POST", "/bets/handlebetreq", map[string]interface{}{"betid": betID, "betreqstatus": models.Accepted})
+
+	config.GlobalConfig.MaxStakeSharesPerUser = 5
+	config.GlobalConfig.MaxStakeSharesPerBet = 8
+	tests := []struct {
+		name         string
+		user, owner  string
+		shares       int64
+		status       int
+		allowBettors bool
+	}{
+		{"for someone else", "carol", "dave", 2, http.StatusForbidden, false},
+		{"on own bet", "alice", "alice", 2, http.StatusForbidden, false},
+		{"no shares", "carol", "carol", 0, http.StatusBadRequest, false},
+		{"within limits", "carol", "carol", 4, http.StatusOK, false},
+		{"over the per-user limit", "carol", "carol", 2, http.StatusBadRequest, false},
+		{"over the per-bet limit", "dave", "dave", 5, http.StatusBadRequest, false},
+		{"up to the per-bet limit", "dave", "dave", 4, http.StatusOK, false},
+		{"past a full bet", "bob", "bob", 1, http.StatusBadRequest, true},
+	}
+	for _, tt := range tests {
+		config.GlobalConfig.AllowBettorStakes = tt.allowBettors
+		if code, out := stake(tt.user, tt.owner, tt.shares); code != tt.status {
+			t.Errorf("%s: got %d %v, want %d", tt.name, code, out, tt.status)
+		}
+	}
+
+	// With the limits lifted, bettors can stake too if that is allowed
+	config.GlobalConfig.MaxStakeSharesPerUser = 0
+	config.GlobalConfig.MaxStakeSharesPerBet = 0
+	config.GlobalConfig.AllowBettorStakes = true
+	if code, out := stake("alice", "alice", 2); code != http.StatusOK {
+		t.Fatalf("bettor stake when allowed: %d %v", code, out)
+	}
+}
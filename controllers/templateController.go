@@ -0,0 +1,527 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/cron"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxTemplatesPerUser = 50
+	maxSchedulesPerUser = 20
+	scheduleBatchSize   = 100
+)
+
+// Pass in the receiver, title, description, odds, number of shares and how many hours each bet should last
+func (ctl *Controller) CreateTemplateFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var templateReq models.BetTemplateRequest
+
+	if err := c.BindJSON(&templateReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(templateReq); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if templateReq.ReceiverName == username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot make a bet template with yourself"})
+		return
+	}
+	if receiver, err := ctl.Users.FindByUsername(ctx, templateReq.ReceiverName); err != nil || receiver.Deleted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Bet receiver %s not found", templateReq.ReceiverName)})
+		return
+	}
+
+	existing, err := ctl.Templates.ListByOwner(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(existing) >= maxTemplatesPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("users can have at most %d bet templates", maxTemplatesPerUser)})
+		return
+	}
+
+	template := models.BetTemplate{
+		ID:             primitive.NewObjectID(),
+		OwnerName:      username,
+		ReceiverName:   templateReq.ReceiverName,
+		Title:          templateReq.Title,
+		Description:    templateReq.Description,
+		CreatorAmount:  templateReq.CreatorAmount,
+		ReceiverAmount: templateReq.ReceiverAmount,
+		NumShares:      templateReq.NumShares,
+		ExpiryHours:    templateReq.ExpiryHours,
+		CreateDate:     primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if template.NumShares == 0 {
+		template.NumShares = 10
+	}
+	if err := ctl.Templates.Insert(ctx, template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Bet template creation unsuccessful"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"template": template})
+}
+
+func (ctl *Controller) ListTemplatesFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	templates, err := ctl.Templates.ListByOwner(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// Also deletes every schedule that uses the template; bets already issued from it are left alone
+func (ctl *Controller) DeleteTemplateFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	template, status, err := ctl.ownTemplate(c, ctx)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := ctl.Schedules.DeleteByTemplate(ctx, template.ID); err != nil {
+			return err
+		}
+		return ctl.Templates.Delete(ctx, template.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("Deleted bet template %s", template.ID.Hex())})
+}
+
+// Sends a bet request from the template straight away, exactly as if it had been made by hand
+func (ctl *Controller) IssueTemplateFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	template, status, err := ctl.ownTemplate(c, ctx)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	var bet models.Bet
+	status = http.StatusOK
+	err = ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		bet, status, err = ctl.createBetReq(ctx, betFromTemplate(template, time.Now()))
+		if err != nil {
+			return err
+		}
+		return ctl.Bus.Publish(ctx, betRequestEvent(bet))
+	})
+	if err != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"InsertedID": bet.ID})
+}
+
+// Pass in the template and either a weekday, hour and minute, or a cron expression, plus the time zone they are in
+func (ctl *Controller) CreateScheduleFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var scheduleReq models.BetScheduleRequest
+
+	if err := c.BindJSON(&scheduleReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(scheduleReq); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := ctl.Templates.FindByID(ctx, scheduleReq.TemplateID)
+	if err == database.ErrNotFound || (err == nil && template.OwnerName != username) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("bet template ID %s not found", scheduleReq.TemplateID.Hex())})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := ctl.Schedules.ListByOwner(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(existing) >= maxSchedulesPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("users can have at most %d bet schedules", maxSchedulesPerUser)})
+		return
+	}
+
+	now := time.Now()
+	schedule := models.BetSchedule{
+		ID:         primitive.NewObjectID(),
+		OwnerName:  username,
+		TemplateID: template.ID,
+		Kind:       scheduleReq.Kind,
+		TimeZone:   scheduleReq.TimeZone,
+		CreateDate: primitive.NewDateTimeFromTime(now),
+	}
+	switch scheduleReq.Kind {
+	case models.WeeklySchedule:
+		schedule.Weekday = scheduleReq.Weekday
+		schedule.Hour = scheduleReq.Hour
+		schedule.Minute = scheduleReq.Minute
+	case models.CronSchedule:
+		schedule.Cron = scheduleReq.Cron
+	}
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = "UTC"
+	}
+	nextRun, err := scheduleNextRun(schedule, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schedule.NextRun = primitive.NewDateTimeFromTime(nextRun)
+
+	if err := ctl.Schedules.Insert(ctx, schedule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Bet schedule creation unsuccessful"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule})
+}
+
+func (ctl *Controller) ListSchedulesFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedules, err := ctl.Schedules.ListByOwner(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func (ctl *Controller) PauseScheduleFunc(c *gin.Context) {
+	ctl.setSchedulePaused(c, true)
+}
+
+// Runs missed while the schedule was paused are skipped rather than made up
+func (ctl *Controller) ResumeScheduleFunc(c *gin.Context) {
+	ctl.setSchedulePaused(c, false)
+}
+
+func (ctl *Controller) setSchedulePaused(c *gin.Context, paused bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	schedule, status, err := ctl.ownSchedule(c, ctx)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	status = http.StatusOK
+	err = ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		schedule, status, err = ctl.pauseSchedule(ctx, schedule.ID, paused, time.Now())
+		return err
+	})
+	if err != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule})
+}
+
+// Re-reads the schedule so a run by the scheduler in the meantime is not undone
+func (ctl *Controller) pauseSchedule(ctx context.Context, scheduleID primitive.ObjectID, paused bool, now time.Time) (models.BetSchedule, int, error) {
+	schedule, err := ctl.Schedules.FindByID(ctx, scheduleID)
+	if err != nil {
+		return schedule, http.StatusInternalServerError, err
+	}
+	if schedule.Paused == paused {
+		return schedule, http.StatusOK, nil
+	}
+	schedule.Paused = paused
+	if !paused {
+		nextRun, err := scheduleNextRun(schedule, now)
+		if err != nil {
+			return schedule, http.StatusBadRequest, err
+		}
+		schedule.NextRun = primitive.NewDateTimeFromTime(nextRun)
+	}
+	if err := ctl.Schedules.Replace(ctx, schedule); err != nil {
+		return schedule, http.StatusInternalServerError, err
+	}
+	return schedule, http.StatusOK, nil
+}
+
+func (ctl *Controller) DeleteScheduleFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	schedule, status, err := ctl.ownSchedule(c, ctx)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ctl.Schedules.Delete(ctx, schedule.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("Deleted bet schedule %s", schedule.ID.Hex())})
+}
+
+// Loads the bet template in the :id path parameter, which must belong to the logged in user
+// Other users' templates are reported as missing
+func (ctl *Controller) ownTemplate(c *gin.Context, ctx context.Context) (models.BetTemplate, int, error) {
+	templateID, err := parseIDParam(c)
+	if err != nil {
+		return models.BetTemplate{}, http.StatusBadRequest, err
+	}
+	username, err := currentUsername(c)
+	if err != nil {
+		return models.BetTemplate{}, http.StatusUnauthorized, err
+	}
+
+	template, err := ctl.Templates.FindByID(ctx, templateID)
+	if err == database.ErrNotFound || (err == nil && template.OwnerName != username) {
+		return models.BetTemplate{}, http.StatusNotFound, fmt.Errorf("bet template ID %s not found", templateID.Hex())
+	} else if err != nil {
+		return models.BetTemplate{}, http.StatusInternalServerError, err
+	}
+	return template, http.StatusOK, nil
+}
+
+// Loads the bet schedule in the :id path parameter, which must belong to the logged in user
+// Other users' schedules are reported as missing
+func (ctl *Controller) ownSchedule(c *gin.Context, ctx context.Context) (models.BetSchedule, int, error) {
+	scheduleID, err := parseIDParam(c)
+	if err != nil {
+		return models.BetSchedule{}, http.StatusBadRequest, err
+	}
+	username, err := currentUsername(c)
+	if err != nil {
+		return models.BetSchedule{}, http.StatusUnauthorized, err
+	}
+
+	schedule, err := ctl.Schedules.FindByID(ctx, scheduleID)
+	if err == database.ErrNotFound || (err == nil && schedule.OwnerName != username) {
+		return models.BetSchedule{}, http.StatusNotFound, fmt.Errorf("bet schedule ID %s not found", scheduleID.Hex())
+	} else if err != nil {
+		return models.BetSchedule{}, http.StatusInternalServerError, err
+	}
+	return schedule, http.StatusOK, nil
+}
+
+// The bet request the template describes, expiring ExpiryHours after now
+func betFromTemplate(template models.BetTemplate, now time.Time) models.Bet {
+	return models.Bet{
+		CreatorName:    template.OwnerName,
+		ReceiverName:   template.ReceiverName,
+		CreatorAmount:  template.CreatorAmount,
+		ReceiverAmount: template.ReceiverAmount,
+		NumShares:      template.NumShares,
+		Title:          template.Title,
+		Description:    template.Description,
+		ExpiryDate:     primitive.NewDateTimeFromTime(now.Add(time.Duration(template.ExpiryHours) * time.Hour)),
+	}
+}
+
+// The first time after the given one that the schedule runs, worked out in the schedule's time zone
+func scheduleNextRun(schedule models.BetSchedule, after time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(schedule.TimeZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown time zone %q", schedule.TimeZone)
+	}
+	var spec cron.Schedule
+	switch schedule.Kind {
+	case models.WeeklySchedule:
+		spec, err = cron.Weekly(time.Weekday(schedule.Weekday), schedule.Hour, schedule.Minute)
+	case models.CronSchedule:
+		spec, err = cron.Parse(schedule.Cron)
+	default:
+		err = fmt.Errorf("invalid schedule kind %d", schedule.Kind)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := spec.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("schedule never runs")
+	}
+	return next, nil
+}
+
+// Issues a bet request for every schedule that is due
+// Runs that were missed, e.g. while the server was down, are made up with a single bet request per schedule,
+// and the schedule then carries on from its next run after now
+// Returns the number of bet requests issued
+func (ctl *Controller) RunSchedules(ctx context.Context, now time.Time) (int, error) {
+	due, err := ctl.Schedules.FindDue(ctx, now, scheduleBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	issued := 0
+	for _, schedule := range due {
+		scheduleID := schedule.ID
+		ok := false
+		err := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			ok, err = ctl.runSchedule(ctx, scheduleID, now)
+			return err
+		})
+		if err != nil {
+			log.Printf("Could not run bet schedule %s: %v\n", scheduleID.Hex(), err)
+			// Record the failure and move the schedule on, so one that keeps failing is not retried every tick
+			err := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+				return ctl.failSchedule(ctx, scheduleID, now)
+			})
+			if err != nil {
+				log.Printf("Could not record the failed run of bet schedule %s: %v\n", scheduleID.Hex(), err)
+			}
+			continue
+		}
+		if ok {
+			issued++
+		}
+	}
+	return issued, nil
+}
+
+// Re-reads the schedule so that one paused, resumed or already run in the meantime is respected
+// A bet request that is refused, e.g. because the receiver has blocked the owner, is recorded on the schedule
+// and the schedule moves on; other failures are returned, and RunSchedules records them with failSchedule
+func (ctl *Controller) runSchedule(ctx context.Context, scheduleID primitive.ObjectID, now time.Time) (bool, error) {
+	schedule, err := ctl.Schedules.FindByID(ctx, scheduleID)
+	if err == database.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if schedule.Paused || schedule.NextRun.Time().After(now) {
+		return false, nil
+	}
+
+	issued := false
+	schedule.LastRun = primitive.NewDateTimeFromTime(now)
+	schedule.LastBetID = nil
+	schedule.LastError = ""
+	template, err := ctl.Templates.FindByID(ctx, schedule.TemplateID)
+	if err == database.ErrNotFound {
+		schedule.LastError = "bet template not found"
+	} else if err != nil {
+		return false, err
+	} else {
+		bet, status, err := ctl.createBetReq(ctx, betFromTemplate(template, now))
+		if err != nil {
+			if status >= http.StatusInternalServerError {
+				return false, err
+			}
+			schedule.LastError = err.Error()
+		} else {
+			if err := ctl.Bus.Publish(ctx, betRequestEvent(bet)); err != nil {
+				return false, err
+			}
+			schedule.LastBetID = &bet.ID
+			issued = true
+		}
+	}
+
+	if err := ctl.advanceSchedule(ctx, schedule, now); err != nil {
+		return false, err
+	}
+	return issued, nil
+}
+
+// Records a run that failed for some reason other than the bet request being refused, once the writes it
+// made have been rolled back, and moves the schedule on to its next run
+// The details only go to the log, since they are about the server rather than the bet
+func (ctl *Controller) failSchedule(ctx context.Context, scheduleID primitive.ObjectID, now time.Time) error {
+	schedule, err := ctl.Schedules.FindByID(ctx, scheduleID)
+	if err == database.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if schedule.Paused || schedule.NextRun.Time().After(now) {
+		return nil
+	}
+	schedule.LastRun = primitive.NewDateTimeFromTime(now)
+	schedule.LastBetID = nil
+	schedule.LastError = "could not issue the bet request"
+	return ctl.advanceSchedule(ctx, schedule, now)
+}
+
+// Saves the schedule with its next run after now, or paused if it has none
+func (ctl *Controller) advanceSchedule(ctx context.Context, schedule models.BetSchedule, now time.Time) error {
+	nextRun, err := scheduleNextRun(schedule, now)
+	if err != nil {
+		// Nothing left to run, so stop checking it
+		schedule.Paused = true
+		if schedule.LastError == "" {
+			schedule.LastError = err.Error()
+		}
+	} else {
+		schedule.NextRun = primitive.NewDateTimeFromTime(nextRun)
+	}
+	return ctl.Schedules.Replace(ctx, schedule)
+}
@@ -0,0 +1,201 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *harness) runSchedules(now time.Time) int {
+	h.t.Helper()
+	issued, err := h.ctl.RunSchedules(context.Background(), now)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return issued
+}
+
+func (h *harness) schedules(user string) []models.BetSchedule {
+	h.t.Helper()
+	schedules, err := h.ctl.Schedules.ListByOwner(context.Background(), user)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return schedules
+}
+
+func TestBetTemplates(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+
+	template := func(receiver string) (int, map[string]interface{}) {
+		return h.do("alice", "POST", "/templates", map[string]interface{}{
+			"receivername": receiver, "title": "Friday game", "creatoramount": 2, "receiveramount": 3, "expiryhours": 24,
+		})
+	}
+	if code, out := template("alice"); code != http.StatusBadRequest {
+		t.Fatalf("template for a bet with herself: %d %v", code, out)
+	}
+	code, out := template("bob")
+	if code != http.StatusOK {
+		t.Fatalf("template: %d %v", code, out)
+	}
+	created := out["template"].(map[string]interface{})
+	if created["numshares"] != 10.0 {
+		t.Fatalf("template has %v shares, want the default of 10", created["numshares"])
+	}
+	templateID := created["id"].(string)
+
+	if code, out := h.do("bob", "POST", "/templates/"+templateID+"/issue", nil); code != http.StatusNotFound {
+		t.Fatalf("bob issued alice's template: %d %v", code, out)
+	}
+	out = h.must(http.StatusOK, "alice", "POST", "/templates/"+templateID+"/issue", nil)
+	bet := h.bet(h.objectID(out["InsertedID"]))
+	if bet.CreatorName != "alice" || bet.ReceiverName != "bob" || bet.Title != "Friday game" || bet.CreatorAmount != 2 || bet.ReceiverAmount != 3 || bet.NumShares != 10 {
+		t.Fatalf("issued bet %+v", bet)
+	}
+	if until := time.Until(bet.ExpiryDate.Time()); until < 23*time.Hour || until > 24*time.Hour {
+		t.Fatalf("issued bet expires in %v, want 24 hours", until)
+	}
+	if bob := h.user("bob"); len(bob.IncomingBetReqs) != 1 {
+		t.Fatalf("bob has incoming bet requests %v", bob.IncomingBetReqs)
+	}
+}
+
+func TestBetSchedules(t *testing.T) {
+	h := newHarness(t)
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	out := h.must(http.StatusOK, "alice", "POST", "/templates", map[string]interface{}{
+		"receivername": "bob", "title": "Friday game", "creatoramount": 2, "receiveramount": 3, "expiryhours": 24,
+	})
+	templateID := out["template"].(map[string]interface{})["id"].(string)
+
+	schedule := func(terms map[string]interface{}) (int, map[string]interface{}) {
+		terms["templateid"] = templateID
+		return h.do("alice", "POST", "/schedules", terms)
+	}
+	if code, out := schedule(map[string]interface{}{"kind": models.CronSchedule, "cron": "61 * * * *"}); code != http.StatusBadRequest {
+		t.Fatalf("schedule with a bad cron expression: %d %v", code, out)
+	}
+	if code, out := schedule(map[string]interface{}{"kind": models.WeeklySchedule, "weekday": 5, "hour": 19, "timezone": "Mars/Base"}); code != http.StatusBadRequest {
+		t.Fatalf("schedule in a made up time zone: %d %v", code, out)
+	}
+	code, out := schedule(map[string]interface{}{"kind": models.WeeklySchedule, "weekday": 5, "hour": 19, "timezone": "UTC"})
+	if code != http.StatusOK {
+		t.Fatalf("weekly schedule: %d %v", code, out)
+	}
+	weekly := out["schedule"].(map[string]interface{})["id"].(string)
+	code, out = schedule(map[string]interface{}{"kind": models.CronSchedule, "cron": "*/15 * * * *"})
+	if code != http.StatusOK {
+		t.Fatalf("cron schedule: %d %v", code, out)
+	}
+	quarterly := out["schedule"].(map[string]interface{})["id"].(string)
+
+	now := time.Now()
+	if issued := h.runSchedules(now); issued != 0 {
+		t.Fatalf("issued %d bet requests before anything was due", issued)
+	}
+	// Three weeks of missed runs are made up with one bet request per schedule
+	now = now.Add(21 * 24 * time.Hour)
+	if issued := h.runSchedules(now); issued != 2 {
+		t.Fatalf("issued %d bet requests for the missed runs, want 2", issued)
+	}
+	if issued := h.runSchedules(now); issued != 0 {
+		t.Fatalf("issued %d more bet requests running again straight away", issued)
+	}
+	for _, s := range h.schedules("alice") {
+		if s.LastBetID == nil || s.LastError != "" || !s.NextRun.Time().After(now) {
+			t.Errorf("schedule %s last issued %v with error %q, next run %v", s.ID.Hex(), s.LastBetID, s.LastError, s.NextRun.Time())
+		}
+	}
+	if bob := h.user("bob"); len(bob.IncomingBetReqs) != 2 {
+		t.Fatalf("bob has incoming bet requests %v, want 2", bob.IncomingBetReqs)
+	}
+
+	if code, out := h.do("bob", "POST", "/schedules/"+quarterly+"/pause", nil); code != http.StatusNotFound {
+		t.Fatalf("bob paused alice's schedule: %d %v", code, out)
+	}
+	if out := h.must(http.StatusOK, "alice", "POST", "/schedules/"+quarterly+"/pause", nil); out["schedule"].(map[string]interface{})["paused"] != true {
+		t.Fatalf("paused schedule %v", out)
+	}
+	now = now.Add(30 * 24 * time.Hour)
+	if issued := h.runSchedules(now); issued != 1 {
+		t.Fatalf("issued %d bet requests with one schedule paused, want 1", issued)
+	}
+	if out := h.must(http.StatusOK, "alice", "POST", "/schedules/"+quarterly+"/resume", nil); out["schedule"].(map[string]interface{})["paused"] != false {
+		t.Fatalf("resumed schedule %v", out)
+	}
+
+	// A refused bet request is recorded, and the schedule carries on
+	h.must(http.StatusOK, "bob", "POST", "/users/block", map[string]interface{}{"blocker": "bob", "blocked": "alice"})
+	now = now.Add(30 * 24 * time.Hour)
+	if issued := h.runSchedules(now); issued != 0 {
+		t.Fatalf("issued %d bet requests to a user who blocked alice", issued)
+	}
+	for _, s := range h.schedules("alice") {
+		if s.LastBetID != nil || s.LastError == "" || !s.NextRun.Time().After(now) {
+			t.Errorf("schedule %s last issued %v with error %q, next run %v", s.ID.Hex(), s.LastBetID, s.LastError, s.NextRun.Time())
+		}
+	}
+
+	h.must(http.StatusOK, "alice", "DELETE", "/schedules/"+weekly, nil)
+	if schedules := h.schedules("alice"); len(schedules) != 1 {
+		t.Fatalf("%d schedules left after deleting one of 2", len(schedules))
+	}
+	// Deleting the template takes its schedules with it
+	h.must(http.StatusOK, "alice", "DELETE", "/templates/"+templateID, nil)
+	if schedules := h.schedules("alice"); len(schedules) != 0 {
+		t.Fatalf("schedules %v left after deleting their template", schedules)
+	}
+	if out := h.must(http.StatusOK, "alice", "GET", "/templates", nil); len(out["templates"].([]interface{})) != 0 {
+		t.Fatalf("templates %v left after deleting the only one", out["templates"])
+	}
+}
+
+// A run that fails partway is rolled back, recorded on the schedule and not retried until the next run
+func TestFailedScheduleRunMovesOn(t *testing.T) {
+	f := &faults{}
+	h := newHarnessWithStores(t, faultyStores(f))
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	out := h.must(http.StatusOK, "alice", "POST", "/templates", map[string]interface{}{
+		"receivername": "bob", "title": "Friday game", "creatoramount": 2, "receiveramount": 3, "expiryhours": 24,
+	})
+	templateID := out["template"].(map[string]interface{})["id"].(string)
+	h.must(http.StatusOK, "alice", "POST", "/schedules", map[string]interface{}{"templateid": templateID, "kind": models.CronSchedule, "cron": "0 * * * *"})
+
+	now := time.Now().Add(2 * time.Hour)
+	f.reset(1)
+	if issued := h.runSchedules(now); issued != 0 {
+		t.Fatalf("issued %d bet requests with a failing write", issued)
+	}
+	f.reset(0)
+	schedules := h.schedules("alice")
+	if len(schedules) != 1 {
+		t.Fatalf("alice has schedules %v, want 1", schedules)
+	}
+	s := schedules[0]
+	if s.LastBetID != nil || s.LastError == "" || !s.LastRun.Time().Equal(primitive.NewDateTimeFromTime(now).Time()) || !s.NextRun.Time().After(now) {
+		t.Fatalf("failed schedule last ran %v, issued %v with error %q, next run %v", s.LastRun.Time(), s.LastBetID, s.LastError, s.NextRun.Time())
+	}
+	if alice, bob := h.user("alice"), h.user("bob"); alice.NumBets != 0 || len(alice.OutgoingBetReqs) != 0 || len(bob.IncomingBetReqs) != 0 {
+		t.Fatalf("failed run left alice with %d bets and outgoing %v, bob with incoming %v", alice.NumBets, alice.OutgoingBetReqs, bob.IncomingBetReqs)
+	}
+	if issued := h.runSchedules(now); issued != 0 {
+		t.Fatalf("retried the failed run straight away, issuing %d bet requests", issued)
+	}
+
+	// The next run goes ahead as normal
+	if issued := h.runSchedules(s.NextRun.Time()); issued != 1 {
+		t.Fatalf("issued %d bet requests on the next run, want 1", issued)
+	}
+	if s := h.schedules("alice")[0]; s.LastBetID == nil || s.LastError != "" {
+		t.Fatalf("schedule after the next run issued %v with error %q", s.LastBetID, s.LastError)
+	}
+}
@@ -8,21 +8,15 @@ import (
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
 	"github.com/simhonchourasia/betfr-be/authentication"
 	"github.com/simhonchourasia/betfr-be/config"
-	"github.com/simhonchourasia/betfr-be/database"
 	"github.com/simhonchourasia/betfr-be/models"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var userCollection *mongo.Collection = database.OpenCollection(database.Client, config.GlobalConfig.UserCollection)
 var validate = validator.New()
 
 func HashPassword(password string) string {
@@ -39,7 +33,7 @@ func VerifyPassword(givenPassword string, hashedPassword string) bool {
 }
 
 // Function to sign up a user
-var SignUpFunc gin.HandlerFunc = func(c *gin.Context) {
+func (ctl *Controller) SignUpFunc(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
@@ -61,8 +55,8 @@ var SignUpFunc gin.HandlerFunc = func(c *gin.Context) {
 		return
 	}
 
-	numSameEmail, emailErr := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
-	numSameUsername, usernameErr := userCollection.CountDocuments(ctx, bson.M{"username": user.Username})
+	numSameEmail, emailErr := ctl.Users.CountByEmail(ctx, *user.Email)
+	numSameUsername, usernameErr := ctl.Users.CountByUsername(ctx, *user.Username)
 	if emailErr != nil || usernameErr != nil {
 		err := fmt.Errorf("error when validating username/email: %v; %v", usernameErr, emailErr)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -83,12 +77,8 @@ var SignUpFunc gin.HandlerFunc = func(c *gin.Context) {
 
 	user.ID = primitive.NewObjectID()
 
-	token, refreshToken, err := authentication.GenerateAllTokens(*user.Username)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-	}
-	user.Token = &token
-	user.RefreshToken = &refreshToken
+	// Signing up does not sign the user in; logging in starts their first session
+	user.Sessions = make([]models.Session, 0)
 
 	// Initialize everything else
 	// TODO: come up with a better solution than raw initializing everything here
@@ -105,8 +95,11 @@ var SignUpFunc gin.HandlerFunc = func(c *gin.Context) {
 	user.OngoingStakes = make([]primitive.ObjectID, 0)
 	user.Balances = make(map[string]int64)
 	user.TotalBalance = 0
+	user.NotificationPrefs = models.NotificationPrefs{DigestBetReqs: true, DigestExpiringBets: true}
+	// The first digest goes out one period after signing up
+	user.LastDigestDate = primitive.NewDateTimeFromTime(time.Now())
 
-	_, err = userCollection.InsertOne(ctx, user)
+	err := ctl.Users.Insert(ctx, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "User signup unsuccessful"})
 		return
@@ -115,172 +108,183 @@ var SignUpFunc gin.HandlerFunc = func(c *gin.Context) {
 	c.JSON(http.StatusOK, "ok")
 }
 
-var LoginFunc gin.HandlerFunc = func(c *gin.Context) {
+func (ctl *Controller) LoginFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
 	var user models.User
-	var matchingUser models.User
 
 	if err := c.BindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	foundUser := userCollection.FindOne(ctx, bson.M{"email": user.Email})
-	if foundUser.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User with email not found"})
+	if user.Email == nil || user.Password == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
 		return
 	}
-	if err := foundUser.Decode(&matchingUser); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+
+	matchingUser, err := ctl.Users.FindByEmail(ctx, *user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User with email not found"})
 		return
 	}
 
 	passwordOk := VerifyPassword(*user.Password, *matchingUser.Password)
 	if !passwordOk {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Incorrect password"})
+		return
 	}
 
-	token, refreshToken, err := authentication.GenerateAllTokens(*matchingUser.Username)
+	// Logging in starts a new token family, so sessions on other devices carry on
+	family := authentication.NewTokenFamily()
+	token, refreshToken, err := authentication.GenerateAllTokens(*matchingUser.Username, family)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	authentication.UpdateAllTokens(token, refreshToken, *matchingUser.Username)
-
-	c.SetCookie("jwt", token, 24*60*60, "/", config.GlobalConfig.Domain, false, true)
+	if err := authentication.StartSession(ctx, ctl.Users, *matchingUser.Username, family, refreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, matchingUser)
-}
+	setTokenCookies(c, token, refreshToken)
 
-func GetClaimsFromCookie(c *gin.Context) (*jwt.StandardClaims, int, error) {
-	cookie, err := c.Cookie("jwt")
+	view, err := ctl.ownUserView(ctx, matchingUser)
 	if err != nil {
-		return nil, http.StatusBadRequest, err
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	token, err := jwt.ParseWithClaims(
-		cookie,
-		&jwt.StandardClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(config.GlobalConfig.SecretKey), nil
-		},
-	)
-	if err != nil {
-		return nil, http.StatusUnauthorized, err
-	}
+	c.JSON(http.StatusOK, models.LoginResponse{
+		UserView:     view,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
 
-	claims := token.Claims.(*jwt.StandardClaims)
-	return claims, http.StatusOK, nil
+func setTokenCookies(c *gin.Context, token string, refreshToken string) {
+	c.SetCookie("jwt", token, 24*60*60, "/", config.GlobalConfig.Domain, false, true)
+	c.SetCookie("refresh", refreshToken, 7*24*60*60, "/users/refresh", config.GlobalConfig.Domain, false, true)
 }
 
-var GetUserFunc gin.HandlerFunc = func(c *gin.Context) {
+// Pass in the refresh token in the body, or rely on the refresh cookie set at login
+// Returns a new access/refresh pair; the old refresh token stops working
+func (ctl *Controller) RefreshFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	claims, statusCode, err := GetClaimsFromCookie(c)
-	if err != nil {
-		c.JSON(statusCode, gin.H{"error": err.Error()})
-		return
+	var refreshReq models.RefreshRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&refreshReq); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
-
-	if claims == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Not logged in"})
+	if refreshReq.RefreshToken == "" {
+		refreshReq.RefreshToken, _ = c.Cookie("refresh")
+	}
+	if refreshReq.RefreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing refresh token"})
 		return
 	}
 
-	var user models.User
-	foundUser := userCollection.FindOne(ctx, bson.M{"username": claims.Issuer})
-	if foundUser.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User with email not found"})
+	var token, refreshToken string
+	var rotateErr error
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		token, refreshToken, rotateErr = authentication.RotateTokens(ctx, ctl.Users, refreshReq.RefreshToken)
+		// The revocation that follows reuse must be committed, so it is not treated as a failure here
+		if rotateErr != nil && rotateErr != authentication.ErrTokenReuse {
+			return rotateErr
+		}
+		return nil
+	})
+	if txErr != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": txErr.Error()})
 		return
 	}
-	if err := foundUser.Decode(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if rotateErr != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": rotateErr.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
-}
-
-var LogoutFunc gin.HandlerFunc = func(c *gin.Context) {
-	// var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
-	// defer cancel()
-
-	c.SetCookie("jwt", "", -1, "/", config.GlobalConfig.Domain, false, true)
+	setTokenCookies(c, token, refreshToken)
 
-	c.JSON(http.StatusOK, gin.H{"msg": "logged out"})
+	c.JSON(http.StatusOK, gin.H{"token": token, "refreshtoken": refreshToken})
 }
 
-var DeleteUserFunc gin.HandlerFunc = func(c *gin.Context) {
+func (ctl *Controller) GetUserFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	var user models.User
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := c.BindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	user, err := ctl.Users.FindByUsername(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	foundUser, err := userCollection.DeleteOne(ctx, bson.M{"email": user.Email, "username": user.Username})
+	view, err := ctl.ownUserView(ctx, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Panic(err)
-	}
-	if foundUser.DeletedCount == 0 {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"error": fmt.Sprintf("User %s could not be found for deletion", *user.Username),
-			},
-		)
 		return
 	}
+	c.JSON(http.StatusOK, view)
+}
 
-	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("Successfully deleted user %s", *user.Username)})
+// The view of a user shown to that same user, which includes their unread notification count
+func (ctl *Controller) ownUserView(ctx context.Context, user models.User) (models.UserView, error) {
+	view := models.NewUserView(user)
+	unread, err := ctl.Notifications.CountUnread(ctx, *user.Username)
+	if err != nil {
+		return models.UserView{}, err
+	}
+	view.UnreadNotifications = unread
+	return view, nil
 }
 
-// Helper function to be used in handling friend requests and balance transfers
-func UpdateUserHelper(c *gin.Context, ctx context.Context, friendUpdate models.UpdateUserHelperStruct) error {
-	upsert := true
-	filter := bson.M{"username": friendUpdate.Username}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	var update primitive.M
-	if friendUpdate.Operation == "$pullAll" {
-		toRemove := []interface{}{friendUpdate.Val}
-		update = bson.M{friendUpdate.Field: toRemove}
-	} else {
-		update = bson.M{friendUpdate.Field: friendUpdate.Val}
-	}
-
-	res, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{{Key: friendUpdate.Operation, Value: update}},
-		&opt,
-	)
+// Signs out of the session the request belongs to; pass everywhere=true to sign out of every session
+func (ctl *Controller) LogoutFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
 
-	if err != nil {
-		log.Panic(err)
-		return err
+	principal, ok := authentication.GetPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "could not get username from context"})
+		return
 	}
 
-	if res.MatchedCount == 0 {
-		return fmt.Errorf("tried to update invalid user %s", friendUpdate.Username)
+	// Revoke the session server-side as well, so copies of its tokens stop working
+	revoke := func() error {
+		return authentication.RevokeSession(ctx, ctl.Users, principal.Username, principal.Family)
 	}
-	return nil
+	if c.Query("everywhere") == "true" {
+		revoke = func() error { return authentication.RevokeTokens(ctx, ctl.Users, principal.Username) }
+	}
+	if err := revoke(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetCookie("jwt", "", -1, "/", config.GlobalConfig.Domain, false, true)
+	c.SetCookie("refresh", "", -1, "/users/refresh", config.GlobalConfig.Domain, false, true)
+
+	c.JSON(http.StatusOK, gin.H{"msg": "logged out"})
+}
+
+// Helper function to be used in handling friend requests and balance transfers
+func (ctl *Controller) UpdateUserHelper(c *gin.Context, ctx context.Context, friendUpdate models.UpdateUserHelperStruct) error {
+	return ctl.Users.UpdateList(ctx, friendUpdate)
 }
 
-// TODO: add support for blocking users with this
 // Adds receiver to outgoing friend reqs of sender and adds sender to incoming reqs of receiver
 // Note that accepting a friend request doesn't require a previous friend request to be sent (will force friendship)
 // API will only succeed if username in context matches token
-var SendFriendReqFunc gin.HandlerFunc = func(c *gin.Context) {
+func (ctl *Controller) SendFriendReqFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
@@ -292,7 +296,7 @@ var SendFriendReqFunc gin.HandlerFunc = func(c *gin.Context) {
 	}
 	// Check that the user using the friend request send API is the one logged in
 	if permissionErr := authentication.CheckUserPermissions(c, friendReq.Sender); permissionErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": permissionErr.Error()})
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
 		return
 	}
 	if *friendReq.Receiver == *friendReq.Sender {
@@ -300,100 +304,115 @@ var SendFriendReqFunc gin.HandlerFunc = func(c *gin.Context) {
 		return
 	}
 
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		status, err = ctl.sendFriendReq(ctx, *friendReq.Sender, *friendReq.Receiver)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
+		return
+	}
+
+	msg := fmt.Sprintf(
+		"Successfully sent friend request from %s to %s",
+		*friendReq.Sender,
+		*friendReq.Receiver,
+	)
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+// Records the friend request on both users and tells the receiver about it
+func (ctl *Controller) sendFriendReq(ctx context.Context, senderName string, receiverName string) (int, error) {
 	// First check that the users exist
 	// TODO: this could be removed? or another endpoint could be added to check if a user exists
 	// TODO: maybe only have these really detailed checks for certain checking levels (efficiency vs error handling)
-	senderUser := userCollection.FindOne(ctx, bson.M{"username": friendReq.Sender})
-	if senderUser.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Friend request sender %s not found", *friendReq.Sender)})
+	sender, err := ctl.Users.FindByUsername(ctx, senderName)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Friend request sender %s not found", senderName)
 	}
-	receiverUser := userCollection.FindOne(ctx, bson.M{"username": friendReq.Receiver})
-	if receiverUser.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Friend request receiver %s not found", *friendReq.Receiver)})
-		return
+	receiver, err := ctl.Users.FindByUsername(ctx, receiverName)
+	if err != nil || receiver.Deleted {
+		return http.StatusInternalServerError, fmt.Errorf("Friend request receiver %s not found", receiverName)
 	}
-	var sender models.User
-	if err := senderUser.Decode(&sender); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if containsString(receiver.BlockedUsers, senderName) {
+		return http.StatusForbidden, fmt.Errorf("can't send friend request to %s", receiverName)
 	}
-	var receiver models.User
-	if err := receiverUser.Decode(&receiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if containsString(sender.BlockedUsers, receiverName) {
+		return http.StatusBadRequest, fmt.Errorf("unblock %s before sending them a friend request", receiverName)
 	}
 	// Sanity check for sender
 	for _, v := range sender.Friends {
-		if v == *friendReq.Receiver {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "users are already friends"})
-			return
+		if v == receiverName {
+			return http.StatusBadRequest, fmt.Errorf("users are already friends")
 		}
 	}
 	for _, v := range sender.IncomingFriendReqs {
-		if v == *friendReq.Receiver {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "sender already received friend request from receiver"})
-			return
+		if v == receiverName {
+			return http.StatusBadRequest, fmt.Errorf("sender already received friend request from receiver")
 		}
 	}
 	for _, v := range sender.OutgoingFriendReqs {
-		if v == *friendReq.Receiver {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "sender already sent friend request to receiver"})
-			return
+		if v == receiverName {
+			return http.StatusBadRequest, fmt.Errorf("sender already sent friend request to receiver")
 		}
 	}
 	// Sanity check for receiver
 	for _, v := range receiver.Friends {
-		if v == *friendReq.Sender {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "users are already friends"})
-			return
+		if v == senderName {
+			return http.StatusBadRequest, fmt.Errorf("users are already friends")
 		}
 	}
 	for _, v := range receiver.IncomingFriendReqs {
-		if v == *friendReq.Sender {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "sender already sent friend request to receiver"})
-			return
+		if v == senderName {
+			return http.StatusBadRequest, fmt.Errorf("sender already sent friend request to receiver")
 		}
 	}
 	for _, v := range receiver.OutgoingFriendReqs {
-		if v == *friendReq.Receiver {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "sender already received friend request from receiver"})
-			return
+		if v == receiverName {
+			return http.StatusBadRequest, fmt.Errorf("sender already received friend request from receiver")
 		}
 	}
 
 	// Add to incoming and outgoing lists
 	updateSender := models.UpdateUserHelperStruct{
-		Username:  *friendReq.Sender,
+		Username:  senderName,
 		Operation: "$push",
 		Field:     "outgoingfriendreqs",
-		Val:       *friendReq.Receiver,
+		Val:       receiverName,
 	}
-	if err := UpdateUserHelper(c, ctx, updateSender); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := ctl.Users.UpdateList(ctx, updateSender); err != nil {
+		return http.StatusInternalServerError, err
 	}
 	updateReceiver := models.UpdateUserHelperStruct{
-		Username:  *friendReq.Receiver,
+		Username:  receiverName,
 		Operation: "$push",
 		Field:     "incomingfriendreqs",
-		Val:       *friendReq.Sender,
+		Val:       senderName,
 	}
-	if err := UpdateUserHelper(c, ctx, updateReceiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := ctl.Users.UpdateList(ctx, updateReceiver); err != nil {
+		return http.StatusInternalServerError, err
 	}
 
-	msg := fmt.Sprintf(
-		"Successfully sent friend request from %s to %s",
-		*friendReq.Sender,
-		*friendReq.Receiver,
-	)
-	c.JSON(http.StatusOK, gin.H{"msg": msg})
+	event := models.Event{
+		Type:     models.FriendRequestSent,
+		Audience: []string{senderName, receiverName},
+		Actor:    senderName,
+		Target:   receiverName,
+	}
+	if err := ctl.Bus.Publish(ctx, event); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
 }
 
 // Removes friends from incoming/outgoing friend reqs
 // Adds to friends if success
-var ResolveFriendReqFunc gin.HandlerFunc = func(c *gin.Context) {
+func (ctl *Controller) ResolveFriendReqFunc(c *gin.Context) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
@@ -405,7 +424,7 @@ var ResolveFriendReqFunc gin.HandlerFunc = func(c *gin.Context) {
 	}
 	// Check that the user accepting the friend request is the one logged in
 	if permissionErr := authentication.CheckUserPermissions(c, friendReq.Receiver); permissionErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": permissionErr.Error()})
+		c.JSON(http.StatusForbidden, gin.H{"error": permissionErr.Error()})
 		return
 	}
 	if *friendReq.Receiver == *friendReq.Sender {
@@ -413,221 +432,154 @@ var ResolveFriendReqFunc gin.HandlerFunc = func(c *gin.Context) {
 		return
 	}
 
-	// First check that the users exist
-	senderUser := userCollection.FindOne(ctx, bson.M{"username": friendReq.Sender})
-	if senderUser.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Friend request sender %s not found", *friendReq.Sender)})
+	var msg string
+	status := http.StatusOK
+	txErr := ctl.Tx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		msg, status, err = ctl.resolveFriendReq(ctx, *friendReq.Sender, *friendReq.Receiver, *friendReq.ReqStatus)
+		return err
+	})
+	if txErr != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": txErr.Error()})
 		return
 	}
-	receiverUser := userCollection.FindOne(ctx, bson.M{"username": friendReq.Receiver})
-	if receiverUser.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Friend request receiver %s not found", *friendReq.Receiver)})
-		return
+
+	c.JSON(http.StatusOK, gin.H{"msg": msg})
+}
+
+// Accepts, declines or blocks a pending friend request, or unfriends, on behalf of its receiver
+func (ctl *Controller) resolveFriendReq(ctx context.Context, senderName string, receiverName string, reqStatus models.RequestStatus) (string, int, error) {
+	// First check that the users exist
+	sender, err := ctl.Users.FindByUsername(ctx, senderName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Friend request sender %s not found", senderName)
+	}
+	receiver, err := ctl.Users.FindByUsername(ctx, receiverName)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("Friend request receiver %s not found", receiverName)
 	}
 
 	// Ensure that the friend request has already been sent; also that there is indeed a sent friend request between them
-	var sender models.User
-	if err := senderUser.Decode(&sender); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 	for _, friendName := range sender.Friends {
-		if friendName == *friendReq.Receiver {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("User %s already in friend list of %s", *friendReq.Receiver, *friendReq.Sender)})
-			return
+		if friendName == receiverName {
+			return "", http.StatusInternalServerError, fmt.Errorf("User %s already in friend list of %s", receiverName, senderName)
 		}
 	}
-	var receiver models.User
-	if err := receiverUser.Decode(&receiver); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 	for _, friendName := range receiver.Friends {
-		if friendName == *friendReq.Sender {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("User %s already in friend list of %s", *friendReq.Sender, *friendReq.Receiver)})
-			return
+		if friendName == senderName {
+			return "", http.StatusInternalServerError, fmt.Errorf("User %s already in friend list of %s", senderName, receiverName)
 		}
 	}
 
 	reqSent := false
 	for _, friendName := range sender.OutgoingFriendReqs {
-		if friendName == *friendReq.Receiver {
+		if friendName == receiverName {
 			reqSent = true
 		}
 	}
 	if !reqSent {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No ongoing request from %s to %s", *friendReq.Sender, *friendReq.Receiver)})
-		return
+		return "", http.StatusBadRequest, fmt.Errorf("No ongoing request from %s to %s", senderName, receiverName)
 	}
 	reqReceived := false
 	for _, friendName := range receiver.IncomingFriendReqs {
-		if friendName == *friendReq.Sender {
+		if friendName == senderName {
 			reqReceived = true
 		}
 	}
 	if !reqReceived {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No ongoing request to %s from %s", *friendReq.Receiver, *friendReq.Sender)})
-		return
+		return "", http.StatusBadRequest, fmt.Errorf("No ongoing request to %s from %s", receiverName, senderName)
 	}
 
-	var msg string
-
-	// TODO: put these into separate functions
-	if *friendReq.ReqStatus == models.Unchanged {
-		msg = fmt.Sprintf("Unchanged friend status between %s and %s", *friendReq.Sender, *friendReq.Receiver)
-	} else if *friendReq.ReqStatus == models.Unfriended {
+	if reqStatus == models.Unchanged {
+		return fmt.Sprintf("Unchanged friend status between %s and %s", senderName, receiverName), http.StatusOK, nil
+	}
+	if reqStatus == models.Unfriended {
 		updateSender := models.UpdateUserHelperStruct{
-			Username:  *friendReq.Sender,
+			Username:  senderName,
 			Operation: "$pullAll",
 			Field:     "friends",
-			Val:       *friendReq.Receiver,
+			Val:       receiverName,
 		}
-		if err := UpdateUserHelper(c, ctx, updateSender); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.Users.UpdateList(ctx, updateSender); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
 		updateReceiver := models.UpdateUserHelperStruct{
-			Username:  *friendReq.Receiver,
+			Username:  receiverName,
 			Operation: "$pullAll",
 			Field:     "friends",
-			Val:       *friendReq.Sender,
+			Val:       senderName,
 		}
-		if err := UpdateUserHelper(c, ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.Users.UpdateList(ctx, updateReceiver); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-		msg = fmt.Sprintf("Unfriended %s and %s", *friendReq.Sender, *friendReq.Receiver)
-	} else {
-		// Otherwise we are just accepting a friend request
-		// First remove from incoming and outgoing lists
+		return fmt.Sprintf("Unfriended %s and %s", senderName, receiverName), http.StatusOK, nil
+	}
+
+	// Otherwise we are answering the friend request
+	// First remove from incoming and outgoing lists
+	for _, field := range []string{"outgoingfriendreqs", "incomingfriendreqs"} {
 		updateSender := models.UpdateUserHelperStruct{
-			Username:  *friendReq.Sender,
+			Username:  senderName,
 			Operation: "$pullAll",
-			Field:     "outgoingfriendreqs",
-			Val:       *friendReq.Receiver,
+			Field:     field,
+			Val:       receiverName,
 		}
-		if err := UpdateUserHelper(c, ctx, updateSender); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.Users.UpdateList(ctx, updateSender); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
 		updateReceiver := models.UpdateUserHelperStruct{
-			Username:  *friendReq.Receiver,
+			Username:  receiverName,
 			Operation: "$pullAll",
-			Field:     "outgoingfriendreqs",
-			Val:       *friendReq.Sender,
+			Field:     field,
+			Val:       senderName,
 		}
-		if err := UpdateUserHelper(c, ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.Users.UpdateList(ctx, updateReceiver); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-		updateSender = models.UpdateUserHelperStruct{
-			Username:  *friendReq.Sender,
-			Operation: "$pullAll",
-			Field:     "incomingfriendreqs",
-			Val:       *friendReq.Receiver,
+	}
+
+	switch reqStatus {
+	case models.Accepted:
+		// Then add to friends
+		updateSender := models.UpdateUserHelperStruct{
+			Username:  senderName,
+			Operation: "$push",
+			Field:     "friends",
+			Val:       receiverName,
 		}
-		if err := UpdateUserHelper(c, ctx, updateSender); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.Users.UpdateList(ctx, updateSender); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-		updateReceiver = models.UpdateUserHelperStruct{
-			Username:  *friendReq.Receiver,
-			Operation: "$pullAll",
-			Field:     "incomingfriendreqs",
-			Val:       *friendReq.Sender,
+		updateReceiver := models.UpdateUserHelperStruct{
+			Username:  receiverName,
+			Operation: "$push",
+			Field:     "friends",
+			Val:       senderName,
 		}
-		if err := UpdateUserHelper(c, ctx, updateReceiver); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err := ctl.Users.UpdateList(ctx, updateReceiver); err != nil {
+			return "", http.StatusInternalServerError, err
 		}
-
-		if *friendReq.ReqStatus == models.Accepted {
-			// Then add to friends
-			updateSender = models.UpdateUserHelperStruct{
-				Username:  *friendReq.Sender,
-				Operation: "$push",
-				Field:     "friends",
-				Val:       *friendReq.Receiver,
-			}
-			if err := UpdateUserHelper(c, ctx, updateSender); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			updateReceiver = models.UpdateUserHelperStruct{
-				Username:  *friendReq.Receiver,
-				Operation: "$push",
-				Field:     "friends",
-				Val:       *friendReq.Sender,
-			}
-			if err := UpdateUserHelper(c, ctx, updateReceiver); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			msg = fmt.Sprintf("Added %s and %s as friends", *friendReq.Sender, *friendReq.Receiver)
-		} else if *friendReq.ReqStatus == models.Declined {
-			msg = fmt.Sprintf("Declined friend request between %s and %s", *friendReq.Sender, *friendReq.Receiver)
+		event := models.Event{
+			Type:     models.FriendRequestAccepted,
+			Audience: []string{senderName, receiverName},
+			Actor:    receiverName,
+			Target:   senderName,
 		}
-
-	}
-
-	c.JSON(http.StatusOK, gin.H{"msg": msg})
-}
-
-// Helper function to transfer a balance from one user to another
-func transferBalance(ctx context.Context, loser models.User, winner models.User, amount int64) error {
-	upsert := true
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	newWinnerBalance := winner.Balances[*loser.Username] + amount
-
-	winnerRes, err := userCollection.UpdateOne(
-		ctx,
-		bson.M{"username": *winner.Username},
-		bson.D{
-			{
-				Key:   "$set",
-				Value: bson.M{fmt.Sprintf("balances.%s", *loser.Username): newWinnerBalance},
-			},
-			{
-				Key:   "$set",
-				Value: bson.M{"totalbalance": winner.TotalBalance + amount},
-			},
-		},
-		&opt,
-	)
-	if err != nil {
-		log.Panic(err)
-		return err
-	}
-	if winnerRes.MatchedCount == 0 {
-		return fmt.Errorf("tried to handle balance for invalid user %s", *winner.Username)
-	}
-
-	newLoserBalance := loser.Balances[*winner.Username] - amount
-	loserRes, err := userCollection.UpdateOne(
-		ctx,
-		bson.M{"username": *loser.Username},
-		bson.D{
-			{
-				Key:   "$set",
-				Value: bson.M{fmt.Sprintf("balances.%s", *winner.Username): newLoserBalance},
-			},
-			{
-				Key:   "$set",
-				Value: bson.M{"totalbalance": loser.TotalBalance - amount},
-			},
-		},
-		&opt,
-	)
-	if err != nil {
-		log.Panic(err)
-		return err
-	}
-	if loserRes.MatchedCount == 0 {
-		return fmt.Errorf("tried to handle balance for invalid user %s", *winner.Username)
+		if err := ctl.Bus.Publish(ctx, event); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		return fmt.Sprintf("Added %s and %s as friends", senderName, receiverName), http.StatusOK, nil
+	case models.Declined:
+		return fmt.Sprintf("Declined friend request between %s and %s", senderName, receiverName), http.StatusOK, nil
+	case models.Blocked:
+		// Declines the request and blocks the sender
+		if status, err := ctl.blockUser(ctx, receiverName, senderName); err != nil {
+			return "", status, err
+		}
+		return fmt.Sprintf("%s blocked %s", receiverName, senderName), http.StatusOK, nil
 	}
-
-	return nil
+	return "", http.StatusOK, nil
 }
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"github.com/simhonchourasia/betfr-be/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxWebhooksPerUser = 10
+	deliveryBatchSize  = 50
+)
+
+// Pass in the URL and the event types to send to it
+// The response includes the signing secret, which is never shown again
+func (ctl *Controller) CreateWebhookFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var hookReq models.WebhookRequest
+
+	if err := c.BindJSON(&hookReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if validationErr := validate.Struct(hookReq); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := webhooks.ValidateURL(hookReq.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	eventTypes := make([]models.EventType, 0, len(hookReq.EventTypes))
+	for _, eventType := range hookReq.EventTypes {
+		if !webhookEventType(eventType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("webhooks cannot subscribe to %q", eventType)})
+			return
+		}
+		if !containsEventType(eventTypes, eventType) {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	if len(eventTypes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "webhooks need at least one event type"})
+		return
+	}
+
+	existing, err := ctl.Webhooks.ListByOwner(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("users can have at most %d webhooks", maxWebhooksPerUser)})
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hook := models.Webhook{
+		ID:         primitive.NewObjectID(),
+		OwnerName:  username,
+		URL:        hookReq.URL,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		CreateDate: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if err := ctl.Webhooks.Insert(ctx, hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Webhook creation unsuccessful"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": hook, "secret": secret})
+}
+
+func (ctl *Controller) ListWebhooksFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	username, err := currentUsername(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	hooks, err := ctl.Webhooks.ListByOwner(ctx, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+// Deliveries still in the outbox for the webhook are given up on by the delivery worker
+func (ctl *Controller) DeleteWebhookFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	hook, status, err := ctl.ownWebhook(c, ctx)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ctl.Webhooks.Delete(ctx, hook.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": fmt.Sprintf("Deleted webhook %s", hook.ID.Hex())})
+}
+
+// The delivery log for a webhook, newest first; pass limit to change how many are returned
+func (ctl *Controller) ListWebhookDeliveriesFunc(c *gin.Context) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	hook, status, err := ctl.ownWebhook(c, ctx)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := defaultPageSize
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+	}
+
+	deliveries, err := ctl.Deliveries.ListByWebhook(ctx, hook.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// Loads the webhook in the :id path parameter, which must belong to the logged in user
+// Other users' webhooks are reported as missing
+func (ctl *Controller) ownWebhook(c *gin.Context, ctx context.Context) (models.Webhook, int, error) {
+	hookID, err := parseIDParam(c)
+	if err != nil {
+		return models.Webhook{}, http.StatusBadRequest, err
+	}
+	username, err := currentUsername(c)
+	if err != nil {
+		return models.Webhook{}, http.StatusUnauthorized, err
+	}
+
+	hook, err := ctl.Webhooks.FindByID(ctx, hookID)
+	if err == database.ErrNotFound || (err == nil && hook.OwnerName != username) {
+		return models.Webhook{}, http.StatusNotFound, fmt.Errorf("webhook ID %s not found", hookID.Hex())
+	} else if err != nil {
+		return models.Webhook{}, http.StatusInternalServerError, err
+	}
+	return hook, http.StatusOK, nil
+}
+
+func webhookEventType(eventType models.EventType) bool {
+	return containsEventType(models.WebhookEventTypes, eventType)
+}
+
+func containsEventType(list []models.EventType, eventType models.EventType) bool {
+	for _, v := range list {
+		if v == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Puts a delivery in the outbox for every webhook that should hear about the event
+// Runs as a bus hook, so the deliveries are written in the same transaction as the event
+func (ctl *Controller) enqueueWebhooks(ctx context.Context, event models.Event) error {
+	if !webhookEventType(event.Type) {
+		return nil
+	}
+	hooks, err := ctl.Webhooks.FindSubscribed(ctx, event.Audience, event.Type)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(hooks))
+	for _, hook := range hooks {
+		delivery := models.WebhookDelivery{
+			ID:          primitive.NewObjectID(),
+			WebhookID:   hook.ID,
+			EventID:     event.ID,
+			EventType:   event.Type,
+			Status:      models.DeliveryPending,
+			Attempts:    make([]models.WebhookAttempt, 0),
+			NextAttempt: event.CreateDate,
+			CreateDate:  event.CreateDate,
+		}
+		payload, err := json.Marshal(models.WebhookPayload{
+			DeliveryID: delivery.ID,
+			WebhookID:  hook.ID,
+			Username:   hook.OwnerName,
+			Event:      event,
+		})
+		if err != nil {
+			return err
+		}
+		delivery.Payload = string(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	return ctl.Deliveries.Insert(ctx, deliveries...)
+}
+
+// Makes one attempt at every delivery in the outbox that is due
+// Failed attempts are retried with exponential backoff until webhooks.MaxAttempts is reached
+// Returns the number of deliveries that succeeded
+func (ctl *Controller) DeliverWebhooks(ctx context.Context, now time.Time) (int, error) {
+	due, err := ctl.Deliveries.FindDue(ctx, now, deliveryBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, delivery := range due {
+		ok, err := ctl.deliverWebhook(ctx, delivery, now)
+		if err != nil {
+			log.Printf("Could not record webhook delivery %s: %v\n", delivery.ID.Hex(), err)
+			continue
+		}
+		if ok {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// Sends one attempt of the delivery and records the outcome in the delivery log
+func (ctl *Controller) deliverWebhook(ctx context.Context, delivery models.WebhookDelivery, now time.Time) (bool, error) {
+	attempt := models.WebhookAttempt{AttemptDate: primitive.NewDateTimeFromTime(now)}
+
+	hook, err := ctl.Webhooks.FindByID(ctx, delivery.WebhookID)
+	if err == database.ErrNotFound {
+		attempt.Error = "webhook was deleted"
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = models.DeliveryFailed
+		return false, ctl.Deliveries.Replace(ctx, delivery)
+	} else if err != nil {
+		return false, err
+	}
+
+	statusCode, sendErr := webhooks.Send(ctx, hook.URL, hook.Secret, delivery.ID.Hex(), string(delivery.EventType), []byte(delivery.Payload), now)
+	attempt.StatusCode = statusCode
+	if sendErr == nil {
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Status = models.DeliverySucceeded
+		return true, ctl.Deliveries.Replace(ctx, delivery)
+	}
+
+	attempt.Error = sendErr.Error()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if len(delivery.Attempts) >= webhooks.MaxAttempts {
+		delivery.Status = models.DeliveryFailed
+	} else {
+		delivery.NextAttempt = primitive.NewDateTimeFromTime(now.Add(webhooks.Backoff(len(delivery.Attempts))))
+	}
+	return false, ctl.Deliveries.Replace(ctx, delivery)
+}
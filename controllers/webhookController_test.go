@@ -0,0 +1,197 @@
+package controllers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"github.com/simhonchourasia/betfr-be/webhooks"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A webhook endpoint that answers with status and records what it was sent
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+// Has alice subscribe url to new bet requests, then sends bob one so a single delivery is queued
+// Returns the webhook's ID and secret
+func (h *harness) queueDelivery(url string) (primitive.ObjectID, string) {
+	h.t.Helper()
+	h.signup("alice", "bob")
+	h.befriend("alice", "bob")
+	out := h.must(http.StatusOK, "alice", "POST", "/webhooks", map[string]interface{}{"url": url, "eventtypes": []models.EventType{models.BetRequestCreated}})
+	hookID := h.objectID(out["webhook"].(map[string]interface{})["id"])
+	h.must(http.StatusOK, "alice", "POST", "/bets/createbetreq", map[string]interface{}{
+		"creatorname": "alice", "receivername": "bob", "title": "test bet",
+		"creatoramount": 10, "receiveramount": 3, "numshares": 10, "expirydate": time.Now().Add(time.Hour),
+	})
+	if len(h.deliveries(hookID)) != 1 {
+		h.t.Fatal("no delivery was queued")
+	}
+	return hookID, out["secret"].(string)
+}
+
+func (h *harness) deliveries(hookID primitive.ObjectID) []models.WebhookDelivery {
+	h.t.Helper()
+	deliveries, err := h.ctl.Deliveries.ListByWebhook(context.Background(), hookID, 0)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return deliveries
+}
+
+func (h *harness) deliverWebhooks(now time.Time) int {
+	h.t.Helper()
+	delivered, err := h.ctl.DeliverWebhooks(context.Background(), now)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return delivered
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	h := newHarness(t)
+	recv := newReceiver(t, http.StatusInternalServerError)
+	hookID, secret := h.queueDelivery(recv.URL)
+
+	now := time.Now()
+	for failed := 1; failed <= 3; failed++ {
+		if delivered := h.deliverWebhooks(now); delivered != 0 {
+			t.Fatalf("attempt %d: %d deliveries succeeded against a failing receiver", failed, delivered)
+		}
+		delivery := h.deliveries(hookID)[0]
+		if delivery.Status != models.DeliveryPending || len(delivery.Attempts) != failed {
+			t.Fatalf("attempt %d: status %d with %d attempts", failed, delivery.Status, len(delivery.Attempts))
+		}
+		if code := delivery.Attempts[failed-1].StatusCode; code != http.StatusInternalServerError {
+			t.Fatalf("attempt %d recorded status %d", failed, code)
+		}
+		want := now.Add(webhooks.Backoff(failed))
+		if delivery.NextAttempt.Time().Unix() != want.Unix() {
+			t.Fatalf("attempt %d: next attempt at %v, want %v", failed, delivery.NextAttempt.Time(), want)
+		}
+
+		// Nothing is sent again before the backoff is up
+		if h.deliverWebhooks(want.Add(-time.Second)); len(recv.received()) != failed {
+			t.Fatalf("attempt %d: delivery was retried before its backoff ran out", failed)
+		}
+		now = want
+	}
+
+	recv.setStatus(http.StatusOK)
+	if delivered := h.deliverWebhooks(now); delivered != 1 {
+		t.Fatalf("%d deliveries succeeded once the receiver recovered, want 1", delivered)
+	}
+	delivery := h.deliveries(hookID)[0]
+	if delivery.Status != models.DeliverySucceeded || len(delivery.Attempts) != 4 {
+		t.Fatalf("status %d with %d attempts, want succeeded after 4", delivery.Status, len(delivery.Attempts))
+	}
+	if h.deliverWebhooks(now.Add(24 * time.Hour)); len(recv.received()) != 4 {
+		t.Fatal("a delivered webhook was sent again")
+	}
+
+	// Every attempt carries the same delivery id and body, each signed with the webhook's secret
+	requests := recv.received()
+	for i, req := range requests {
+		if id := req.header.Get(webhooks.DeliveryHeader); id != delivery.ID.Hex() {
+			t.Errorf("attempt %d has delivery id %q, want %q", i+1, id, delivery.ID.Hex())
+		}
+		if string(req.body) != delivery.Payload {
+			t.Errorf("attempt %d sent a different body", i+1)
+		}
+		if req.header.Get(webhooks.EventHeader) != string(models.BetRequestCreated) {
+			t.Errorf("attempt %d has event %q", i+1, req.header.Get(webhooks.EventHeader))
+		}
+		timestamp, _ := strconv.ParseInt(req.header.Get(webhooks.TimestampHeader), 10, 64)
+		if !webhooks.Verify(secret, timestamp, req.body, req.header.Get(webhooks.SignatureHeader)) {
+			t.Errorf("attempt %d did not verify", i+1)
+		}
+	}
+}
+
+func TestWebhookGivesUpAtMaxAttempts(t *testing.T) {
+	h := newHarness(t)
+	recv := newReceiver(t, http.StatusServiceUnavailable)
+	hookID, _ := h.queueDelivery(recv.URL)
+
+	now := time.Now()
+	for i := 0; i < webhooks.MaxAttempts; i++ {
+		h.deliverWebhooks(now)
+		now = now.Add(webhooks.Backoff(i + 1))
+	}
+	delivery := h.deliveries(hookID)[0]
+	if delivery.Status != models.DeliveryFailed {
+		t.Fatalf("status %d after %d failed attempts, want failed", delivery.Status, webhooks.MaxAttempts)
+	}
+	if len(delivery.Attempts) != webhooks.MaxAttempts {
+		t.Fatalf("%d attempts recorded, want %d", len(delivery.Attempts), webhooks.MaxAttempts)
+	}
+
+	// A failed delivery stays out of the outbox even if the receiver recovers
+	recv.setStatus(http.StatusOK)
+	h.deliverWebhooks(now.Add(24 * time.Hour))
+	if got := len(recv.received()); got != webhooks.MaxAttempts {
+		t.Fatalf("receiver was called %d times, want %d", got, webhooks.MaxAttempts)
+	}
+}
+
+func TestDeletedWebhookFailsDelivery(t *testing.T) {
+	h := newHarness(t)
+	recv := newReceiver(t, http.StatusOK)
+	hookID, _ := h.queueDelivery(recv.URL)
+
+	h.must(http.StatusOK, "alice", "DELETE", "/webhooks/"+hookID.Hex(), nil)
+	if delivered := h.deliverWebhooks(time.Now()); delivered != 0 {
+		t.Fatalf("%d deliveries succeeded for a deleted webhook", delivered)
+	}
+	delivery := h.deliveries(hookID)[0]
+	if delivery.Status != models.DeliveryFailed || len(delivery.Attempts) != 1 || delivery.Attempts[0].Error == "" {
+		t.Fatalf("delivery for a deleted webhook: %+v", delivery)
+	}
+	if got := len(recv.received()); got != 0 {
+		t.Fatalf("receiver of a deleted webhook was called %d times", got)
+	}
+	if h.deliverWebhooks(time.Now().Add(24 * time.Hour)); len(h.deliveries(hookID)[0].Attempts) != 1 {
+		t.Fatal("delivery for a deleted webhook was attempted again")
+	}
+}
@@ -0,0 +1,179 @@
+// Package cron works out when recurring schedules fire
+// It understands the usual five-field cron expressions and simple weekly times, and has no storage dependencies
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Next gives up looking after this long, so expressions that can never match (like the 31st of February) end
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+// The minutes, hours, days of the month, months and weekdays a schedule fires on, one bit per allowed value
+type Schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// Like most crons, when both the day of the month and the weekday are restricted, either one matching is enough
+	anyDay bool
+}
+
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are both Sunday
+}
+
+// Parses "minute hour day-of-month month day-of-week", where each field is *, a number, a range a-b,
+// any of those with a /step, or a comma-separated list of them
+// e.g. "0 19 * * 5" is every Friday at 19:00
+func Parse(expr string) (Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("cron expression needs %d fields, got %d", len(fields), len(parts))
+	}
+	bits := make([]uint64, len(fields))
+	for i, part := range parts {
+		var err error
+		if bits[i], err = parseField(part, fields[i]); err != nil {
+			return Schedule{}, err
+		}
+	}
+	weekdays := bits[4]
+	if weekdays&(1<<7) != 0 {
+		weekdays = weekdays&^(1<<7) | 1
+	}
+	return Schedule{
+		minutes:  bits[0],
+		hours:    bits[1],
+		days:     bits[2],
+		months:   bits[3],
+		weekdays: weekdays,
+		anyDay:   parts[2] != "*" && parts[4] != "*",
+	}, nil
+}
+
+// Fires once a week, on the given day at hour:minute
+func Weekly(day time.Weekday, hour int, minute int) (Schedule, error) {
+	if day < time.Sunday || day > time.Saturday {
+		return Schedule{}, fmt.Errorf("invalid weekday %d", day)
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return Schedule{}, fmt.Errorf("invalid time %02d:%02d", hour, minute)
+	}
+	return Schedule{
+		minutes:  1 << uint(minute),
+		hours:    1 << uint(hour),
+		days:     span(1, 31),
+		months:   span(1, 12),
+		weekdays: 1 << uint(day),
+	}, nil
+}
+
+// The first time strictly after the given one that the schedule fires, in after's location
+// Wall clock times skipped by a daylight saving change do not fire
+// Returns the zero time if it does not fire in the next five years
+func (s Schedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := after.Add(searchLimit)
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// A wall clock time skipped by a daylight saving change can come back from time.Date as an earlier instant,
+// so this makes sure the search always moves on
+func forward(from time.Time, to time.Time) time.Time {
+	if to.After(from) {
+		return to
+	}
+	return from.Add(time.Hour)
+}
+
+func (s Schedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+func parseField(part string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", f.name, part)
+			}
+			rangePart, step = item[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s field %q", f.name, part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s field %q", f.name, part)
+				}
+			} else if step > 1 {
+				// a/n runs from a to the end of the field
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q is out of range %d-%d", f.name, part, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Every value from lo to hi
+func span(lo int, hi int) uint64 {
+	var bits uint64
+	for v := lo; v <= hi; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
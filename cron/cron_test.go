@@ -0,0 +1,92 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	base := time.Date(2026, 10, 16, 18, 30, 0, 0, time.UTC) // a Friday
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"0 19 * * 5", "2026-10-16T19:00:00Z"},
+		{"30 18 * * 5", "2026-10-23T18:30:00Z"}, // strictly after, so not base itself
+		{"*/15 * * * *", "2026-10-16T18:45:00Z"},
+		{"0 0 1 * *", "2026-11-01T00:00:00Z"},
+		{"0 0 29 2 *", "2028-02-29T00:00:00Z"},
+		{"0 9 1 * 1", "2026-10-19T09:00:00Z"}, // day of month or weekday, as in standard cron
+		{"0 9 * * 7", "2026-10-18T09:00:00Z"}, // 7 is Sunday too
+		{"5-10/5 2,4 * 1-3 *", "2027-01-01T02:05:00Z"},
+		{"0 0 31 2 *", "0001-01-01T00:00:00Z"}, // never fires
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := s.Next(base).Format(time.RFC3339); got != tt.want {
+			t.Errorf("%q: next after %v is %s, want %s", tt.expr, base, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "a * * * *", "5-1 * * * *", "1,,2 * * * *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) allowed", expr)
+		}
+	}
+}
+
+func TestWeekly(t *testing.T) {
+	s, err := Weekly(time.Friday, 19, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2026, 10, 16, 18, 30, 0, 0, time.UTC)
+	if got, want := s.Next(base), time.Date(2026, 10, 16, 19, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next after %v is %v, want %v", base, got, want)
+	}
+	if got, want := s.Next(base.Add(time.Hour)), time.Date(2026, 10, 23, 19, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next after %v is %v, want %v", base.Add(time.Hour), got, want)
+	}
+	for _, bad := range [][3]int{{7, 0, 0}, {-1, 0, 0}, {5, 24, 0}, {5, 0, 60}} {
+		if _, err := Weekly(time.Weekday(bad[0]), bad[1], bad[2]); err == nil {
+			t.Errorf("Weekly(%v) allowed", bad)
+		}
+	}
+}
+
+func TestNextInTimeZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"wall clock time", "0 9 * * *", time.Date(2027, 1, 4, 12, 0, 0, 0, newYork), time.Date(2027, 1, 5, 9, 0, 0, 0, newYork)},
+		// 02:30 does not exist on 14 March 2027 in New York, so that day is skipped
+		{"skipped by daylight saving", "30 2 * * *", time.Date(2027, 3, 13, 12, 0, 0, 0, newYork), time.Date(2027, 3, 15, 2, 30, 0, 0, newYork)},
+		{"half hour offset", "0 * * * *", time.Date(2027, 3, 13, 12, 10, 0, 0, kolkata), time.Date(2027, 3, 13, 13, 0, 0, 0, kolkata)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := s.Next(tt.after)
+		if !got.Equal(tt.want) || got.Location() != tt.after.Location() {
+			t.Errorf("%s: next after %v is %v, want %v", tt.name, tt.after, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/simhonchourasia/betfr-be/config"
@@ -39,7 +40,16 @@ func GetDBInstance() *mongo.Client {
 	return client
 }
 
-var Client *mongo.Client = GetDBInstance()
+var clientOnce sync.Once
+var client *mongo.Client
+
+// Connects on first use rather than at import time, so that the in-memory stores never need Mongo
+func GetClient() *mongo.Client {
+	clientOnce.Do(func() {
+		client = GetDBInstance()
+	})
+	return client
+}
 
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
 	return client.Database(config.GlobalConfig.Cluster).Collection(collectionName)
@@ -0,0 +1,1389 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Shared state behind the in-memory stores; one lock guards everything
+type memoryDB struct {
+	mu            sync.RWMutex
+	txMu          sync.Mutex             // serializes transactions
+	users         map[string]models.User // keyed by username
+	bets          map[primitive.ObjectID]models.Bet
+	stakes        map[primitive.ObjectID]models.Stake
+	ledger        []models.LedgerEntry
+	events        []models.Event
+	eventSeq      int64 // Seq of the last event appended
+	webhooks      map[primitive.ObjectID]models.Webhook
+	deliveries    map[primitive.ObjectID]models.WebhookDelivery
+	notifications map[primitive.ObjectID]models.Notification
+	settlements   map[primitive.ObjectID]models.Settlement
+	pools         map[primitive.ObjectID]models.Pool
+	templates     map[primitive.ObjectID]models.BetTemplate
+	schedules     map[primitive.ObjectID]models.BetSchedule
+}
+
+type memoryUserStore struct {
+	db *memoryDB
+}
+
+type memoryBetStore struct {
+	db *memoryDB
+}
+
+type memoryStakeStore struct {
+	db *memoryDB
+}
+
+type memoryLedgerStore struct {
+	db *memoryDB
+}
+
+type memoryEventStore struct {
+	db *memoryDB
+}
+
+type memoryWebhookStore struct {
+	db *memoryDB
+}
+
+type memoryDeliveryStore struct {
+	db *memoryDB
+}
+
+type memoryNotificationStore struct {
+	db *memoryDB
+}
+
+type memorySettlementStore struct {
+	db *memoryDB
+}
+
+type memoryPoolStore struct {
+	db *memoryDB
+}
+
+type memoryTemplateStore struct {
+	db *memoryDB
+}
+
+type memoryScheduleStore struct {
+	db *memoryDB
+}
+
+type memoryTransactor struct {
+	db *memoryDB
+}
+
+type memoryTxKey struct{}
+
+// Writes held back until the transaction in the context commits
+type memoryTx struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+// Stores that keep everything in process memory; safe for concurrent use
+func NewMemoryStores() Stores {
+	db := &memoryDB{
+		users:         make(map[string]models.User),
+		bets:          make(map[primitive.ObjectID]models.Bet),
+		stakes:        make(map[primitive.ObjectID]models.Stake),
+		webhooks:      make(map[primitive.ObjectID]models.Webhook),
+		deliveries:    make(map[primitive.ObjectID]models.WebhookDelivery),
+		notifications: make(map[primitive.ObjectID]models.Notification),
+		settlements:   make(map[primitive.ObjectID]models.Settlement),
+		pools:         make(map[primitive.ObjectID]models.Pool),
+		templates:     make(map[primitive.ObjectID]models.BetTemplate),
+		schedules:     make(map[primitive.ObjectID]models.BetSchedule),
+	}
+	return Stores{
+		Users:         &memoryUserStore{db: db},
+		Bets:          &memoryBetStore{db: db},
+		Stakes:        &memoryStakeStore{db: db},
+		Ledger:        &memoryLedgerStore{db: db},
+		Events:        &memoryEventStore{db: db},
+		Webhooks:      &memoryWebhookStore{db: db},
+		Deliveries:    &memoryDeliveryStore{db: db},
+		Notifications: &memoryNotificationStore{db: db},
+		Settlements:   &memorySettlementStore{db: db},
+		Pools:         &memoryPoolStore{db: db},
+		Templates:     &memoryTemplateStore{db: db},
+		Schedules:     &memoryScheduleStore{db: db},
+		Tx:            &memoryTransactor{db: db},
+	}
+}
+
+// Snapshots all state before running fn and restores it if fn fails
+// Only other transactions are excluded while fn runs, so a failed transaction also rolls back
+// any non-transactional writes that happened during it
+// Events are the exception: they are held back until fn returns, so readers of the log never see
+// an event that is later rolled back
+func (t *memoryTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	t.db.txMu.Lock()
+	defer t.db.txMu.Unlock()
+
+	t.db.mu.RLock()
+	users := make(map[string]models.User, len(t.db.users))
+	for k, v := range t.db.users {
+		users[k] = cloneUser(v)
+	}
+	bets := make(map[primitive.ObjectID]models.Bet, len(t.db.bets))
+	for k, v := range t.db.bets {
+		bets[k] = cloneBet(v)
+	}
+	stakes := make(map[primitive.ObjectID]models.Stake, len(t.db.stakes))
+	for k, v := range t.db.stakes {
+		stakes[k] = v
+	}
+	webhooks := make(map[primitive.ObjectID]models.Webhook, len(t.db.webhooks))
+	for k, v := range t.db.webhooks {
+		webhooks[k] = cloneWebhook(v)
+	}
+	deliveries := make(map[primitive.ObjectID]models.WebhookDelivery, len(t.db.deliveries))
+	for k, v := range t.db.deliveries {
+		deliveries[k] = cloneDelivery(v)
+	}
+	notifications := make(map[primitive.ObjectID]models.Notification, len(t.db.notifications))
+	for k, v := range t.db.notifications {
+		notifications[k] = v
+	}
+	settlements := make(map[primitive.ObjectID]models.Settlement, len(t.db.settlements))
+	for k, v := range t.db.settlements {
+		settlements[k] = v
+	}
+	pools := make(map[primitive.ObjectID]models.Pool, len(t.db.pools))
+	for k, v := range t.db.pools {
+		pools[k] = clonePool(v)
+	}
+	templates := make(map[primitive.ObjectID]models.BetTemplate, len(t.db.templates))
+	for k, v := range t.db.templates {
+		templates[k] = v
+	}
+	schedules := make(map[primitive.ObjectID]models.BetSchedule, len(t.db.schedules))
+	for k, v := range t.db.schedules {
+		schedules[k] = cloneSchedule(v)
+	}
+	ledgerLen := len(t.db.ledger)
+	t.db.mu.RUnlock()
+
+	tx := &memoryTx{}
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, tx)); err != nil {
+		t.db.mu.Lock()
+		t.db.users = users
+		t.db.bets = bets
+		t.db.stakes = stakes
+		t.db.ledger = t.db.ledger[:ledgerLen]
+		t.db.webhooks = webhooks
+		t.db.deliveries = deliveries
+		t.db.notifications = notifications
+		t.db.settlements = settlements
+		t.db.pools = pools
+		t.db.templates = templates
+		t.db.schedules = schedules
+		t.db.mu.Unlock()
+		return err
+	}
+
+	t.db.mu.Lock()
+	t.db.appendEvents(tx.events)
+	t.db.mu.Unlock()
+	return nil
+}
+
+func cloneEvent(event models.Event) models.Event {
+	event.Audience = append([]string(nil), event.Audience...)
+	if event.BetID != nil {
+		betID := *event.BetID
+		event.BetID = &betID
+	}
+	if event.StakeID != nil {
+		stakeID := *event.StakeID
+		event.StakeID = &stakeID
+	}
+	if event.SettlementID != nil {
+		settlementID := *event.SettlementID
+		event.SettlementID = &settlementID
+	}
+	if event.PoolID != nil {
+		poolID := *event.PoolID
+		event.PoolID = &poolID
+	}
+	return event
+}
+
+func cloneWebhook(hook models.Webhook) models.Webhook {
+	hook.EventTypes = append([]models.EventType(nil), hook.EventTypes...)
+	return hook
+}
+
+func clonePool(pool models.Pool) models.Pool {
+	pool.Outcomes = append([]string(nil), pool.Outcomes...)
+	pool.Entries = append([]models.PoolEntry(nil), pool.Entries...)
+	pool.Votes = append([]models.PoolVote(nil), pool.Votes...)
+	pool.Transfers = append([]models.Debt(nil), pool.Transfers...)
+	if pool.WinningOutcome != nil {
+		winningOutcome := *pool.WinningOutcome
+		pool.WinningOutcome = &winningOutcome
+	}
+	return pool
+}
+
+func cloneSchedule(schedule models.BetSchedule) models.BetSchedule {
+	if schedule.LastBetID != nil {
+		lastBetID := *schedule.LastBetID
+		schedule.LastBetID = &lastBetID
+	}
+	return schedule
+}
+
+func cloneDelivery(delivery models.WebhookDelivery) models.WebhookDelivery {
+	delivery.Attempts = append([]models.WebhookAttempt(nil), delivery.Attempts...)
+	return delivery
+}
+
+// Copies the slices and maps so callers never share memory with the store
+func cloneUser(user models.User) models.User {
+	user.OutgoingFriendReqs = append([]string(nil), user.OutgoingFriendReqs...)
+	user.IncomingFriendReqs = append([]string(nil), user.IncomingFriendReqs...)
+	user.BlockedUsers = append([]string(nil), user.BlockedUsers...)
+	user.Friends = append([]string(nil), user.Friends...)
+	user.IncomingBetReqs = append([]primitive.ObjectID(nil), user.IncomingBetReqs...)
+	user.OutgoingBetReqs = append([]primitive.ObjectID(nil), user.OutgoingBetReqs...)
+	user.ResolvedBets = append([]primitive.ObjectID(nil), user.ResolvedBets...)
+	user.ConflictedBets = append([]primitive.ObjectID(nil), user.ConflictedBets...)
+	user.OngoingBets = append([]primitive.ObjectID(nil), user.OngoingBets...)
+	user.ResolvedStakes = append([]primitive.ObjectID(nil), user.ResolvedStakes...)
+	user.OngoingStakes = append([]primitive.ObjectID(nil), user.OngoingStakes...)
+	user.Sessions = append([]models.Session(nil), user.Sessions...)
+	balances := make(map[string]int64, len(user.Balances))
+	for k, v := range user.Balances {
+		balances[k] = v
+	}
+	user.Balances = balances
+	return user
+}
+
+func cloneBet(bet models.Bet) models.Bet {
+	bet.CreatorStakes = append([]primitive.ObjectID(nil), bet.CreatorStakes...)
+	bet.ReceiverStakes = append([]primitive.ObjectID(nil), bet.ReceiverStakes...)
+	bet.Negotiation = append([]models.BetTerms(nil), bet.Negotiation...)
+	bet.Legs = append([]models.BetLeg(nil), bet.Legs...)
+	return bet
+}
+
+// Returns a pointer to the list field of user named by field
+func stringListField(user *models.User, field string) *[]string {
+	switch field {
+	case "outgoingfriendreqs":
+		return &user.OutgoingFriendReqs
+	case "incomingfriendreqs":
+		return &user.IncomingFriendReqs
+	case "blockedusers":
+		return &user.BlockedUsers
+	case "friends":
+		return &user.Friends
+	}
+	return nil
+}
+
+func idListField(user *models.User, field string) *[]primitive.ObjectID {
+	switch field {
+	case "incomingbetreqs":
+		return &user.IncomingBetReqs
+	case "outgoingbetreqs":
+		return &user.OutgoingBetReqs
+	case "resolvedbets":
+		return &user.ResolvedBets
+	case "conflictedbets":
+		return &user.ConflictedBets
+	case "ongoingbets":
+		return &user.OngoingBets
+	case "resolvedstakes":
+		return &user.ResolvedStakes
+	case "ongoingstakes":
+		return &user.OngoingStakes
+	}
+	return nil
+}
+
+func (s *memoryUserStore) FindByUsername(ctx context.Context, username string) (models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (s *memoryUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, user := range s.db.users {
+		if user.Email != nil && *user.Email == email {
+			return cloneUser(user), nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (s *memoryUserStore) CountByUsername(ctx context.Context, username string) (int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if _, ok := s.db.users[username]; ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (s *memoryUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var count int64
+	for _, user := range s.db.users {
+		if user.Email != nil && *user.Email == email {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryUserStore) Insert(ctx context.Context, user models.User) error {
+	if user.Username == nil {
+		return fmt.Errorf("cannot insert user without a username")
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.users[*user.Username]; ok {
+		return fmt.Errorf("user %s already exists", *user.Username)
+	}
+	s.db.users[*user.Username] = cloneUser(user)
+	return nil
+}
+
+func (s *memoryUserStore) Tombstone(ctx context.Context, username string, deleteDate time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return fmt.Errorf("tried to delete invalid user %s", username)
+	}
+	s.db.users[username] = models.User{
+		ID:                 user.ID,
+		Username:           user.Username,
+		OutgoingFriendReqs: make([]string, 0),
+		IncomingFriendReqs: make([]string, 0),
+		BlockedUsers:       make([]string, 0),
+		Friends:            make([]string, 0),
+		IncomingBetReqs:    make([]primitive.ObjectID, 0),
+		OutgoingBetReqs:    make([]primitive.ObjectID, 0),
+		ResolvedBets:       user.ResolvedBets,
+		ConflictedBets:     make([]primitive.ObjectID, 0),
+		OngoingBets:        make([]primitive.ObjectID, 0),
+		ResolvedStakes:     user.ResolvedStakes,
+		OngoingStakes:      make([]primitive.ObjectID, 0),
+		Balances:           make(map[string]int64),
+		NumBets:            user.NumBets,
+		Deleted:            true,
+		DeleteDate:         primitive.NewDateTimeFromTime(deleteDate),
+	}
+	return nil
+}
+
+func (s *memoryUserStore) PullFromAllLists(ctx context.Context, username string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for name, user := range s.db.users {
+		for field := range stringListFields {
+			list := stringListField(&user, field)
+			kept := make([]string, 0, len(*list))
+			for _, v := range *list {
+				if v != username {
+					kept = append(kept, v)
+				}
+			}
+			*list = kept
+		}
+		s.db.users[name] = user
+	}
+	return nil
+}
+
+func (s *memoryUserStore) Search(ctx context.Context, prefix string, viewer string, limit int) ([]models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	users := make([]models.User, 0)
+	for name, user := range s.db.users {
+		if !strings.HasPrefix(name, prefix) || user.Deleted {
+			continue
+		}
+		blocked := false
+		for _, v := range user.BlockedUsers {
+			if v == viewer {
+				blocked = true
+			}
+		}
+		if !blocked {
+			users = append(users, cloneUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return *users[i].Username < *users[j].Username
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) IncrementNumBets(ctx context.Context, username string) (models.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	before := cloneUser(user)
+	user.NumBets++
+	s.db.users[username] = user
+	return before, nil
+}
+
+func (s *memoryUserStore) UpdateList(ctx context.Context, update models.UpdateUserHelperStruct) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[update.Username]
+	if !ok {
+		return fmt.Errorf("tried to update invalid user %s", update.Username)
+	}
+	user = cloneUser(user)
+
+	if list := stringListField(&user, update.Field); list != nil {
+		switch update.Operation {
+		case "$push":
+			*list = append(*list, update.Val)
+		case "$pullAll":
+			kept := make([]string, 0, len(*list))
+			for _, v := range *list {
+				if v != update.Val {
+					kept = append(kept, v)
+				}
+			}
+			*list = kept
+		default:
+			return fmt.Errorf("unsupported list operation %s", update.Operation)
+		}
+	} else if list := idListField(&user, update.Field); list != nil {
+		switch update.Operation {
+		case "$push":
+			*list = append(*list, update.IdVal)
+		case "$pullAll":
+			kept := make([]primitive.ObjectID, 0, len(*list))
+			for _, v := range *list {
+				if v != update.IdVal {
+					kept = append(kept, v)
+				}
+			}
+			*list = kept
+		default:
+			return fmt.Errorf("unsupported list operation %s", update.Operation)
+		}
+	} else {
+		return fmt.Errorf("unknown user list field %s", update.Field)
+	}
+
+	s.db.users[update.Username] = user
+	return nil
+}
+
+func (s *memoryUserStore) AddSession(ctx context.Context, username string, session models.Session, limit int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return fmt.Errorf("tried to update tokens for invalid user %s", username)
+	}
+	user.Sessions = append(append([]models.Session(nil), user.Sessions...), session)
+	if len(user.Sessions) > limit {
+		user.Sessions = user.Sessions[len(user.Sessions)-limit:]
+	}
+	s.db.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) RotateSession(ctx context.Context, username string, family string, oldRefreshToken string, refreshToken string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return false, fmt.Errorf("tried to update tokens for invalid user %s", username)
+	}
+	user.Sessions = append([]models.Session(nil), user.Sessions...)
+	for i, session := range user.Sessions {
+		if session.Family == family && session.RefreshToken == oldRefreshToken {
+			user.Sessions[i].RefreshToken = refreshToken
+			s.db.users[username] = user
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryUserStore) RevokeSessions(ctx context.Context, username string, family string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return fmt.Errorf("tried to update tokens for invalid user %s", username)
+	}
+	sessions := make([]models.Session, 0, len(user.Sessions))
+	for _, session := range user.Sessions {
+		if family != "" && session.Family != family {
+			sessions = append(sessions, session)
+		}
+	}
+	user.Sessions = sessions
+	s.db.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) SetBalances(ctx context.Context, username string, balances map[string]int64, totalBalance int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return fmt.Errorf("tried to handle balance for invalid user %s", username)
+	}
+	user.Balances = balances
+	user.TotalBalance = totalBalance
+	s.db.users[username] = cloneUser(user)
+	return nil
+}
+
+func (s *memoryUserStore) SetNotificationPrefs(ctx context.Context, username string, prefs models.NotificationPrefs) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return fmt.Errorf("tried to update notification preferences for invalid user %s", username)
+	}
+	user.NotificationPrefs = prefs
+	s.db.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) SetLastDigestDate(ctx context.Context, username string, date time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	user, ok := s.db.users[username]
+	if !ok {
+		return fmt.Errorf("tried to update digest date for invalid user %s", username)
+	}
+	user.LastDigestDate = primitive.NewDateTimeFromTime(date)
+	s.db.users[username] = user
+	return nil
+}
+
+func (s *memoryUserStore) FindDigestDue(ctx context.Context, before time.Time, limit int) ([]models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	cutoff := primitive.NewDateTimeFromTime(before)
+	users := make([]models.User, 0)
+	for _, user := range s.db.users {
+		if !user.Deleted && user.NotificationPrefs.WantsDigest() && user.LastDigestDate < cutoff {
+			users = append(users, cloneUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].LastDigestDate < users[j].LastDigestDate
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *memoryLedgerStore) Append(ctx context.Context, entries ...models.LedgerEntry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.ledger = append(s.db.ledger, entries...)
+	return nil
+}
+
+func (s *memoryLedgerStore) ListByUsername(ctx context.Context, username string) ([]models.LedgerEntry, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	entries := make([]models.LedgerEntry, 0)
+	for _, entry := range s.db.ledger {
+		if entry.Username == username {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryLedgerStore) List(ctx context.Context, username string, page PageRequest) ([]models.LedgerEntry, error) {
+	if err := page.validate(SortByCreateDate); err != nil {
+		return nil, err
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	entries := make([]models.LedgerEntry, 0)
+	for _, entry := range s.db.ledger {
+		if entry.Username != username {
+			continue
+		}
+		if page.After != nil && page.compare(entry.CreateDate, entry.ID, page.After.SortValue, page.After.ID) <= 0 {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return page.compare(entries[i].CreateDate, entries[i].ID, entries[j].CreateDate, entries[j].ID) < 0
+	})
+	if len(entries) > page.Limit {
+		entries = entries[:page.Limit]
+	}
+	return entries, nil
+}
+
+// Inside a transaction the events are only numbered and added to the log once it commits
+func (s *memoryEventStore) Append(ctx context.Context, events ...models.Event) error {
+	if tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		for _, event := range events {
+			tx.events = append(tx.events, cloneEvent(event))
+		}
+		return nil
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.appendEvents(events)
+	return nil
+}
+
+// Caller must hold db.mu
+func (db *memoryDB) appendEvents(events []models.Event) {
+	for _, event := range events {
+		db.eventSeq++
+		event = cloneEvent(event)
+		event.Seq = db.eventSeq
+		db.events = append(db.events, event)
+	}
+}
+
+func (s *memoryEventStore) ListAfter(ctx context.Context, username string, after int64, limit int) ([]models.Event, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	events := make([]models.Event, 0)
+	for _, event := range s.db.events {
+		if event.Seq <= after {
+			continue
+		}
+		for _, v := range event.Audience {
+			if v == username {
+				events = append(events, cloneEvent(event))
+				break
+			}
+		}
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (s *memoryBetStore) Insert(ctx context.Context, bet models.Bet) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.bets[bet.ID]; ok {
+		return fmt.Errorf("bet %s already exists", bet.ID.Hex())
+	}
+	s.db.bets[bet.ID] = cloneBet(bet)
+	return nil
+}
+
+func (s *memoryBetStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Bet, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	bet, ok := s.db.bets[id]
+	if !ok {
+		return models.Bet{}, ErrNotFound
+	}
+	return cloneBet(bet), nil
+}
+
+func (s *memoryBetStore) FindByBetID(ctx context.Context, betID string) (models.Bet, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	for _, bet := range s.db.bets {
+		if bet.BetID != nil && *bet.BetID == betID {
+			return cloneBet(bet), nil
+		}
+	}
+	return models.Bet{}, ErrNotFound
+}
+
+func (s *memoryBetStore) FindDerived(ctx context.Context, betID string) ([]models.Bet, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	bets := make([]models.Bet, 0)
+	for _, bet := range s.db.bets {
+		open := bet.OverallStatus == models.Undecided || bet.OverallStatus == models.Conflicted
+		if open && bet.DerivedFrom(betID) {
+			bets = append(bets, cloneBet(bet))
+		}
+	}
+	sort.Slice(bets, func(i, j int) bool {
+		if bets[i].CreateDate != bets[j].CreateDate {
+			return bets[i].CreateDate < bets[j].CreateDate
+		}
+		return bets[i].ID.Hex() < bets[j].ID.Hex()
+	})
+	return bets, nil
+}
+
+func (s *memoryBetStore) Replace(ctx context.Context, bet models.Bet) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.bets[bet.ID]; !ok {
+		return fmt.Errorf("bet %s did not previously exist when trying to replace", bet.ID.Hex())
+	}
+	s.db.bets[bet.ID] = cloneBet(bet)
+	return nil
+}
+
+func (s *memoryBetStore) FindExpired(ctx context.Context, before time.Time) ([]models.Bet, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	bets := make([]models.Bet, 0)
+	for _, bet := range s.db.bets {
+		if bet.OverallStatus == models.Undecided && bet.ExpiryDate.Time().Before(before) {
+			bets = append(bets, cloneBet(bet))
+		}
+	}
+	return bets, nil
+}
+
+func (s *memoryBetStore) List(ctx context.Context, filter BetFilter, page PageRequest) ([]models.Bet, error) {
+	if err := page.validate(SortByCreateDate, SortByExpiryDate); err != nil {
+		return nil, err
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	bets := make([]models.Bet, 0)
+	for _, bet := range s.db.bets {
+		if !filter.matches(bet) {
+			continue
+		}
+		if page.After != nil && page.compare(betSortValue(bet, page.SortBy), bet.ID, page.After.SortValue, page.After.ID) <= 0 {
+			continue
+		}
+		bets = append(bets, cloneBet(bet))
+	}
+	sort.Slice(bets, func(i, j int) bool {
+		return page.compare(betSortValue(bets[i], page.SortBy), bets[i].ID, betSortValue(bets[j], page.SortBy), bets[j].ID) < 0
+	})
+	if len(bets) > page.Limit {
+		bets = bets[:page.Limit]
+	}
+	return bets, nil
+}
+
+func (s *memoryStakeStore) Insert(ctx context.Context, stake models.Stake) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.stakes[stake.ID]; ok {
+		return fmt.Errorf("stake %s already exists", stake.ID.Hex())
+	}
+	s.db.stakes[stake.ID] = stake
+	return nil
+}
+
+func (s *memoryStakeStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Stake, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	stake, ok := s.db.stakes[id]
+	if !ok {
+		return models.Stake{}, ErrNotFound
+	}
+	return stake, nil
+}
+
+func (s *memoryStakeStore) UpdateFilled(ctx context.Context, id primitive.ObjectID, sharesFilled int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	stake, ok := s.db.stakes[id]
+	if !ok {
+		return fmt.Errorf("could not find stake with ID %s to update", id.Hex())
+	}
+	stake.SharesFilled = sharesFilled
+	s.db.stakes[id] = stake
+	return nil
+}
+
+func (s *memoryStakeStore) Replace(ctx context.Context, stake models.Stake) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.stakes[stake.ID]; !ok {
+		return fmt.Errorf("stake %s did not previously exist when trying to replace", stake.ID.Hex())
+	}
+	s.db.stakes[stake.ID] = stake
+	return nil
+}
+
+func (s *memoryStakeStore) FindByUnderlying(ctx context.Context, betID primitive.ObjectID) ([]models.Stake, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	stakes := make([]models.Stake, 0)
+	for _, stake := range s.db.stakes {
+		if stake.Underlying == betID {
+			stakes = append(stakes, stake)
+		}
+	}
+	sortStakes(stakes)
+	return stakes, nil
+}
+
+func (s *memoryStakeStore) List(ctx context.Context, filter StakeFilter, page PageRequest) ([]models.Stake, error) {
+	if err := page.validate(SortByCreateDate); err != nil {
+		return nil, err
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	stakes := make([]models.Stake, 0)
+	for _, stake := range s.db.stakes {
+		if !filter.matches(stake) {
+			continue
+		}
+		if page.After != nil && page.compare(stake.CreateDate, stake.ID, page.After.SortValue, page.After.ID) <= 0 {
+			continue
+		}
+		stakes = append(stakes, stake)
+	}
+	sort.Slice(stakes, func(i, j int) bool {
+		return page.compare(stakes[i].CreateDate, stakes[i].ID, stakes[j].CreateDate, stakes[j].ID) < 0
+	})
+	if len(stakes) > page.Limit {
+		stakes = stakes[:page.Limit]
+	}
+	return stakes, nil
+}
+
+// Oldest first, with the ObjectID (which embeds creation time) breaking ties
+func sortStakes(stakes []models.Stake) {
+	sort.Slice(stakes, func(i, j int) bool {
+		if stakes[i].CreateDate != stakes[j].CreateDate {
+			return stakes[i].CreateDate < stakes[j].CreateDate
+		}
+		return stakes[i].ID.Hex() < stakes[j].ID.Hex()
+	})
+}
+
+func (s *memoryWebhookStore) Insert(ctx context.Context, hook models.Webhook) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.webhooks[hook.ID]; ok {
+		return fmt.Errorf("webhook %s already exists", hook.ID.Hex())
+	}
+	s.db.webhooks[hook.ID] = cloneWebhook(hook)
+	return nil
+}
+
+func (s *memoryWebhookStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	hook, ok := s.db.webhooks[id]
+	if !ok {
+		return models.Webhook{}, ErrNotFound
+	}
+	return cloneWebhook(hook), nil
+}
+
+func (s *memoryWebhookStore) ListByOwner(ctx context.Context, owner string) ([]models.Webhook, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	hooks := make([]models.Webhook, 0)
+	for _, hook := range s.db.webhooks {
+		if hook.OwnerName == owner {
+			hooks = append(hooks, cloneWebhook(hook))
+		}
+	}
+	sortWebhooks(hooks)
+	return hooks, nil
+}
+
+func (s *memoryWebhookStore) FindSubscribed(ctx context.Context, owners []string, eventType models.EventType) ([]models.Webhook, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	hooks := make([]models.Webhook, 0)
+	for _, hook := range s.db.webhooks {
+		ownerMatches := false
+		for _, owner := range owners {
+			if hook.OwnerName == owner {
+				ownerMatches = true
+			}
+		}
+		typeMatches := false
+		for _, t := range hook.EventTypes {
+			if t == eventType {
+				typeMatches = true
+			}
+		}
+		if ownerMatches && typeMatches {
+			hooks = append(hooks, cloneWebhook(hook))
+		}
+	}
+	sortWebhooks(hooks)
+	return hooks, nil
+}
+
+func (s *memoryWebhookStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.db.webhooks, id)
+	return nil
+}
+
+func (s *memoryWebhookStore) DeleteByOwner(ctx context.Context, owner string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for id, hook := range s.db.webhooks {
+		if hook.OwnerName == owner {
+			delete(s.db.webhooks, id)
+		}
+	}
+	return nil
+}
+
+func sortWebhooks(hooks []models.Webhook) {
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].CreateDate != hooks[j].CreateDate {
+			return hooks[i].CreateDate < hooks[j].CreateDate
+		}
+		return hooks[i].ID.Hex() < hooks[j].ID.Hex()
+	})
+}
+
+func (s *memoryDeliveryStore) Insert(ctx context.Context, deliveries ...models.WebhookDelivery) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, delivery := range deliveries {
+		if _, ok := s.db.deliveries[delivery.ID]; ok {
+			return fmt.Errorf("webhook delivery %s already exists", delivery.ID.Hex())
+		}
+		s.db.deliveries[delivery.ID] = cloneDelivery(delivery)
+	}
+	return nil
+}
+
+func (s *memoryDeliveryStore) FindDue(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	due := make([]models.WebhookDelivery, 0)
+	for _, delivery := range s.db.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttempt.Time().After(now) {
+			due = append(due, cloneDelivery(delivery))
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttempt != due[j].NextAttempt {
+			return due[i].NextAttempt < due[j].NextAttempt
+		}
+		return due[i].ID.Hex() < due[j].ID.Hex()
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s *memoryDeliveryStore) Replace(ctx context.Context, delivery models.WebhookDelivery) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.deliveries[delivery.ID]; !ok {
+		return fmt.Errorf("webhook delivery %s did not previously exist when trying to replace", delivery.ID.Hex())
+	}
+	s.db.deliveries[delivery.ID] = cloneDelivery(delivery)
+	return nil
+}
+
+func (s *memoryDeliveryStore) ListByWebhook(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]models.WebhookDelivery, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	deliveries := make([]models.WebhookDelivery, 0)
+	for _, delivery := range s.db.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, cloneDelivery(delivery))
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].CreateDate != deliveries[j].CreateDate {
+			return deliveries[i].CreateDate > deliveries[j].CreateDate
+		}
+		return deliveries[i].ID.Hex() > deliveries[j].ID.Hex()
+	})
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *memoryNotificationStore) Insert(ctx context.Context, notifications ...models.Notification) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, notification := range notifications {
+		if _, ok := s.db.notifications[notification.ID]; ok {
+			return fmt.Errorf("notification %s already exists", notification.ID.Hex())
+		}
+		s.db.notifications[notification.ID] = notification
+	}
+	return nil
+}
+
+func (s *memoryNotificationStore) List(ctx context.Context, filter NotificationFilter, page PageRequest) ([]models.Notification, error) {
+	if err := page.validate(SortByCreateDate); err != nil {
+		return nil, err
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	notifications := make([]models.Notification, 0)
+	for _, notification := range s.db.notifications {
+		if !filter.matches(notification) {
+			continue
+		}
+		if page.After != nil && page.compare(notification.CreateDate, notification.ID, page.After.SortValue, page.After.ID) <= 0 {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return page.compare(notifications[i].CreateDate, notifications[i].ID, notifications[j].CreateDate, notifications[j].ID) < 0
+	})
+	if len(notifications) > page.Limit {
+		notifications = notifications[:page.Limit]
+	}
+	return notifications, nil
+}
+
+func (s *memoryNotificationStore) MarkRead(ctx context.Context, username string, ids []primitive.ObjectID) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	var marked int64
+	for _, id := range ids {
+		notification, ok := s.db.notifications[id]
+		if !ok || notification.Username != username || notification.Read {
+			continue
+		}
+		notification.Read = true
+		s.db.notifications[id] = notification
+		marked++
+	}
+	return marked, nil
+}
+
+func (s *memoryNotificationStore) MarkAllRead(ctx context.Context, username string) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	var marked int64
+	for id, notification := range s.db.notifications {
+		if notification.Username == username && !notification.Read {
+			notification.Read = true
+			s.db.notifications[id] = notification
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+func (s *memoryNotificationStore) CountUnread(ctx context.Context, username string) (int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var unread int64
+	for _, notification := range s.db.notifications {
+		if notification.Username == username && !notification.Read {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+func (s *memoryNotificationStore) DeleteByUsername(ctx context.Context, username string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for id, notification := range s.db.notifications {
+		if notification.Username == username {
+			delete(s.db.notifications, id)
+		}
+	}
+	return nil
+}
+
+func (s *memorySettlementStore) Insert(ctx context.Context, settlement models.Settlement) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.settlements[settlement.ID]; ok {
+		return fmt.Errorf("settlement %s already exists", settlement.ID.Hex())
+	}
+	s.db.settlements[settlement.ID] = settlement
+	return nil
+}
+
+func (s *memorySettlementStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Settlement, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	settlement, ok := s.db.settlements[id]
+	if !ok {
+		return models.Settlement{}, ErrNotFound
+	}
+	return settlement, nil
+}
+
+func (s *memorySettlementStore) Replace(ctx context.Context, settlement models.Settlement) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.settlements[settlement.ID]; !ok {
+		return fmt.Errorf("settlement %s did not previously exist when trying to replace", settlement.ID.Hex())
+	}
+	s.db.settlements[settlement.ID] = settlement
+	return nil
+}
+
+func (s *memorySettlementStore) ListByUser(ctx context.Context, username string) ([]models.Settlement, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	settlements := make([]models.Settlement, 0)
+	for _, settlement := range s.db.settlements {
+		if settlement.Payer == username || settlement.Payee == username {
+			settlements = append(settlements, settlement)
+		}
+	}
+	sort.Slice(settlements, func(i, j int) bool {
+		if settlements[i].CreateDate != settlements[j].CreateDate {
+			return settlements[i].CreateDate > settlements[j].CreateDate
+		}
+		return settlements[i].ID.Hex() > settlements[j].ID.Hex()
+	})
+	return settlements, nil
+}
+
+func (s *memoryPoolStore) Insert(ctx context.Context, pool models.Pool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.pools[pool.ID]; ok {
+		return fmt.Errorf("pool %s already exists", pool.ID.Hex())
+	}
+	s.db.pools[pool.ID] = clonePool(pool)
+	return nil
+}
+
+func (s *memoryPoolStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Pool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	pool, ok := s.db.pools[id]
+	if !ok {
+		return models.Pool{}, ErrNotFound
+	}
+	return clonePool(pool), nil
+}
+
+func (s *memoryPoolStore) Replace(ctx context.Context, pool models.Pool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.pools[pool.ID]; !ok {
+		return fmt.Errorf("pool %s did not previously exist when trying to replace", pool.ID.Hex())
+	}
+	s.db.pools[pool.ID] = clonePool(pool)
+	return nil
+}
+
+func (s *memoryPoolStore) ListByUser(ctx context.Context, username string) ([]models.Pool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	pools := make([]models.Pool, 0)
+	for _, pool := range s.db.pools {
+		if pool.Involves(username) {
+			pools = append(pools, clonePool(pool))
+		}
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		if pools[i].CreateDate != pools[j].CreateDate {
+			return pools[i].CreateDate > pools[j].CreateDate
+		}
+		return pools[i].ID.Hex() > pools[j].ID.Hex()
+	})
+	return pools, nil
+}
+
+func (s *memoryTemplateStore) Insert(ctx context.Context, template models.BetTemplate) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.templates[template.ID]; ok {
+		return fmt.Errorf("bet template %s already exists", template.ID.Hex())
+	}
+	s.db.templates[template.ID] = template
+	return nil
+}
+
+func (s *memoryTemplateStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.BetTemplate, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	template, ok := s.db.templates[id]
+	if !ok {
+		return models.BetTemplate{}, ErrNotFound
+	}
+	return template, nil
+}
+
+func (s *memoryTemplateStore) ListByOwner(ctx context.Context, owner string) ([]models.BetTemplate, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	templates := make([]models.BetTemplate, 0)
+	for _, template := range s.db.templates {
+		if template.OwnerName == owner {
+			templates = append(templates, template)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].CreateDate != templates[j].CreateDate {
+			return templates[i].CreateDate < templates[j].CreateDate
+		}
+		return templates[i].ID.Hex() < templates[j].ID.Hex()
+	})
+	return templates, nil
+}
+
+func (s *memoryTemplateStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.templates[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.db.templates, id)
+	return nil
+}
+
+func (s *memoryTemplateStore) DeleteByOwner(ctx context.Context, owner string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for id, template := range s.db.templates {
+		if template.OwnerName == owner {
+			delete(s.db.templates, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryScheduleStore) Insert(ctx context.Context, schedule models.BetSchedule) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.schedules[schedule.ID]; ok {
+		return fmt.Errorf("bet schedule %s already exists", schedule.ID.Hex())
+	}
+	s.db.schedules[schedule.ID] = cloneSchedule(schedule)
+	return nil
+}
+
+func (s *memoryScheduleStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.BetSchedule, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	schedule, ok := s.db.schedules[id]
+	if !ok {
+		return models.BetSchedule{}, ErrNotFound
+	}
+	return cloneSchedule(schedule), nil
+}
+
+func (s *memoryScheduleStore) Replace(ctx context.Context, schedule models.BetSchedule) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.schedules[schedule.ID]; !ok {
+		return fmt.Errorf("bet schedule %s did not previously exist when trying to replace", schedule.ID.Hex())
+	}
+	s.db.schedules[schedule.ID] = cloneSchedule(schedule)
+	return nil
+}
+
+func (s *memoryScheduleStore) ListByOwner(ctx context.Context, owner string) ([]models.BetSchedule, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	schedules := make([]models.BetSchedule, 0)
+	for _, schedule := range s.db.schedules {
+		if schedule.OwnerName == owner {
+			schedules = append(schedules, cloneSchedule(schedule))
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].CreateDate != schedules[j].CreateDate {
+			return schedules[i].CreateDate < schedules[j].CreateDate
+		}
+		return schedules[i].ID.Hex() < schedules[j].ID.Hex()
+	})
+	return schedules, nil
+}
+
+func (s *memoryScheduleStore) FindDue(ctx context.Context, now time.Time, limit int) ([]models.BetSchedule, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	due := make([]models.BetSchedule, 0)
+	for _, schedule := range s.db.schedules {
+		if !schedule.Paused && !schedule.NextRun.Time().After(now) {
+			due = append(due, cloneSchedule(schedule))
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextRun != due[j].NextRun {
+			return due[i].NextRun < due[j].NextRun
+		}
+		return due[i].ID.Hex() < due[j].ID.Hex()
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s *memoryScheduleStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.schedules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.db.schedules, id)
+	return nil
+}
+
+func (s *memoryScheduleStore) DeleteByTemplate(ctx context.Context, templateID primitive.ObjectID) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for id, schedule := range s.db.schedules {
+		if schedule.TemplateID == templateID {
+			delete(s.db.schedules, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryScheduleStore) DeleteByOwner(ctx context.Context, owner string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for id, schedule := range s.db.schedules {
+		if schedule.OwnerName == owner {
+			delete(s.db.schedules, id)
+		}
+	}
+	return nil
+}
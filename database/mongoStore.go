@@ -0,0 +1,932 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoUserStore struct {
+	collection *mongo.Collection
+}
+
+type mongoBetStore struct {
+	collection *mongo.Collection
+}
+
+type mongoStakeStore struct {
+	collection *mongo.Collection
+}
+
+type mongoLedgerStore struct {
+	collection *mongo.Collection
+}
+
+type mongoEventStore struct {
+	collection *mongo.Collection
+	counters   *mongo.Collection
+}
+
+type mongoWebhookStore struct {
+	collection *mongo.Collection
+}
+
+type mongoDeliveryStore struct {
+	collection *mongo.Collection
+}
+
+type mongoNotificationStore struct {
+	collection *mongo.Collection
+}
+
+type mongoSettlementStore struct {
+	collection *mongo.Collection
+}
+
+type mongoPoolStore struct {
+	collection *mongo.Collection
+}
+
+type mongoTemplateStore struct {
+	collection *mongo.Collection
+}
+
+type mongoScheduleStore struct {
+	collection *mongo.Collection
+}
+
+type mongoTransactor struct {
+	client *mongo.Client
+}
+
+// Transactions need Mongo to be running as a replica set
+func NewMongoStores(client *mongo.Client) Stores {
+	events := &mongoEventStore{
+		collection: OpenCollection(client, config.GlobalConfig.EventCollection),
+		counters:   OpenCollection(client, config.GlobalConfig.CounterCollection),
+	}
+	return Stores{
+		Users:         &mongoUserStore{collection: OpenCollection(client, config.GlobalConfig.UserCollection)},
+		Bets:          &mongoBetStore{collection: OpenCollection(client, config.GlobalConfig.BetCollection)},
+		Stakes:        &mongoStakeStore{collection: OpenCollection(client, config.GlobalConfig.StakeCollection)},
+		Ledger:        &mongoLedgerStore{collection: OpenCollection(client, config.GlobalConfig.LedgerCollection)},
+		Events:        events,
+		Webhooks:      &mongoWebhookStore{collection: OpenCollection(client, config.GlobalConfig.WebhookCollection)},
+		Deliveries:    &mongoDeliveryStore{collection: OpenCollection(client, config.GlobalConfig.DeliveryCollection)},
+		Notifications: &mongoNotificationStore{collection: OpenCollection(client, config.GlobalConfig.NotificationCollection)},
+		Settlements:   &mongoSettlementStore{collection: OpenCollection(client, config.GlobalConfig.SettlementCollection)},
+		Pools:         &mongoPoolStore{collection: OpenCollection(client, config.GlobalConfig.PoolCollection)},
+		Templates:     &mongoTemplateStore{collection: OpenCollection(client, config.GlobalConfig.TemplateCollection)},
+		Schedules:     &mongoScheduleStore{collection: OpenCollection(client, config.GlobalConfig.ScheduleCollection)},
+		Tx:            &mongoTransactor{client: client},
+	}
+}
+
+// The session context is passed down to fn so every store call inside it joins the transaction
+func (t *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// Maps the driver's missing document error onto ErrNotFound
+func decodeSingle(res *mongo.SingleResult, out interface{}) error {
+	if err := res.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return res.Decode(out)
+}
+
+func (s *mongoUserStore) FindByUsername(ctx context.Context, username string) (models.User, error) {
+	var user models.User
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"username": username}), &user)
+	return user, err
+}
+
+func (s *mongoUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"email": email}), &user)
+	return user, err
+}
+
+func (s *mongoUserStore) CountByUsername(ctx context.Context, username string) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{"username": username})
+}
+
+func (s *mongoUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{"email": email})
+}
+
+func (s *mongoUserStore) Insert(ctx context.Context, user models.User) error {
+	_, err := s.collection.InsertOne(ctx, user)
+	return err
+}
+
+func (s *mongoUserStore) Tombstone(ctx context.Context, username string, deleteDate time.Time) error {
+	empty := make([]interface{}, 0)
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": username},
+		bson.D{{Key: "$set", Value: bson.M{
+			"email":              nil,
+			"password":           nil,
+			"sessions":           empty,
+			"outgoingfriendreqs": empty,
+			"incomingfriendreqs": empty,
+			"blockedusers":       empty,
+			"friends":            empty,
+			"incomingbetreqs":    empty,
+			"outgoingbetreqs":    empty,
+			"conflictedbets":     empty,
+			"ongoingbets":        empty,
+			"ongoingstakes":      empty,
+			"balances":           bson.M{},
+			"totalbalance":       0,
+			"notificationprefs":  models.NotificationPrefs{},
+			"deleted":            true,
+			"deletedate":         primitive.NewDateTimeFromTime(deleteDate),
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to delete invalid user %s", username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) PullFromAllLists(ctx context.Context, username string) error {
+	for field := range stringListFields {
+		_, err := s.collection.UpdateMany(
+			ctx,
+			bson.M{field: username},
+			bson.D{{Key: "$pull", Value: bson.M{field: username}}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mongoUserStore) Search(ctx context.Context, prefix string, viewer string, limit int) ([]models.User, error) {
+	filter := bson.M{
+		"username":     bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		"deleted":      bson.M{"$ne": true},
+		"blockedusers": bson.M{"$ne": viewer},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]models.User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *mongoUserStore) IncrementNumBets(ctx context.Context, username string) (models.User, error) {
+	var user models.User
+	update := bson.D{
+		{Key: "$inc", Value: bson.M{"numbets": 1}},
+	}
+	err := decodeSingle(s.collection.FindOneAndUpdate(ctx, bson.M{"username": username}, update), &user)
+	return user, err
+}
+
+func (s *mongoUserStore) UpdateList(ctx context.Context, update models.UpdateUserHelperStruct) error {
+	var val interface{} = update.IdVal
+	if stringListFields[update.Field] {
+		val = update.Val
+	}
+
+	var change primitive.M
+	switch update.Operation {
+	case "$pullAll":
+		change = bson.M{update.Field: []interface{}{val}}
+	case "$push":
+		change = bson.M{update.Field: val}
+	default:
+		return fmt.Errorf("unsupported list operation %s", update.Operation)
+	}
+
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": update.Username},
+		bson.D{{Key: update.Operation, Value: change}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to update invalid user %s", update.Username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) AddSession(ctx context.Context, username string, session models.Session, limit int) error {
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": username},
+		bson.D{{Key: "$push", Value: bson.M{"sessions": bson.M{"$each": bson.A{session}, "$slice": -limit}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to update tokens for invalid user %s", username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) RotateSession(ctx context.Context, username string, family string, oldRefreshToken string, refreshToken string) (bool, error) {
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": username, "sessions": bson.M{"$elemMatch": bson.M{"family": family, "refreshtoken": oldRefreshToken}}},
+		bson.D{{Key: "$set", Value: bson.M{"sessions.$.refreshtoken": refreshToken}}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (s *mongoUserStore) RevokeSessions(ctx context.Context, username string, family string) error {
+	update := bson.D{{Key: "$set", Value: bson.M{"sessions": make([]interface{}, 0)}}}
+	if family != "" {
+		update = bson.D{{Key: "$pull", Value: bson.M{"sessions": bson.M{"family": family}}}}
+	}
+	res, err := s.collection.UpdateOne(ctx, bson.M{"username": username}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to update tokens for invalid user %s", username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) SetBalances(ctx context.Context, username string, balances map[string]int64, totalBalance int64) error {
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": username},
+		bson.D{{Key: "$set", Value: bson.M{
+			"balances":     balances,
+			"totalbalance": totalBalance,
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to handle balance for invalid user %s", username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) SetNotificationPrefs(ctx context.Context, username string, prefs models.NotificationPrefs) error {
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": username},
+		bson.D{{Key: "$set", Value: bson.M{"notificationprefs": prefs}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to update notification preferences for invalid user %s", username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) SetLastDigestDate(ctx context.Context, username string, date time.Time) error {
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"username": username},
+		bson.D{{Key: "$set", Value: bson.M{"lastdigestdate": primitive.NewDateTimeFromTime(date)}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("tried to update digest date for invalid user %s", username)
+	}
+	return nil
+}
+
+func (s *mongoUserStore) FindDigestDue(ctx context.Context, before time.Time, limit int) ([]models.User, error) {
+	filter := bson.M{
+		"deleted": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"notificationprefs.digestbetreqs": true},
+			bson.M{"notificationprefs.digestexpiringbets": true},
+		},
+		// Users created before digests existed have no date yet, so they count as due
+		"lastdigestdate": bson.M{"$not": bson.M{"$gte": primitive.NewDateTimeFromTime(before)}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastdigestdate", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]models.User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *mongoBetStore) Insert(ctx context.Context, bet models.Bet) error {
+	_, err := s.collection.InsertOne(ctx, bet)
+	return err
+}
+
+func (s *mongoBetStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Bet, error) {
+	var bet models.Bet
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &bet)
+	return bet, err
+}
+
+func (s *mongoBetStore) FindByBetID(ctx context.Context, betID string) (models.Bet, error) {
+	var bet models.Bet
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"betid": betID}), &bet)
+	return bet, err
+}
+
+func (s *mongoBetStore) FindDerived(ctx context.Context, betID string) ([]models.Bet, error) {
+	filter := bson.M{
+		"overallstatus": bson.M{"$in": bson.A{models.Undecided, models.Conflicted}},
+		"$or":           bson.A{bson.M{"underlying": betID}, bson.M{"legs.underlying": betID}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	bets := make([]models.Bet, 0)
+	if err := cursor.All(ctx, &bets); err != nil {
+		return nil, err
+	}
+	return bets, nil
+}
+
+func (s *mongoBetStore) Replace(ctx context.Context, bet models.Bet) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": bet.ID}, bet)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("bet %s did not previously exist when trying to replace", bet.ID.Hex())
+	}
+	return nil
+}
+
+func (s *mongoLedgerStore) Append(ctx context.Context, entries ...models.LedgerEntry) error {
+	docs := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		docs = append(docs, entry)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoLedgerStore) ListByUsername(ctx context.Context, username string) ([]models.LedgerEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"username": username}, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]models.LedgerEntry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *mongoLedgerStore) List(ctx context.Context, username string, page PageRequest) ([]models.LedgerEntry, error) {
+	if err := page.validate(SortByCreateDate); err != nil {
+		return nil, err
+	}
+	cursor, err := s.collection.Find(ctx, page.mongoFilter(bson.M{"username": username}), page.findOptions())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]models.LedgerEntry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Takes the numbers from a counter document inside the caller's transaction
+// Another transaction appending events gets a write conflict on the counter and is retried once this one
+// commits, so numbers are handed out in commit order and no reader can skip past one that is not yet visible
+func (s *mongoEventStore) Append(ctx context.Context, events ...models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{"$inc": bson.M{"seq": int64(len(events))}}
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	if err := s.counters.FindOneAndUpdate(ctx, bson.M{"_id": "events"}, update, opts).Decode(&counter); err != nil {
+		return err
+	}
+	docs := make([]interface{}, 0, len(events))
+	for i, event := range events {
+		event.Seq = counter.Seq - int64(len(events)-1-i)
+		docs = append(docs, event)
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoEventStore) ListAfter(ctx context.Context, username string, after int64, limit int) ([]models.Event, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	filter := bson.M{"audience": username, "seq": bson.M{"$gt": after}}
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]models.Event, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *mongoBetStore) FindExpired(ctx context.Context, before time.Time) ([]models.Bet, error) {
+	filter := bson.M{
+		"overallstatus": models.Undecided,
+		"expirydate":    bson.M{"$lt": primitive.NewDateTimeFromTime(before)},
+	}
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	bets := make([]models.Bet, 0)
+	if err := cursor.All(ctx, &bets); err != nil {
+		return nil, err
+	}
+	return bets, nil
+}
+
+func (s *mongoBetStore) List(ctx context.Context, filter BetFilter, page PageRequest) ([]models.Bet, error) {
+	if err := page.validate(SortByCreateDate, SortByExpiryDate); err != nil {
+		return nil, err
+	}
+	cursor, err := s.collection.Find(ctx, page.mongoFilter(filter.mongoFilter()), page.findOptions())
+	if err != nil {
+		return nil, err
+	}
+	bets := make([]models.Bet, 0)
+	if err := cursor.All(ctx, &bets); err != nil {
+		return nil, err
+	}
+	return bets, nil
+}
+
+func (s *mongoStakeStore) Insert(ctx context.Context, stake models.Stake) error {
+	_, err := s.collection.InsertOne(ctx, stake)
+	return err
+}
+
+func (s *mongoStakeStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Stake, error) {
+	var stake models.Stake
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &stake)
+	return stake, err
+}
+
+func (s *mongoStakeStore) UpdateFilled(ctx context.Context, id primitive.ObjectID, sharesFilled int64) error {
+	res, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.D{{Key: "$set", Value: bson.M{"amountfilled": sharesFilled}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("could not find stake with ID %s to update", id.Hex())
+	}
+	return nil
+}
+
+func (s *mongoStakeStore) Replace(ctx context.Context, stake models.Stake) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": stake.ID}, stake)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("stake %s did not previously exist when trying to replace", stake.ID.Hex())
+	}
+	return nil
+}
+
+func (s *mongoStakeStore) FindByUnderlying(ctx context.Context, betID primitive.ObjectID) ([]models.Stake, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"underlying": betID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	stakes := make([]models.Stake, 0)
+	if err := cursor.All(ctx, &stakes); err != nil {
+		return nil, err
+	}
+	return stakes, nil
+}
+
+func (s *mongoStakeStore) List(ctx context.Context, filter StakeFilter, page PageRequest) ([]models.Stake, error) {
+	if err := page.validate(SortByCreateDate); err != nil {
+		return nil, err
+	}
+	cursor, err := s.collection.Find(ctx, page.mongoFilter(filter.mongoFilter()), page.findOptions())
+	if err != nil {
+		return nil, err
+	}
+	stakes := make([]models.Stake, 0)
+	if err := cursor.All(ctx, &stakes); err != nil {
+		return nil, err
+	}
+	return stakes, nil
+}
+
+func (s *mongoWebhookStore) Insert(ctx context.Context, hook models.Webhook) error {
+	_, err := s.collection.InsertOne(ctx, hook)
+	return err
+}
+
+func (s *mongoWebhookStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	var hook models.Webhook
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &hook)
+	return hook, err
+}
+
+func (s *mongoWebhookStore) ListByOwner(ctx context.Context, owner string) ([]models.Webhook, error) {
+	return s.find(ctx, bson.M{"ownername": owner})
+}
+
+func (s *mongoWebhookStore) FindSubscribed(ctx context.Context, owners []string, eventType models.EventType) ([]models.Webhook, error) {
+	return s.find(ctx, bson.M{"ownername": bson.M{"$in": owners}, "eventtypes": eventType})
+}
+
+func (s *mongoWebhookStore) find(ctx context.Context, filter bson.M) ([]models.Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]models.Webhook, 0)
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (s *mongoWebhookStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoWebhookStore) DeleteByOwner(ctx context.Context, owner string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"ownername": owner})
+	return err
+}
+
+func (s *mongoDeliveryStore) Insert(ctx context.Context, deliveries ...models.WebhookDelivery) error {
+	docs := make([]interface{}, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		docs = append(docs, delivery)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoDeliveryStore) FindDue(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	filter := bson.M{
+		"status":      models.DeliveryPending,
+		"nextattempt": bson.M{"$lte": primitive.NewDateTimeFromTime(now)},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "nextattempt", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	return s.find(ctx, filter, opts)
+}
+
+func (s *mongoDeliveryStore) Replace(ctx context.Context, delivery models.WebhookDelivery) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("webhook delivery %s did not previously exist when trying to replace", delivery.ID.Hex())
+	}
+	return nil
+}
+
+func (s *mongoDeliveryStore) ListByWebhook(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]models.WebhookDelivery, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	return s.find(ctx, bson.M{"webhookid": webhookID}, opts)
+}
+
+func (s *mongoDeliveryStore) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]models.WebhookDelivery, error) {
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]models.WebhookDelivery, 0)
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (s *mongoNotificationStore) Insert(ctx context.Context, notifications ...models.Notification) error {
+	docs := make([]interface{}, 0, len(notifications))
+	for _, notification := range notifications {
+		docs = append(docs, notification)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoNotificationStore) List(ctx context.Context, filter NotificationFilter, page PageRequest) ([]models.Notification, error) {
+	if err := page.validate(SortByCreateDate); err != nil {
+		return nil, err
+	}
+	cursor, err := s.collection.Find(ctx, page.mongoFilter(filter.mongoFilter()), page.findOptions())
+	if err != nil {
+		return nil, err
+	}
+	notifications := make([]models.Notification, 0)
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (s *mongoNotificationStore) MarkRead(ctx context.Context, username string, ids []primitive.ObjectID) (int64, error) {
+	res, err := s.collection.UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "username": username, "read": false},
+		bson.D{{Key: "$set", Value: bson.M{"read": true}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (s *mongoNotificationStore) MarkAllRead(ctx context.Context, username string) (int64, error) {
+	res, err := s.collection.UpdateMany(
+		ctx,
+		bson.M{"username": username, "read": false},
+		bson.D{{Key: "$set", Value: bson.M{"read": true}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (s *mongoNotificationStore) CountUnread(ctx context.Context, username string) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{"username": username, "read": false})
+}
+
+func (s *mongoNotificationStore) DeleteByUsername(ctx context.Context, username string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"username": username})
+	return err
+}
+
+func (s *mongoSettlementStore) Insert(ctx context.Context, settlement models.Settlement) error {
+	_, err := s.collection.InsertOne(ctx, settlement)
+	return err
+}
+
+func (s *mongoSettlementStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Settlement, error) {
+	var settlement models.Settlement
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &settlement)
+	return settlement, err
+}
+
+func (s *mongoSettlementStore) Replace(ctx context.Context, settlement models.Settlement) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": settlement.ID}, settlement)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("settlement %s did not previously exist when trying to replace", settlement.ID.Hex())
+	}
+	return nil
+}
+
+func (s *mongoSettlementStore) ListByUser(ctx context.Context, username string) ([]models.Settlement, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"payer": username}, bson.M{"payee": username}}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	settlements := make([]models.Settlement, 0)
+	if err := cursor.All(ctx, &settlements); err != nil {
+		return nil, err
+	}
+	return settlements, nil
+}
+
+func (s *mongoPoolStore) Insert(ctx context.Context, pool models.Pool) error {
+	_, err := s.collection.InsertOne(ctx, pool)
+	return err
+}
+
+func (s *mongoPoolStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Pool, error) {
+	var pool models.Pool
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &pool)
+	return pool, err
+}
+
+func (s *mongoPoolStore) Replace(ctx context.Context, pool models.Pool) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": pool.ID}, pool)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("pool %s did not previously exist when trying to replace", pool.ID.Hex())
+	}
+	return nil
+}
+
+func (s *mongoPoolStore) ListByUser(ctx context.Context, username string) ([]models.Pool, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"creatorname": username},
+		bson.M{"resolver": username},
+		bson.M{"entries.username": username},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]models.Pool, 0)
+	if err := cursor.All(ctx, &pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+func (s *mongoTemplateStore) Insert(ctx context.Context, template models.BetTemplate) error {
+	_, err := s.collection.InsertOne(ctx, template)
+	return err
+}
+
+func (s *mongoTemplateStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.BetTemplate, error) {
+	var template models.BetTemplate
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &template)
+	return template, err
+}
+
+func (s *mongoTemplateStore) ListByOwner(ctx context.Context, owner string) ([]models.BetTemplate, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"ownername": owner}, opts)
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]models.BetTemplate, 0)
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *mongoTemplateStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTemplateStore) DeleteByOwner(ctx context.Context, owner string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"ownername": owner})
+	return err
+}
+
+func (s *mongoScheduleStore) Insert(ctx context.Context, schedule models.BetSchedule) error {
+	_, err := s.collection.InsertOne(ctx, schedule)
+	return err
+}
+
+func (s *mongoScheduleStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.BetSchedule, error) {
+	var schedule models.BetSchedule
+	err := decodeSingle(s.collection.FindOne(ctx, bson.M{"_id": id}), &schedule)
+	return schedule, err
+}
+
+func (s *mongoScheduleStore) Replace(ctx context.Context, schedule models.BetSchedule) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": schedule.ID}, schedule)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("bet schedule %s did not previously exist when trying to replace", schedule.ID.Hex())
+	}
+	return nil
+}
+
+func (s *mongoScheduleStore) ListByOwner(ctx context.Context, owner string) ([]models.BetSchedule, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdate", Value: 1}, {Key: "_id", Value: 1}})
+	return s.find(ctx, bson.M{"ownername": owner}, opts)
+}
+
+func (s *mongoScheduleStore) FindDue(ctx context.Context, now time.Time, limit int) ([]models.BetSchedule, error) {
+	filter := bson.M{
+		"paused":  false,
+		"nextrun": bson.M{"$lte": primitive.NewDateTimeFromTime(now)},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "nextrun", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	return s.find(ctx, filter, opts)
+}
+
+func (s *mongoScheduleStore) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]models.BetSchedule, error) {
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]models.BetSchedule, 0)
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (s *mongoScheduleStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoScheduleStore) DeleteByTemplate(ctx context.Context, templateID primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"templateid": templateID})
+	return err
+}
+
+func (s *mongoScheduleStore) DeleteByOwner(ctx context.Context, owner string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"ownername": owner})
+	return err
+}
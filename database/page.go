@@ -0,0 +1,268 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrBadCursor = errors.New("invalid page cursor")
+
+// Fields that list queries can be sorted by; the ObjectID always breaks ties
+const (
+	SortByCreateDate = "createdate"
+	SortByExpiryDate = "expirydate"
+)
+
+// Position just after the last item of a page
+type Cursor struct {
+	SortValue primitive.DateTime
+	ID        primitive.ObjectID
+}
+
+// Opaque form handed to clients
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(int64(c.SortValue), 10) + ":" + c.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrBadCursor
+	}
+	value, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	return &Cursor{SortValue: primitive.DateTime(value), ID: id}, nil
+}
+
+type PageRequest struct {
+	SortBy     string // one of the SortBy constants
+	Descending bool
+	After      *Cursor // nil for the first page
+	Limit      int
+}
+
+func (p PageRequest) direction() int {
+	if p.Descending {
+		return -1
+	}
+	return 1
+}
+
+// Mongo condition selecting documents strictly after the cursor in sort order
+func (p PageRequest) cursorFilter() bson.M {
+	op := "$gt"
+	if p.Descending {
+		op = "$lt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{p.SortBy: bson.M{op: p.After.SortValue}},
+		bson.M{p.SortBy: p.After.SortValue, "_id": bson.M{op: p.After.ID}},
+	}}
+}
+
+// Negative if (value, id) comes before (otherValue, otherID) in the page's sort order
+func (p PageRequest) compare(value primitive.DateTime, id primitive.ObjectID, otherValue primitive.DateTime, otherID primitive.ObjectID) int {
+	c := 0
+	switch {
+	case value < otherValue:
+		c = -1
+	case value > otherValue:
+		c = 1
+	default:
+		c = strings.Compare(id.Hex(), otherID.Hex())
+	}
+	return c * p.direction()
+}
+
+// Every field is optional; zero values do not filter
+type BetFilter struct {
+	Participant   string // creator or receiver
+	Status        *models.BetStatus
+	Underlying    string // matches conditional bets on it and parlays with it as a leg
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Only bets the viewer is allowed to see; see models.Bet.VisibleTo
+	Viewer        string
+	ViewerFriends []string
+}
+
+func (f BetFilter) mongoFilter() bson.M {
+	filter := bson.M{}
+	clauses := bson.A{}
+	if f.Participant != "" {
+		clauses = append(clauses, bson.M{"$or": bson.A{bson.M{"creatorname": f.Participant}, bson.M{"receivername": f.Participant}}})
+	}
+	if f.Viewer != "" {
+		friends := f.ViewerFriends
+		if friends == nil {
+			friends = make([]string, 0)
+		}
+		clauses = append(clauses, bson.M{"$or": bson.A{
+			bson.M{"visibility": models.Public},
+			bson.M{"creatorname": f.Viewer},
+			bson.M{"receivername": f.Viewer},
+			bson.M{"arbiter": f.Viewer},
+			bson.M{
+				"visibility": bson.M{"$in": bson.A{models.FriendsOfParticipants, nil}},
+				"$or":        bson.A{bson.M{"creatorname": bson.M{"$in": friends}}, bson.M{"receivername": bson.M{"$in": friends}}},
+			},
+		}})
+	}
+	if f.Status != nil {
+		filter["overallstatus"] = *f.Status
+	}
+	if f.Underlying != "" {
+		clauses = append(clauses, bson.M{"$or": bson.A{bson.M{"underlying": f.Underlying}, bson.M{"legs.underlying": f.Underlying}}})
+	}
+	if len(clauses) > 0 {
+		filter["$and"] = clauses
+	}
+	created := bson.M{}
+	if !f.CreatedAfter.IsZero() {
+		created["$gte"] = primitive.NewDateTimeFromTime(f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		created["$lt"] = primitive.NewDateTimeFromTime(f.CreatedBefore)
+	}
+	if len(created) > 0 {
+		filter["createdate"] = created
+	}
+	return filter
+}
+
+func (f BetFilter) matches(bet models.Bet) bool {
+	if f.Participant != "" && bet.CreatorName != f.Participant && bet.ReceiverName != f.Participant {
+		return false
+	}
+	if f.Viewer != "" && !bet.VisibleTo(f.Viewer, f.ViewerFriends) {
+		return false
+	}
+	if f.Status != nil && bet.OverallStatus != *f.Status {
+		return false
+	}
+	if f.Underlying != "" && !bet.DerivedFrom(f.Underlying) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && bet.CreateDate.Time().Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !bet.CreateDate.Time().Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// Every field is optional; zero values do not filter
+type StakeFilter struct {
+	OwnerName  string
+	Underlying primitive.ObjectID
+}
+
+func (f StakeFilter) mongoFilter() bson.M {
+	filter := bson.M{}
+	if f.OwnerName != "" {
+		filter["ownername"] = f.OwnerName
+	}
+	if !f.Underlying.IsZero() {
+		filter["underlying"] = f.Underlying
+	}
+	return filter
+}
+
+func (f StakeFilter) matches(stake models.Stake) bool {
+	if f.OwnerName != "" && stake.OwnerName != f.OwnerName {
+		return false
+	}
+	if !f.Underlying.IsZero() && stake.Underlying != f.Underlying {
+		return false
+	}
+	return true
+}
+
+// Every field except Username is optional
+type NotificationFilter struct {
+	Username   string
+	UnreadOnly bool
+}
+
+func (f NotificationFilter) mongoFilter() bson.M {
+	filter := bson.M{"username": f.Username}
+	if f.UnreadOnly {
+		filter["read"] = false
+	}
+	return filter
+}
+
+func (f NotificationFilter) matches(notification models.Notification) bool {
+	return notification.Username == f.Username && !(f.UnreadOnly && notification.Read)
+}
+
+// Cursor for the page after the one ending with this bet
+func BetCursor(bet models.Bet, sortBy string) Cursor {
+	return Cursor{SortValue: betSortValue(bet, sortBy), ID: bet.ID}
+}
+
+// Cursor for the page after the one ending with this stake
+func StakeCursor(stake models.Stake) Cursor {
+	return Cursor{SortValue: stake.CreateDate, ID: stake.ID}
+}
+
+// Cursor for the page after the one ending with this ledger entry
+func LedgerCursor(entry models.LedgerEntry) Cursor {
+	return Cursor{SortValue: entry.CreateDate, ID: entry.ID}
+}
+
+// Cursor for the page after the one ending with this notification
+func NotificationCursor(notification models.Notification) Cursor {
+	return Cursor{SortValue: notification.CreateDate, ID: notification.ID}
+}
+
+func betSortValue(bet models.Bet, sortBy string) primitive.DateTime {
+	if sortBy == SortByExpiryDate {
+		return bet.ExpiryDate
+	}
+	return bet.CreateDate
+}
+
+// Mongo find options for the page's sort order and size
+func (p PageRequest) findOptions() *options.FindOptions {
+	return options.Find().
+		SetSort(bson.D{{Key: p.SortBy, Value: p.direction()}, {Key: "_id", Value: p.direction()}}).
+		SetLimit(int64(p.Limit))
+}
+
+func (p PageRequest) mongoFilter(filter bson.M) bson.M {
+	if p.After == nil {
+		return filter
+	}
+	return bson.M{"$and": bson.A{filter, p.cursorFilter()}}
+}
+
+func (p PageRequest) validate(allowed ...string) error {
+	for _, field := range allowed {
+		if p.SortBy == field {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot sort by %q", p.SortBy)
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Returned by every store when the requested document does not exist
+var ErrNotFound = errors.New("document not found")
+
+// Fields of models.User that hold usernames; every other list field holds ObjectIDs
+var stringListFields = map[string]bool{
+	"outgoingfriendreqs": true,
+	"incomingfriendreqs": true,
+	"blockedusers":       true,
+	"friends":            true,
+}
+
+// Everything the controllers need to persist, bundled so it can be swapped out in one go
+type Stores struct {
+	Users         UserStore
+	Bets          BetStore
+	Stakes        StakeStore
+	Ledger        LedgerStore
+	Events        EventStore
+	Webhooks      WebhookStore
+	Deliveries    DeliveryStore
+	Notifications NotificationStore
+	Settlements   SettlementStore
+	Pools         PoolStore
+	Templates     TemplateStore
+	Schedules     ScheduleStore
+	Tx            Transactor
+}
+
+// Runs fn so that either all of its writes are applied or none are
+// fn must only use the ctx it is given, and may be retried, so it should re-read anything it depends on
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type UserStore interface {
+	FindByUsername(ctx context.Context, username string) (models.User, error)
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	CountByUsername(ctx context.Context, username string) (int64, error)
+	CountByEmail(ctx context.Context, email string) (int64, error)
+	Insert(ctx context.Context, user models.User) error
+	// Clears everything about the user except the username, which stays reserved so old bets still point at them
+	Tombstone(ctx context.Context, username string, deleteDate time.Time) error
+	// Removes the username from every other user's friend, friend request and blocked lists
+	PullFromAllLists(ctx context.Context, username string) error
+	// At most limit users whose username starts with prefix, in username order
+	// Deleted users and users who have blocked viewer are left out
+	Search(ctx context.Context, prefix string, viewer string, limit int) ([]models.User, error)
+	// Returns the user as it was before the increment
+	IncrementNumBets(ctx context.Context, username string) (models.User, error)
+	// Supports the "$push" and "$pullAll" operations on the list fields of models.User
+	UpdateList(ctx context.Context, update models.UpdateUserHelperStruct) error
+	// Adds a session, dropping the oldest ones so the user has at most limit
+	AddSession(ctx context.Context, username string, session models.Session, limit int) error
+	// Stores the session's new refresh token only if its current one is still oldRefreshToken
+	// Returns false, without changing anything, if another rotation or a sign out got there first
+	RotateSession(ctx context.Context, username string, family string, oldRefreshToken string, refreshToken string) (bool, error)
+	// Ends the session with the given family, or every session if family is empty
+	RevokeSessions(ctx context.Context, username string, family string) error
+	// Overwrites the balance projection; the ledger is the source of truth for these values
+	SetBalances(ctx context.Context, username string, balances map[string]int64, totalBalance int64) error
+	SetNotificationPrefs(ctx context.Context, username string, prefs models.NotificationPrefs) error
+	SetLastDigestDate(ctx context.Context, username string, date time.Time) error
+	// At most limit users who want a digest and last had one checked for before the given time, longest waiting first
+	// Deleted users are left out
+	FindDigestDue(ctx context.Context, before time.Time, limit int) ([]models.User, error)
+}
+
+type BetStore interface {
+	Insert(ctx context.Context, bet models.Bet) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Bet, error)
+	FindByBetID(ctx context.Context, betID string) (models.Bet, error)
+	Replace(ctx context.Context, bet models.Bet) error
+	// Undecided or conflicted bets whose underlying, or one of whose parlay legs, is the given BetID
+	FindDerived(ctx context.Context, betID string) ([]models.Bet, error)
+	// Undecided bets whose expiry date is before the given time
+	FindExpired(ctx context.Context, before time.Time) ([]models.Bet, error)
+	// At most page.Limit bets matching the filter, sorted by page.SortBy (createdate or expirydate)
+	List(ctx context.Context, filter BetFilter, page PageRequest) ([]models.Bet, error)
+}
+
+// Append-only; entries are never updated or deleted
+type LedgerStore interface {
+	Append(ctx context.Context, entries ...models.LedgerEntry) error
+	// Oldest entries first
+	ListByUsername(ctx context.Context, username string) ([]models.LedgerEntry, error)
+	// At most page.Limit of the user's entries, sorted by createdate
+	List(ctx context.Context, username string, page PageRequest) ([]models.LedgerEntry, error)
+}
+
+// Append-only log of models.Event, read back by the event stream
+type EventStore interface {
+	// Numbers the events in the order their transactions commit, so a reader that has seen an event's Seq
+	// never later finds an event with a smaller one; must be called inside a transaction
+	Append(ctx context.Context, events ...models.Event) error
+	// At most limit events whose audience includes username and whose Seq is after the given one, oldest first
+	// Pass 0 to read from the start of the log
+	ListAfter(ctx context.Context, username string, after int64, limit int) ([]models.Event, error)
+}
+
+type WebhookStore interface {
+	Insert(ctx context.Context, hook models.Webhook) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error)
+	// Oldest first
+	ListByOwner(ctx context.Context, owner string) ([]models.Webhook, error)
+	// Webhooks owned by any of the given users that subscribe to the event type
+	FindSubscribed(ctx context.Context, owners []string, eventType models.EventType) ([]models.Webhook, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	DeleteByOwner(ctx context.Context, owner string) error
+}
+
+// The webhook outbox and delivery log
+type DeliveryStore interface {
+	Insert(ctx context.Context, deliveries ...models.WebhookDelivery) error
+	// At most limit pending deliveries whose next attempt is at or before now, most overdue first
+	FindDue(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	Replace(ctx context.Context, delivery models.WebhookDelivery) error
+	// At most limit deliveries for the webhook, newest first
+	ListByWebhook(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]models.WebhookDelivery, error)
+}
+
+type NotificationStore interface {
+	Insert(ctx context.Context, notifications ...models.Notification) error
+	// At most page.Limit notifications matching the filter, sorted by createdate
+	List(ctx context.Context, filter NotificationFilter, page PageRequest) ([]models.Notification, error)
+	// Only marks notifications that belong to username; returns how many were unread before
+	MarkRead(ctx context.Context, username string, ids []primitive.ObjectID) (int64, error)
+	MarkAllRead(ctx context.Context, username string) (int64, error)
+	CountUnread(ctx context.Context, username string) (int64, error)
+	DeleteByUsername(ctx context.Context, username string) error
+}
+
+type SettlementStore interface {
+	Insert(ctx context.Context, settlement models.Settlement) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Settlement, error)
+	Replace(ctx context.Context, settlement models.Settlement) error
+	// Settlements the user is the payer or payee of, newest first
+	ListByUser(ctx context.Context, username string) ([]models.Settlement, error)
+}
+
+type PoolStore interface {
+	Insert(ctx context.Context, pool models.Pool) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Pool, error)
+	Replace(ctx context.Context, pool models.Pool) error
+	// Pools the user created, resolves or has bought into, newest first
+	ListByUser(ctx context.Context, username string) ([]models.Pool, error)
+}
+
+type TemplateStore interface {
+	Insert(ctx context.Context, template models.BetTemplate) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.BetTemplate, error)
+	// Oldest first
+	ListByOwner(ctx context.Context, owner string) ([]models.BetTemplate, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	DeleteByOwner(ctx context.Context, owner string) error
+}
+
+type ScheduleStore interface {
+	Insert(ctx context.Context, schedule models.BetSchedule) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.BetSchedule, error)
+	Replace(ctx context.Context, schedule models.BetSchedule) error
+	// Oldest first
+	ListByOwner(ctx context.Context, owner string) ([]models.BetSchedule, error)
+	// At most limit schedules that are not paused and whose next run is at or before now, most overdue first
+	FindDue(ctx context.Context, now time.Time, limit int) ([]models.BetSchedule, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	DeleteByTemplate(ctx context.Context, templateID primitive.ObjectID) error
+	DeleteByOwner(ctx context.Context, owner string) error
+}
+
+type StakeStore interface {
+	Insert(ctx context.Context, stake models.Stake) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Stake, error)
+	UpdateFilled(ctx context.Context, id primitive.ObjectID, sharesFilled int64) error
+	Replace(ctx context.Context, stake models.Stake) error
+	// Every stake placed on the given bet, oldest first
+	FindByUnderlying(ctx context.Context, betID primitive.ObjectID) ([]models.Stake, error)
+	// At most page.Limit stakes matching the filter, sorted by createdate
+	List(ctx context.Context, filter StakeFilter, page PageRequest) ([]models.Stake, error)
+}
@@ -0,0 +1,129 @@
+// Package eligibility decides whether a user may open a stake on a bet
+// Every rule that fails has its own error type, so callers can tell violations apart with errors.As
+package eligibility
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// Limits on staking; zero for a limit means no limit
+type Limits struct {
+	AllowBettorStakes bool  // whether the creator and receiver may stake on their own bet
+	MaxSharesPerUser  int64 // across all of one user's stakes on a bet
+	MaxSharesPerBet   int64 // across all stakes on a bet, on both sides
+}
+
+// What is known about a bet when a stake is requested
+type BetState struct {
+	Bet         models.Bet
+	Ongoing     bool  // accepted by the receiver; pending requests cannot be staked on
+	OwnerShares int64 // shares the stake owner already has on the bet, not counting voided stakes
+}
+
+type NotOwnerError struct {
+	Caller string
+	Owner  string
+}
+
+func (e *NotOwnerError) Error() string {
+	return fmt.Sprintf("current user %s cannot open stakes for %s", e.Caller, e.Owner)
+}
+
+type BetNotOngoingError struct {
+	Status models.BetStatus
+}
+
+func (e *BetNotOngoingError) Error() string {
+	switch e.Status {
+	case models.Undecided:
+		return "bet has not been accepted yet"
+	case models.Conflicted:
+		return "bet is conflicted"
+	case models.Expired:
+		return "bet has expired"
+	case models.Cancelled, models.Voided:
+		return "bet has been called off"
+	default:
+		return "bet has already been settled"
+	}
+}
+
+type BetExpiredError struct {
+	ExpiryDate time.Time
+}
+
+func (e *BetExpiredError) Error() string {
+	return fmt.Sprintf("bet expired at %s", e.ExpiryDate.Format(time.RFC3339))
+}
+
+type BettorStakeError struct {
+	Username string
+}
+
+func (e *BettorStakeError) Error() string {
+	return fmt.Sprintf("%s is a party to this bet and cannot stake on it", e.Username)
+}
+
+type InvalidSharesError struct {
+	NumShares int64
+}
+
+func (e *InvalidSharesError) Error() string {
+	return fmt.Sprintf("number of shares must be positive, got %d", e.NumShares)
+}
+
+type UserLimitError struct {
+	Limit     int64
+	Staked    int64 // already staked by the user on this bet
+	Requested int64
+}
+
+func (e *UserLimitError) Error() string {
+	return fmt.Sprintf("stake of %d shares would exceed the limit of %d shares per user on a bet (%d already staked)", e.Requested, e.Limit, e.Staked)
+}
+
+type BetLimitError struct {
+	Limit     int64
+	Staked    int64 // already staked on this bet by everyone
+	Requested int64
+}
+
+func (e *BetLimitError) Error() string {
+	return fmt.Sprintf("stake of %d shares would exceed the limit of %d shares on this bet (%d already staked)", e.Requested, e.Limit, e.Staked)
+}
+
+// Checks that the logged in user is the one the stake is for
+func CheckOwner(caller, owner string) error {
+	if caller != owner {
+		return &NotOwnerError{Caller: caller, Owner: owner}
+	}
+	return nil
+}
+
+// Checks every rule for a new stake of numShares by owner, returning the first violation
+func CheckStake(state BetState, owner string, numShares int64, limits Limits, now time.Time) error {
+	bet := state.Bet
+	if bet.OverallStatus != models.Undecided || !state.Ongoing {
+		return &BetNotOngoingError{Status: bet.OverallStatus}
+	}
+	if !bet.ExpiryDate.Time().After(now) {
+		return &BetExpiredError{ExpiryDate: bet.ExpiryDate.Time()}
+	}
+	if !limits.AllowBettorStakes && (owner == bet.CreatorName || owner == bet.ReceiverName) {
+		return &BettorStakeError{Username: owner}
+	}
+	if numShares <= 0 {
+		return &InvalidSharesError{NumShares: numShares}
+	}
+	if limits.MaxSharesPerUser > 0 && state.OwnerShares+numShares > limits.MaxSharesPerUser {
+		return &UserLimitError{Limit: limits.MaxSharesPerUser, Staked: state.OwnerShares, Requested: numShares}
+	}
+	betStaked := bet.CreatorStaked + bet.ReceiverStaked
+	if limits.MaxSharesPerBet > 0 && betStaked+numShares > limits.MaxSharesPerBet {
+		return &BetLimitError{Limit: limits.MaxSharesPerBet, Staked: betStaked, Requested: numShares}
+	}
+	return nil
+}
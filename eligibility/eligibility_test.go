@@ -0,0 +1,103 @@
+package eligibility
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var now = time.Date(2023, 3, 4, 12, 0, 0, 0, time.UTC)
+
+// An ongoing bet between alice and bob that expires in an hour, with 4 shares staked on it so far
+func ongoing() BetState {
+	return BetState{
+		Bet: models.Bet{
+			CreatorName:    "alice",
+			ReceiverName:   "bob",
+			OverallStatus:  models.Undecided,
+			ExpiryDate:     primitive.NewDateTimeFromTime(now.Add(time.Hour)),
+			CreatorStaked:  3,
+			ReceiverStaked: 1,
+		},
+		Ongoing: true,
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		owner  string
+		want   error
+	}{
+		{"own stake", "carol", "carol", nil},
+		{"someone else's stake", "carol", "dave", &NotOwnerError{Caller: "carol", Owner: "dave"}},
+		{"no caller", "", "dave", &NotOwnerError{Caller: "", Owner: "dave"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckOwner(tt.caller, tt.owner); !reflect.DeepEqual(err, tt.want) {
+				t.Fatalf("CheckOwner(%q, %q) = %v, want %v", tt.caller, tt.owner, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckStake(t *testing.T) {
+	with := func(change func(*BetState)) BetState {
+		state := ongoing()
+		change(&state)
+		return state
+	}
+	tests := []struct {
+		name      string
+		state     BetState
+		owner     string
+		numShares int64
+		limits    Limits
+		want      error
+	}{
+		{"allowed", ongoing(), "carol", 5, Limits{}, nil},
+		{"pending request", with(func(s *BetState) { s.Ongoing = false }), "carol", 5, Limits{}, &BetNotOngoingError{Status: models.Undecided}},
+		{"conflicted", with(func(s *BetState) { s.Bet.OverallStatus = models.Conflicted }), "carol", 5, Limits{}, &BetNotOngoingError{Status: models.Conflicted}},
+		{"settled", with(func(s *BetState) { s.Bet.OverallStatus = models.CreatorWon }), "carol", 5, Limits{}, &BetNotOngoingError{Status: models.CreatorWon}},
+		{"expires now", with(func(s *BetState) { s.Bet.ExpiryDate = primitive.NewDateTimeFromTime(now) }), "carol", 5, Limits{},
+			&BetExpiredError{ExpiryDate: primitive.NewDateTimeFromTime(now).Time()}},
+		{"creator", ongoing(), "alice", 5, Limits{}, &BettorStakeError{Username: "alice"}},
+		{"receiver", ongoing(), "bob", 5, Limits{}, &BettorStakeError{Username: "bob"}},
+		{"bettor when allowed", ongoing(), "bob", 5, Limits{AllowBettorStakes: true}, nil},
+		{"no shares", ongoing(), "carol", 0, Limits{}, &InvalidSharesError{NumShares: 0}},
+		{"negative shares", ongoing(), "carol", -2, Limits{}, &InvalidSharesError{NumShares: -2}},
+		{"up to the user limit", with(func(s *BetState) { s.OwnerShares = 3 }), "carol", 2, Limits{MaxSharesPerUser: 5}, nil},
+		{"over the user limit", with(func(s *BetState) { s.OwnerShares = 3 }), "carol", 3, Limits{MaxSharesPerUser: 5},
+			&UserLimitError{Limit: 5, Staked: 3, Requested: 3}},
+		{"up to the bet limit", ongoing(), "carol", 6, Limits{MaxSharesPerBet: 10}, nil},
+		{"over the bet limit", ongoing(), "carol", 7, Limits{MaxSharesPerBet: 10}, &BetLimitError{Limit: 10, Staked: 4, Requested: 7}},
+		// Rules are checked in order, so the first one broken is the one reported
+		{"bettor over the limits", ongoing(), "alice", 50, Limits{MaxSharesPerUser: 5, MaxSharesPerBet: 10}, &BettorStakeError{Username: "alice"}},
+		{"over both limits", ongoing(), "carol", 50, Limits{MaxSharesPerUser: 5, MaxSharesPerBet: 10}, &UserLimitError{Limit: 5, Staked: 0, Requested: 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckStake(tt.state, tt.owner, tt.numShares, tt.limits, now); !reflect.DeepEqual(err, tt.want) {
+				t.Fatalf("CheckStake by %s of %d shares = %v, want %v", tt.owner, tt.numShares, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorsAreDistinguishable(t *testing.T) {
+	err := CheckStake(ongoing(), "alice", 5, Limits{}, now)
+	var bettor *BettorStakeError
+	if !errors.As(err, &bettor) || bettor.Username != "alice" {
+		t.Fatalf("CheckStake by the creator gave %v, want a BettorStakeError", err)
+	}
+	var limit *UserLimitError
+	if errors.As(err, &limit) {
+		t.Fatalf("BettorStakeError %v also matched UserLimitError", err)
+	}
+}
@@ -0,0 +1,155 @@
+// Package events publishes domain events to the event log and wakes up anyone streaming them
+// The log in database.EventStore is the source of truth; the bus only tells subscribers when to read it again
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Bus struct {
+	store  database.EventStore
+	mu     sync.Mutex
+	subs   map[string]map[chan struct{}]struct{} // keyed by username
+	closed bool
+	hooks  []func(ctx context.Context, event models.Event) error
+	tx     database.Transactor // from Transactor, used for events published outside a transaction
+}
+
+func NewBus(store database.EventStore) *Bus {
+	return &Bus{store: store, subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+type pendingKey struct{}
+
+// Usernames to wake once the surrounding transaction commits
+type pending struct {
+	mu        sync.Mutex
+	usernames []string
+}
+
+// Registers fn to run on every published event, with the publisher's ctx so it joins any transaction
+// Hooks should be registered before the bus is used
+func (b *Bus) OnPublish(fn func(ctx context.Context, event models.Event) error) {
+	b.hooks = append(b.hooks, fn)
+}
+
+// Appends the event to the log, runs the hooks and wakes the event's audience
+// Inside a transaction from Transactor, the event is only written and announced if the transaction commits
+// Outside one, it is published in a transaction of its own, since the event store must number it on commit
+func (b *Bus) Publish(ctx context.Context, event models.Event) error {
+	if _, ok := ctx.Value(pendingKey{}).(*pending); !ok && b.tx != nil {
+		return b.tx.WithTransaction(ctx, func(ctx context.Context) error {
+			return b.Publish(ctx, event)
+		})
+	}
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	if event.CreateDate == 0 {
+		event.CreateDate = primitive.NewDateTimeFromTime(time.Now())
+	}
+	if err := b.store.Append(ctx, event); err != nil {
+		return err
+	}
+	for _, hook := range b.hooks {
+		if err := hook(ctx, event); err != nil {
+			return err
+		}
+	}
+	if p, ok := ctx.Value(pendingKey{}).(*pending); ok {
+		p.mu.Lock()
+		p.usernames = append(p.usernames, event.Audience...)
+		p.mu.Unlock()
+		return nil
+	}
+	b.notify(event.Audience...)
+	return nil
+}
+
+// Returns a channel that receives a value whenever there may be new events for the user
+// Wake-ups are coalesced, so readers should always read the log up to the end; call the returned func to stop
+func (b *Bus) Subscribe(username string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs[username] == nil {
+		b.subs[username] = make(map[chan struct{}]struct{})
+	}
+	b.subs[username][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[username][ch]; !ok {
+			return
+		}
+		delete(b.subs[username], ch)
+		if len(b.subs[username]) == 0 {
+			delete(b.subs, username)
+		}
+	}
+}
+
+// Closes every subscription so long-lived streams end; used on shutdown
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, chans := range b.subs {
+		for ch := range chans {
+			close(ch)
+		}
+	}
+	b.subs = make(map[string]map[chan struct{}]struct{})
+}
+
+func (b *Bus) notify(usernames ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, username := range usernames {
+		for ch := range b.subs[username] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Wraps a transactor so events published inside a transaction are announced after it commits
+// Without this a subscriber could read the log before the transaction's events are visible and miss them
+func (b *Bus) Transactor(tx database.Transactor) database.Transactor {
+	b.tx = &transactor{tx: tx, bus: b}
+	return b.tx
+}
+
+type transactor struct {
+	tx  database.Transactor
+	bus *Bus
+}
+
+func (t *transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	p := &pending{}
+	err := t.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		// fn may be retried, and only the last attempt's events are committed
+		p.mu.Lock()
+		p.usernames = nil
+		p.mu.Unlock()
+		return fn(context.WithValue(ctx, pendingKey{}, p))
+	})
+	if err == nil {
+		t.bus.notify(p.usernames...)
+	}
+	return err
+}
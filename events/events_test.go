@@ -0,0 +1,118 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newBus() (*Bus, database.Stores) {
+	stores := database.NewMemoryStores()
+	bus := NewBus(stores.Events)
+	stores.Tx = bus.Transactor(stores.Tx)
+	return bus, stores
+}
+
+func listAll(t *testing.T, store database.EventStore, username string, after int64) []models.Event {
+	t.Helper()
+	events, err := store.ListAfter(context.Background(), username, after, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestRolledBackEventsAreNeverListed(t *testing.T) {
+	bus, stores := newBus()
+	wake, unsubscribe := bus.Subscribe("alice")
+	defer unsubscribe()
+
+	failed := errors.New("failed")
+	err := stores.Tx.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := bus.Publish(ctx, models.Event{Type: models.BetResolved, Audience: []string{"alice"}}); err != nil {
+			return err
+		}
+		if events := listAll(t, stores.Events, "alice", 0); len(events) != 0 {
+			t.Errorf("uncommitted event was listed: %+v", events)
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("transaction gave %v, want %v", err, failed)
+	}
+	if events := listAll(t, stores.Events, "alice", 0); len(events) != 0 {
+		t.Fatalf("rolled back event was listed: %+v", events)
+	}
+	select {
+	case <-wake:
+		t.Fatal("subscriber was woken for a rolled back event")
+	default:
+	}
+}
+
+func TestSeqFollowsCommitOrder(t *testing.T) {
+	bus, stores := newBus()
+	first := models.Event{ID: primitive.NewObjectID(), Type: models.BetResolved, Audience: []string{"alice"}}
+	second := models.Event{ID: primitive.NewObjectID(), Type: models.StakePaidOut, Audience: []string{"alice"}}
+
+	// first is created and published before second but commits after it, as a slow transaction would
+	var seen []models.Event
+	err := stores.Tx.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := bus.Publish(ctx, first); err != nil {
+			return err
+		}
+		if err := stores.Events.Append(context.Background(), second); err != nil {
+			return err
+		}
+		seen = listAll(t, stores.Events, "alice", 0)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0].ID != second.ID {
+		t.Fatalf("reader saw %+v during the transaction, want only the committed event", seen)
+	}
+
+	// A reader resuming after the event it saw must still get the one committed later
+	events := listAll(t, stores.Events, "alice", seen[0].Seq)
+	if len(events) != 1 || events[0].ID != first.ID {
+		t.Fatalf("resuming after seq %d gave %+v, want the later commit", seen[0].Seq, events)
+	}
+	if events[0].Seq <= seen[0].Seq {
+		t.Fatalf("later commit got seq %d, not after %d", events[0].Seq, seen[0].Seq)
+	}
+}
+
+func TestPublishOutsideTransaction(t *testing.T) {
+	bus, stores := newBus()
+	wake, unsubscribe := bus.Subscribe("alice")
+	defer unsubscribe()
+
+	for i := 0; i < 3; i++ {
+		if err := bus.Publish(context.Background(), models.Event{Type: models.BetResolved, Audience: []string{"alice", "bob"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := listAll(t, stores.Events, "alice", 0)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	for i, event := range events {
+		if event.Seq != int64(i+1) {
+			t.Errorf("event %d has seq %d, want %d", i, event.Seq, i+1)
+		}
+	}
+	if events := listAll(t, stores.Events, "carol", 0); len(events) != 0 {
+		t.Errorf("event outside the audience was listed: %+v", events)
+	}
+	select {
+	case <-wake:
+	default:
+		t.Fatal("subscriber was not woken")
+	}
+}
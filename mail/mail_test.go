@@ -0,0 +1,224 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// What a fake SMTP server was sent: the envelope addresses and the message exactly as it came over the wire
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+// Accepts one SMTP session on localhost without authentication or TLS, and reports what it was sent
+func fakeSMTPServer(t *testing.T) (string, int, <-chan received) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	out := make(chan received, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		r := bufio.NewReader(conn)
+		reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+		var msg received
+		reply("220 localhost fake smtp")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0]); {
+			case verb == "EHLO" || verb == "HELO":
+				reply("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(cmd), "MAIL FROM:"):
+				msg.from = cmd[len("MAIL FROM:"):]
+				reply("250 ok")
+			case strings.HasPrefix(strings.ToUpper(cmd), "RCPT TO:"):
+				msg.to = append(msg.to, cmd[len("RCPT TO:"):])
+				reply("250 ok")
+			case verb == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				msg.data = data.String()
+				reply("250 queued")
+			case verb == "QUIT":
+				reply("221 bye")
+				out <- msg
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, out
+}
+
+func TestSMTPMailer(t *testing.T) {
+	host, port, sent := fakeSMTPServer(t)
+	mailer := NewSMTPMailer(host, port, "", "", "Betfr <noreply@betfr.test>")
+	err := mailer.Send(context.Background(), Message{
+		To:      "Alice Smith <alice@example.com>",
+		Subject: "Your digest\r\nBcc: mallory@example.com",
+		Body:    "line one\nline two\r\nline three\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got received
+	select {
+	case got = <-sent:
+	case <-time.After(10 * time.Second):
+		t.Fatal("fake SMTP server never finished the session")
+	}
+	// The envelope takes the bare addresses, while the headers keep the display names
+	if got.from != "<noreply@betfr.test>" || len(got.to) != 1 || got.to[0] != "<alice@example.com>" {
+		t.Fatalf("envelope from %s to %v", got.from, got.to)
+	}
+	headers, body, found := strings.Cut(got.data, "\r\n\r\n")
+	if !found {
+		t.Fatalf("no blank line between headers and body in %q", got.data)
+	}
+	for _, want := range []string{"From: Betfr <noreply@betfr.test>", "To: Alice Smith <alice@example.com>", "Subject: Your digest  Bcc: mallory@example.com"} {
+		if !strings.Contains(headers+"\r\n", want+"\r\n") {
+			t.Errorf("headers %q are missing %q", headers, want)
+		}
+	}
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf("subject added a header of its own: %q", headers)
+	}
+	// Every line break goes over the wire as CRLF, however the body was written
+	if body != "line one\r\nline two\r\nline three\r\n" {
+		t.Errorf("body went over the wire as %q", body)
+	}
+	if strings.Count(got.data, "\n") != strings.Count(got.data, "\r\n") {
+		t.Errorf("message has a bare line feed: %q", got.data)
+	}
+}
+
+func TestSMTPMailerRejectsBadAddresses(t *testing.T) {
+	// Nothing listens on this port, so a send that got as far as connecting would fail differently
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{"bad recipient", "noreply@betfr.test", "alice@example.com\r\nRCPT TO:<mallory@example.com>", "invalid recipient address"},
+		{"bad sender", "not an address", "alice@example.com", "invalid from address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewSMTPMailer("127.0.0.1", port, "", "", tt.from).Send(context.Background(), Message{To: tt.to, Subject: "hi", Body: "hi"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Send gave %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer := NewFileMailer(dir, "noreply@betfr.test")
+	for _, to := range []string{"alice@example.com", "../bob@example.com"} {
+		if err := mailer.Send(context.Background(), Message{To: to, Subject: "Hello", Body: "one\ntwo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The outbox is created on first use, and a recipient cannot steer the file out of it
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("outbox has %d files, want 2", len(files))
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".eml") {
+			t.Errorf("file %s is not an .eml file", file.Name())
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg := string(data); !strings.HasPrefix(msg, "From: noreply@betfr.test\r\n") || !strings.HasSuffix(msg, "\r\n\r\none\r\ntwo") {
+			t.Errorf("file %s holds %q", file.Name(), msg)
+		}
+	}
+	if parent, err := os.ReadDir(filepath.Dir(dir)); err != nil || len(parent) != 1 {
+		t.Errorf("files were written outside the outbox: %v %v", parent, err)
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Your digest", "Your digest"},
+		{"Hi\r\nBcc: mallory@example.com", "Hi  Bcc: mallory@example.com"},
+		{"Hi\nBcc: mallory@example.com", "Hi Bcc: mallory@example.com"},
+		{"Hi\rBcc: mallory@example.com", "Hi Bcc: mallory@example.com"},
+	}
+	for _, tt := range tests {
+		if got := headerValue(tt.in); got != tt.want {
+			t.Errorf("headerValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	now := time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	got := string(format("Betfr <noreply@betfr.test>", Message{To: "alice@example.com", Subject: "Hello", Body: "a\nb\r\nc\n\nd"}, now))
+	want := "From: Betfr <noreply@betfr.test>\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Sat, 04 Mar 2023 05:06:07 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"a\r\nb\r\nc\r\n\r\nd"
+	if got != want {
+		t.Fatalf("format gave\n%q\nwant\n%q", got, want)
+	}
+}
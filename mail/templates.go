@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+)
+
+// A message whose subject and body are filled in from data when it is rendered
+type Template struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("Mon 2 Jan 2006 15:04 MST") },
+}
+
+func mustTemplate(name string, subject string, body string) Template {
+	return Template{
+		subject: template.Must(template.New(name + " subject").Funcs(templateFuncs).Parse(subject)),
+		body:    template.Must(template.New(name + " body").Funcs(templateFuncs).Parse(body)),
+	}
+}
+
+func (t Template) Render(to string, data interface{}) (Message, error) {
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject.String(), Body: body.String()}, nil
+}
+
+// A bet as it is listed in the digest
+type DigestBet struct {
+	Title        string
+	Counterparty string // the other participant
+	ExpiryDate   time.Time
+}
+
+type DigestData struct {
+	Username     string
+	BetRequests  []DigestBet // waiting on the user's answer
+	ExpiringBets []DigestBet // ongoing and close to their expiry date
+}
+
+var DigestTemplate = mustTemplate("digest",
+	`Your betfr digest`,
+	`Hi {{.Username}},
+{{if .BetRequests}}
+These bet requests are waiting on your answer:
+{{range .BetRequests}}  - "{{.Title}}" from {{.Counterparty}}, expires {{date .ExpiryDate}}
+{{end}}{{end}}{{if .ExpiringBets}}
+These bets expire soon, so resolve them before they do:
+{{range .ExpiringBets}}  - "{{.Title}}" with {{.Counterparty}}, expires {{date .ExpiryDate}}
+{{end}}{{end}}
+You can change what the digest includes in your notification preferences.
+`)
@@ -0,0 +1,221 @@
+// Package matching pairs up stakes on the two sides of a bet
+// It has no storage or framework dependencies; callers load resting orders, submit new ones and persist the fills
+package matching
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Side int8
+
+const (
+	BackCreator Side = iota
+	BackReceiver
+)
+
+func (s Side) Opposite() Side {
+	if s == BackCreator {
+		return BackReceiver
+	}
+	return BackCreator
+}
+
+var (
+	ErrInvalidOdds    = errors.New("odds must be positive")
+	ErrInvalidShares  = errors.New("number of shares must be positive")
+	ErrDuplicateOrder = errors.New("order is already in the book")
+	ErrUnknownOrder   = errors.New("order is not in the book")
+)
+
+// The odds of a bet, as in models.Bet
+// If the creator wins, each backing share on the receiver's side pays CreatorAmount to the creator's side,
+// and if the receiver wins, each share on the creator's side pays ReceiverAmount to the receiver's side
+type Odds struct {
+	CreatorAmount  int64
+	ReceiverAmount int64
+}
+
+func (o Odds) validate() error {
+	if o.CreatorAmount <= 0 || o.ReceiverAmount <= 0 {
+		return ErrInvalidOdds
+	}
+	return nil
+}
+
+// Tokens put at risk by one share on the given side
+// A creator-side share risks ReceiverAmount to win CreatorAmount, and vice versa
+func (o Odds) Price(side Side) int64 {
+	if side == BackCreator {
+		return o.ReceiverAmount
+	}
+	return o.CreatorAmount
+}
+
+// Tokens won by one share on the given side
+func (o Odds) Payout(side Side) int64 {
+	return o.Price(side.Opposite())
+}
+
+// Converts an amount of tokens into whole shares on the given side
+// Returns the number of shares and the tokens left over, which are never staked
+func (o Odds) SharesForTokens(side Side, tokens int64) (int64, int64, error) {
+	if err := o.validate(); err != nil {
+		return 0, 0, err
+	}
+	if tokens <= 0 {
+		return 0, 0, fmt.Errorf("cannot stake %d tokens", tokens)
+	}
+	price := o.Price(side)
+	return tokens / price, tokens % price, nil
+}
+
+// A stake waiting in or being submitted to a book
+// Shares is the total size and Filled how much of it has been matched so far
+type Order struct {
+	ID     string
+	Side   Side
+	Shares int64
+	Filled int64
+}
+
+func (o Order) Remaining() int64 {
+	return o.Shares - o.Filled
+}
+
+// One match between a creator-side order and a receiver-side order
+// CreatorTokens and ReceiverTokens are what each side put at risk, so
+// CreatorTokens*CreatorAmount always equals ReceiverTokens*ReceiverAmount
+type Fill struct {
+	CreatorOrder   string
+	ReceiverOrder  string
+	Shares         int64
+	CreatorTokens  int64
+	ReceiverTokens int64
+}
+
+// A FIFO order book for one bet
+// Every order on a side trades at the same price, so price-time priority reduces to arrival order
+type Book struct {
+	odds   Odds
+	queues [2][]*Order
+	ids    map[string]bool
+}
+
+func NewBook(odds Odds) (*Book, error) {
+	if err := odds.validate(); err != nil {
+		return nil, err
+	}
+	return &Book{odds: odds, ids: make(map[string]bool)}, nil
+}
+
+func (b *Book) Odds() Odds {
+	return b.odds
+}
+
+// Puts an existing partially filled order at the back of its queue without matching it
+// Used to rebuild a book from storage; call it in arrival order
+func (b *Book) Restore(order Order) error {
+	if order.Shares <= 0 || order.Filled < 0 || order.Filled > order.Shares {
+		return ErrInvalidShares
+	}
+	if b.ids[order.ID] {
+		return ErrDuplicateOrder
+	}
+	if order.Remaining() == 0 {
+		return nil
+	}
+	b.ids[order.ID] = true
+	o := order
+	b.queues[order.Side] = append(b.queues[order.Side], &o)
+	return nil
+}
+
+// Matches a new order against the opposite queue, oldest first
+// Whatever is not filled rests at the back of the order's own queue
+// Returns the fills in the order they happened and the order as it stands afterwards
+func (b *Book) Submit(order Order) ([]Fill, Order, error) {
+	if order.Shares <= 0 || order.Filled != 0 {
+		return nil, order, ErrInvalidShares
+	}
+	if b.ids[order.ID] {
+		return nil, order, ErrDuplicateOrder
+	}
+
+	fills := make([]Fill, 0)
+	opposite := order.Side.Opposite()
+	queue := b.queues[opposite]
+	for len(queue) > 0 && order.Remaining() > 0 {
+		maker := queue[0]
+		shares := min64(order.Remaining(), maker.Remaining())
+		order.Filled += shares
+		maker.Filled += shares
+		fills = append(fills, b.fill(order, *maker, shares))
+		if maker.Remaining() == 0 {
+			delete(b.ids, maker.ID)
+			queue = queue[1:]
+		}
+	}
+	b.queues[opposite] = queue
+
+	if order.Remaining() > 0 {
+		b.ids[order.ID] = true
+		o := order
+		b.queues[order.Side] = append(b.queues[order.Side], &o)
+	}
+	return fills, order, nil
+}
+
+// Takes the unfilled remainder of an order out of the book
+// Returns the order as it was when removed; its filled part is unaffected
+func (b *Book) Cancel(id string) (Order, error) {
+	for side := range b.queues {
+		for i, o := range b.queues[side] {
+			if o.ID == id {
+				b.queues[side] = append(b.queues[side][:i:i], b.queues[side][i+1:]...)
+				delete(b.ids, id)
+				return *o, nil
+			}
+		}
+	}
+	return Order{}, ErrUnknownOrder
+}
+
+// Orders still waiting to be filled on a side, oldest first
+func (b *Book) Resting(side Side) []Order {
+	orders := make([]Order, 0, len(b.queues[side]))
+	for _, o := range b.queues[side] {
+		orders = append(orders, *o)
+	}
+	return orders
+}
+
+// Total unfilled shares waiting on a side
+func (b *Book) Unfilled(side Side) int64 {
+	var total int64
+	for _, o := range b.queues[side] {
+		total += o.Remaining()
+	}
+	return total
+}
+
+func (b *Book) fill(taker Order, maker Order, shares int64) Fill {
+	f := Fill{
+		Shares:         shares,
+		CreatorTokens:  shares * b.odds.Price(BackCreator),
+		ReceiverTokens: shares * b.odds.Price(BackReceiver),
+	}
+	if taker.Side == BackCreator {
+		f.CreatorOrder, f.ReceiverOrder = taker.ID, maker.ID
+	} else {
+		f.CreatorOrder, f.ReceiverOrder = maker.ID, taker.ID
+	}
+	return f
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,337 @@
+package matching
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func newTestBook(t *testing.T, odds Odds) *Book {
+	t.Helper()
+	book, err := NewBook(odds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+func TestSubmit(t *testing.T) {
+	odds := Odds{CreatorAmount: 3, ReceiverAmount: 2}
+	tests := []struct {
+		name     string
+		resting  []Order // submitted first, in order
+		order    Order
+		fills    []Fill
+		filled   int64
+		creator  []Order // resting afterwards
+		receiver []Order
+	}{
+		{
+			name:    "empty book rests the order",
+			order:   Order{ID: "a", Side: BackCreator, Shares: 4},
+			fills:   []Fill{},
+			creator: []Order{{ID: "a", Side: BackCreator, Shares: 4}},
+		},
+		{
+			name:    "same side never matches",
+			resting: []Order{{ID: "a", Side: BackCreator, Shares: 4}},
+			order:   Order{ID: "b", Side: BackCreator, Shares: 2},
+			fills:   []Fill{},
+			creator: []Order{{ID: "a", Side: BackCreator, Shares: 4}, {ID: "b", Side: BackCreator, Shares: 2}},
+		},
+		{
+			name:    "exact match empties the book",
+			resting: []Order{{ID: "a", Side: BackCreator, Shares: 4}},
+			order:   Order{ID: "b", Side: BackReceiver, Shares: 4},
+			fills:   []Fill{{CreatorOrder: "a", ReceiverOrder: "b", Shares: 4, CreatorTokens: 8, ReceiverTokens: 12}},
+			filled:  4,
+		},
+		{
+			name:    "oldest resting order fills first",
+			resting: []Order{{ID: "a", Side: BackReceiver, Shares: 2}, {ID: "b", Side: BackReceiver, Shares: 2}, {ID: "c", Side: BackReceiver, Shares: 2}},
+			order:   Order{ID: "d", Side: BackCreator, Shares: 3},
+			fills: []Fill{
+				{CreatorOrder: "d", ReceiverOrder: "a", Shares: 2, CreatorTokens: 4, ReceiverTokens: 6},
+				{CreatorOrder: "d", ReceiverOrder: "b", Shares: 1, CreatorTokens: 2, ReceiverTokens: 3},
+			},
+			filled:   3,
+			receiver: []Order{{ID: "b", Side: BackReceiver, Shares: 2, Filled: 1}, {ID: "c", Side: BackReceiver, Shares: 2}},
+		},
+		{
+			name:     "unfilled remainder of the taker rests",
+			resting:  []Order{{ID: "a", Side: BackCreator, Shares: 2}},
+			order:    Order{ID: "b", Side: BackReceiver, Shares: 5},
+			fills:    []Fill{{CreatorOrder: "a", ReceiverOrder: "b", Shares: 2, CreatorTokens: 4, ReceiverTokens: 6}},
+			filled:   2,
+			receiver: []Order{{ID: "b", Side: BackReceiver, Shares: 5, Filled: 2}},
+		},
+		{
+			name:    "partially filled maker keeps its place",
+			resting: []Order{{ID: "a", Side: BackCreator, Shares: 5}, {ID: "b", Side: BackCreator, Shares: 1}, {ID: "c", Side: BackReceiver, Shares: 2}},
+			order:   Order{ID: "d", Side: BackReceiver, Shares: 1},
+			fills:   []Fill{{CreatorOrder: "a", ReceiverOrder: "d", Shares: 1, CreatorTokens: 2, ReceiverTokens: 3}},
+			filled:  1,
+			creator: []Order{{ID: "a", Side: BackCreator, Shares: 5, Filled: 3}, {ID: "b", Side: BackCreator, Shares: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestBook(t, odds)
+			for _, o := range tt.resting {
+				if _, _, err := book.Submit(o); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fills, order, err := book.Submit(tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fills, tt.fills) {
+				t.Errorf("fills = %+v, want %+v", fills, tt.fills)
+			}
+			if order.Filled != tt.filled {
+				t.Errorf("filled = %d, want %d", order.Filled, tt.filled)
+			}
+			if got := book.Resting(BackCreator); len(got)+len(tt.creator) > 0 && !reflect.DeepEqual(got, tt.creator) {
+				t.Errorf("creator side = %+v, want %+v", got, tt.creator)
+			}
+			if got := book.Resting(BackReceiver); len(got)+len(tt.receiver) > 0 && !reflect.DeepEqual(got, tt.receiver) {
+				t.Errorf("receiver side = %+v, want %+v", got, tt.receiver)
+			}
+		})
+	}
+}
+
+func TestSubmitRejects(t *testing.T) {
+	book := newTestBook(t, Odds{CreatorAmount: 1, ReceiverAmount: 1})
+	if _, _, err := book.Submit(Order{ID: "a", Side: BackCreator, Shares: 2}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		order Order
+		err   error
+	}{
+		{"no shares", Order{ID: "b", Side: BackCreator}, ErrInvalidShares},
+		{"negative shares", Order{ID: "b", Side: BackCreator, Shares: -1}, ErrInvalidShares},
+		{"already filled", Order{ID: "b", Side: BackCreator, Shares: 2, Filled: 1}, ErrInvalidShares},
+		{"duplicate id", Order{ID: "a", Side: BackReceiver, Shares: 1}, ErrDuplicateOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := book.Submit(tt.order); err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got := book.Unfilled(BackCreator); got != 2 {
+				t.Fatalf("rejected order changed the book: %d creator shares unfilled", got)
+			}
+		})
+	}
+	if _, err := NewBook(Odds{CreatorAmount: 0, ReceiverAmount: 1}); err != ErrInvalidOdds {
+		t.Fatalf("NewBook with zero odds gave %v, want ErrInvalidOdds", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	book := newTestBook(t, Odds{CreatorAmount: 1, ReceiverAmount: 1})
+	for _, o := range []Order{{ID: "a", Side: BackCreator, Shares: 3}, {ID: "b", Side: BackCreator, Shares: 2}} {
+		if _, _, err := book.Submit(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := book.Submit(Order{ID: "c", Side: BackReceiver, Shares: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, err := book.Cancel("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Order{ID: "a", Side: BackCreator, Shares: 3, Filled: 1}); cancelled != want {
+		t.Fatalf("cancelled = %+v, want %+v", cancelled, want)
+	}
+	if got := book.Unfilled(BackCreator); got != 2 {
+		t.Fatalf("unfilled = %d, want 2", got)
+	}
+	if _, err := book.Cancel("a"); err != ErrUnknownOrder {
+		t.Fatalf("cancelling twice gave %v, want ErrUnknownOrder", err)
+	}
+	// c was fully filled so it never rested
+	if _, err := book.Cancel("c"); err != ErrUnknownOrder {
+		t.Fatalf("cancelling a filled order gave %v, want ErrUnknownOrder", err)
+	}
+	// The id is free again once the order has left the book
+	if _, _, err := book.Submit(Order{ID: "a", Side: BackReceiver, Shares: 2}); err != nil {
+		t.Fatalf("resubmitting a cancelled id: %v", err)
+	}
+	if got := book.Unfilled(BackCreator); got != 0 {
+		t.Fatalf("unfilled = %d after b was matched, want 0", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	book := newTestBook(t, Odds{CreatorAmount: 2, ReceiverAmount: 5})
+	tests := []struct {
+		name  string
+		order Order
+		err   error
+	}{
+		{"partially filled", Order{ID: "a", Side: BackCreator, Shares: 4, Filled: 1}, nil},
+		{"unfilled", Order{ID: "b", Side: BackCreator, Shares: 2}, nil},
+		{"fully filled is skipped", Order{ID: "c", Side: BackCreator, Shares: 2, Filled: 2}, nil},
+		{"overfilled", Order{ID: "d", Side: BackCreator, Shares: 2, Filled: 3}, ErrInvalidShares},
+		{"negative fill", Order{ID: "d", Side: BackCreator, Shares: 2, Filled: -1}, ErrInvalidShares},
+		{"duplicate id", Order{ID: "a", Side: BackReceiver, Shares: 1}, ErrDuplicateOrder},
+	}
+	for _, tt := range tests {
+		if err := book.Restore(tt.order); err != tt.err {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	want := []Order{{ID: "a", Side: BackCreator, Shares: 4, Filled: 1}, {ID: "b", Side: BackCreator, Shares: 2}}
+	if got := book.Resting(BackCreator); !reflect.DeepEqual(got, want) {
+		t.Fatalf("resting = %+v, want %+v", got, want)
+	}
+	if got := book.Unfilled(BackCreator); got != 5 {
+		t.Fatalf("unfilled = %d, want 5", got)
+	}
+
+	// Restored orders keep their priority and only their remainder can be matched
+	fills, _, err := book.Submit(Order{ID: "e", Side: BackReceiver, Shares: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFills := []Fill{
+		{CreatorOrder: "a", ReceiverOrder: "e", Shares: 3, CreatorTokens: 15, ReceiverTokens: 6},
+		{CreatorOrder: "b", ReceiverOrder: "e", Shares: 1, CreatorTokens: 5, ReceiverTokens: 2},
+	}
+	if !reflect.DeepEqual(fills, wantFills) {
+		t.Fatalf("fills = %+v, want %+v", fills, wantFills)
+	}
+}
+
+func TestSharesForTokens(t *testing.T) {
+	odds := Odds{CreatorAmount: 3, ReceiverAmount: 7}
+	tests := []struct {
+		side     Side
+		tokens   int64
+		shares   int64
+		leftover int64
+	}{
+		{BackCreator, 7, 1, 0},
+		{BackCreator, 20, 2, 6},
+		{BackReceiver, 20, 6, 2},
+		{BackReceiver, 2, 0, 2},
+	}
+	for _, tt := range tests {
+		shares, leftover, err := odds.SharesForTokens(tt.side, tt.tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shares != tt.shares || leftover != tt.leftover {
+			t.Errorf("SharesForTokens(%d, %d) = %d, %d, want %d, %d", tt.side, tt.tokens, shares, leftover, tt.shares, tt.leftover)
+		}
+	}
+	if _, _, err := odds.SharesForTokens(BackCreator, 0); err == nil {
+		t.Error("staking no tokens was allowed")
+	}
+}
+
+// Runs random sequences of submits, cancels and rebuilds, checking after every step that
+// each fill is balanced, and that every share submitted is filled, resting or cancelled exactly once
+func TestBookProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 300; run++ {
+		odds := Odds{CreatorAmount: rng.Int63n(20) + 1, ReceiverAmount: rng.Int63n(20) + 1}
+		book := newTestBook(t, odds)
+		submitted := make(map[string]Order)
+		filled := make(map[string]int64)
+		cancelled := make(map[string]int64)
+		var filledShares [2]int64
+
+		for step := 0; step < 60; step++ {
+			switch op := rng.Intn(10); {
+			case op < 7:
+				id := fmt.Sprint(step)
+				order := Order{ID: id, Side: Side(rng.Intn(2)), Shares: rng.Int63n(10) + 1}
+				fills, after, err := book.Submit(order)
+				if err != nil {
+					t.Fatal(err)
+				}
+				submitted[id] = order
+				var total int64
+				for _, f := range fills {
+					if f.CreatorTokens*odds.CreatorAmount != f.ReceiverTokens*odds.ReceiverAmount {
+						t.Fatalf("run %d: unbalanced fill %+v at odds %+v", run, f, odds)
+					}
+					if f.CreatorTokens != f.Shares*odds.Price(BackCreator) || f.ReceiverTokens != f.Shares*odds.Price(BackReceiver) {
+						t.Fatalf("run %d: fill %+v not priced at odds %+v", run, f, odds)
+					}
+					filled[f.CreatorOrder] += f.Shares
+					filled[f.ReceiverOrder] += f.Shares
+					filledShares[BackCreator] += f.Shares
+					filledShares[BackReceiver] += f.Shares
+					total += f.Shares
+				}
+				if after.Filled != total {
+					t.Fatalf("run %d: order reports %d filled, fills add up to %d", run, after.Filled, total)
+				}
+			case op < 9:
+				resting := append(book.Resting(BackCreator), book.Resting(BackReceiver)...)
+				if len(resting) == 0 {
+					continue
+				}
+				target := resting[rng.Intn(len(resting))]
+				o, err := book.Cancel(target.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cancelled[o.ID] += o.Remaining()
+			default:
+				// Rebuild the book as the stake controller does after loading it from storage
+				rebuilt := newTestBook(t, odds)
+				for _, side := range []Side{BackCreator, BackReceiver} {
+					for _, o := range book.Resting(side) {
+						if err := rebuilt.Restore(o); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				before := [2][]Order{book.Resting(BackCreator), book.Resting(BackReceiver)}
+				after := [2][]Order{rebuilt.Resting(BackCreator), rebuilt.Resting(BackReceiver)}
+				if !reflect.DeepEqual(before, after) {
+					t.Fatalf("run %d: rebuilt book %+v differs from %+v", run, after, before)
+				}
+				book = rebuilt
+			}
+
+			// Matching both sides pairs every filled share, and the book is never left crossed
+			if filledShares[BackCreator] != filledShares[BackReceiver] {
+				t.Fatalf("run %d: %d creator shares filled against %d receiver shares", run, filledShares[BackCreator], filledShares[BackReceiver])
+			}
+			if book.Unfilled(BackCreator) > 0 && book.Unfilled(BackReceiver) > 0 {
+				t.Fatalf("run %d: both sides have unfilled shares", run)
+			}
+			resting := make(map[string]int64)
+			var unfilled [2]int64
+			for _, side := range []Side{BackCreator, BackReceiver} {
+				for _, o := range book.Resting(side) {
+					resting[o.ID] = o.Remaining()
+					unfilled[side] += o.Remaining()
+					if o.Filled != filled[o.ID] {
+						t.Fatalf("run %d: order %s reports %d filled, fills add up to %d", run, o.ID, o.Filled, filled[o.ID])
+					}
+				}
+				if unfilled[side] != book.Unfilled(side) {
+					t.Fatalf("run %d: Unfilled = %d, resting orders add up to %d", run, book.Unfilled(side), unfilled[side])
+				}
+			}
+			for id, order := range submitted {
+				if got := filled[id] + resting[id] + cancelled[id]; got != order.Shares {
+					t.Fatalf("run %d: order %s of %d shares has %d filled, %d resting and %d cancelled", run, id, order.Shares, filled[id], resting[id], cancelled[id])
+				}
+			}
+		}
+	}
+}
@@ -6,26 +6,25 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/simhonchourasia/betfr-be/authentication"
 	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/database"
 )
 
-var Authentication gin.HandlerFunc = func(c *gin.Context) {
-	clientToken := c.Request.Header.Get("token")
-	if clientToken == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Missing authorization header"})
-		c.Abort()
-		return
-	}
-
-	claims, err := authentication.ValidateToken(clientToken)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		c.Abort()
-		return
+// Accepts an access token as Authorization: Bearer, the jwt cookie or the legacy token header
+// Puts an authentication.Principal into the context, or aborts with 401
+// Needs the user store to reject tokens that were revoked by logout or refresh token reuse
+func Authentication(users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authentication.Authenticate(c.Request.Context(), users, c)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="betfr"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		authentication.SetPrincipal(c, principal)
+
+		c.Next()
 	}
-
-	c.Set("username", claims.Username)
-
-	c.Next()
 }
 
 // https://stackoverflow.com/questions/29418478/go-gin-framework-cors
@@ -33,7 +32,7 @@ var CORSMiddleware gin.HandlerFunc = func(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Origin", config.GlobalConfig.OriginFE)
 	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 	if c.Request.Method == "OPTIONS" {
 		c.AbortWithStatus(204)
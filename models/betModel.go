@@ -10,8 +10,36 @@ const (
 	CreatorWon
 	ReceiverWon
 	Conflicted
+	Expired   // passed its expiry date without being resolved
+	Cancelled // request withdrawn by the creator before it was accepted
+	Voided    // called off by both parties; no balances move
 )
 
+// Who can see a bet, and so who can stake on it
+// The participants and the arbiter can always see their bets
+type BetVisibility int8
+
+const (
+	FriendsOfParticipants BetVisibility = iota // friends of the creator or receiver; the default
+	Private                                    // only the participants and the arbiter
+	Public                                     // every user
+)
+
+type BetType int8
+
+const (
+	Binary      BetType = iota // the creator or the receiver is reported as the winner; the default
+	OverUnder                  // both parties report a number, and the winner depends on which side of Line it falls
+	Parlay                     // the creator wins only if every leg resolves the way it picks; settled automatically
+	Conditional                // a binary bet that only goes ahead if Underlying resolves as Condition, and is voided otherwise
+)
+
+// One bet within a parlay and the way the parlay's creator says it will go
+type BetLeg struct {
+	Underlying string    `json:"underlying"` // uses BetID
+	Outcome    BetStatus `json:"outcome"`    // CreatorWon or ReceiverWon, in terms of the leg's own creator and receiver
+}
+
 type Bet struct {
 	ID                     primitive.ObjectID   `bson:"_id,omitempty"`
 	BetID                  *string              `json:"betid"` // concatenates username with bet number
@@ -29,11 +57,108 @@ type Bet struct {
 	ReceiverStakedUnfilled int64                `json:"receiverstakedunfilled"`
 	CreatorStakes          []primitive.ObjectID `json:"creatorstakes"` // queues of stakes; earlier stuff gets filled first
 	ReceiverStakes         []primitive.ObjectID `json:"receiverstakes"`
-	Underlying             *string              `json:"underlying"` // uses BetID
+	Underlying             *string              `json:"underlying"` // uses BetID; conditional bets only
 	Title                  string               `json:"title"`
 	Description            string               `json:"description"`
 	CreateDate             primitive.DateTime   `json:"createdate"`
 	ExpiryDate             primitive.DateTime   `json:"expirydate"`
+	CreatorCancel          bool                 `json:"creatorcancel"` // whether each party has proposed calling the bet off
+	ReceiverCancel         bool                 `json:"receivercancel"`
+	AwaitingResponse       string               `json:"awaitingresponse"` // who has to answer a pending request; the receiver unless countered
+	Negotiation            []BetTerms           `json:"negotiation"`      // every set of terms proposed, oldest first
+	Arbiter                string               `json:"arbiter"`          // mutual friend who rules on the bet if it is conflicted
+	CreatorArbiter         string               `json:"creatorarbiter"`   // arbiter each party has proposed since creation
+	ReceiverArbiter        string               `json:"receiverarbiter"`
+	Visibility             BetVisibility        `json:"visibility"`
+	Type                   BetType              `json:"type"`
+	Line                   *float64             `json:"line,omitempty"`          // over/under bets only, e.g. 42.5
+	CreatorTakesOver       bool                 `json:"creatortakesover"`        // over/under bets only; the receiver takes the under
+	CreatorResult          *float64             `json:"creatorresult,omitempty"` // the number each party has reported, for over/under bets
+	ReceiverResult         *float64             `json:"receiverresult,omitempty"`
+	Legs                   []BetLeg             `json:"legs,omitempty"` // parlay bets only
+	Condition              BetStatus            `json:"condition"`      // conditional bets only; how Underlying has to resolve
+	Activated              bool                 `json:"activated"`      // conditional bets only; set once Underlying resolves as Condition
+}
+
+// Who wins an over/under bet with the given result
+// A result exactly on the line is a push, and the bet is voided
+func (bet Bet) OverUnderOutcome(result float64) BetStatus {
+	if result == *bet.Line {
+		return Voided
+	}
+	if (result > *bet.Line) == bet.CreatorTakesOver {
+		return CreatorWon
+	}
+	return ReceiverWon
+}
+
+// How a parlay stands, given the overall status of each leg's bet keyed by BetID
+// It is lost as soon as any leg goes the other way, and won once every leg has gone the creator's way
+// Legs that expire or are called off are dropped, and if every leg is dropped the parlay is voided
+// Undecided while it could still go either way
+func (bet Bet) ParlayOutcome(legs map[string]BetStatus) BetStatus {
+	open := false
+	won := 0
+	for _, leg := range bet.Legs {
+		switch status := legs[leg.Underlying]; status {
+		case CreatorWon, ReceiverWon:
+			if status != leg.Outcome {
+				return ReceiverWon
+			}
+			won++
+		case Undecided, Conflicted:
+			open = true
+		}
+	}
+	if open {
+		return Undecided
+	}
+	if won == 0 {
+		return Voided
+	}
+	return CreatorWon
+}
+
+// Whether the bet is a conditional bet on, or a parlay with a leg on, the bet with the given BetID
+func (bet Bet) DerivedFrom(betID string) bool {
+	if bet.Underlying != nil && *bet.Underlying == betID {
+		return true
+	}
+	for _, leg := range bet.Legs {
+		if leg.Underlying == betID {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a user can see the bet, given that user's friends list
+// Friendship is mutual, so a friend of either participant has that participant in their own list
+func (bet Bet) VisibleTo(username string, friends []string) bool {
+	if username == bet.CreatorName || username == bet.ReceiverName || (bet.Arbiter != "" && username == bet.Arbiter) {
+		return true
+	}
+	switch bet.Visibility {
+	case Public:
+		return true
+	case FriendsOfParticipants:
+		for _, friend := range friends {
+			if friend == bet.CreatorName || friend == bet.ReceiverName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// One proposal of odds, size and expiry while a bet request is being negotiated
+type BetTerms struct {
+	ProposedBy     string             `json:"proposedby"`
+	CreatorAmount  int64              `json:"creatoramount"`
+	ReceiverAmount int64              `json:"receiveramount"`
+	NumShares      int64              `json:"numshares"`
+	ExpiryDate     primitive.DateTime `json:"expirydate"`
+	ProposeDate    primitive.DateTime `json:"proposedate"`
 }
 
 // CreatorAmount and ReceiverAmount are just betting odds
@@ -53,10 +178,30 @@ type Bet struct {
 type BetReqHandle struct {
 	BetID        primitive.ObjectID `json:"betid"`
 	BetReqStatus RequestStatus      `json:"betreqstatus"`
+	CounterTerms *BetTerms          `json:"counterterms"` // only used with CounterOffered
+}
+
+type BetCancel struct {
+	BetID    primitive.ObjectID `json:"betid"`
+	Username string             `json:"username"`
+	Cancel   bool               `json:"cancel"` // false withdraws an earlier proposal
+}
+
+type ArbiterProposal struct {
+	BetID    primitive.ObjectID `json:"betid"`
+	Username string             `json:"username"`
+	Arbiter  string             `json:"arbiter"`
+}
+
+type ArbiterRuling struct {
+	BetID    primitive.ObjectID `json:"betid"`
+	Username string             `json:"username"` // the arbiter
+	Ruling   BetStatus          `json:"ruling"`   // CreatorWon or ReceiverWon
 }
 
 type BetResolve struct {
 	BetID            primitive.ObjectID `json:"betid"`
-	BetResolveStatus BetStatus          `json:"betresolvestatus"`
+	BetResolveStatus BetStatus          `json:"betresolvestatus"` // binary bets only; over/under bets derive it from Result
+	Result           *float64           `json:"result"`           // over/under bets only
 	Username         string             `json:"username"`
 }
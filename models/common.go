@@ -8,4 +8,5 @@ const (
 	Declined
 	Unfriended
 	Blocked
+	CounterOffered
 )
@@ -0,0 +1,44 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type EventType string
+
+const (
+	FriendRequestSent     EventType = "FriendRequestSent"
+	FriendRequestAccepted EventType = "FriendRequestAccepted"
+	BetRequestCreated     EventType = "BetRequestCreated"
+	BetRequestAccepted    EventType = "BetRequestAccepted"
+	BetRequestDeclined    EventType = "BetRequestDeclined"
+	BetRequestCountered   EventType = "BetRequestCountered"
+	BetResolutionClaimed  EventType = "BetResolutionClaimed" // one party has said who won and the other has not yet
+	StakeFilled           EventType = "StakeFilled"
+	StakePaidOut          EventType = "StakePaidOut"
+	BetConflicted         EventType = "BetConflicted"
+	BetResolved           EventType = "BetResolved"
+	SettlementProposed    EventType = "SettlementProposed"
+	SettlementConfirmed   EventType = "SettlementConfirmed"
+	SettlementDeclined    EventType = "SettlementDeclined"
+	PoolResolved          EventType = "PoolResolved" // sent to each entrant with what they won or lost
+	PoolVoided            EventType = "PoolVoided"
+)
+
+// Something that happened which users may want to hear about straight away
+// Events are appended to a log and never changed; Seq orders them and is used as the SSE event id
+type Event struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Seq          int64               `json:"seq"` // set by the event store in the order events are committed
+	Type         EventType           `json:"type"`
+	Audience     []string            `json:"-"`                // usernames allowed to see the event
+	Actor        string              `json:"actor,omitempty"`  // user whose action caused the event, if any
+	Target       string              `json:"target,omitempty"` // user the event is aimed at, if any
+	BetID        *primitive.ObjectID `json:"betid,omitempty" bson:"betid,omitempty"`
+	StakeID      *primitive.ObjectID `json:"stakeid,omitempty" bson:"stakeid,omitempty"`
+	SettlementID *primitive.ObjectID `json:"settlementid,omitempty" bson:"settlementid,omitempty"`
+	PoolID       *primitive.ObjectID `json:"poolid,omitempty" bson:"poolid,omitempty"`
+	Shares       int64               `json:"shares,omitempty"`  // shares matched, for StakeFilled
+	Amount       int64               `json:"amount,omitempty"`  // tokens won, or lost if negative, for StakePaidOut and PoolResolved, or paid for settlements
+	Outcome      BetStatus           `json:"outcome,omitempty"` // winner for BetResolved, or claimed winner for BetResolutionClaimed
+	Result       *float64            `json:"result,omitempty"`  // reported number, for BetResolutionClaimed on over/under bets
+	CreateDate   primitive.DateTime  `json:"createdate"`
+}
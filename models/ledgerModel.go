@@ -0,0 +1,40 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type LedgerDirection int8
+
+const (
+	Debit LedgerDirection = iota
+	Credit
+)
+
+// Entries are only ever appended; every transfer writes a debit and a credit sharing a TransferID
+// User.Balances and User.TotalBalance are projections of these entries
+type LedgerEntry struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	TransferID   primitive.ObjectID `json:"transferid"`
+	Username     string             `json:"username"`     // whose account the entry is on
+	Counterparty string             `json:"counterparty"` // the other side of the transfer
+	Direction    LedgerDirection    `json:"direction"`
+	Amount       int64              `json:"amount"` // always positive; Direction gives the sign
+	BetID        primitive.ObjectID `json:"betid"`
+	StakeID      primitive.ObjectID `json:"stakeid,omitempty" bson:"stakeid,omitempty"`
+	SettlementID primitive.ObjectID `json:"settlementid,omitempty" bson:"settlementid,omitempty"`
+	PoolID       primitive.ObjectID `json:"poolid,omitempty" bson:"poolid,omitempty"`
+	CreateDate   primitive.DateTime `json:"createdate"`
+}
+
+// One page of GET /users/ledger; NextCursor is empty on the last page
+type LedgerPage struct {
+	Entries    []LedgerEntry `json:"entries"`
+	NextCursor string        `json:"nextcursor"`
+}
+
+// Amount with the sign applied, as it affects the owner's balance
+func (e LedgerEntry) SignedAmount() int64 {
+	if e.Direction == Debit {
+		return -e.Amount
+	}
+	return e.Amount
+}
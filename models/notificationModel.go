@@ -0,0 +1,29 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// An inbox entry for one user, made from an event that needs their attention
+type Notification struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Username     string              `json:"username"` // whose inbox it is in
+	Type         EventType           `json:"type"`
+	EventID      primitive.ObjectID  `json:"eventid"`
+	Actor        string              `json:"actor,omitempty"`
+	BetID        *primitive.ObjectID `json:"betid,omitempty" bson:"betid,omitempty"`
+	StakeID      *primitive.ObjectID `json:"stakeid,omitempty" bson:"stakeid,omitempty"`
+	SettlementID *primitive.ObjectID `json:"settlementid,omitempty" bson:"settlementid,omitempty"`
+	PoolID       *primitive.ObjectID `json:"poolid,omitempty" bson:"poolid,omitempty"`
+	Message      string              `json:"message"`
+	Read         bool                `json:"read"`
+	CreateDate   primitive.DateTime  `json:"createdate"`
+}
+
+type MarkReadRequest struct {
+	IDs []primitive.ObjectID `json:"ids" validate:"required"`
+}
+
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"nextcursor"`
+	Unread        int64          `json:"unread"`
+}
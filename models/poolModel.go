@@ -0,0 +1,97 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type PoolStatus int8
+
+const (
+	PoolOpen      PoolStatus = iota // taking buy-ins, or waiting on the resolver or a majority of votes
+	PoolPaidOut                     // resolved, and losers have paid winners
+	PoolCalledOff                   // nobody backed the winning outcome, or the pool was called off; no balances move
+)
+
+// One user's buy-in; each user backs a single outcome, and buying in again adds to the same entry
+type PoolEntry struct {
+	Username   string             `json:"username"`
+	Outcome    int                `json:"outcome"` // index into Pool.Outcomes
+	Amount     int64              `json:"amount"`
+	CreateDate primitive.DateTime `json:"createdate"`
+}
+
+type PoolVote struct {
+	Username string `json:"username"`
+	Outcome  int    `json:"outcome"`
+}
+
+// A bet between any number of users on one of several named outcomes
+// When it is resolved, everyone who backed another outcome pays what they bought in for, and that is split
+// between the entries on the winning outcome in proportion to their buy-ins (pari-mutuel)
+// Only the creator and the creator's friends can buy in
+type Pool struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatorName    string             `json:"creatorname"`
+	Title          string             `json:"title"`
+	Description    string             `json:"description"`
+	Outcomes       []string           `json:"outcomes"`
+	Resolver       string             `json:"resolver"` // rules on the outcome and cannot buy in; empty to go by a majority vote of the entrants
+	Entries        []PoolEntry        `json:"entries"`  // oldest first
+	Votes          []PoolVote         `json:"votes"`    // majority vote pools only; buy-ins stop once the first vote is cast
+	Status         PoolStatus         `json:"status"`
+	WinningOutcome *int               `json:"winningoutcome,omitempty"`
+	Transfers      []Debt             `json:"transfers"` // what each loser paid each winner on resolution
+	CloseDate      primitive.DateTime `json:"closedate"` // no buy-ins after this
+	CreateDate     primitive.DateTime `json:"createdate"`
+	ResolveDate    primitive.DateTime `json:"resolvedate"`
+}
+
+// The user's entry in the pool, if they have bought in
+func (pool Pool) Entry(username string) (PoolEntry, bool) {
+	for _, entry := range pool.Entries {
+		if entry.Username == username {
+			return entry, true
+		}
+	}
+	return PoolEntry{}, false
+}
+
+// Whether the user is the creator, the resolver or an entrant of the pool
+func (pool Pool) Involves(username string) bool {
+	if username == pool.CreatorName || username == pool.Resolver {
+		return true
+	}
+	_, ok := pool.Entry(username)
+	return ok
+}
+
+// Whether a user with the given friends can see the pool; friends of the creator can see it so they can buy in
+func (pool Pool) VisibleTo(username string, friends []string) bool {
+	if pool.Involves(username) {
+		return true
+	}
+	for _, friend := range friends {
+		if friend == pool.CreatorName {
+			return true
+		}
+	}
+	return false
+}
+
+type PoolRequest struct {
+	Title       string             `json:"title" validate:"required,max=100"`
+	Description string             `json:"description" validate:"max=1000"`
+	Outcomes    []string           `json:"outcomes" validate:"required,min=2,max=20,dive,required,max=100"`
+	Resolver    string             `json:"resolver" validate:"max=30"`
+	CloseDate   primitive.DateTime `json:"closedate" validate:"required"`
+}
+
+type PoolBuyIn struct {
+	PoolID  primitive.ObjectID `json:"poolid" validate:"required"`
+	Outcome int                `json:"outcome" validate:"min=0"`
+	Amount  int64              `json:"amount" validate:"required,min=1"`
+}
+
+// A ruling from the resolver, or a vote from an entrant when the pool has no resolver
+type PoolResolve struct {
+	PoolID  primitive.ObjectID `json:"poolid" validate:"required"`
+	Outcome int                `json:"outcome" validate:"min=0"`
+}
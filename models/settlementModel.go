@@ -0,0 +1,57 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type SettlementStatus int8
+
+const (
+	SettlementPending  SettlementStatus = iota // waiting on the payee
+	SettlementSettled                          // confirmed by the payee
+	SettlementRejected                         // declined by the payee
+)
+
+// One user owing another, either as it stands in their balances or as a payment that would clear it
+type Debt struct {
+	From   string `json:"from"` // who owes, or who should pay
+	To     string `json:"to"`
+	Amount int64  `json:"amount"` // always positive
+}
+
+// A payment in a settlement plan, with the debts it pays down on its way from the payer to the payee
+type Transfer struct {
+	Debt
+	Paid []Debt `json:"paid"`
+}
+
+// A payment made outside the app, proposed by the payer and confirmed by the payee
+// It pays down what the payer owes the payee, or for a transfer from a plan, a chain of debts from the
+// payer to the payee; everyone in the middle of the chain owes and is owed the same amount less
+type Settlement struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Payer       string             `json:"payer"`
+	Payee       string             `json:"payee"`
+	Amount      int64              `json:"amount"`
+	Paid        []Debt             `json:"paid"` // the debts paid down once the payee confirms
+	Status      SettlementStatus   `json:"status"`
+	CreateDate  primitive.DateTime `json:"createdate"`
+	ResolveDate primitive.DateTime `json:"resolvedate"` // when the payee confirmed or declined
+}
+
+type SettlementProposal struct {
+	Payee  string `json:"payee" validate:"required,min=1,max=30"`
+	Amount int64  `json:"amount" validate:"min=0"` // zero for everything the payer owes the payee
+	// The paid debts of a transfer from a settlement plan; leave out to pay down the payer's own debt to the payee
+	Paid []Debt `json:"paid" validate:"max=100"`
+}
+
+type SettlementResponse struct {
+	SettlementID primitive.ObjectID `json:"settlementid" validate:"required"`
+	Status       RequestStatus      `json:"status"` // Accepted or Declined
+}
+
+// What a group owes each other now, and the fewest payments that would clear it if everyone agreed to them
+type SettlementPlan struct {
+	Members   []string   `json:"members"`
+	Debts     []Debt     `json:"debts"`
+	Transfers []Transfer `json:"transfers"`
+}
@@ -13,16 +13,23 @@ type Stake struct {
 	BackingCreator bool               `json:"backingcreator"`
 	Comment        string             `json:"comment"`
 	CreateDate     primitive.DateTime `json:"createdate"`
+	Voided         bool               `json:"voided"` // set when the underlying bet is called off; voided stakes never pay out
 }
 
 type StakeRequest struct {
 	Underlying     primitive.ObjectID `json:"underlying"`
 	OwnerName      string             `json:"ownername"`
 	NumShares      int64              `bson:"numshares"`
+	NumTokens      int64              `json:"numtokens"` // alternative to NumShares; converted at the price for the chosen side
 	BackingCreator bool               `json:"backingcreator"`
 	Comment        string             `json:"comment"`
 }
 
+type StakeCancel struct {
+	StakeID  primitive.ObjectID `json:"stakeid"`
+	Username string             `json:"username"`
+}
+
 // UNUSED
 type StakeUpdate struct {
 	Underlying primitive.ObjectID `json:"underlying"`
@@ -0,0 +1,65 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Saved terms for a bet request that gets made again and again, by hand or on a schedule
+// Every bet issued from it is a fresh binary bet request from the owner to the receiver
+type BetTemplate struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerName      string             `json:"ownername"`    // creator of every bet issued from the template
+	ReceiverName   string             `json:"receivername"` // the counterparty
+	Title          string             `json:"title"`
+	Description    string             `json:"description"`
+	CreatorAmount  int64              `json:"creatoramount"`
+	ReceiverAmount int64              `json:"receiveramount"`
+	NumShares      int64              `json:"numshares"`
+	ExpiryHours    int                `json:"expiryhours"` // how long after being issued each bet expires
+	CreateDate     primitive.DateTime `json:"createdate"`
+}
+
+type BetTemplateRequest struct {
+	ReceiverName   string `json:"receivername" validate:"required,min=1,max=30"`
+	Title          string `json:"title" validate:"required,max=100"`
+	Description    string `json:"description" validate:"max=1000"`
+	CreatorAmount  int64  `json:"creatoramount" validate:"required,min=1"`
+	ReceiverAmount int64  `json:"receiveramount" validate:"required,min=1"`
+	NumShares      int64  `json:"numshares" validate:"min=0"` // 10 if left out, as for bets
+	ExpiryHours    int    `json:"expiryhours" validate:"required,min=1,max=8760"`
+}
+
+type ScheduleKind int8
+
+const (
+	WeeklySchedule ScheduleKind = iota // on Weekday at Hour:Minute
+	CronSchedule                       // whenever Cron matches, e.g. "0 19 * * 5"
+)
+
+// Issues a bet request from a template at regular times
+// If runs are missed, e.g. while the server is down, the next check issues a single bet request for them
+type BetSchedule struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	OwnerName  string              `json:"ownername"`
+	TemplateID primitive.ObjectID  `json:"templateid"`
+	Kind       ScheduleKind        `json:"kind"`
+	Weekday    int                 `json:"weekday"` // weekly schedules only; 0 is Sunday
+	Hour       int                 `json:"hour"`    // weekly schedules only
+	Minute     int                 `json:"minute"`  // weekly schedules only
+	Cron       string              `json:"cron,omitempty"`
+	TimeZone   string              `json:"timezone"` // IANA name the times are in, e.g. Europe/London
+	Paused     bool                `json:"paused"`
+	NextRun    primitive.DateTime  `json:"nextrun"`
+	LastRun    primitive.DateTime  `json:"lastrun"`
+	LastBetID  *primitive.ObjectID `json:"lastbetid,omitempty" bson:"lastbetid,omitempty"`
+	LastError  string              `json:"lasterror,omitempty"` // why the last run did not issue a bet, if it did not
+	CreateDate primitive.DateTime  `json:"createdate"`
+}
+
+type BetScheduleRequest struct {
+	TemplateID primitive.ObjectID `json:"templateid" validate:"required"`
+	Kind       ScheduleKind       `json:"kind"`
+	Weekday    int                `json:"weekday" validate:"min=0,max=6"`
+	Hour       int                `json:"hour" validate:"min=0,max=23"`
+	Minute     int                `json:"minute" validate:"min=0,max=59"`
+	Cron       string             `json:"cron" validate:"max=100"`
+	TimeZone   string             `json:"timezone" validate:"max=64"` // UTC if left out
+}
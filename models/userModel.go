@@ -7,8 +7,7 @@ type User struct {
 	Username           *string              `json:"username" validate:"required,min=1,max=30"`
 	Email              *string              `json:"email" validate:"email,required"`
 	Password           *string              `json:"password" validate:"required,min=6,max=100"`
-	Token              *string              `json:"token"`
-	RefreshToken       *string              `json:"refreshtoken"`
+	Sessions           []Session            `json:"sessions"` // one per device the user is signed in on, oldest first
 	OutgoingFriendReqs []string             `json:"outgoingfriendreqs"`
 	IncomingFriendReqs []string             `json:"incomingfriendreqs"`
 	BlockedUsers       []string             `json:"blockedusers"`
@@ -23,6 +22,38 @@ type User struct {
 	Balances           map[string]int64     `json:"balances"`
 	TotalBalance       int64                `json:"totalbalance"`
 	NumBets            int                  `json:"numbets"`
+	Deleted            bool                 `json:"deleted"` // tombstone left behind by account deletion; the username stays reserved
+	DeleteDate         primitive.DateTime   `json:"deletedate"`
+	NotificationPrefs  NotificationPrefs    `json:"notificationprefs"`
+	LastDigestDate     primitive.DateTime   `json:"lastdigestdate"` // when the digest email was last checked for; set at signup
+}
+
+// One login; every access/refresh pair minted since then shares the Family, so one stolen token can revoke them all
+type Session struct {
+	Family       string             `json:"family"`
+	RefreshToken string             `json:"refreshtoken"` // the only refresh token in the family that still works
+	CreateDate   primitive.DateTime `json:"createdate"`
+}
+
+// The user's session with the given token family, if they are still signed in on it
+func (u User) Session(family string) (Session, bool) {
+	for _, session := range u.Sessions {
+		if family != "" && session.Family == family {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
+
+// What a user wants to hear about in their digest email
+// No digest is sent to users who have turned everything off
+type NotificationPrefs struct {
+	DigestBetReqs      bool `json:"digestbetreqs"`      // bet requests waiting on the user's answer
+	DigestExpiringBets bool `json:"digestexpiringbets"` // ongoing bets that are close to their expiry date
+}
+
+func (prefs NotificationPrefs) WantsDigest() bool {
+	return prefs.DigestBetReqs || prefs.DigestExpiringBets
 }
 
 type UpdateUserHelperStruct struct {
@@ -33,6 +64,11 @@ type UpdateUserHelperStruct struct {
 	IdVal     primitive.ObjectID
 }
 
+type BlockRequest struct {
+	Blocker string `json:"blocker"`
+	Blocked string `json:"blocked"`
+}
+
 type FriendRequest struct {
 	Sender    *string        `json:"sender" validate:"required,min=1,max=30"`
 	Receiver  *string        `json:"receiver" validate:"required,min=1,max=30"`
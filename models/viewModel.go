@@ -0,0 +1,219 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Views are what the read endpoints return
+// They are built field by field so that Password, Token and RefreshToken can never leak
+
+type UserView struct {
+	ID                 primitive.ObjectID   `json:"id"`
+	Username           string               `json:"username"`
+	Email              string               `json:"email"`
+	OutgoingFriendReqs []string             `json:"outgoingfriendreqs"`
+	IncomingFriendReqs []string             `json:"incomingfriendreqs"`
+	BlockedUsers       []string             `json:"blockedusers"`
+	Friends            []string             `json:"friends"`
+	IncomingBetReqs    []primitive.ObjectID `json:"incomingbetreqs"`
+	OutgoingBetReqs    []primitive.ObjectID `json:"outgoingbetreqs"`
+	ResolvedBets       []primitive.ObjectID `json:"resolvedbets"`
+	ConflictedBets     []primitive.ObjectID `json:"conflictedbets"`
+	OngoingBets        []primitive.ObjectID `json:"ongoingbets"`
+	ResolvedStakes     []primitive.ObjectID `json:"resolvedstakes"`
+	OngoingStakes      []primitive.ObjectID `json:"ongoingstakes"`
+	Balances           map[string]int64     `json:"balances"`
+	TotalBalance       int64                `json:"totalbalance"`
+	NumBets            int                  `json:"numbets"`
+	// Not stored on the user; set by the endpoints that return the user's own view
+	UnreadNotifications int64 `json:"unreadnotifications"`
+}
+
+func NewUserView(user User) UserView {
+	view := UserView{
+		ID:                 user.ID,
+		OutgoingFriendReqs: user.OutgoingFriendReqs,
+		IncomingFriendReqs: user.IncomingFriendReqs,
+		BlockedUsers:       user.BlockedUsers,
+		Friends:            user.Friends,
+		IncomingBetReqs:    user.IncomingBetReqs,
+		OutgoingBetReqs:    user.OutgoingBetReqs,
+		ResolvedBets:       user.ResolvedBets,
+		ConflictedBets:     user.ConflictedBets,
+		OngoingBets:        user.OngoingBets,
+		ResolvedStakes:     user.ResolvedStakes,
+		OngoingStakes:      user.OngoingStakes,
+		Balances:           user.Balances,
+		TotalBalance:       user.TotalBalance,
+		NumBets:            user.NumBets,
+	}
+	if user.Username != nil {
+		view.Username = *user.Username
+	}
+	if user.Email != nil {
+		view.Email = *user.Email
+	}
+	return view
+}
+
+// What other users can see about someone, e.g. in search results
+type UserSummary struct {
+	Username string `json:"username"`
+	NumBets  int    `json:"numbets"`
+}
+
+func NewUserSummary(user User) UserSummary {
+	summary := UserSummary{NumBets: user.NumBets}
+	if user.Username != nil {
+		summary.Username = *user.Username
+	}
+	return summary
+}
+
+// Login returns the user along with the tokens for the new session
+type LoginResponse struct {
+	UserView
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshtoken"`
+}
+
+type DeleteAccountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"` // re-confirms the account owner
+	Force    bool   `json:"force"`    // call off open bets and stakes and settle balances instead of refusing
+}
+
+// Everything stored about a user, offered before their account is deleted
+type UserExport struct {
+	User       UserView           `json:"user"`
+	Bets       []BetView          `json:"bets"`
+	Stakes     []StakeView        `json:"stakes"`
+	Ledger     []LedgerEntry      `json:"ledger"`
+	ExportDate primitive.DateTime `json:"exportdate"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshtoken"`
+}
+
+type BetView struct {
+	ID                     primitive.ObjectID   `json:"id"`
+	BetID                  string               `json:"betid"`
+	OverallStatus          BetStatus            `json:"overallstatus"`
+	CreatorName            string               `json:"creatorname"`
+	ReceiverName           string               `json:"receivername"`
+	CreatorAmount          int64                `json:"creatoramount"`
+	ReceiverAmount         int64                `json:"receiveramount"`
+	NumShares              int64                `json:"numshares"`
+	CreatorStatus          BetStatus            `json:"creatorstatus"`
+	ReceiverStatus         BetStatus            `json:"receiverstatus"`
+	CreatorStaked          int64                `json:"creatorstaked"`
+	ReceiverStaked         int64                `json:"receiverstaked"`
+	CreatorStakedUnfilled  int64                `json:"creatorstakedunfilled"`
+	ReceiverStakedUnfilled int64                `json:"receiverstakedunfilled"`
+	CreatorStakes          []primitive.ObjectID `json:"creatorstakes"`
+	ReceiverStakes         []primitive.ObjectID `json:"receiverstakes"`
+	Underlying             string               `json:"underlying"`
+	Title                  string               `json:"title"`
+	Description            string               `json:"description"`
+	CreateDate             primitive.DateTime   `json:"createdate"`
+	ExpiryDate             primitive.DateTime   `json:"expirydate"`
+	CreatorCancel          bool                 `json:"creatorcancel"`
+	ReceiverCancel         bool                 `json:"receivercancel"`
+	AwaitingResponse       string               `json:"awaitingresponse"`
+	Negotiation            []BetTerms           `json:"negotiation"`
+	Arbiter                string               `json:"arbiter"`
+	CreatorArbiter         string               `json:"creatorarbiter"`
+	ReceiverArbiter        string               `json:"receiverarbiter"`
+	Visibility             BetVisibility        `json:"visibility"`
+	Type                   BetType              `json:"type"`
+	Line                   *float64             `json:"line,omitempty"`
+	CreatorTakesOver       bool                 `json:"creatortakesover"`
+	CreatorResult          *float64             `json:"creatorresult,omitempty"`
+	ReceiverResult         *float64             `json:"receiverresult,omitempty"`
+	Legs                   []BetLeg             `json:"legs,omitempty"`
+	Condition              BetStatus            `json:"condition"`
+	Activated              bool                 `json:"activated"`
+}
+
+func NewBetView(bet Bet) BetView {
+	view := BetView{
+		ID:                     bet.ID,
+		OverallStatus:          bet.OverallStatus,
+		CreatorName:            bet.CreatorName,
+		ReceiverName:           bet.ReceiverName,
+		CreatorAmount:          bet.CreatorAmount,
+		ReceiverAmount:         bet.ReceiverAmount,
+		NumShares:              bet.NumShares,
+		CreatorStatus:          bet.CreatorStatus,
+		ReceiverStatus:         bet.ReceiverStatus,
+		CreatorStaked:          bet.CreatorStaked,
+		ReceiverStaked:         bet.ReceiverStaked,
+		CreatorStakedUnfilled:  bet.CreatorStakedUnfilled,
+		ReceiverStakedUnfilled: bet.ReceiverStakedUnfilled,
+		CreatorStakes:          bet.CreatorStakes,
+		ReceiverStakes:         bet.ReceiverStakes,
+		Title:                  bet.Title,
+		Description:            bet.Description,
+		CreateDate:             bet.CreateDate,
+		ExpiryDate:             bet.ExpiryDate,
+		CreatorCancel:          bet.CreatorCancel,
+		ReceiverCancel:         bet.ReceiverCancel,
+		AwaitingResponse:       bet.AwaitingResponse,
+		Negotiation:            bet.Negotiation,
+		Arbiter:                bet.Arbiter,
+		CreatorArbiter:         bet.CreatorArbiter,
+		ReceiverArbiter:        bet.ReceiverArbiter,
+		Visibility:             bet.Visibility,
+		Type:                   bet.Type,
+		Line:                   bet.Line,
+		CreatorTakesOver:       bet.CreatorTakesOver,
+		CreatorResult:          bet.CreatorResult,
+		ReceiverResult:         bet.ReceiverResult,
+		Legs:                   bet.Legs,
+		Condition:              bet.Condition,
+		Activated:              bet.Activated,
+	}
+	if bet.BetID != nil {
+		view.BetID = *bet.BetID
+	}
+	if bet.Underlying != nil {
+		view.Underlying = *bet.Underlying
+	}
+	return view
+}
+
+type StakeView struct {
+	ID             primitive.ObjectID `json:"id"`
+	Underlying     primitive.ObjectID `json:"underlying"`
+	OwnerName      string             `json:"ownername"`
+	SharesStaked   int64              `json:"sharesstaked"`
+	SharesFilled   int64              `json:"sharesfilled"`
+	BackingCreator bool               `json:"backingcreator"`
+	Comment        string             `json:"comment"`
+	CreateDate     primitive.DateTime `json:"createdate"`
+	Voided         bool               `json:"voided"`
+}
+
+func NewStakeView(stake Stake) StakeView {
+	return StakeView{
+		ID:             stake.ID,
+		Underlying:     stake.Underlying,
+		OwnerName:      stake.OwnerName,
+		SharesStaked:   stake.SharesStaked,
+		SharesFilled:   stake.SharesFilled,
+		BackingCreator: stake.BackingCreator,
+		Comment:        stake.Comment,
+		CreateDate:     stake.CreateDate,
+		Voided:         stake.Voided,
+	}
+}
+
+// One page of a list endpoint; NextCursor is empty on the last page
+type BetPage struct {
+	Bets       []BetView `json:"bets"`
+	NextCursor string    `json:"nextcursor"`
+}
+
+type StakePage struct {
+	Stakes     []StakeView `json:"stakes"`
+	NextCursor string      `json:"nextcursor"`
+}
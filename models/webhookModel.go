@@ -0,0 +1,65 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type WebhookDeliveryStatus int8
+
+const (
+	DeliveryPending WebhookDeliveryStatus = iota
+	DeliverySucceeded
+	DeliveryFailed // gave up after the last retry, or the webhook was removed
+)
+
+// Events that can be sent to webhooks
+var WebhookEventTypes = []EventType{
+	BetRequestCreated,
+	BetRequestAccepted,
+	BetResolved,
+	BetConflicted,
+	StakeFilled,
+	StakePaidOut,
+}
+
+// Posts a user's events to a URL of their choosing
+// Each request carries an HMAC of the body made with Secret, so the receiver can check it came from us
+type Webhook struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerName  string             `json:"ownername"`
+	URL        string             `json:"url"`
+	Secret     string             `json:"-"` // only shown once, in the response to creating the webhook
+	EventTypes []EventType        `json:"eventtypes"`
+	CreateDate primitive.DateTime `json:"createdate"`
+}
+
+type WebhookRequest struct {
+	URL        string      `json:"url" validate:"required"`
+	EventTypes []EventType `json:"eventtypes" validate:"required"`
+}
+
+type WebhookAttempt struct {
+	AttemptDate primitive.DateTime `json:"attemptdate"`
+	StatusCode  int                `json:"statuscode,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// One event on its way to one webhook
+// Pending deliveries are the outbox; together with finished ones they make up the delivery log
+type WebhookDelivery struct {
+	ID          primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	WebhookID   primitive.ObjectID    `json:"webhookid"`
+	EventID     primitive.ObjectID    `json:"eventid"`
+	EventType   EventType             `json:"eventtype"`
+	Payload     string                `json:"payload"` // the same body is sent on every attempt
+	Status      WebhookDeliveryStatus `json:"status"`
+	Attempts    []WebhookAttempt      `json:"attempts"`
+	NextAttempt primitive.DateTime    `json:"nextattempt"`
+	CreateDate  primitive.DateTime    `json:"createdate"`
+}
+
+// Body of every webhook request
+type WebhookPayload struct {
+	DeliveryID primitive.ObjectID `json:"deliveryid"`
+	WebhookID  primitive.ObjectID `json:"webhookid"`
+	Username   string             `json:"username"` // owner of the webhook
+	Event      Event              `json:"event"`
+}
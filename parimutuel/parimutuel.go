@@ -0,0 +1,99 @@
+// Package parimutuel splits a pool between the entries that backed the winning outcome
+// It has no storage dependencies; callers pass in the entries and write the resulting transfers themselves
+package parimutuel
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// What each user wins from a pool, and the transfers from losers to winners that pay it
+// Every loser pays exactly what they bought in for, and the winners' shares add up to exactly the losing total
+type Payout struct {
+	Winnings  map[string]int64 // by winning username; a winner may get zero if the losing total is small
+	Transfers []models.Debt
+}
+
+// Works out the payout when the given outcome wins
+// Returns false if nobody backed the outcome, in which case the pool should be voided
+//
+// Tokens are whole numbers, so each winner first gets their share of the losing total rounded down,
+// and whatever is left over goes out one token at a time to the winners with the largest remainders
+// Winners with equal remainders are taken in entry order, so earlier buy-ins win ties
+func Resolve(entries []models.PoolEntry, winning int) (Payout, bool) {
+	winners := make([]models.PoolEntry, 0)
+	losers := make([]models.PoolEntry, 0)
+	var backed, losing int64
+	for _, entry := range entries {
+		if entry.Amount <= 0 {
+			continue
+		}
+		if entry.Outcome == winning {
+			winners = append(winners, entry)
+			backed += entry.Amount
+		} else {
+			losers = append(losers, entry)
+			losing += entry.Amount
+		}
+	}
+	if len(winners) == 0 {
+		return Payout{}, false
+	}
+
+	shares := split(winners, backed, losing)
+	payout := Payout{Winnings: make(map[string]int64, len(winners)), Transfers: make([]models.Debt, 0)}
+	for i, winner := range winners {
+		payout.Winnings[winner.Username] = shares[i]
+	}
+
+	// Losers pay winners in entry order, each loser moving on to the next winner once the current one is paid in full,
+	// which takes at most one transfer fewer than there are losers and winners combined
+	w := 0
+	owed := shares[0]
+	for _, loser := range losers {
+		left := loser.Amount
+		for left > 0 {
+			for owed == 0 {
+				w++
+				owed = shares[w]
+			}
+			amount := left
+			if owed < amount {
+				amount = owed
+			}
+			payout.Transfers = append(payout.Transfers, models.Debt{From: loser.Username, To: winners[w].Username, Amount: amount})
+			left -= amount
+			owed -= amount
+		}
+	}
+	return payout, true
+}
+
+// Each winner's share of losing, using the largest remainder method
+func split(winners []models.PoolEntry, backed int64, losing int64) []int64 {
+	shares := make([]int64, len(winners))
+	remainders := make([]uint64, len(winners))
+	var given int64
+	for i, winner := range winners {
+		// losing*amount can overflow an int64, so it is worked out in 128 bits; the quotient is at most losing
+		hi, lo := bits.Mul64(uint64(losing), uint64(winner.Amount))
+		quo, rem := bits.Div64(hi, lo, uint64(backed))
+		shares[i] = int64(quo)
+		remainders[i] = rem
+		given += shares[i]
+	}
+
+	order := make([]int, len(winners))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for i := int64(0); i < losing-given; i++ {
+		shares[order[i]]++
+	}
+	return shares
+}
@@ -0,0 +1,158 @@
+package parimutuel
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+func entry(username string, outcome int, amount int64) models.PoolEntry {
+	return models.PoolEntry{Username: username, Outcome: outcome, Amount: amount}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []models.PoolEntry
+		winning   int
+		winnings  map[string]int64
+		transfers []models.Debt
+	}{
+		{
+			name:      "single winner takes everything",
+			entries:   []models.PoolEntry{entry("a", 0, 10), entry("b", 1, 5), entry("c", 2, 7)},
+			winnings:  map[string]int64{"a": 12},
+			transfers: []models.Debt{{From: "b", To: "a", Amount: 5}, {From: "c", To: "a", Amount: 7}},
+		},
+		{
+			// 31 split 10:20 is 10.33 and 20.67, so the leftover token goes to b
+			name:      "largest remainder gets the leftover",
+			entries:   []models.PoolEntry{entry("a", 0, 10), entry("b", 0, 20), entry("c", 1, 30), entry("d", 2, 1)},
+			winnings:  map[string]int64{"a": 10, "b": 21},
+			transfers: []models.Debt{{From: "c", To: "a", Amount: 10}, {From: "c", To: "b", Amount: 20}, {From: "d", To: "b", Amount: 1}},
+		},
+		{
+			name:      "equal remainders go to earlier entries",
+			entries:   []models.PoolEntry{entry("a", 0, 1), entry("b", 0, 1), entry("c", 0, 1), entry("d", 1, 2)},
+			winnings:  map[string]int64{"a": 1, "b": 1, "c": 0},
+			transfers: []models.Debt{{From: "d", To: "a", Amount: 1}, {From: "d", To: "b", Amount: 1}},
+		},
+		{
+			name:      "nobody lost",
+			entries:   []models.PoolEntry{entry("a", 1, 4), entry("b", 1, 6)},
+			winning:   1,
+			winnings:  map[string]int64{"a": 0, "b": 0},
+			transfers: []models.Debt{},
+		},
+		{
+			name:      "empty entries are ignored",
+			entries:   []models.PoolEntry{entry("a", 0, 0), entry("b", 0, 3), entry("c", 1, 0), entry("d", 1, 3)},
+			winnings:  map[string]int64{"b": 3},
+			transfers: []models.Debt{{From: "d", To: "b", Amount: 3}},
+		},
+	}
+	for _, tt := range tests {
+		payout, ok := Resolve(tt.entries, tt.winning)
+		if !ok {
+			t.Errorf("%s: no winners", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(payout.Winnings, tt.winnings) {
+			t.Errorf("%s: winnings %v, want %v", tt.name, payout.Winnings, tt.winnings)
+		}
+		if !reflect.DeepEqual(payout.Transfers, tt.transfers) {
+			t.Errorf("%s: transfers %v, want %v", tt.name, payout.Transfers, tt.transfers)
+		}
+	}
+}
+
+func TestResolveWithoutWinners(t *testing.T) {
+	for _, entries := range [][]models.PoolEntry{
+		nil,
+		{entry("a", 0, 10), entry("b", 2, 5)},
+		{entry("a", 1, 0), entry("b", 0, 5)},
+	} {
+		if payout, ok := Resolve(entries, 1); ok {
+			t.Errorf("Resolve(%v) paid out %v with nobody on the winning outcome", entries, payout)
+		}
+	}
+}
+
+func TestResolveLargeAmounts(t *testing.T) {
+	entries := []models.PoolEntry{entry("a", 0, math.MaxInt64/2), entry("b", 0, 3), entry("c", 1, math.MaxInt64/2-10)}
+	payout, ok := Resolve(entries, 0)
+	if !ok {
+		t.Fatal("no winners")
+	}
+	if total := payout.Winnings["a"] + payout.Winnings["b"]; total != math.MaxInt64/2-10 {
+		t.Fatalf("winnings add up to %d, want %d", total, int64(math.MaxInt64/2-10))
+	}
+	if b := payout.Winnings["b"]; b < 2 || b > 3 {
+		t.Fatalf("b won %d for 3 tokens at just under even odds", b)
+	}
+}
+
+// Checks on random pools that losers pay exactly their buy-in, winners get exactly their winnings,
+// and each winner's share is their proportional share rounded one way or the other
+func TestResolveProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 5000; run++ {
+		entries := make([]models.PoolEntry, 0)
+		for i := 0; i < 1+rng.Intn(8); i++ {
+			entries = append(entries, entry(string(rune('a'+i)), rng.Intn(3), 1+rng.Int63n(100)))
+		}
+		payout, ok := Resolve(entries, 0)
+		var backed, losing, winners, losers int64
+		for _, e := range entries {
+			if e.Outcome == 0 {
+				backed += e.Amount
+				winners++
+			} else {
+				losing += e.Amount
+				losers++
+			}
+		}
+		if ok != (winners > 0) {
+			t.Fatalf("%v: Resolve returned %t with %d winners", entries, ok, winners)
+		}
+		if !ok {
+			continue
+		}
+
+		paid := make(map[string]int64)
+		received := make(map[string]int64)
+		for _, transfer := range payout.Transfers {
+			if transfer.Amount <= 0 {
+				t.Fatalf("%v: transfer %+v", entries, transfer)
+			}
+			paid[transfer.From] += transfer.Amount
+			received[transfer.To] += transfer.Amount
+		}
+		if max := winners + losers - 1; int64(len(payout.Transfers)) > max {
+			t.Fatalf("%v: %d transfers, want at most %d", entries, len(payout.Transfers), max)
+		}
+		var total int64
+		for _, e := range entries {
+			if e.Outcome != 0 {
+				if paid[e.Username] != e.Amount || received[e.Username] != 0 {
+					t.Fatalf("%v: loser %s paid %d and received %d", entries, e.Username, paid[e.Username], received[e.Username])
+				}
+				continue
+			}
+			won := payout.Winnings[e.Username]
+			if received[e.Username] != won || paid[e.Username] != 0 {
+				t.Fatalf("%v: winner %s won %d but received %d and paid %d", entries, e.Username, won, received[e.Username], paid[e.Username])
+			}
+			if floor := losing * e.Amount / backed; won != floor && won != floor+1 {
+				t.Fatalf("%v: winner %s got %d, proportional share rounds down to %d", entries, e.Username, won, floor)
+			}
+			total += won
+		}
+		if total != losing {
+			t.Fatalf("%v: winnings add up to %d, losers put in %d", entries, total, losing)
+		}
+	}
+}
@@ -5,11 +5,17 @@ import (
 	"github.com/simhonchourasia/betfr-be/controllers"
 )
 
-func UnprotectedBetRoutes(incomingRoutes *gin.Engine) {
+func UnprotectedBetRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
 }
 
-func ProtectedBetRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/bets/createbetreq", controllers.CreateBetReqFunc)
-	incomingRoutes.POST("/bets/handlebetreq", controllers.HandleBetReqFunc)
-	incomingRoutes.POST("/bets/resolvebet", controllers.ResolveBetFunc)
+func ProtectedBetRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.POST("/bets/createbetreq", ctl.CreateBetReqFunc)
+	incomingRoutes.POST("/bets/handlebetreq", ctl.HandleBetReqFunc)
+	incomingRoutes.POST("/bets/resolvebet", ctl.ResolveBetFunc)
+	incomingRoutes.POST("/bets/cancelbetreq", ctl.CancelBetReqFunc)
+	incomingRoutes.POST("/bets/proposecancel", ctl.ProposeCancelFunc)
+	incomingRoutes.POST("/bets/proposearbiter", ctl.ProposeArbiterFunc)
+	incomingRoutes.POST("/bets/arbitrate", ctl.ArbitrateFunc)
+	incomingRoutes.GET("/bets", ctl.ListBetsFunc)
+	incomingRoutes.GET("/bets/:id", ctl.GetBetFunc)
 }
@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+func ProtectedEventRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.GET("/events", ctl.StreamEventsFunc)
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+func ProtectedNotificationRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.GET("/notifications", ctl.ListNotificationsFunc)
+	incomingRoutes.POST("/notifications/markread", ctl.MarkNotificationsReadFunc)
+	incomingRoutes.POST("/notifications/markallread", ctl.MarkAllNotificationsReadFunc)
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+func ProtectedPoolRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.POST("/pools/create", ctl.CreatePoolFunc)
+	incomingRoutes.POST("/pools/buyin", ctl.BuyInPoolFunc)
+	incomingRoutes.POST("/pools/resolve", ctl.ResolvePoolFunc)
+	incomingRoutes.GET("/pools", ctl.ListPoolsFunc)
+	incomingRoutes.GET("/pools/:id", ctl.GetPoolFunc)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+func ProtectedSettlementRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.GET("/settlements", ctl.ListSettlementsFunc)
+	incomingRoutes.GET("/settlements/plan", ctl.GetSettlementPlanFunc)
+	incomingRoutes.POST("/settlements/propose", ctl.ProposeSettlementFunc)
+	incomingRoutes.POST("/settlements/confirm", ctl.ConfirmSettlementFunc)
+}
@@ -5,9 +5,11 @@ import (
 	"github.com/simhonchourasia/betfr-be/controllers"
 )
 
-func UnprotectedStakeRoutes(incomingRoutes *gin.Engine) {
+func UnprotectedStakeRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
 }
 
-func ProtectedStakeRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/stakes/createstake", controllers.CreateStakeFunc)
+func ProtectedStakeRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.POST("/stakes/createstake", ctl.CreateStakeFunc)
+	incomingRoutes.POST("/stakes/cancelstake", ctl.CancelStakeFunc)
+	incomingRoutes.GET("/stakes/:id", ctl.GetStakeFunc)
 }
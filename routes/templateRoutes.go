@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+func ProtectedTemplateRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.POST("/templates", ctl.CreateTemplateFunc)
+	incomingRoutes.GET("/templates", ctl.ListTemplatesFunc)
+	incomingRoutes.DELETE("/templates/:id", ctl.DeleteTemplateFunc)
+	incomingRoutes.POST("/templates/:id/issue", ctl.IssueTemplateFunc)
+	incomingRoutes.POST("/schedules", ctl.CreateScheduleFunc)
+	incomingRoutes.GET("/schedules", ctl.ListSchedulesFunc)
+	incomingRoutes.POST("/schedules/:id/pause", ctl.PauseScheduleFunc)
+	incomingRoutes.POST("/schedules/:id/resume", ctl.ResumeScheduleFunc)
+	incomingRoutes.DELETE("/schedules/:id", ctl.DeleteScheduleFunc)
+}
@@ -5,15 +5,25 @@ import (
 	"github.com/simhonchourasia/betfr-be/controllers"
 )
 
-func UnprotectedUserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", controllers.SignUpFunc)
-	incomingRoutes.POST("/users/login", controllers.LoginFunc)
-	incomingRoutes.GET("/users/get", controllers.GetUserFunc)
-	incomingRoutes.POST("/users/logout", controllers.LogoutFunc)
-	incomingRoutes.DELETE("/users/deleteuser", controllers.DeleteUserFunc)
+func UnprotectedUserRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.POST("/users/signup", ctl.SignUpFunc)
+	incomingRoutes.POST("/users/login", ctl.LoginFunc)
+	incomingRoutes.POST("/users/refresh", ctl.RefreshFunc)
 }
 
-func ProtectedUserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/sendfriendreq", controllers.SendFriendReqFunc)
-	incomingRoutes.POST("/users/handlefriendreq", controllers.ResolveFriendReqFunc)
+func ProtectedUserRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.GET("/users/get", ctl.GetUserFunc)
+	incomingRoutes.POST("/users/logout", ctl.LogoutFunc)
+	incomingRoutes.DELETE("/users/deleteuser", ctl.DeleteUserFunc)
+	incomingRoutes.GET("/users/export", ctl.ExportUserFunc)
+	incomingRoutes.POST("/users/sendfriendreq", ctl.SendFriendReqFunc)
+	incomingRoutes.POST("/users/handlefriendreq", ctl.ResolveFriendReqFunc)
+	incomingRoutes.GET("/users/ledger", ctl.GetLedgerFunc)
+	incomingRoutes.POST("/users/block", ctl.BlockUserFunc)
+	incomingRoutes.POST("/users/unblock", ctl.UnblockUserFunc)
+	incomingRoutes.GET("/users/search", ctl.SearchUsersFunc)
+	incomingRoutes.GET("/users/notificationprefs", ctl.GetNotificationPrefsFunc)
+	incomingRoutes.POST("/users/notificationprefs", ctl.SetNotificationPrefsFunc)
+	incomingRoutes.GET("/users/:username/bets", ctl.ListUserBetsFunc)
+	incomingRoutes.GET("/users/:username/stakes", ctl.ListUserStakesFunc)
 }
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+func ProtectedWebhookRoutes(incomingRoutes *gin.Engine, ctl *controllers.Controller) {
+	incomingRoutes.POST("/webhooks", ctl.CreateWebhookFunc)
+	incomingRoutes.GET("/webhooks", ctl.ListWebhooksFunc)
+	incomingRoutes.DELETE("/webhooks/:id", ctl.DeleteWebhookFunc)
+	incomingRoutes.GET("/webhooks/:id/deliveries", ctl.ListWebhookDeliveriesFunc)
+}
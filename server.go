@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/simhonchourasia/betfr-be/config"
+	"github.com/simhonchourasia/betfr-be/controllers"
+	"github.com/simhonchourasia/betfr-be/database"
+	"github.com/simhonchourasia/betfr-be/mail"
 	"github.com/simhonchourasia/betfr-be/middleware"
 	"github.com/simhonchourasia/betfr-be/routes"
+	"github.com/simhonchourasia/betfr-be/workers"
 )
 
 // testing
@@ -16,18 +29,37 @@ func main() {
 
 	port := config.GlobalConfig.Port
 
+	var stores database.Stores
+	if config.GlobalConfig.InMemory {
+		log.Println("Using in-memory stores; nothing will be persisted")
+		stores = database.NewMemoryStores()
+	} else {
+		stores = database.NewMongoStores(database.GetClient())
+	}
+	mailer, err := mail.NewMailer()
+	if err != nil {
+		panic("Error in mailer config: " + err.Error())
+	}
+	ctl := controllers.NewController(stores, mailer)
+
 	router := gin.New()
 	// TODO: specify trusted proxies
 	router.Use(gin.Logger())
 	router.Use(middleware.CORSMiddleware)
-	routes.UnprotectedUserRoutes(router) // Signup and login
-	routes.UnprotectedBetRoutes(router)
-	routes.UnprotectedStakeRoutes(router)
+	routes.UnprotectedUserRoutes(router, ctl) // Signup, login and token refresh
+	routes.UnprotectedBetRoutes(router, ctl)
+	routes.UnprotectedStakeRoutes(router, ctl)
 
-	router.Use(middleware.Authentication)
-	routes.ProtectedUserRoutes(router)
-	routes.ProtectedBetRoutes(router)
-	routes.ProtectedStakeRoutes(router)
+	router.Use(middleware.Authentication(stores.Users))
+	routes.ProtectedUserRoutes(router, ctl)
+	routes.ProtectedBetRoutes(router, ctl)
+	routes.ProtectedStakeRoutes(router, ctl)
+	routes.ProtectedEventRoutes(router, ctl)
+	routes.ProtectedWebhookRoutes(router, ctl)
+	routes.ProtectedNotificationRoutes(router, ctl)
+	routes.ProtectedSettlementRoutes(router, ctl)
+	routes.ProtectedPoolRoutes(router, ctl)
+	routes.ProtectedTemplateRoutes(router, ctl)
 
 	// API-2
 	router.GET("/api-1", func(c *gin.Context) {
@@ -41,5 +73,40 @@ func main() {
 		c.JSON(200, gin.H{"success": "Access granted for api-2"})
 	})
 
-	router.Run(":" + port)
+	sweeper := workers.NewExpirySweeper(ctl, time.Duration(config.GlobalConfig.ExpirySweepSecs)*time.Second)
+	sweeper.Start()
+	deliverer := workers.NewWebhookDeliverer(ctl, time.Duration(config.GlobalConfig.WebhookPollSecs)*time.Second)
+	deliverer.Start()
+	digests := workers.NewDigestMailer(ctl, time.Duration(config.GlobalConfig.DigestPollSecs)*time.Second)
+	digests.Start()
+	scheduler := workers.NewBetScheduler(ctl, time.Duration(config.GlobalConfig.SchedulePollSecs)*time.Second)
+	scheduler.Start()
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+	// Event streams never finish on their own, so end them when shutdown starts
+	server.RegisterOnShutdown(ctl.Bus.Close)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a shutdown signal, then let in-flight requests and background work finish
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown: %v\n", err)
+	}
+	sweeper.Stop()
+	deliverer.Stop()
+	digests.Stop()
+	scheduler.Stop()
 }
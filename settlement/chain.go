@@ -0,0 +1,305 @@
+package settlement
+
+import (
+	"sort"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// Fewest transfers that clear the debts, as in Simplify, each with the debts it pays down on the way from its
+// payer to its payee; no two transfers pay down the same part of a debt, so they can be settled in any order
+// Simplify can pair up users with no chain of debts between them; if it does, this falls back to one
+// transfer per chain, which can take a few more
+// Circles of debts are paid down by the first transfer through one of their members, or else the first
+// transfer; a group where everyone comes out even needs no transfers, so its circles are left as they are
+func Plan(debts []models.Debt) []models.Transfer {
+	remaining, cycles := cancelCycles(debts)
+	transfers, ok := routeAll(remaining, Simplify(remaining))
+	if !ok {
+		transfers = chains(remaining)
+	}
+	for _, cycle := range cycles {
+		if len(transfers) == 0 {
+			break
+		}
+		owner := 0
+		for i, transfer := range transfers {
+			if touches(transfer.Paid, cycle) {
+				owner = i
+				break
+			}
+		}
+		paid := newGraph(transfers[owner].Paid)
+		for _, debt := range cycle {
+			paid.add(debt.From, debt.To, debt.Amount)
+		}
+		transfers[owner].Paid = paid.debts()
+	}
+	return transfers
+}
+
+// How much paying down the debts moves from one user to the other, and whether that is all it does:
+// the payer pays down that much more than they are paid down, the payee the reverse, and everyone else
+// owes and is owed the same amount less
+func Payment(paid []models.Debt, from string, to string) (int64, bool) {
+	for _, debt := range paid {
+		if debt.Amount <= 0 || debt.From == debt.To {
+			return 0, false
+		}
+	}
+	nets := Nets(paid)
+	amount := nets[to]
+	if amount <= 0 || nets[from] != -amount {
+		return 0, false
+	}
+	for name, net := range nets {
+		if net != 0 && name != from && name != to {
+			return 0, false
+		}
+	}
+	return amount, true
+}
+
+// Debts by who owes them, then by who they are owed to
+type graph map[string]map[string]int64
+
+func newGraph(debts []models.Debt) graph {
+	g := make(graph)
+	for _, debt := range debts {
+		g.add(debt.From, debt.To, debt.Amount)
+	}
+	return g
+}
+
+func (g graph) add(from string, to string, amount int64) {
+	if g[from] == nil {
+		g[from] = make(map[string]int64)
+	}
+	g[from][to] += amount
+	if g[from][to] == 0 {
+		delete(g[from], to)
+	}
+}
+
+// Everyone who owes or is owed anything, sorted so plans come out the same every time
+func (g graph) names() []string {
+	seen := make(map[string]bool)
+	for from, owed := range g {
+		for to := range owed {
+			seen[from], seen[to] = true, true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g graph) owed(from string) []string {
+	names := make([]string, 0, len(g[from]))
+	for to, amount := range g[from] {
+		if amount > 0 {
+			names = append(names, to)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g graph) debts() []models.Debt {
+	debts := make([]models.Debt, 0)
+	for _, from := range g.names() {
+		for _, to := range g.owed(from) {
+			debts = append(debts, models.Debt{From: from, To: to, Amount: g[from][to]})
+		}
+	}
+	return debts
+}
+
+// Shortest chain of debts from a user to the first user found that done accepts, or nil if there is none
+func (g graph) path(from string, done func(string) bool) []string {
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if done(name) {
+			path := []string{name}
+			for name != from {
+				name = prev[name]
+				path = append([]string{name}, path...)
+			}
+			return path
+		}
+		for _, next := range g.owed(name) {
+			if _, seen := prev[next]; !seen {
+				prev[next] = name
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// Smallest debt along a chain of users who each owe the next
+func (g graph) bottleneck(path []string, limit int64) int64 {
+	for i := 1; i < len(path); i++ {
+		if owed := g[path[i-1]][path[i]]; owed < limit {
+			limit = owed
+		}
+	}
+	return limit
+}
+
+// Some circle of users who each owe the next, with the last owing the first, or nil if there is none
+func (g graph) cycle() []string {
+	onPath, finished := make(map[string]bool), make(map[string]bool)
+	path := make([]string, 0)
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		onPath[name] = true
+		path = append(path, name)
+		for _, next := range g.owed(name) {
+			if onPath[next] {
+				for i := range path {
+					if path[i] == next {
+						return append([]string(nil), path[i:]...)
+					}
+				}
+			}
+			if !finished[next] {
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		onPath[name] = false
+		finished[name] = true
+		return nil
+	}
+	for _, name := range g.names() {
+		if !finished[name] {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Pays down every circle of debts by its smallest debt, which clears at least one debt each time
+// Returns the debts left, which have no circles, and what was paid down on each circle
+func cancelCycles(debts []models.Debt) ([]models.Debt, [][]models.Debt) {
+	g := newGraph(debts)
+	cycles := make([][]models.Debt, 0)
+	for cycle := g.cycle(); cycle != nil; cycle = g.cycle() {
+		closed := append(cycle, cycle[0])
+		amount := g.bottleneck(closed, g[cycle[0]][cycle[1%len(cycle)]])
+		paid := make([]models.Debt, 0, len(cycle))
+		for i := 1; i < len(closed); i++ {
+			g.add(closed[i-1], closed[i], -amount)
+			paid = append(paid, models.Debt{From: closed[i-1], To: closed[i], Amount: amount})
+		}
+		cycles = append(cycles, paid)
+	}
+	return g.debts(), cycles
+}
+
+// Most that can be paid from one user to another along chains of debts, up to amount, and how much that
+// pays down on each debt
+// Augments along shortest chains, undoing earlier payments where that lets more through (Edmonds-Karp)
+func route(debts []models.Debt, from string, to string, amount int64) ([]models.Debt, int64) {
+	// residual holds what is still owed plus what has been paid the other way and could be undone
+	residual, paid := newGraph(debts), make(graph)
+	routed := int64(0)
+	for routed < amount && from != to {
+		path := residual.path(from, func(name string) bool { return name == to })
+		if path == nil {
+			break
+		}
+		push := residual.bottleneck(path, amount-routed)
+		for i := 1; i < len(path); i++ {
+			u, v := path[i-1], path[i]
+			residual.add(u, v, -push)
+			residual.add(v, u, push)
+			undone := paid[v][u]
+			if undone > push {
+				undone = push
+			}
+			paid.add(v, u, -undone)
+			paid.add(u, v, push-undone)
+		}
+		routed += push
+	}
+	return paid.debts(), routed
+}
+
+// Routes each transfer in turn along what the ones before it left, or reports that one could not be routed
+func routeAll(debts []models.Debt, transfers []models.Debt) ([]models.Transfer, bool) {
+	g := newGraph(debts)
+	routed := make([]models.Transfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		paid, amount := route(g.debts(), transfer.From, transfer.To, transfer.Amount)
+		if amount < transfer.Amount {
+			return nil, false
+		}
+		for _, debt := range paid {
+			g.add(debt.From, debt.To, -debt.Amount)
+		}
+		routed = append(routed, models.Transfer{Debt: transfer, Paid: paid})
+	}
+	return routed, true
+}
+
+// One transfer per chain from each user who owes overall to someone who is owed overall, with transfers
+// between the same two users combined
+// With no circles of debts, a user who owes overall always has a chain to someone who is owed
+func chains(debts []models.Debt) []models.Transfer {
+	g, nets := newGraph(debts), Nets(debts)
+	transfers := make([]models.Transfer, 0)
+	paid := make(map[models.Debt]graph) // keyed by payer and payee, with no amount
+	for _, debtor := range g.names() {
+		for nets[debtor] < 0 {
+			path := g.path(debtor, func(name string) bool { return nets[name] > 0 })
+			if path == nil {
+				break
+			}
+			creditor := path[len(path)-1]
+			amount := -nets[debtor]
+			if nets[creditor] < amount {
+				amount = nets[creditor]
+			}
+			amount = g.bottleneck(path, amount)
+			key := models.Debt{From: debtor, To: creditor}
+			if paid[key] == nil {
+				paid[key] = make(graph)
+				transfers = append(transfers, models.Transfer{Debt: key})
+			}
+			for i := 1; i < len(path); i++ {
+				g.add(path[i-1], path[i], -amount)
+				paid[key].add(path[i-1], path[i], amount)
+			}
+			nets[debtor] += amount
+			nets[creditor] -= amount
+		}
+	}
+	for i, transfer := range transfers {
+		transfers[i].Paid = paid[transfer.Debt].debts()
+		transfers[i].Amount = -Nets(transfers[i].Paid)[transfer.From]
+	}
+	return transfers
+}
+
+func touches(paid []models.Debt, cycle []models.Debt) bool {
+	for _, debt := range paid {
+		for _, link := range cycle {
+			if debt.From == link.From || debt.To == link.From {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package settlement
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name  string
+		debts []models.Debt
+		want  []models.Transfer
+	}{
+		{"nothing owed", nil, []models.Transfer{}},
+		{"single debt", []models.Debt{debt("a", "b", 5)}, []models.Transfer{
+			{Debt: debt("a", "b", 5), Paid: []models.Debt{debt("a", "b", 5)}},
+		}},
+		{"chain", []models.Debt{debt("a", "b", 10), debt("b", "c", 10)}, []models.Transfer{
+			{Debt: debt("a", "c", 10), Paid: []models.Debt{debt("a", "b", 10), debt("b", "c", 10)}},
+		}},
+		{"circle is paid down with the chain through it", []models.Debt{debt("a", "b", 10), debt("b", "c", 10), debt("c", "a", 3)}, []models.Transfer{
+			{Debt: debt("a", "c", 7), Paid: []models.Debt{debt("a", "b", 10), debt("b", "c", 10), debt("c", "a", 3)}},
+		}},
+		{"circle of equal debts is left", []models.Debt{debt("a", "b", 10), debt("b", "c", 10), debt("c", "a", 10)}, []models.Transfer{}},
+		{"two chains to the same payee", []models.Debt{debt("a", "b", 5), debt("b", "d", 5), debt("a", "c", 5), debt("c", "d", 5)}, []models.Transfer{
+			{Debt: debt("a", "d", 10), Paid: []models.Debt{debt("a", "b", 5), debt("a", "c", 5), debt("b", "d", 5), debt("c", "d", 5)}},
+		}},
+		// a and e come out even between them, but a owes c and d owes e, so a cannot pay e
+		{"only pairs up users with a chain between them", []models.Debt{debt("a", "c", 10), debt("d", "e", 10)}, []models.Transfer{
+			{Debt: debt("a", "c", 10), Paid: []models.Debt{debt("a", "c", 10)}},
+			{Debt: debt("d", "e", 10), Paid: []models.Debt{debt("d", "e", 10)}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Plan(tt.debts); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Plan(%v) = %v, want %v", tt.debts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteUndoesShorterChain(t *testing.T) {
+	// The shortest chain a-c-d has to be given up for a-c and b-d to both be used
+	debts := []models.Debt{debt("a", "b", 1), debt("a", "c", 1), debt("b", "c", 1), debt("c", "d", 1), debt("b", "d", 1)}
+	paid, routed := route(debts, "a", "d", 2)
+	if want := []models.Debt{debt("a", "b", 1), debt("a", "c", 1), debt("b", "d", 1), debt("c", "d", 1)}; routed != 2 || !reflect.DeepEqual(paid, want) {
+		t.Fatalf("route(%v) = %v, %d, want %v, 2", debts, paid, routed, want)
+	}
+}
+
+func TestPayment(t *testing.T) {
+	tests := []struct {
+		name   string
+		paid   []models.Debt
+		amount int64
+		ok     bool
+	}{
+		{"direct", []models.Debt{debt("a", "c", 4)}, 4, true},
+		{"chain", []models.Debt{debt("a", "b", 4), debt("b", "c", 4)}, 4, true},
+		{"with a circle", []models.Debt{debt("a", "b", 6), debt("b", "c", 6), debt("c", "a", 2)}, 4, true},
+		{"wrong way round", []models.Debt{debt("c", "a", 4)}, 0, false},
+		{"leaves someone out of pocket", []models.Debt{debt("a", "b", 4), debt("b", "c", 3)}, 0, false},
+		{"pays someone else", []models.Debt{debt("a", "c", 4), debt("d", "e", 1)}, 0, false},
+		{"negative amount", []models.Debt{debt("a", "c", 5), debt("a", "c", -1)}, 0, false},
+		{"nothing", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if amount, ok := Payment(tt.paid, "a", "c"); amount != tt.amount || ok != tt.ok {
+				t.Fatalf("Payment(%v) = %d, %t, want %d, %t", tt.paid, amount, ok, tt.amount, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPlanProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for iter := 0; iter < 2000; iter++ {
+		n := 2 + r.Intn(len(names)-1)
+		debts := make([]models.Debt, 0)
+		for k := r.Intn(15); k > 0; k-- {
+			i, j := r.Intn(n), r.Intn(n)
+			if i != j {
+				debts = append(debts, debt(names[i], names[j], int64(1+r.Intn(20))))
+			}
+		}
+
+		// Paying down every transfer's debts, in any order, leaves nobody owing anybody anything
+		plan := Plan(debts)
+		remaining := newGraph(debts)
+		for _, i := range r.Perm(len(plan)) {
+			transfer := plan[i]
+			if amount, ok := Payment(transfer.Paid, transfer.From, transfer.To); !ok || amount != transfer.Amount {
+				t.Fatalf("Plan(%v) transfer %v pays down %v", debts, transfer.Debt, transfer.Paid)
+			}
+			for _, debt := range transfer.Paid {
+				if remaining[debt.From][debt.To] < debt.Amount {
+					t.Fatalf("Plan(%v) pays down %v, more than is left of it", debts, debt)
+				}
+				remaining.add(debt.From, debt.To, -debt.Amount)
+			}
+		}
+		if left := remaining.debts(); len(plan) > 0 && len(left) != 0 {
+			t.Fatalf("after settling Plan(%v), %v is still owed", debts, left)
+		}
+		for name, net := range Nets(remaining.debts()) {
+			if net != 0 {
+				t.Fatalf("after settling Plan(%v), %s is %d off even", debts, name, net)
+			}
+		}
+		if simplified := Simplify(debts); len(plan) < len(simplified) {
+			t.Fatalf("Plan(%v) = %v has fewer transfers than Simplify's %v", debts, plan, simplified)
+		}
+	}
+}
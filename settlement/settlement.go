@@ -0,0 +1,137 @@
+// Package settlement works out who should pay whom to clear the debts within a group
+// It has no storage dependencies; callers pass in balances and write the resulting transfers themselves
+package settlement
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+// Simplify finds the fewest transfers exactly when at most this many users are owed or owe something overall
+// Past that it falls back to a greedy plan, which can take a few more transfers than needed
+const exactLimit = 12
+
+// Debts between members, read from each member's balances; balances with anyone outside the group are ignored
+// balances maps each member to their models.User.Balances, where a negative value is owed to that counterparty
+func GroupDebts(balances map[string]map[string]int64, members []string) []models.Debt {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	debts := make([]models.Debt, 0)
+	for _, from := range sorted {
+		for _, to := range sorted {
+			if amount := balances[from][to]; from != to && amount < 0 {
+				debts = append(debts, models.Debt{From: from, To: to, Amount: -amount})
+			}
+		}
+	}
+	return debts
+}
+
+// Net position of each user: positive if they are owed more than they owe
+func Nets(debts []models.Debt) map[string]int64 {
+	nets := make(map[string]int64)
+	for _, debt := range debts {
+		nets[debt.From] -= debt.Amount
+		nets[debt.To] += debt.Amount
+	}
+	return nets
+}
+
+// Fewest transfers that leave everyone with the same net position as the debts
+// Users who come out even, such as everyone in a circle of equal debts, pay and receive nothing
+func Simplify(debts []models.Debt) []models.Debt {
+	nets := Nets(debts)
+	names := make([]string, 0, len(nets))
+	for name, net := range nets {
+		if net != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) > exactLimit {
+		return settleGroup(names, nets)
+	}
+	transfers := make([]models.Debt, 0)
+	for _, group := range zeroSumGroups(names, nets) {
+		transfers = append(transfers, settleGroup(group, nets)...)
+	}
+	return transfers
+}
+
+// Splits the users into as many groups as possible that each net to zero
+// A group of k users can always be cleared with k-1 transfers, so more groups means fewer transfers overall
+func zeroSumGroups(names []string, nets map[string]int64) [][]string {
+	n := len(names)
+	full := 1<<n - 1
+	sums := make([]int64, full+1)
+	for mask := 1; mask <= full; mask++ {
+		sums[mask] = sums[mask&(mask-1)] + nets[names[bits.TrailingZeros(uint(mask))]]
+	}
+
+	// best[mask] is the most zero-sum groups mask splits into, and first[mask] is the group holding its lowest user
+	best := make([]int, full+1)
+	first := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		if sums[mask] != 0 {
+			continue
+		}
+		low := mask & -mask
+		rest := mask ^ low
+		for sub := rest; ; sub = (sub - 1) & rest {
+			group := sub | low
+			if sums[group] == 0 && (first[mask] == 0 || best[mask^group]+1 > best[mask]) {
+				best[mask] = best[mask^group] + 1
+				first[mask] = group
+			}
+			if sub == 0 {
+				break
+			}
+		}
+	}
+
+	groups := make([][]string, 0, best[full])
+	for mask := full; mask != 0; mask ^= first[mask] {
+		group := make([]string, 0)
+		for i := 0; i < n; i++ {
+			if first[mask]&(1<<i) != 0 {
+				group = append(group, names[i])
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Has the biggest debtor pay the biggest creditor until everyone is even
+// Each transfer clears at least one user, so a group of k users takes at most k-1 transfers
+func settleGroup(names []string, nets map[string]int64) []models.Debt {
+	remaining := make(map[string]int64, len(names))
+	for _, name := range names {
+		remaining[name] = nets[name]
+	}
+	transfers := make([]models.Debt, 0)
+	for {
+		debtor, creditor := "", ""
+		for _, name := range names {
+			if remaining[name] < 0 && (debtor == "" || remaining[name] < remaining[debtor]) {
+				debtor = name
+			}
+			if remaining[name] > 0 && (creditor == "" || remaining[name] > remaining[creditor]) {
+				creditor = name
+			}
+		}
+		if debtor == "" || creditor == "" {
+			return transfers
+		}
+		amount := -remaining[debtor]
+		if remaining[creditor] < amount {
+			amount = remaining[creditor]
+		}
+		transfers = append(transfers, models.Debt{From: debtor, To: creditor, Amount: amount})
+		remaining[debtor] += amount
+		remaining[creditor] -= amount
+	}
+}
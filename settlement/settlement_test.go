@@ -0,0 +1,106 @@
+package settlement
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/simhonchourasia/betfr-be/models"
+)
+
+func debt(from string, to string, amount int64) models.Debt {
+	return models.Debt{From: from, To: to, Amount: amount}
+}
+
+func TestGroupDebts(t *testing.T) {
+	balances := map[string]map[string]int64{
+		"alice": {"bob": -10, "carol": 4, "zed": -7},
+		"bob":   {"alice": 10},
+		"carol": {"alice": -4},
+	}
+	got := GroupDebts(balances, []string{"carol", "bob", "alice"})
+	want := []models.Debt{debt("carol", "alice", 4), debt("alice", "bob", 10)}
+	if !reflect.DeepEqual(sortDebts(got), sortDebts(want)) {
+		t.Fatalf("GroupDebts = %v, want %v (debts with zed are outside the group)", got, want)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name  string
+		debts []models.Debt
+		want  []models.Debt
+	}{
+		{"nothing owed", nil, []models.Debt{}},
+		{"single debt", []models.Debt{debt("a", "b", 5)}, []models.Debt{debt("a", "b", 5)}},
+		{"opposite debts cancel", []models.Debt{debt("a", "b", 5), debt("b", "a", 5)}, []models.Debt{}},
+		{"circle of equal debts", []models.Debt{debt("a", "b", 10), debt("b", "c", 10), debt("c", "a", 10)}, []models.Debt{}},
+		{"chain collapses", []models.Debt{debt("a", "b", 10), debt("b", "c", 10)}, []models.Debt{debt("a", "c", 10)}},
+		{"partial chain", []models.Debt{debt("a", "b", 10), debt("b", "c", 4)}, []models.Debt{debt("a", "b", 6), debt("a", "c", 4)}},
+		// Paying the biggest creditor first would take three transfers here
+		{"independent pairs", []models.Debt{debt("a", "x", 3), debt("b", "y", 2), debt("a", "y", 0)}, []models.Debt{debt("a", "x", 3), debt("b", "y", 2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Simplify(tt.debts)
+			if !reflect.DeepEqual(sortDebts(got), sortDebts(tt.want)) {
+				t.Fatalf("Simplify(%v) = %v, want %v", tt.debts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimplifyProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o"}
+	for iter := 0; iter < 2000; iter++ {
+		n := 2 + r.Intn(len(names)-1)
+		debts := make([]models.Debt, 0)
+		for k := r.Intn(20); k > 0; k-- {
+			i, j := r.Intn(n), r.Intn(n)
+			if i != j {
+				debts = append(debts, debt(names[i], names[j], int64(1+r.Intn(20))))
+			}
+		}
+
+		plan := Simplify(debts)
+		before, after := Nets(debts), Nets(plan)
+		owing := 0
+		for _, name := range names[:n] {
+			if before[name] != after[name] {
+				t.Fatalf("Simplify(%v) = %v changes the net position of %s", debts, plan, name)
+			}
+			if before[name] != 0 {
+				owing++
+			}
+		}
+		if owing > 0 && len(plan) > owing-1 {
+			t.Fatalf("Simplify(%v) = %v takes more than %d transfers", debts, plan, owing-1)
+		}
+		paying, paid := map[string]bool{}, map[string]bool{}
+		for _, transfer := range plan {
+			if transfer.Amount <= 0 || transfer.From == transfer.To {
+				t.Fatalf("Simplify(%v) gave invalid transfer %v", debts, transfer)
+			}
+			paying[transfer.From] = true
+			paid[transfer.To] = true
+		}
+		for name := range paying {
+			if paid[name] {
+				t.Fatalf("Simplify(%v) = %v has %s both paying and being paid", debts, plan, name)
+			}
+		}
+	}
+}
+
+func sortDebts(debts []models.Debt) []models.Debt {
+	sorted := append([]models.Debt{}, debts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].From != sorted[j].From {
+			return sorted[i].From < sorted[j].From
+		}
+		return sorted[i].To < sorted[j].To
+	})
+	return sorted
+}
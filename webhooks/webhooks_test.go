@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"event":{"type":"BetResolved"}}`)
+	signature := Sign(secret, 1700000000, body)
+	if !Verify(secret, 1700000000, body, signature) {
+		t.Fatal("signature did not verify")
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		signature string
+	}{
+		{"other secret", "not the secret", 1700000000, body, signature},
+		{"other timestamp", secret, 1700000001, body, signature},
+		{"other body", secret, 1700000000, []byte(`{"event":{"type":"BetConflicted"}}`), signature},
+		{"truncated signature", secret, 1700000000, body, signature[:len(signature)-1]},
+		{"no prefix", secret, 1700000000, body, signature[len("sha256="):]},
+		{"empty signature", secret, 1700000000, body, ""},
+	}
+	for _, tt := range tests {
+		if Verify(tt.secret, tt.timestamp, tt.body, tt.signature) {
+			t.Errorf("%s: tampered request verified", tt.name)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failedAttempts int
+		want           time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{MaxAttempts - 1, 32 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.failedAttempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.failedAttempts, got, tt.want)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, raw := range []string{"http://example.com/hook", "https://example.com:8443/hook?x=1"} {
+		if err := ValidateURL(raw); err != nil {
+			t.Errorf("ValidateURL(%q) = %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"", "example.com/hook", "ftp://example.com/hook", "https:///hook", "://"} {
+		if err := ValidateURL(raw); err == nil {
+			t.Errorf("ValidateURL(%q) allowed", raw)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	status := http.StatusNoContent
+	var got *http.Request
+	var gotBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	body := []byte(`{"hello":"world"}`)
+	now := time.Unix(1700000000, 0)
+	code, err := Send(context.Background(), receiver.URL, "secret", "delivery-1", "BetResolved", body, now)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("Send = %d, %v", code, err)
+	}
+	if string(gotBody) != string(body) {
+		t.Fatalf("receiver got body %q", gotBody)
+	}
+	timestamp, _ := strconv.ParseInt(got.Header.Get(TimestampHeader), 10, 64)
+	if timestamp != now.Unix() {
+		t.Errorf("timestamp header = %d, want %d", timestamp, now.Unix())
+	}
+	if !Verify("secret", timestamp, gotBody, got.Header.Get(SignatureHeader)) {
+		t.Error("receiver could not verify the signature")
+	}
+	if got.Header.Get(EventHeader) != "BetResolved" || got.Header.Get(DeliveryHeader) != "delivery-1" {
+		t.Errorf("event header %q, delivery header %q", got.Header.Get(EventHeader), got.Header.Get(DeliveryHeader))
+	}
+
+	status = http.StatusBadGateway
+	code, err = Send(context.Background(), receiver.URL, "secret", "delivery-1", "BetResolved", body, now)
+	if err == nil || code != http.StatusBadGateway {
+		t.Fatalf("Send to a failing receiver = %d, %v, want 502 and an error", code, err)
+	}
+}
@@ -0,0 +1,21 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+// Periodically issues bet requests for recurring schedules that are due
+// Schedules keep their next run in the database, so runs missed while the server was down are picked up on the first pass
+func NewBetScheduler(ctl *controllers.Controller, interval time.Duration) *Worker {
+	return NewWorker("bet scheduler", interval, func(ctx context.Context) error {
+		issued, err := ctl.RunSchedules(ctx, time.Now())
+		if issued > 0 {
+			log.Printf("Issued %d scheduled bet requests\n", issued)
+		}
+		return err
+	})
+}
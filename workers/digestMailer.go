@@ -0,0 +1,20 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+// Periodically emails digests to the users who are due one
+func NewDigestMailer(ctl *controllers.Controller, interval time.Duration) *Worker {
+	return NewWorker("digest mailer", interval, func(ctx context.Context) error {
+		sent, err := ctl.SendDigests(ctx, time.Now())
+		if sent > 0 {
+			log.Printf("Sent %d digest emails\n", sent)
+		}
+		return err
+	})
+}
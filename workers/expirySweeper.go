@@ -0,0 +1,20 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+// Periodically closes bets that have passed their expiry date
+func NewExpirySweeper(ctl *controllers.Controller, interval time.Duration) *Worker {
+	return NewWorker("expiry sweeper", interval, func(ctx context.Context) error {
+		closed, err := ctl.SweepExpiredBets(ctx, time.Now())
+		if closed > 0 {
+			log.Printf("Closed %d expired bets\n", closed)
+		}
+		return err
+	})
+}
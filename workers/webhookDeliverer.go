@@ -0,0 +1,20 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/simhonchourasia/betfr-be/controllers"
+)
+
+// Periodically sends the webhook deliveries in the outbox that are due
+func NewWebhookDeliverer(ctl *controllers.Controller, interval time.Duration) *Worker {
+	return NewWorker("webhook deliverer", interval, func(ctx context.Context) error {
+		delivered, err := ctl.DeliverWebhooks(ctx, time.Now())
+		if delivered > 0 {
+			log.Printf("Delivered %d webhooks\n", delivered)
+		}
+		return err
+	})
+}
@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Runs a job on a fixed interval in the background until stopped
+type Worker struct {
+	name     string
+	interval time.Duration
+	job      func(ctx context.Context) error
+	cancel   context.CancelFunc
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewWorker(name string, interval time.Duration, job func(ctx context.Context) error) *Worker {
+	return &Worker{
+		name:     name,
+		interval: interval,
+		job:      job,
+		done:     make(chan struct{}),
+	}
+}
+
+// Runs the job once straight away and then every interval
+func (w *Worker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go func() {
+		defer close(w.done)
+		log.Printf("Started %s worker, running every %s\n", w.name, w.interval)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			if err := w.job(ctx); err != nil {
+				log.Printf("%s worker: %v\n", w.name, err)
+			}
+			select {
+			case <-ctx.Done():
+				log.Printf("Stopped %s worker\n", w.name)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Cancels the job's context and waits for any run in progress to return
+func (w *Worker) Stop() {
+	w.once.Do(func() {
+		if w.cancel == nil {
+			close(w.done)
+			return
+		}
+		w.cancel()
+	})
+	<-w.done
+}